@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"ennoea/pkg/ennoea"
+	"fmt"
+	"os"
+)
+
+// Exit codes of the subcommands.
+const (
+	// exitOK is returned when the command succeeded.
+	exitOK = 0
+
+	// exitFailed is returned when the command ran but found problems,
+	// such as lint findings above the failure threshold.
+	exitFailed = 1
+
+	// exitError is returned when the command could not run, such as
+	// when the arguments are invalid or a file cannot be read.
+	exitError = 2
+)
+
+// commands is a map of subcommand names to the functions that run
+// them. Each function is given the arguments after the subcommand
+// name and returns the exit code.
+var commands = map[string]func(args []string) int{
+	"lint":   runLint,
+	"import": runImport,
+}
+
+// readArchitectureFile reads an architecture from a JSON file.
+func readArchitectureFile(path string) (ennoea.Architecture, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return ennoea.Architecture{}, fmt.Errorf("failed to read architecture file: %v", err)
+	}
+
+	var arch ennoea.Architecture
+	err = json.Unmarshal(file, &arch)
+	if err != nil {
+		return ennoea.Architecture{}, fmt.Errorf("failed to unmarshal architecture file: %v", err)
+	}
+
+	return arch, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"ennoea/pkg/ennoea"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runImport imports a file of another format as an architecture.
+//
+//	ennoea import <format> [-id id] [-name name] [-layout algorithm] [-config config.json] [-update architecture.json] [-o architecture.json] file
+//
+// The architecture is written to the output file, or to stdout if
+// there is none. The file is read from stdin if it is "-", and some
+// formats can be read from a directory. Warnings about the parts of
+// the file that could not be imported are written to stderr.
+//
+// An architecture from a previous import can be updated, keeping the
+// objects of the components that are still there.
+func runImport(args []string) int {
+	usage := func(w io.Writer) {
+		fmt.Fprintf(w, "usage: ennoea import <format> [flags] file\n")
+		fmt.Fprintf(w, "formats: %s\n", strings.Join(ennoea.ImportFormats(), ", "))
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		usage(os.Stderr)
+		return exitError
+	}
+	format := args[0]
+
+	flags := flag.NewFlagSet("import "+format, flag.ContinueOnError)
+	idFlag := flags.String("id", "", "the ID of the architecture")
+	nameFlag := flags.String("name", "", "the name of the architecture")
+	layoutFlag := flags.String("layout", "", "the layout algorithm: force, layered, grouped or grid (default depends on the format)")
+	configFlag := flags.String("config", "", "the format specific configuration file")
+	updateFlag := flags.String("update", "", "the architecture file of a previous import to update")
+	outputFlag := flags.String("o", "", "the file to write the architecture to")
+	flags.Usage = func() {
+		usage(flags.Output())
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitError
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitError
+	}
+
+	// Read the file
+	data, err := readImportFile(format, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	options := ennoea.ImportOptions{
+		ID:     *idFlag,
+		Name:   *nameFlag,
+		Layout: *layoutFlag,
+	}
+	if *configFlag != "" {
+		options.Config, err = os.ReadFile(*configFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read config: %v\n", err)
+			return exitError
+		}
+	}
+	if *updateFlag != "" {
+		previous, err := readArchitectureFile(*updateFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		options.Previous = &previous
+	}
+
+	result, err := ennoea.Import(format, data, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	// Write the architecture
+	output, err := json.MarshalIndent(result.Architecture, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal architecture: %v\n", err)
+		return exitError
+	}
+	if *outputFlag == "" {
+		fmt.Println(string(output))
+		return exitOK
+	}
+	err = os.WriteFile(*outputFlag, append(output, '\n'), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write architecture: %v\n", err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+// readImportFile reads the file to import from stdin if the path is
+// "-", or from a directory or a file.
+func readImportFile(format, path string) ([]byte, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		return ennoea.ReadImportDirectory(format, path)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	return data, nil
+}
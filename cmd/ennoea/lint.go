@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"ennoea/pkg/ennoea"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runLint runs the lint rules against architecture files.
+//
+//	ennoea lint [-config lint.json] [-fail-on error] [-format text] architecture.json...
+//
+// The exit code is 0 if there are no findings at or above the
+// failure severity, 1 if there are, and 2 if the files could not be
+// linted.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configFlag := flags.String("config", "", "the lint rule configuration file")
+	failOnFlag := flags.String("fail-on", ennoea.LintError, "the lowest severity that fails the lint: info, warning or error")
+	formatFlag := flags.String("format", "text", "the output format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: ennoea lint [flags] architecture.json...\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+	if *failOnFlag != ennoea.LintInfo && *failOnFlag != ennoea.LintWarning && *failOnFlag != ennoea.LintError {
+		fmt.Fprintf(os.Stderr, "invalid severity: %s\n", *failOnFlag)
+		return exitError
+	}
+	if *formatFlag != "text" && *formatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "invalid format: %s\n", *formatFlag)
+		return exitError
+	}
+
+	// Load the lint configuration
+	var config ennoea.LintConfig
+	if *configFlag != "" {
+		var err error
+		config, err = ennoea.LoadLintConfig(*configFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	// Lint each of the files
+	results := make(map[string][]ennoea.LintFinding, flags.NArg())
+	failed := false
+	for _, path := range flags.Args() {
+		arch, err := readArchitectureFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return exitError
+		}
+
+		findings, err := ennoea.LintArchitecture(arch, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return exitError
+		}
+
+		results[path] = findings
+		failed = failed || ennoea.LintFailed(findings, *failOnFlag)
+	}
+
+	// Write the findings
+	if *formatFlag == "json" {
+		output, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal findings: %v\n", err)
+			return exitError
+		}
+		fmt.Println(string(output))
+	} else {
+		for _, path := range flags.Args() {
+			for _, f := range results[path] {
+				fmt.Printf("%s: %s\n", path, f)
+			}
+		}
+	}
+
+	if failed {
+		return exitFailed
+	}
+	return exitOK
+}
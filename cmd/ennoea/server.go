@@ -6,20 +6,46 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 )
 
 var (
 	portFlag        = flag.Int("port", 8080, "the port number that this server should listen on")
 	staticFilesFlag = flag.String("static", "build/static", "the static file directory")
 	saveDirFlag     = flag.String("save-dir", "saves", "the directory to save files to")
+	lintConfigFlag  = flag.String("lint-config", "", "the lint rule configuration file")
+	policyDirFlag   = flag.String("policy-dir", "policies", "the directory to save policies to")
+
+	otlpArchitectureFlag = flag.String("otlp-architecture", "", "the ID of the architecture that received traces are written to, which enables the trace receiver")
+	otlpAddressFlag      = flag.String("otlp-address", ":4318", "the address that the trace receiver listens on, or empty to use the server port")
+	otlpPathFlag         = flag.String("otlp-path", "/v1/traces", "the path that the trace receiver accepts OTLP/HTTP exports on")
+	otlpWindowFlag       = flag.Duration("otlp-window", 5*time.Minute, "the length of the rolling window of received spans")
+	otlpIntervalFlag     = flag.Duration("otlp-interval", 30*time.Second, "how often the received traces are written to the architecture")
 )
 
 var (
 	// architectureHandler is the handler for the architecture routes.
 	architectureHandler *ennoea.ArchitectureHandler
+
+	// policyHandler is the handler for the policy routes.
+	policyHandler *ennoea.PolicyHandler
+
+	// importHandler is the handler for the import routes.
+	importHandler *ennoea.ImportHandler
+
+	// traceReceiver is the OTLP/HTTP trace receiver, or nil if it is
+	// not enabled.
+	traceReceiver *ennoea.TraceReceiver
 )
 
 func main() {
+	// Run the subcommand if one was given instead of the server.
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 	listenAddress := fmt.Sprintf(":%d", *portFlag)
 
@@ -29,6 +55,11 @@ func main() {
 	// Handle the architecture routes
 	setupRoutes()
 
+	// Start the trace receiver
+	if traceReceiver != nil {
+		startTraceReceiver()
+	}
+
 	// Start the server
 	fmt.Printf("listening on %s\n", listenAddress)
 	http.ListenAndServe(listenAddress, nil)
@@ -46,12 +77,49 @@ func initialize() {
 	if err != nil {
 		panic(err)
 	}
+
+	// Configure the lint rules that run when architectures are saved.
+	if *lintConfigFlag != "" {
+		config, err := ennoea.LoadLintConfig(*lintConfigFlag)
+		if err != nil {
+			panic(err)
+		}
+		architectureHandler.SetLintConfig(config)
+	}
+
+	// Ensure that the policy directory exists.
+	err = ensureDirectoryExists(*policyDirFlag)
+	if err != nil {
+		panic(err)
+	}
+
+	// Create the policy handler and evaluate the policies on save.
+	policyHandler = ennoea.NewPolicyHandler(*policyDirFlag)
+	architectureHandler.SetPolicies(policyHandler)
+
+	// Create the import handler that saves imported architectures.
+	importHandler = ennoea.NewImportHandler(architectureHandler)
+
+	// Create the trace receiver that writes to the architecture.
+	if *otlpArchitectureFlag != "" {
+		traceReceiver = ennoea.NewTraceReceiver(architectureHandler, ennoea.TraceReceiverConfig{
+			ArchitectureID: *otlpArchitectureFlag,
+			Window:         *otlpWindowFlag,
+			Interval:       *otlpIntervalFlag,
+		})
+	}
 }
 
 func setupRoutes() {
 	// Handle the architecture saving loading routes
 	http.Handle("/architectures/", architectureHandler)
 
+	// Handle the policy routes
+	http.Handle("/policies/", policyHandler)
+
+	// Handle the import routes
+	http.Handle("/import/", importHandler)
+
 	// Handle the static file routes
 	http.Handle("/static/",
 		http.StripPrefix("/static/",
@@ -64,6 +132,24 @@ func setupRoutes() {
 			http.Dir(*staticFilesFlag+"/html")))
 }
 
+// startTraceReceiver serves the trace receiver, on its own address if
+// one is given, and writes the received traces in the background.
+func startTraceReceiver() {
+	if *otlpAddressFlag == "" {
+		http.Handle(*otlpPathFlag, traceReceiver)
+	} else {
+		mux := http.NewServeMux()
+		mux.Handle(*otlpPathFlag, traceReceiver)
+		fmt.Printf("receiving traces on %s%s\n", *otlpAddressFlag, *otlpPathFlag)
+		go func() {
+			err := http.ListenAndServe(*otlpAddressFlag, mux)
+			fmt.Fprintf(os.Stderr, "trace receiver stopped: %v\n", err)
+		}()
+	}
+
+	go traceReceiver.Run(nil)
+}
+
 // ensureDirectoryExists ensures that the directory exists.
 // If the directory does not exist, it will be created.
 func ensureDirectoryExists(dir string) error {
@@ -0,0 +1,418 @@
+package ennoea
+
+import (
+	"net/http"
+)
+
+const (
+	// maxAnalysisCycles is the maximum number of cycles that are
+	// listed in the analysis. Dense graphs can contain an
+	// exponential number of cycles.
+	maxAnalysisCycles = 100
+
+	// maxAnalysisCycleSteps is the maximum number of edges that are
+	// followed while searching for cycles.
+	maxAnalysisCycleSteps = 100000
+
+	// maxAnalysisChains is the maximum number of longest dependency
+	// chains that are listed in the analysis.
+	maxAnalysisChains = 10
+)
+
+// ArchitectureAnalysis represents the result of analysing the graph
+// of an architecture. The components are the nodes of the graph and
+// the connections are the directed edges, following the flow of the
+// data through each connection.
+type ArchitectureAnalysis struct {
+	// Components is the fan-in and fan-out of each component.
+	Components []ComponentAnalysis `json:"components"`
+
+	// StronglyConnectedComponents is a list of the groups of
+	// components that can all reach each other. Only groups with
+	// more than one component are listed.
+	StronglyConnectedComponents [][]string `json:"stronglyConnectedComponents"`
+
+	// Cycles is a list of the cycles in the graph. Each cycle is a
+	// list of component IDs, where the last component connects back
+	// to the first. A "bi" connection on its own is not a cycle.
+	Cycles [][]string `json:"cycles"`
+
+	// CyclesTruncated is set when there were too many cycles to list.
+	CyclesTruncated bool `json:"cyclesTruncated"`
+
+	// Orphans is a list of the components without any connections.
+	Orphans []string `json:"orphans"`
+
+	// ArticulationPoints is a list of the components that would
+	// split the architecture in two if they were removed. The
+	// direction of the connections is ignored.
+	ArticulationPoints []string `json:"articulationPoints"`
+
+	// Bridges is a list of the connections that would split the
+	// architecture in two if they were removed. The direction of
+	// the connections is ignored.
+	Bridges []string `json:"bridges"`
+
+	// LongestChains is a list of the longest chains of components
+	// that depend on each other. Cycles are collapsed when the
+	// chains are computed, so a component in a cycle stands in
+	// for the whole cycle.
+	LongestChains [][]string `json:"longestChains"`
+}
+
+// ComponentAnalysis represents the analysis of a single component.
+type ComponentAnalysis struct {
+	// ID is the ID of the component.
+	ID string `json:"id"`
+
+	// Name is the name of the component.
+	Name string `json:"name"`
+
+	// FanIn is the number of components that send data to this
+	// component.
+	FanIn int `json:"fanIn"`
+
+	// FanOut is the number of components that this component sends
+	// data to.
+	FanOut int `json:"fanOut"`
+}
+
+// analyseArchitecture computes the graph analysis of the architecture.
+func analyseArchitecture(arch Architecture) ArchitectureAnalysis {
+	g := newArchitectureGraph(arch)
+	analysis := ArchitectureAnalysis{
+		Components:                  make([]ComponentAnalysis, 0, g.size()),
+		StronglyConnectedComponents: [][]string{},
+		Orphans:                     []string{},
+	}
+
+	// Fan-in, fan-out and orphans
+	for i, c := range arch.Components {
+		analysis.Components = append(analysis.Components, ComponentAnalysis{
+			ID:     c.ID,
+			Name:   c.Name,
+			FanIn:  len(g.predecessors(i)),
+			FanOut: len(g.successors(i)),
+		})
+		if len(g.in[i]) == 0 && len(g.out[i]) == 0 {
+			analysis.Orphans = append(analysis.Orphans, c.ID)
+		}
+	}
+
+	// Strongly connected components
+	sccs := g.stronglyConnectedComponents()
+	for _, scc := range sccs {
+		if len(scc) > 1 {
+			analysis.StronglyConnectedComponents = append(analysis.StronglyConnectedComponents, g.ids(scc))
+		}
+	}
+
+	analysis.Cycles, analysis.CyclesTruncated = g.cycles(sccs, maxAnalysisCycles)
+	analysis.ArticulationPoints, analysis.Bridges = g.articulationPointsAndBridges()
+	analysis.LongestChains = g.longestChains(sccs, maxAnalysisChains)
+
+	return analysis
+}
+
+// cycles returns the elementary cycles of the graph, up to the limit,
+// using Johnson's algorithm inside each strongly connected component.
+// Each cycle is reported once, starting from the component that comes
+// first in the architecture. A cycle must use a different connection
+// for every step, so the two edges of a single "bi" connection do not
+// form a cycle. The search is also cut short after a fixed number of
+// steps, so the result is truncated on graphs that are too large to
+// search.
+func (g *architectureGraph) cycles(sccs [][]int, limit int) ([][]string, bool) {
+	cycles := [][]string{}
+	truncated := false
+	steps := 0
+
+	component := make([]int, g.size())
+	for i, scc := range sccs {
+		for _, v := range scc {
+			component[v] = i
+		}
+	}
+
+	blocked := make([]bool, g.size())
+	blockedBy := make([]map[int]bool, g.size())
+	var path []int
+
+	var unblock func(v int)
+	unblock = func(v int) {
+		blocked[v] = false
+		for w := range blockedBy[v] {
+			delete(blockedBy[v], w)
+			if blocked[w] {
+				unblock(w)
+			}
+		}
+	}
+
+	// circuit searches for the cycles through the start that continue
+	// from v, and returns true if v can get back to the start
+	var circuit func(start, v int) bool
+	circuit = func(start, v int) bool {
+		found := false
+		blocked[v] = true
+
+		var next []int
+		for _, w := range g.successors(v) {
+			if w >= start && component[w] == component[start] {
+				next = append(next, w)
+			}
+		}
+
+		for _, w := range next {
+			steps++
+			if steps > maxAnalysisCycleSteps || truncated {
+				truncated = true
+				return found
+			}
+
+			if w == start {
+				// Back to the start counts as found even when the
+				// cycle is a single "bi" connection, because v may
+				// still close a longer cycle from another path
+				found = true
+				if len(path) == 2 && !g.hasReturnConnection(start, v) {
+					continue
+				}
+				if len(cycles) >= limit {
+					truncated = true
+					return found
+				}
+				cycles = append(cycles, g.ids(path))
+				continue
+			}
+
+			if !blocked[w] {
+				path = append(path, w)
+				if circuit(start, w) {
+					found = true
+				}
+				path = path[:len(path)-1]
+			}
+		}
+
+		if found {
+			unblock(v)
+		} else {
+			for _, w := range next {
+				if blockedBy[w] == nil {
+					blockedBy[w] = make(map[int]bool)
+				}
+				blockedBy[w][v] = true
+			}
+		}
+		return found
+	}
+
+	for start := 0; start < g.size() && !truncated; start++ {
+		// Components on their own are only in a cycle if they are
+		// connected to themselves
+		if len(sccs[component[start]]) == 1 && !g.hasEdge(start, start) {
+			continue
+		}
+		for _, v := range sccs[component[start]] {
+			blocked[v] = false
+			blockedBy[v] = nil
+		}
+		path = []int{start}
+		circuit(start, start)
+	}
+
+	return cycles, truncated
+}
+
+// hasEdge returns true if there is an edge from one component to the
+// other.
+func (g *architectureGraph) hasEdge(from, to int) bool {
+	for _, e := range g.out[from] {
+		if e.to == to {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReturnConnection returns true if the two components send data to
+// each other through two different connections.
+func (g *architectureGraph) hasReturnConnection(a, b int) bool {
+	for _, there := range g.out[a] {
+		if there.to != b {
+			continue
+		}
+		for _, back := range g.out[b] {
+			if back.to == a && back.connection != there.connection {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// articulationPointsAndBridges returns the articulation points and the
+// bridges of the graph when the direction of the connections is
+// ignored. Parallel connections between the same components are not
+// bridges because removing one of them leaves the other.
+func (g *architectureGraph) articulationPointsAndBridges() ([]string, []string) {
+	type undirectedEdge struct {
+		to         int
+		connection int
+	}
+
+	// Build the undirected graph from the connections
+	adjacent := make([][]undirectedEdge, g.size())
+	for i, c := range g.arch.Connections {
+		source, ok := g.index[c.Source]
+		if !ok {
+			continue
+		}
+		target, ok := g.index[c.Target]
+		if !ok || source == target {
+			continue
+		}
+		adjacent[source] = append(adjacent[source], undirectedEdge{to: target, connection: i})
+		adjacent[target] = append(adjacent[target], undirectedEdge{to: source, connection: i})
+	}
+
+	counter := 0
+	discovered := make([]int, g.size())
+	low := make([]int, g.size())
+	visited := make([]bool, g.size())
+	isArticulation := make([]bool, g.size())
+	isBridge := make(map[int]bool)
+
+	var visit func(v, parentConnection int)
+	visit = func(v, parentConnection int) {
+		visited[v] = true
+		discovered[v] = counter
+		low[v] = counter
+		counter++
+		children := 0
+
+		for _, e := range adjacent[v] {
+			if e.connection == parentConnection {
+				continue
+			}
+			if visited[e.to] {
+				low[v] = min(low[v], discovered[e.to])
+				continue
+			}
+
+			children++
+			visit(e.to, e.connection)
+			low[v] = min(low[v], low[e.to])
+
+			if low[e.to] > discovered[v] {
+				isBridge[e.connection] = true
+			}
+			if parentConnection >= 0 && low[e.to] >= discovered[v] {
+				isArticulation[v] = true
+			}
+		}
+
+		// The root of the search tree is an articulation point if
+		// it has more than one child.
+		if parentConnection < 0 && children > 1 {
+			isArticulation[v] = true
+		}
+	}
+
+	for v := 0; v < g.size(); v++ {
+		if !visited[v] {
+			visit(v, -1)
+		}
+	}
+
+	articulationPoints := []string{}
+	for v, ok := range isArticulation {
+		if ok {
+			articulationPoints = append(articulationPoints, g.id(v))
+		}
+	}
+	bridges := []string{}
+	for i, c := range g.arch.Connections {
+		if isBridge[i] {
+			bridges = append(bridges, c.ID)
+		}
+	}
+
+	return articulationPoints, bridges
+}
+
+// longestChains returns the longest chains of components in the graph
+// with its strongly connected components collapsed, up to the limit.
+// The strongly connected components must be in reverse topological
+// order. Chains with a single component are not reported.
+func (g *architectureGraph) longestChains(sccs [][]int, limit int) [][]string {
+	chains := [][]string{}
+
+	// Map each component to its strongly connected component
+	componentSCC := make([]int, g.size())
+	for i, scc := range sccs {
+		for _, v := range scc {
+			componentSCC[v] = i
+		}
+	}
+
+	// Compute the length of the longest chain starting at each
+	// strongly connected component. The successors of a strongly
+	// connected component always come before it in the list.
+	length := make([]int, len(sccs))
+	next := make([]graphEdge, len(sccs))
+	hasNext := make([]bool, len(sccs))
+	best := 0
+	for i, scc := range sccs {
+		length[i] = 1
+		for _, v := range scc {
+			for _, e := range g.out[v] {
+				j := componentSCC[e.to]
+				if j != i && length[j]+1 > length[i] {
+					length[i] = length[j] + 1
+					next[i] = e
+					hasNext[i] = true
+				}
+			}
+		}
+		best = max(best, length[i])
+	}
+
+	if best < 2 {
+		return chains
+	}
+
+	// Walk the chains from each strongly connected component that
+	// starts a longest chain.
+	for i := len(sccs) - 1; i >= 0 && len(chains) < limit; i-- {
+		if length[i] != best {
+			continue
+		}
+
+		chain := []string{g.id(sccs[i][0])}
+		for j := i; hasNext[j]; j = componentSCC[next[j].to] {
+			chain = append(chain, g.id(next[j].to))
+		}
+		chains = append(chains, chain)
+	}
+
+	return chains
+}
+
+// handleGetAnalysis handles GET requests for the graph analysis of an
+// architecture.
+// GET /architectures/${architectureID}/analysis
+//
+//	return the fan-in and fan-out of each component, the strongly
+//	connected components and cycles, the orphaned components, the
+//	articulation points and bridges, and the longest dependency
+//	chains.
+func (h *ArchitectureHandler) handleGetAnalysis(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, analyseArchitecture(arch))
+}
@@ -0,0 +1,142 @@
+package ennoea
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testArchitecture builds an architecture with the components and
+// connections, where each connection is "source>target" for an out
+// flow or "source=target" for a bi flow.
+func testArchitecture(components []string, connections ...string) Architecture {
+	var arch Architecture
+	for _, id := range components {
+		arch.Components = append(arch.Components, Component{ID: id, Name: id})
+	}
+	for i, c := range connections {
+		flow := "out"
+		for j := 0; j < len(c); j++ {
+			if c[j] == '>' || c[j] == '=' {
+				if c[j] == '=' {
+					flow = "bi"
+				}
+				arch.Connections = append(arch.Connections, Connection{
+					ID:     fmt.Sprintf("c%d", i),
+					Source: c[:j],
+					Target: c[j+1:],
+					Flow:   flow,
+				})
+				break
+			}
+		}
+	}
+	return arch
+}
+
+func TestAnalysisCycles(t *testing.T) {
+	tests := []struct {
+		name        string
+		components  []string
+		connections []string
+		cycles      [][]string
+	}{
+		{
+			name:        "acyclic",
+			components:  []string{"a", "b", "c"},
+			connections: []string{"a>b", "b>c", "a>c"},
+			cycles:      [][]string{},
+		},
+		{
+			name:        "triangle",
+			components:  []string{"a", "b", "c"},
+			connections: []string{"a>b", "b>c", "c>a"},
+			cycles:      [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:        "bi connection is not a cycle",
+			components:  []string{"a", "b"},
+			connections: []string{"a=b"},
+			cycles:      [][]string{},
+		},
+		{
+			name:        "two connections back and forth",
+			components:  []string{"a", "b"},
+			connections: []string{"a>b", "b>a"},
+			cycles:      [][]string{{"a", "b"}},
+		},
+		{
+			name:        "bi connection in a longer cycle",
+			components:  []string{"a", "b", "c"},
+			connections: []string{"a=b", "b>c", "c>a"},
+			cycles:      [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:        "bi connection closes a cycle after it is rejected",
+			components:  []string{"a", "b", "c"},
+			connections: []string{"a=b", "a>c", "c>b"},
+			cycles:      [][]string{{"a", "c", "b"}},
+		},
+		{
+			name:        "self connection",
+			components:  []string{"a", "b"},
+			connections: []string{"a>a", "a>b"},
+			cycles:      [][]string{{"a"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			arch := testArchitecture(test.components, test.connections...)
+			analysis := analyseArchitecture(arch)
+			if !reflect.DeepEqual(analysis.Cycles, test.cycles) {
+				t.Errorf("cycles = %v, want %v", analysis.Cycles, test.cycles)
+			}
+			if analysis.CyclesTruncated {
+				t.Errorf("cycles are truncated")
+			}
+		})
+	}
+}
+
+func TestAnalysisCyclesTruncated(t *testing.T) {
+	// Every pair of components is connected both ways, which has far
+	// more cycles than are listed
+	var components, connections []string
+	for i := 0; i < 10; i++ {
+		components = append(components, fmt.Sprint(i))
+		for j := 0; j < i; j++ {
+			connections = append(connections, fmt.Sprintf("%d>%d", i, j), fmt.Sprintf("%d>%d", j, i))
+		}
+	}
+	analysis := analyseArchitecture(testArchitecture(components, connections...))
+	if len(analysis.Cycles) != maxAnalysisCycles || !analysis.CyclesTruncated {
+		t.Errorf("got %d cycles, truncated %v", len(analysis.Cycles), analysis.CyclesTruncated)
+	}
+}
+
+func TestAnalysisCyclesLayered(t *testing.T) {
+	// A layered graph without cycles has an exponential number of
+	// paths, which must not be searched
+	var components, connections []string
+	for layer := 0; layer < 100; layer++ {
+		for i := 0; i < 3; i++ {
+			components = append(components, fmt.Sprintf("%d-%d", layer, i))
+			if layer == 0 {
+				continue
+			}
+			for j := 0; j < 3; j++ {
+				connections = append(connections, fmt.Sprintf("%d-%d>%d-%d", layer-1, j, layer, i))
+			}
+		}
+	}
+	start := time.Now()
+	analysis := analyseArchitecture(testArchitecture(components, connections...))
+	if len(analysis.Cycles) != 0 || analysis.CyclesTruncated {
+		t.Errorf("got %d cycles, truncated %v", len(analysis.Cycles), analysis.CyclesTruncated)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("analysis took %v", elapsed)
+	}
+}
@@ -22,6 +22,10 @@ type Architecture struct {
 
 	// Connections is a list of the connections in the architecture.
 	Connections []Connection `json:"connections"`
+
+	// Views is a list of saved queries that show part of the
+	// architecture.
+	Views []View `json:"views,omitempty"`
 }
 
 // isValid returns an error if the architecture is invalid.
@@ -57,6 +61,71 @@ func (a Architecture) isValid() error {
 		}
 	}
 
+	// Check that the views are valid
+	for _, v := range a.Views {
+		if err := v.isValid(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isConsistent returns an error if the entities in the architecture
+// do not refer to each other correctly. The IDs of the components,
+// groups and connections must be unique, and every group member and
+// connection endpoint must refer to a component that exists.
+func (a Architecture) isConsistent() error {
+	// Check that the component IDs are unique
+	components := make(map[string]bool, len(a.Components))
+	for _, c := range a.Components {
+		if components[c.ID] {
+			return fmt.Errorf("inconsistent architecture: duplicate component id: %s", c.ID)
+		}
+		components[c.ID] = true
+	}
+
+	// Check that the group IDs are unique and that the members exist
+	groups := make(map[string]bool, len(a.Groups))
+	for _, g := range a.Groups {
+		if groups[g.ID] {
+			return fmt.Errorf("inconsistent architecture: duplicate group id: %s", g.ID)
+		}
+		groups[g.ID] = true
+
+		for _, member := range g.Components {
+			if !components[member] {
+				return fmt.Errorf("inconsistent architecture: group %s: unknown component: %s", g.ID, member)
+			}
+		}
+	}
+
+	// Check that the connection IDs are unique and that the
+	// endpoints exist
+	connections := make(map[string]bool, len(a.Connections))
+	for _, c := range a.Connections {
+		if connections[c.ID] {
+			return fmt.Errorf("inconsistent architecture: duplicate connection id: %s", c.ID)
+		}
+		connections[c.ID] = true
+
+		if !components[c.Source] {
+			return fmt.Errorf("inconsistent architecture: connection %s: unknown source: %s", c.ID, c.Source)
+		}
+		if !components[c.Target] {
+			return fmt.Errorf("inconsistent architecture: connection %s: unknown target: %s", c.ID, c.Target)
+		}
+	}
+
+	// Check that the view IDs are unique
+	views := make(map[string]bool, len(a.Views))
+	for _, v := range a.Views {
+		if views[v.ID] {
+			return fmt.Errorf("inconsistent architecture: duplicate view id: %s", v.ID)
+		}
+		views[v.ID] = true
+	}
+
 	return nil
 }
 
@@ -74,6 +143,10 @@ type Info struct {
 	// description is used to provide more information about the
 	// architecture.
 	Description string `json:"description"`
+
+	// Source is the ID of the architecture that this architecture was
+	// extracted from, if it was derived from another architecture.
+	Source string `json:"source,omitempty"`
 }
 
 // isValid returns an error if the info is invalid. It does this by
@@ -205,6 +278,16 @@ type Component struct {
 
 	// Object is the 3D object of the component.
 	Object Object3D `json:"object"`
+
+	// Capacity is the declared maximum throughput of the component
+	// in bytes per second. A capacity of zero means that the
+	// capacity is unknown.
+	Capacity Rate `json:"capacity,omitempty"`
+
+	// Labels is a map of arbitrary key value pairs that describe the
+	// component, such as "zone": "public". Labels are used by
+	// policies to select components.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // isValid returns an error if the component is invalid.
@@ -232,6 +315,11 @@ func (c Component) isValid() error {
 		return fmt.Errorf("invalid component: %w", err)
 	}
 
+	// Check that the capacity is valid if it is defined
+	if c.Capacity < 0 {
+		return fmt.Errorf("invalid component: capacity is negative")
+	}
+
 	return nil
 }
 
@@ -251,6 +339,10 @@ type Group struct {
 
 	// BoundingBox is the bounding box of the group.
 	BoundingBox BoundingBox `json:"boundingBox"`
+
+	// Labels is a map of arbitrary key value pairs that describe the
+	// group, such as "env": "prod".
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // isValid returns an error if the group is invalid.
@@ -304,24 +396,41 @@ type Connection struct {
 	Flow string `json:"flow"`
 
 	// OutRate is the rate of the out flow of the connection.
-	// The rate is represented as a number of bytes per second.
+	// The rate is represented as a number of bytes per second,
+	// but can be loaded from a unit string such as "10MB/s".
 	// This is ignored if the flow is "in".
-	OutRate int `json:"outRate"`
+	OutRate Rate `json:"outRate"`
 
 	// InRate is the rate of the in flow of the connection.
-	// The rate is represented as a number of bytes per second.
+	// The rate is represented as a number of bytes per second,
+	// but can be loaded from a unit string such as "10MB/s".
 	// This is ignored if the flow is "out".
-	InRate int `json:"inRate"`
+	InRate Rate `json:"inRate"`
 
 	// OutPacketSize is the size of the out packets of the connection.
-	// The size is represented as a number of bytes per packet. It is
+	// The size is represented as a number of bytes per packet, but
+	// can be loaded from a unit string such as "512KiB". It is
 	// ignored if the flow is "in".
-	OutPacketSize int `json:"outPacketSize"`
+	OutPacketSize Size `json:"outPacketSize"`
 
 	// InPacketSize is the size of the in packets of the connection.
-	// The size is represented as a number of bytes per packet. It is
+	// The size is represented as a number of bytes per packet, but
+	// can be loaded from a unit string such as "512KiB". It is
 	// ignored if the flow is "out".
-	InPacketSize int `json:"inPacketSize"`
+	InPacketSize Size `json:"inPacketSize"`
+
+	// Latency is the declared latency of the connection in
+	// milliseconds. It is used to weight path queries.
+	Latency float64 `json:"latency,omitempty"`
+
+	// Capacity is the declared maximum rate of the connection in
+	// bytes per second, in either direction. A capacity of zero
+	// means that the capacity is unknown.
+	Capacity Rate `json:"capacity,omitempty"`
+
+	// Labels is a map of arbitrary key value pairs that describe the
+	// connection, such as "tls": "true".
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // isValid returns an error if the connection is invalid.
@@ -374,6 +483,16 @@ func (c Connection) isValid() error {
 		return fmt.Errorf("invalid connection: in packet size is negative")
 	}
 
+	// Check that the latency is valid if it is defined
+	if c.Latency < 0 {
+		return fmt.Errorf("invalid connection: latency is negative")
+	}
+
+	// Check that the capacity is valid if it is defined
+	if c.Capacity < 0 {
+		return fmt.Errorf("invalid connection: capacity is negative")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,402 @@
+package ennoea
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// collapsedComponentPrefix is the prefix of the ID of the
+	// synthetic component that replaces a collapsed group. The rest
+	// of the ID is the ID of the group, so the client can expand the
+	// group again.
+	collapsedComponentPrefix = "group:"
+
+	// aggregateConnectionPrefix is the prefix of the ID of a
+	// connection that replaces the connections between a collapsed
+	// group and another component. The rest of the ID is the IDs of
+	// the source and the target, escaped by aggregateIDEscaper and
+	// separated by a colon.
+	aggregateConnectionPrefix = "aggregate:"
+
+	// maxGroupDepth is the deepest nesting depth that can be
+	// requested.
+	maxGroupDepth = 64
+)
+
+// aggregateIDEscaper escapes the percent signs and colons in the IDs
+// of the ends of an aggregate connection, so that the colon between
+// them is unambiguous and the IDs can be decoded as URI components.
+var aggregateIDEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// groupDepths returns the nesting depth of each group. A group is
+// nested inside another group if its members are a subset of the
+// members of the other group. Groups with the same members are
+// nested in the order they are listed. Top level groups have a depth
+// of zero.
+func groupDepths(groups []Group) []int {
+	members := make([]map[string]bool, len(groups))
+	for i, g := range groups {
+		members[i] = make(map[string]bool, len(g.Components))
+		for _, id := range g.Components {
+			members[i][id] = true
+		}
+	}
+
+	depths := make([]int, len(groups))
+	for i := range groups {
+		for j := range groups {
+			if i != j && isNestedIn(members[i], members[j], i, j) {
+				depths[i]++
+			}
+		}
+	}
+	return depths
+}
+
+// isNestedIn returns true if group i is nested inside group j.
+func isNestedIn(inner, outer map[string]bool, i, j int) bool {
+	if len(inner) == 0 || len(inner) > len(outer) {
+		return false
+	}
+	for id := range inner {
+		if !outer[id] {
+			return false
+		}
+	}
+	// Groups with the same members are nested in list order
+	return len(inner) < len(outer) || j < i
+}
+
+// collapseGroups returns a derived architecture in which each of the
+// groups is replaced by a synthetic component. When the groups share
+// components, the larger group collapses first and claims the shared
+// components. Groups that are nested inside a collapsed group are
+// removed, and the members of the other groups are mapped to the
+// synthetic components.
+//
+// Connections inside a collapsed group are removed. The connections
+// between a collapsed group and any other component are merged into
+// one aggregate connection per pair of ends, with the rates in each
+// direction summed.
+func collapseGroups(arch Architecture, groupIDs []string) (Architecture, error) {
+	selected := make([]int, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		found := false
+		for i, g := range arch.Groups {
+			if g.ID == id {
+				selected = append(selected, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return Architecture{}, fmt.Errorf("unknown group: %s", id)
+		}
+	}
+	sort.SliceStable(selected, func(a, b int) bool {
+		return len(arch.Groups[selected[a]].Components) > len(arch.Groups[selected[b]].Components)
+	})
+
+	// Map each member of a collapsed group to its synthetic component
+	index := make(map[string]int, len(arch.Components))
+	for i, c := range arch.Components {
+		index[c.ID] = i
+	}
+	mapped := make(map[string]string)
+	collapsed := make(map[int]bool)
+	var synthetic []Component
+	for _, gi := range selected {
+		g := arch.Groups[gi]
+		if collapsed[gi] {
+			continue
+		}
+		var members []Component
+		for _, id := range g.Components {
+			if _, ok := mapped[id]; ok {
+				continue
+			}
+			if i, ok := index[id]; ok {
+				members = append(members, arch.Components[i])
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+
+		// The client expands the group by the ID of the synthetic
+		// component, so it cannot be renamed around a component
+		// that already has the ID
+		c := collapsedComponent(g, members)
+		if _, ok := index[c.ID]; ok {
+			return Architecture{}, fmt.Errorf("collapsed group %s has the ID of component %s", g.ID, c.ID)
+		}
+		for _, m := range members {
+			mapped[m.ID] = c.ID
+		}
+		collapsed[gi] = true
+		synthetic = append(synthetic, c)
+	}
+
+	derived := arch
+	derived.Info.ID = arch.Info.ID + "-collapsed"
+	derived.Info.Name = arch.Info.Name + " (collapsed)"
+
+	// Keep the components that were not collapsed, in order, followed
+	// by the synthetic components
+	derived.Components = make([]Component, 0, len(arch.Components))
+	for _, c := range arch.Components {
+		if _, ok := mapped[c.ID]; !ok {
+			derived.Components = append(derived.Components, c)
+		}
+	}
+	derived.Components = append(derived.Components, synthetic...)
+
+	// Remap the members of the groups that remain. A group whose
+	// members were all collapsed into a single component is nested
+	// inside it and is removed.
+	derived.Groups = make([]Group, 0, len(arch.Groups))
+	for i, g := range arch.Groups {
+		if collapsed[i] {
+			continue
+		}
+		seen := make(map[string]bool, len(g.Components))
+		components := make([]string, 0, len(g.Components))
+		onlyCollapsed := true
+		for _, id := range g.Components {
+			if to, ok := mapped[id]; ok {
+				id = to
+			} else {
+				onlyCollapsed = false
+			}
+			if !seen[id] {
+				seen[id] = true
+				components = append(components, id)
+			}
+		}
+		if onlyCollapsed && len(components) <= 1 {
+			continue
+		}
+		g.Components = components
+		derived.Groups = append(derived.Groups, g)
+	}
+
+	derived.Connections = aggregateConnections(arch.Connections, mapped)
+
+	// The synthetic IDs may still clash with the IDs of the
+	// connections that were kept
+	if err := derived.isConsistent(); err != nil {
+		return Architecture{}, err
+	}
+	return derived, nil
+}
+
+// collapsedComponent returns the synthetic component that replaces the
+// group. It is placed at the centre of the members and its capacity is
+// the sum of their capacities.
+func collapsedComponent(g Group, members []Component) Component {
+	var centre [3]float64
+	var capacity Rate
+	for _, m := range members {
+		centre = vecAdd(centre, m.Object.Position)
+		capacity += m.Capacity
+	}
+	centre = vecScale(centre, 1/float64(len(members)))
+
+	// Larger groups are drawn as larger boxes
+	size := math.Max(1, math.Cbrt(float64(len(members))))
+
+	labels := make(map[string]string, len(g.Labels))
+	for k, v := range g.Labels {
+		labels[k] = v
+	}
+
+	return Component{
+		ID:   collapsedComponentPrefix + g.ID,
+		Type: "app",
+		Name: g.Name,
+		Object: Object3D{
+			Visible:  true,
+			Position: centre,
+			Scale:    [3]float64{size, size, size},
+			Geometry: "box",
+			Color:    g.BoundingBox.Color,
+		},
+		Capacity: capacity,
+		Labels:   labels,
+	}
+}
+
+// connectionAggregate accumulates the connections between two ends.
+type connectionAggregate struct {
+	source, target string
+
+	// forward and reverse are set when any connection carries data
+	// from the source to the target, or from the target to the source.
+	forward, reverse bool
+
+	outRate, inRate       Rate
+	outPackets, inPackets float64
+	latency               float64
+	capacity              Rate
+	count                 int
+}
+
+// aggregateConnections remaps the ends of the connections and merges
+// the connections that touch a collapsed group. Connections that are
+// inside a collapsed group are removed.
+func aggregateConnections(connections []Connection, mapped map[string]string) []Connection {
+	result := make([]Connection, 0, len(connections))
+	aggregates := make(map[[2]string]*connectionAggregate)
+	var order [][2]string
+
+	for _, c := range connections {
+		source, sourceMapped := mapped[c.Source]
+		if !sourceMapped {
+			source = c.Source
+		}
+		target, targetMapped := mapped[c.Target]
+		if !targetMapped {
+			target = c.Target
+		}
+
+		if !sourceMapped && !targetMapped {
+			result = append(result, c)
+			continue
+		}
+		if source == target {
+			continue
+		}
+
+		// Aggregate by the pair of ends in a fixed order so that
+		// connections in either direction are merged. The aggregate
+		// takes its direction from the first connection.
+		key := [2]string{source, target}
+		if source > target {
+			key = [2]string{target, source}
+		}
+		a, ok := aggregates[key]
+		if !ok {
+			a = &connectionAggregate{source: source, target: target}
+			aggregates[key] = a
+			order = append(order, key)
+		}
+		flipped := source != a.source
+
+		outRate, outPackets := edgeRate(c, true)
+		inRate, inPackets := edgeRate(c, false)
+		sends := c.Flow == "out" || c.Flow == "bi"
+		receives := c.Flow == "in" || c.Flow == "bi"
+		if flipped {
+			outRate, inRate = inRate, outRate
+			outPackets, inPackets = inPackets, outPackets
+			sends, receives = receives, sends
+		}
+		if sends {
+			a.forward = true
+			a.outRate += outRate
+			a.outPackets += outPackets
+		}
+		if receives {
+			a.reverse = true
+			a.inRate += inRate
+			a.inPackets += inPackets
+		}
+		a.latency = math.Max(a.latency, c.Latency)
+		a.capacity += c.Capacity
+		a.count++
+	}
+
+	for _, key := range order {
+		result = append(result, aggregates[key].connection())
+	}
+	return result
+}
+
+// connection returns the aggregate as a connection. The packet sizes
+// are the average size of the packets in each direction.
+func (a *connectionAggregate) connection() Connection {
+	flow := "bi"
+	switch {
+	case a.forward && !a.reverse:
+		flow = "out"
+	case a.reverse && !a.forward:
+		flow = "in"
+	}
+
+	c := Connection{
+		ID:       aggregateConnectionPrefix + aggregateIDEscaper.Replace(a.source) + ":" + aggregateIDEscaper.Replace(a.target),
+		Name:     fmt.Sprintf("%d connections", a.count),
+		Source:   a.source,
+		Target:   a.target,
+		Flow:     flow,
+		OutRate:  a.outRate,
+		InRate:   a.inRate,
+		Latency:  a.latency,
+		Capacity: a.capacity,
+	}
+	if a.count == 1 {
+		c.Name = "1 connection"
+	}
+	if a.outPackets > 0 {
+		c.OutPacketSize = Size(math.Round(float64(a.outRate) / a.outPackets))
+	}
+	if a.inPackets > 0 {
+		c.InPacketSize = Size(math.Round(float64(a.inRate) / a.inPackets))
+	}
+	return c
+}
+
+// handleGetCollapsed handles GET requests for a summarised view of an
+// architecture with groups collapsed into single components.
+// GET /architectures/${architectureID}/collapsed?groups=${groupID},${groupID}
+// GET /architectures/${architectureID}/collapsed?depth=${depth}
+//
+//	return a derived architecture in which the groups, or all of the
+//	groups at the nesting depth, are replaced by a component with the
+//	ID "group:${groupID}". The connections to the collapsed groups are
+//	merged into aggregate connections with the summed rates, with the
+//	ID "aggregate:${sourceID}:${targetID}". Any "%" and ":" in the IDs
+//	of the ends are escaped as "%25" and "%3A". The groups cannot be
+//	collapsed if these IDs are already used by the architecture.
+func (h *ArchitectureHandler) handleGetCollapsed(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var groupIDs []string
+	switch {
+	case query.Get("groups") != "":
+		groupIDs = strings.Split(query.Get("groups"), ",")
+	case query.Get("depth") != "":
+		depth, err := queryInt(query.Get("depth"), 0, 0, maxGroupDepth)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid depth: %v", err)
+			return
+		}
+		for i, d := range groupDepths(arch.Groups) {
+			if d == depth {
+				groupIDs = append(groupIDs, arch.Groups[i].ID)
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Missing groups or depth")
+		return
+	}
+
+	derived, err := collapseGroups(arch, groupIDs)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to collapse groups: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, derived)
+}
@@ -0,0 +1,66 @@
+package ennoea
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCollapseAggregateIDs(t *testing.T) {
+	// Without escaping, both aggregates would have the ID
+	// "aggregate:group:g:c:d"
+	arch := testArchitecture([]string{"a", "b", "c:d", "d", "e%3A"}, "a>c:d", "b>d", "a>e%3A")
+	arch.Groups = []Group{
+		{ID: "g", Components: []string{"a"}},
+		{ID: "g:c", Components: []string{"b"}},
+	}
+
+	collapsed, err := collapseGroups(arch, []string{"g", "g:c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for _, c := range collapsed.Connections {
+		if ids[c.ID] {
+			t.Errorf("duplicate connection id: %s", c.ID)
+		}
+		ids[c.ID] = true
+
+		// The ends are decoded from the ID
+		ends := strings.Split(strings.TrimPrefix(c.ID, aggregateConnectionPrefix), ":")
+		if len(ends) != 2 {
+			t.Errorf("%s: want two ends", c.ID)
+			continue
+		}
+		source, _ := url.PathUnescape(ends[0])
+		target, _ := url.PathUnescape(ends[1])
+		if source != c.Source || target != c.Target {
+			t.Errorf("%s: ends = %s and %s, want %s and %s", c.ID, source, target, c.Source, c.Target)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("connections = %v, want 3 aggregates", collapsed.Connections)
+	}
+}
+
+func TestCollapseClashingIDs(t *testing.T) {
+	// The synthetic component of g would have the ID of a real
+	// component
+	arch := testArchitecture([]string{"a", "b", "group:g"}, "a>b", "b>group:g")
+	arch.Groups = []Group{{ID: "g", Components: []string{"a", "b"}}}
+	if _, err := collapseGroups(arch, []string{"g"}); err == nil || !strings.Contains(err.Error(), "group:g") {
+		t.Errorf("collapsing g: error = %v", err)
+	}
+
+	// The aggregate of the connections from g to c would have the ID
+	// of a connection that is kept
+	arch = testArchitecture([]string{"a", "b", "c"}, "a>c", "b>c")
+	arch.Connections[1].ID = "aggregate:group%3Ag:c"
+	arch.Connections[1].Source = "c"
+	arch.Connections[1].Target = "b"
+	arch.Groups = []Group{{ID: "g", Components: []string{"a"}}}
+	if _, err := collapseGroups(arch, []string{"g"}); err == nil || !strings.Contains(err.Error(), "duplicate connection id") {
+		t.Errorf("collapsing g: error = %v", err)
+	}
+}
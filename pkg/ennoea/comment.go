@@ -0,0 +1,573 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+Comment threads are stored in a comments.json file in the directory of
+the architecture that they belong to. A thread is anchored to the
+architecture itself or to a component, group or connection by its ID.
+Because the anchor is the stable ID and not the name, threads stay
+attached when an entity is renamed.
+
+Architecture save directory structure:
+saves/
+	${architectureID}/
+		saveInfo.json
+		architecture.json
+		comments.json
+
+comments.json
+[
+	{
+		"id": "threadID",
+		"anchor": {
+			"type": "component",
+			"id": "componentID"
+		},
+		"resolved": false,
+		"comments": [
+			{
+				"id": "commentID",
+				"author": "authorName",
+				"body": "Should this talk to the database directly?",
+				"created": "2021-10-10T10:10:10Z"
+			}
+		]
+	}
+]
+*/
+
+// Anchor types that a comment thread can be attached to.
+const (
+	anchorArchitecture = "architecture"
+	anchorComponent    = "component"
+	anchorGroup        = "group"
+	anchorConnection   = "connection"
+)
+
+// errThreadNotFound is returned when a comment thread does not exist.
+var errThreadNotFound = errors.New("thread not found")
+
+// errCommentNotFound is returned when a comment does not exist.
+var errCommentNotFound = errors.New("comment not found")
+
+// CommentThread represents a discussion attached to an entity of an
+// architecture.
+type CommentThread struct {
+	// ID is the unique identifier of the thread.
+	ID string `json:"id"`
+
+	// Anchor is the entity that the thread is attached to.
+	Anchor CommentAnchor `json:"anchor"`
+
+	// Resolved determines whether or not the discussion in the
+	// thread has been resolved.
+	Resolved bool `json:"resolved"`
+
+	// ResolvedBy is the author that resolved the thread.
+	ResolvedBy string `json:"resolvedBy,omitempty"`
+
+	// ResolvedAt is the time the thread was resolved.
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+
+	// Detached is set when the anchor no longer exists in the
+	// architecture. It is computed when the thread is loaded and
+	// is not saved.
+	Detached bool `json:"detached,omitempty"`
+
+	// Comments is the list of comments in the thread. The first
+	// comment starts the thread and the rest are replies.
+	Comments []Comment `json:"comments"`
+}
+
+// CommentAnchor represents the entity that a thread is attached to.
+type CommentAnchor struct {
+	// Type is the type of the entity. The type must be either
+	// "architecture", "component", "group" or "connection".
+	Type string `json:"type"`
+
+	// ID is the ID of the entity.
+	ID string `json:"id"`
+}
+
+// Comment represents a single comment in a thread.
+type Comment struct {
+	// ID is the unique identifier of the comment.
+	ID string `json:"id"`
+
+	// Author is the name of the author of the comment.
+	Author string `json:"author"`
+
+	// Body is the text of the comment.
+	Body string `json:"body"`
+
+	// Created is the time the comment was created.
+	Created time.Time `json:"created"`
+
+	// Edited is the time the comment was last edited.
+	Edited *time.Time `json:"edited,omitempty"`
+}
+
+// isValid returns an error if the comment is invalid.
+func (c Comment) isValid() error {
+	// Check that the author is not empty
+	if strings.TrimSpace(c.Author) == "" {
+		return fmt.Errorf("invalid comment: author is empty")
+	}
+
+	// Check that the body is not empty
+	if strings.TrimSpace(c.Body) == "" {
+		return fmt.Errorf("invalid comment: body is empty")
+	}
+
+	return nil
+}
+
+// anchorExists returns true if the anchor refers to an entity in the
+// architecture.
+func (a Architecture) anchorExists(anchor CommentAnchor) bool {
+	switch anchor.Type {
+	case anchorArchitecture:
+		return anchor.ID == a.Info.ID
+	case anchorComponent:
+		return componentIDs(a.Components)[anchor.ID]
+	case anchorGroup:
+		return groupIDs(a.Groups)[anchor.ID]
+	case anchorConnection:
+		return connectionIDs(a.Connections)[anchor.ID]
+	default:
+		return false
+	}
+}
+
+// commentRequest is the body of the requests that create threads and
+// comments.
+type commentRequest struct {
+	Anchor CommentAnchor `json:"anchor"`
+	Author string        `json:"author"`
+	Body   string        `json:"body"`
+}
+
+// handleCommentsRoute handles requests for the comment threads of an
+// architecture.
+// GET /architectures/${architectureID}/comments[?type=&id=&resolved=]
+//
+//	return the threads, optionally filtered by anchor and status.
+//
+// POST /architectures/${architectureID}/comments
+//
+//	start a new thread. The body contains the anchor, author and body.
+//
+// GET /architectures/${architectureID}/comments/${threadID}
+//
+//	return a thread.
+//
+// DELETE /architectures/${architectureID}/comments/${threadID}
+//
+//	delete a thread.
+//
+// POST /architectures/${architectureID}/comments/${threadID}/replies
+//
+//	reply to a thread. The body contains the author and body.
+//
+// PUT /architectures/${architectureID}/comments/${threadID}/replies/${commentID}
+//
+//	edit the body of a comment.
+//
+// DELETE /architectures/${architectureID}/comments/${threadID}/replies/${commentID}
+//
+//	delete a reply.
+//
+// POST /architectures/${architectureID}/comments/${threadID}/resolve
+// POST /architectures/${architectureID}/comments/${threadID}/reopen
+//
+//	resolve or reopen a thread. The body contains the author.
+func (h *ArchitectureHandler) handleCommentsRoute(w http.ResponseWriter, r *http.Request, architectureID string, segments []string) {
+	if _, ok := h.architectureSave(architectureID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Architecture not found")
+		return
+	}
+
+	h.commentsMutex.Lock()
+	defer h.commentsMutex.Unlock()
+
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		h.handleGetThreads(w, r, architectureID)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		h.handlePostThread(w, r, architectureID)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		h.handleGetThread(w, r, architectureID, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		h.handleDeleteThread(w, r, architectureID, segments[0])
+	case len(segments) == 2 && segments[1] == "replies" && r.Method == http.MethodPost:
+		h.handlePostReply(w, r, architectureID, segments[0])
+	case len(segments) == 3 && segments[1] == "replies" && r.Method == http.MethodPut:
+		h.handlePutReply(w, r, architectureID, segments[0], segments[2])
+	case len(segments) == 3 && segments[1] == "replies" && r.Method == http.MethodDelete:
+		h.handleDeleteReply(w, r, architectureID, segments[0], segments[2])
+	case len(segments) == 2 && segments[1] == "resolve" && r.Method == http.MethodPost:
+		h.handleResolveThread(w, r, architectureID, segments[0], true)
+	case len(segments) == 2 && segments[1] == "reopen" && r.Method == http.MethodPost:
+		h.handleResolveThread(w, r, architectureID, segments[0], false)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+	}
+}
+
+// handleGetThreads handles GET requests for the threads of an
+// architecture. The threads can be filtered by the anchor type and ID
+// and by whether or not they are resolved.
+func (h *ArchitectureHandler) handleGetThreads(w http.ResponseWriter, r *http.Request, architectureID string) {
+	threads, err := h.loadThreads(architectureID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load comments: %v", err)
+		return
+	}
+
+	query := r.URL.Query()
+	filtered := make([]CommentThread, 0, len(threads))
+	for _, thread := range threads {
+		if t := query.Get("type"); t != "" && thread.Anchor.Type != t {
+			continue
+		}
+		if id := query.Get("id"); id != "" && thread.Anchor.ID != id {
+			continue
+		}
+		if resolved := query.Get("resolved"); resolved != "" && fmt.Sprint(thread.Resolved) != resolved {
+			continue
+		}
+		filtered = append(filtered, thread)
+	}
+
+	writeJSONResponse(w, http.StatusOK, filtered)
+}
+
+// handlePostThread handles POST requests to start a new thread. The
+// anchor must refer to an entity that exists in the architecture.
+func (h *ArchitectureHandler) handlePostThread(w http.ResponseWriter, r *http.Request, architectureID string) {
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to load comment: %v", err)
+		return
+	}
+
+	// Threads on the architecture itself do not need to repeat the ID
+	if req.Anchor.Type == anchorArchitecture && req.Anchor.ID == "" {
+		req.Anchor.ID = architectureID
+	}
+
+	// Check that the anchor exists
+	arch, err := h.loadArchitectureByID(architectureID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load architecture: %v", err)
+		return
+	}
+	if !arch.anchorExists(req.Anchor) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid anchor: %s %s does not exist", req.Anchor.Type, req.Anchor.ID)
+		return
+	}
+
+	comment := Comment{
+		ID:      generateID(),
+		Author:  req.Author,
+		Body:    req.Body,
+		Created: time.Now(),
+	}
+	if err := comment.isValid(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid comment: %v", err)
+		return
+	}
+
+	thread := CommentThread{
+		ID:       generateID(),
+		Anchor:   req.Anchor,
+		Comments: []Comment{comment},
+	}
+
+	threads, err := h.loadThreads(architectureID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load comments: %v", err)
+		return
+	}
+	threads = append(threads, thread)
+	if err := h.saveThreads(architectureID, threads); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save comments: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, thread)
+}
+
+// handleGetThread handles GET requests for a single thread.
+func (h *ArchitectureHandler) handleGetThread(w http.ResponseWriter, r *http.Request, architectureID, threadID string) {
+	threads, err := h.loadThreads(architectureID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load comments: %v", err)
+		return
+	}
+
+	i := findThread(threads, threadID)
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Thread not found")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, threads[i])
+}
+
+// handleDeleteThread handles DELETE requests for a single thread.
+func (h *ArchitectureHandler) handleDeleteThread(w http.ResponseWriter, r *http.Request, architectureID, threadID string) {
+	h.updateThreads(w, architectureID, func(threads []CommentThread) ([]CommentThread, interface{}, error) {
+		i := findThread(threads, threadID)
+		if i < 0 {
+			return nil, nil, errThreadNotFound
+		}
+		return append(threads[:i], threads[i+1:]...), nil, nil
+	})
+}
+
+// handlePostReply handles POST requests to reply to a thread.
+func (h *ArchitectureHandler) handlePostReply(w http.ResponseWriter, r *http.Request, architectureID, threadID string) {
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to load comment: %v", err)
+		return
+	}
+
+	comment := Comment{
+		ID:      generateID(),
+		Author:  req.Author,
+		Body:    req.Body,
+		Created: time.Now(),
+	}
+	if err := comment.isValid(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid comment: %v", err)
+		return
+	}
+
+	h.updateThreads(w, architectureID, func(threads []CommentThread) ([]CommentThread, interface{}, error) {
+		i := findThread(threads, threadID)
+		if i < 0 {
+			return nil, nil, errThreadNotFound
+		}
+		threads[i].Comments = append(threads[i].Comments, comment)
+		return threads, threads[i], nil
+	})
+}
+
+// handlePutReply handles PUT requests to edit the body of a comment.
+func (h *ArchitectureHandler) handlePutReply(w http.ResponseWriter, r *http.Request, architectureID, threadID, commentID string) {
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to load comment: %v", err)
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid comment: body is empty")
+		return
+	}
+
+	h.updateThreads(w, architectureID, func(threads []CommentThread) ([]CommentThread, interface{}, error) {
+		i := findThread(threads, threadID)
+		if i < 0 {
+			return nil, nil, errThreadNotFound
+		}
+		j := findComment(threads[i].Comments, commentID)
+		if j < 0 {
+			return nil, nil, errCommentNotFound
+		}
+		now := time.Now()
+		threads[i].Comments[j].Body = req.Body
+		threads[i].Comments[j].Edited = &now
+		return threads, threads[i], nil
+	})
+}
+
+// handleDeleteReply handles DELETE requests for a reply. The first
+// comment of a thread cannot be deleted on its own, the whole thread
+// must be deleted instead.
+func (h *ArchitectureHandler) handleDeleteReply(w http.ResponseWriter, r *http.Request, architectureID, threadID, commentID string) {
+	h.updateThreads(w, architectureID, func(threads []CommentThread) ([]CommentThread, interface{}, error) {
+		i := findThread(threads, threadID)
+		if i < 0 {
+			return nil, nil, errThreadNotFound
+		}
+		j := findComment(threads[i].Comments, commentID)
+		if j < 0 {
+			return nil, nil, errCommentNotFound
+		}
+		if j == 0 {
+			return nil, nil, fmt.Errorf("the first comment of a thread cannot be deleted")
+		}
+		comments := threads[i].Comments
+		threads[i].Comments = append(comments[:j], comments[j+1:]...)
+		return threads, threads[i], nil
+	})
+}
+
+// handleResolveThread handles POST requests to resolve or reopen a
+// thread.
+func (h *ArchitectureHandler) handleResolveThread(w http.ResponseWriter, r *http.Request, architectureID, threadID string, resolved bool) {
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to load request: %v", err)
+		return
+	}
+	if resolved && strings.TrimSpace(req.Author) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid request: author is empty")
+		return
+	}
+
+	h.updateThreads(w, architectureID, func(threads []CommentThread) ([]CommentThread, interface{}, error) {
+		i := findThread(threads, threadID)
+		if i < 0 {
+			return nil, nil, errThreadNotFound
+		}
+		threads[i].Resolved = resolved
+		threads[i].ResolvedBy = ""
+		threads[i].ResolvedAt = nil
+		if resolved {
+			now := time.Now()
+			threads[i].ResolvedBy = req.Author
+			threads[i].ResolvedAt = &now
+		}
+		return threads, threads[i], nil
+	})
+}
+
+// updateThreads loads the threads of the architecture, applies the
+// update and saves the result. The value returned by the update is
+// written to the response, or no content is written if it is nil.
+func (h *ArchitectureHandler) updateThreads(w http.ResponseWriter, architectureID string, update func([]CommentThread) ([]CommentThread, interface{}, error)) {
+	threads, err := h.loadThreads(architectureID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load comments: %v", err)
+		return
+	}
+
+	threads, response, err := update(threads)
+	if errors.Is(err, errThreadNotFound) || errors.Is(err, errCommentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Failed to update comments: %v", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to update comments: %v", err)
+		return
+	}
+
+	if err := h.saveThreads(architectureID, threads); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save comments: %v", err)
+		return
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// findThread returns the index of the thread with the ID, or -1 if
+// there is no such thread.
+func findThread(threads []CommentThread, threadID string) int {
+	for i, thread := range threads {
+		if thread.ID == threadID {
+			return i
+		}
+	}
+	return -1
+}
+
+// findComment returns the index of the comment with the ID, or -1 if
+// there is no such comment.
+func findComment(comments []Comment, commentID string) int {
+	for i, comment := range comments {
+		if comment.ID == commentID {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadThreads loads the comment threads of the architecture. An
+// architecture without a comments file has no threads. Threads whose
+// anchor no longer exists are marked as detached.
+func (h *ArchitectureHandler) loadThreads(architectureID string) ([]CommentThread, error) {
+	filePath := filepath.Join(h.filePath, architectureID, "comments.json")
+	file, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return []CommentThread{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read comments file: %v", err)
+	}
+
+	var threads []CommentThread
+	err = json.Unmarshal(file, &threads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal comments file: %v", err)
+	}
+
+	// Mark the threads whose anchor has been removed. A broken
+	// overlay cannot be loaded, in which case nothing is marked.
+	if arch, err := h.loadArchitectureByID(architectureID); err == nil {
+		for i := range threads {
+			threads[i].Detached = !arch.anchorExists(threads[i].Anchor)
+		}
+	}
+
+	return threads, nil
+}
+
+// saveThreads saves the comment threads of the architecture.
+func (h *ArchitectureHandler) saveThreads(architectureID string, threads []CommentThread) error {
+	// Detached is computed on load so it is not saved
+	for i := range threads {
+		threads[i].Detached = false
+	}
+
+	file, err := json.Marshal(threads)
+	if err != nil {
+		return fmt.Errorf("failed to marshal comments: %v", err)
+	}
+
+	filePath := filepath.Join(h.filePath, architectureID, "comments.json")
+	err = os.WriteFile(filePath, file, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save comments file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTestHandler creates an architecture handler with a policy store
+// in temporary directories, with the architecture in testdata/shop.json
+// saved as "shop".
+func newTestHandler(t *testing.T) (*ArchitectureHandler, *PolicyHandler) {
+	t.Helper()
+	h, err := NewArchitectureHandler(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := NewPolicyHandler(t.TempDir())
+	h.SetPolicies(policies)
+
+	file, err := os.ReadFile("testdata/shop.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveTest(h, http.MethodPut, "/architectures/", string(file))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT shop returned %d: %s", w.Code, w.Body)
+	}
+	return h, policies
+}
+
+// serveTest serves a request with the body and returns the response.
+func serveTest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+// serveTestJSON serves a request, checks the status of the response
+// and decodes its body into v.
+func serveTestJSON(t *testing.T, h http.Handler, method, target, body string, status int, v interface{}) {
+	t.Helper()
+	w := serveTest(h, method, target, body)
+	if w.Code != status {
+		t.Fatalf("%s %s returned %d, want %d: %s", method, target, w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s returned an invalid body: %v", method, target, err)
+		}
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	h, _ := newTestHandler(t)
+	const comments = "/architectures/shop/comments"
+
+	var thread CommentThread
+	serveTestJSON(t, h, http.MethodPost, comments, `{"anchor": {"type": "component", "id": "web"}, "author": "ann", "body": "Cache this?"}`, http.StatusCreated, &thread)
+	if thread.Anchor != (CommentAnchor{Type: "component", ID: "web"}) || len(thread.Comments) != 1 || thread.Comments[0].Author != "ann" {
+		t.Fatalf("POST thread returned %+v", thread)
+	}
+	var other CommentThread
+	serveTestJSON(t, h, http.MethodPost, comments, `{"anchor": {"type": "architecture"}, "author": "bob", "body": "Looks good"}`, http.StatusCreated, &other)
+	if other.Anchor.ID != "shop" {
+		t.Errorf("architecture anchor ID = %q, want shop", other.Anchor.ID)
+	}
+
+	threadPath := comments + "/" + thread.ID
+	serveTestJSON(t, h, http.MethodPost, threadPath+"/replies", `{"author": "bob", "body": "Yes"}`, http.StatusOK, &thread)
+	if len(thread.Comments) != 2 {
+		t.Fatalf("thread has %d comments after a reply, want 2", len(thread.Comments))
+	}
+	reply := thread.Comments[1]
+	serveTestJSON(t, h, http.MethodPut, threadPath+"/replies/"+reply.ID, `{"body": "Yes, in Redis"}`, http.StatusOK, &thread)
+	if got := thread.Comments[1]; got.Body != "Yes, in Redis" || got.Edited == nil || got.Author != "bob" {
+		t.Errorf("edited reply = %+v", got)
+	}
+
+	serveTestJSON(t, h, http.MethodPost, threadPath+"/resolve", `{}`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, threadPath+"/resolve", `{"author": "ann"}`, http.StatusOK, &thread)
+	if !thread.Resolved || thread.ResolvedBy != "ann" || thread.ResolvedAt == nil {
+		t.Errorf("resolved thread = %+v", thread)
+	}
+
+	var threads []CommentThread
+	serveTestJSON(t, h, http.MethodGet, comments+"?resolved=true", "", http.StatusOK, &threads)
+	if len(threads) != 1 || threads[0].ID != thread.ID {
+		t.Errorf("resolved threads = %+v", threads)
+	}
+	serveTestJSON(t, h, http.MethodGet, comments+"?type=architecture", "", http.StatusOK, &threads)
+	if len(threads) != 1 || threads[0].ID != other.ID {
+		t.Errorf("architecture threads = %+v", threads)
+	}
+
+	var reopened CommentThread
+	serveTestJSON(t, h, http.MethodPost, threadPath+"/reopen", "", http.StatusOK, &reopened)
+	if reopened.Resolved || reopened.ResolvedBy != "" || reopened.ResolvedAt != nil {
+		t.Errorf("reopened thread = %+v", reopened)
+	}
+
+	serveTestJSON(t, h, http.MethodDelete, threadPath+"/replies/"+thread.Comments[0].ID, "", http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodDelete, threadPath+"/replies/"+reply.ID, "", http.StatusOK, &thread)
+	if len(thread.Comments) != 1 {
+		t.Errorf("thread has %d comments after deleting the reply, want 1", len(thread.Comments))
+	}
+	serveTestJSON(t, h, http.MethodDelete, threadPath+"/replies/"+reply.ID, "", http.StatusNotFound, nil)
+
+	serveTestJSON(t, h, http.MethodDelete, threadPath, "", http.StatusNoContent, nil)
+	serveTestJSON(t, h, http.MethodGet, threadPath, "", http.StatusNotFound, nil)
+	serveTestJSON(t, h, http.MethodGet, comments, "", http.StatusOK, &threads)
+	if len(threads) != 1 || threads[0].ID != other.ID {
+		t.Errorf("threads after deleting one = %+v", threads)
+	}
+}
+
+func TestCommentThreadValidation(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		name, target, body string
+		status             int
+	}{
+		{"missing entity", "/architectures/shop/comments", `{"anchor": {"type": "component", "id": "cache"}, "author": "ann", "body": "Hi"}`, http.StatusBadRequest},
+		{"wrong type", "/architectures/shop/comments", `{"anchor": {"type": "group", "id": "web"}, "author": "ann", "body": "Hi"}`, http.StatusBadRequest},
+		{"empty author", "/architectures/shop/comments", `{"anchor": {"type": "group", "id": "backend"}, "author": " ", "body": "Hi"}`, http.StatusBadRequest},
+		{"empty body", "/architectures/shop/comments", `{"anchor": {"type": "connection", "id": "api-db"}, "author": "ann", "body": ""}`, http.StatusBadRequest},
+		{"invalid JSON", "/architectures/shop/comments", `{`, http.StatusBadRequest},
+		{"missing architecture", "/architectures/none/comments", `{"anchor": {"type": "architecture"}, "author": "ann", "body": "Hi"}`, http.StatusNotFound},
+		{"missing thread", "/architectures/shop/comments/none/replies", `{"author": "ann", "body": "Hi"}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if w := serveTest(h, http.MethodPost, test.target, test.body); w.Code != test.status {
+			t.Errorf("%s: POST returned %d, want %d: %s", test.name, w.Code, test.status, w.Body)
+		}
+	}
+}
+
+func TestCommentThreadDetached(t *testing.T) {
+	h, _ := newTestHandler(t)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/comments", `{"anchor": {"type": "connection", "id": "web-api"}, "author": "ann", "body": "Use gRPC?"}`, http.StatusCreated, nil)
+
+	// Remove the connection from the architecture
+	arch, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch.Connections = arch.Connections[1:]
+	body, _ := json.Marshal(arch)
+	serveTestJSON(t, h, http.MethodPut, "/architectures/", string(body), http.StatusOK, nil)
+
+	var threads []CommentThread
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/comments", "", http.StatusOK, &threads)
+	if len(threads) != 1 || !threads[0].Detached {
+		t.Errorf("threads after removing the anchor = %+v", threads)
+	}
+}
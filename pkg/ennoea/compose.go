@@ -0,0 +1,387 @@
+package ennoea
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+The compose importer reads docker-compose files. Each service becomes
+a component, and each network becomes a group of the services that are
+attached to it. Services without networks are attached to the default
+network, like they are by docker compose.
+
+The depends_on and links of a service become connections from the
+service to the services it uses. The published ports of a service are
+recorded on a connection from an "external" component that stands for
+the clients outside of the compose project, and the ports that a
+service listens on are recorded on the connections to it:
+
+	labels.ports      the ports of the target, for example "8080:80/tcp"
+	labels.links      the aliases of a link
+	labels.condition  the condition of a depends_on
+*/
+
+const (
+	// composeDefaultNetwork is the network that services without any
+	// networks are attached to.
+	composeDefaultNetwork = "default"
+
+	// composeExternalID is the ID of the component that stands for
+	// the clients of the published ports.
+	composeExternalID = "external"
+)
+
+// composeFile represents the parts of a docker-compose file that are
+// imported.
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+	Networks map[string]composeNetwork `yaml:"networks"`
+}
+
+// composeService represents a service of a docker-compose file.
+type composeService struct {
+	Image         string            `yaml:"image"`
+	Build         yaml.Node         `yaml:"build"`
+	ContainerName string            `yaml:"container_name"`
+	DependsOn     composeDependsOn  `yaml:"depends_on"`
+	Links         []string          `yaml:"links"`
+	Networks      composeKeys       `yaml:"networks"`
+	NetworkMode   string            `yaml:"network_mode"`
+	Ports         []composePort     `yaml:"ports"`
+	Expose        []composePortText `yaml:"expose"`
+	Labels        composeLabels     `yaml:"labels"`
+	Deploy        struct {
+		Replicas *int `yaml:"replicas"`
+	} `yaml:"deploy"`
+}
+
+// composeNetwork represents a network of a docker-compose file.
+type composeNetwork struct {
+	Name     string        `yaml:"name"`
+	Driver   string        `yaml:"driver"`
+	External yaml.Node     `yaml:"external"`
+	Labels   composeLabels `yaml:"labels"`
+}
+
+// composeKeys is a list of names that can be written as a sequence or
+// as the keys of a mapping.
+type composeKeys []string
+
+// UnmarshalYAML decodes the names from a sequence or a mapping.
+func (k *composeKeys) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		*k = names
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			*k = append(*k, node.Content[i].Value)
+		}
+	default:
+		return fmt.Errorf("line %d: expected a list or a map", node.Line)
+	}
+	return nil
+}
+
+// composeDependsOn is the depends_on of a service, which is a list of
+// services or a map of services to their conditions.
+type composeDependsOn struct {
+	services   []string
+	conditions map[string]string
+}
+
+// UnmarshalYAML decodes the services from a sequence or a mapping.
+func (d *composeDependsOn) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return node.Decode(&d.services)
+	case yaml.MappingNode:
+		d.conditions = make(map[string]string)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var dependency struct {
+				Condition string `yaml:"condition"`
+			}
+			if err := node.Content[i+1].Decode(&dependency); err != nil {
+				return err
+			}
+			name := node.Content[i].Value
+			d.services = append(d.services, name)
+			d.conditions[name] = dependency.Condition
+		}
+		return nil
+	default:
+		return fmt.Errorf("line %d: expected a list or a map", node.Line)
+	}
+}
+
+// composeLabels is a map of labels that can be written as a mapping
+// or as a sequence of "key=value" strings.
+type composeLabels map[string]string
+
+// UnmarshalYAML decodes the labels from a mapping or a sequence.
+func (l *composeLabels) UnmarshalYAML(node *yaml.Node) error {
+	labels := make(map[string]string)
+	switch node.Kind {
+	case yaml.MappingNode:
+		if err := node.Decode(&labels); err != nil {
+			return err
+		}
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			key, value, _ := strings.Cut(item, "=")
+			labels[key] = value
+		}
+	default:
+		return fmt.Errorf("line %d: expected a list or a map", node.Line)
+	}
+	*l = labels
+	return nil
+}
+
+// composePortText is a port that can be written as a number or a
+// string.
+type composePortText string
+
+// UnmarshalYAML decodes the port from a scalar.
+func (p *composePortText) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a port", node.Line)
+	}
+	*p = composePortText(node.Value)
+	return nil
+}
+
+// composePort is a port mapping of a service. The short syntax is
+// "[host_ip:][published:]target[/protocol]" and the long syntax is a
+// mapping with the same fields.
+type composePort struct {
+	HostIP    string `yaml:"host_ip"`
+	Published string `yaml:"published"`
+	Target    string `yaml:"target"`
+	Protocol  string `yaml:"protocol"`
+}
+
+// UnmarshalYAML decodes the port from the short or long syntax.
+func (p *composePort) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		spec := node.Value
+		if i := strings.LastIndex(spec, "/"); i >= 0 {
+			p.Protocol = spec[i+1:]
+			spec = spec[:i]
+		}
+		parts := strings.Split(spec, ":")
+		p.Target = parts[len(parts)-1]
+		if len(parts) >= 2 {
+			p.Published = parts[len(parts)-2]
+		}
+		if len(parts) >= 3 {
+			p.HostIP = strings.Join(parts[:len(parts)-2], ":")
+		}
+	case yaml.MappingNode:
+		type port composePort
+		if err := node.Decode((*port)(p)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: expected a port", node.Line)
+	}
+	if p.Target == "" {
+		return fmt.Errorf("line %d: port is missing a target", node.Line)
+	}
+	return nil
+}
+
+// String returns the port in the short syntax without the host IP.
+func (p composePort) String() string {
+	s := p.Target
+	if p.Published != "" {
+		s = p.Published + ":" + s
+	}
+	if p.Protocol != "" {
+		s += "/" + p.Protocol
+	}
+	return s
+}
+
+// importCompose imports a docker-compose file.
+func importCompose(data, config []byte) (ImportResult, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to parse compose file: %v", err)
+	}
+	if len(file.Services) == 0 {
+		return ImportResult{}, fmt.Errorf("compose file has no services")
+	}
+
+	var result ImportResult
+	arch := &result.Architecture
+	arch.Info.Name = file.Name
+	arch.Info.Description = "Imported from a docker-compose file"
+
+	names := sortedKeys(file.Services)
+
+	// Each service becomes a component
+	members := make(map[string][]string)
+	for _, name := range names {
+		service := file.Services[name]
+		c := newImportedComponent(name, name, "app")
+		if service.ContainerName != "" {
+			c.Name = service.ContainerName
+		}
+		for k, v := range service.Labels {
+			c.Labels[k] = v
+		}
+		if service.Image != "" {
+			c.Labels["image"] = service.Image
+		}
+		if !service.Build.IsZero() {
+			c.Labels["build"] = "true"
+		}
+		if service.Deploy.Replicas != nil {
+			c.Labels["replicas"] = strconv.Itoa(*service.Deploy.Replicas)
+		}
+		if service.NetworkMode != "" {
+			c.Labels["network_mode"] = service.NetworkMode
+		}
+		if ports := composeServicePorts(service); ports != "" {
+			c.Labels["ports"] = ports
+		}
+		arch.Components = append(arch.Components, c)
+
+		// Services that share the network of the host or another
+		// container are not attached to any compose networks
+		networks := service.Networks
+		if len(networks) == 0 && service.NetworkMode == "" {
+			networks = composeKeys{composeDefaultNetwork}
+		}
+		for _, network := range networks {
+			members[network] = append(members[network], name)
+		}
+	}
+
+	// Each network with services becomes a group
+	for _, network := range sortedKeys(members) {
+		definition, defined := file.Networks[network]
+		if !defined && network != composeDefaultNetwork {
+			result.warnf("network %s is not defined", network)
+		}
+		g := newImportedGroup(network, network, len(arch.Groups))
+		if definition.Name != "" {
+			g.Name = definition.Name
+		}
+		for k, v := range definition.Labels {
+			g.Labels[k] = v
+		}
+		if definition.Driver != "" {
+			g.Labels["driver"] = definition.Driver
+		}
+		if !definition.External.IsZero() {
+			g.Labels["external"] = "true"
+		}
+		g.Components = members[network]
+		arch.Groups = append(arch.Groups, g)
+	}
+
+	// The depends_on and links of each service become connections
+	connections := make(map[string]int)
+	connect := func(source, target, name string) *Connection {
+		id := source + ":" + target
+		if i, ok := connections[id]; ok {
+			return &arch.Connections[i]
+		}
+		c := newImportedConnection(id, name, source, target)
+		if ports := composeServicePorts(file.Services[target]); ports != "" {
+			c.Labels["ports"] = ports
+		}
+		connections[id] = len(arch.Connections)
+		arch.Connections = append(arch.Connections, c)
+		return &arch.Connections[len(arch.Connections)-1]
+	}
+	for _, name := range names {
+		service := file.Services[name]
+		for _, target := range service.DependsOn.services {
+			if _, ok := file.Services[target]; !ok {
+				result.warnf("service %s depends on unknown service %s", name, target)
+				continue
+			}
+			c := connect(name, target, name+" depends on "+target)
+			if condition := service.DependsOn.conditions[target]; condition != "" {
+				c.Labels["condition"] = condition
+			}
+		}
+		for _, link := range service.Links {
+			target, alias, _ := strings.Cut(link, ":")
+			if _, ok := file.Services[target]; !ok {
+				result.warnf("service %s links to unknown service %s", name, target)
+				continue
+			}
+			c := connect(name, target, name+" links to "+target)
+			if alias != "" {
+				c.Labels["links"] = alias
+			}
+		}
+		if strings.HasPrefix(service.NetworkMode, "service:") {
+			target := strings.TrimPrefix(service.NetworkMode, "service:")
+			if _, ok := file.Services[target]; !ok {
+				result.warnf("service %s shares the network of unknown service %s", name, target)
+			}
+		}
+	}
+
+	// The published ports become connections from the external
+	// clients. The ID of the external component must not be the name
+	// of a service.
+	external := ""
+	for _, name := range names {
+		var published []string
+		for _, port := range file.Services[name].Ports {
+			if port.Published != "" {
+				published = append(published, port.String())
+			}
+		}
+		if len(published) == 0 {
+			continue
+		}
+		if external == "" {
+			external = composeExternalID
+			for {
+				if _, ok := file.Services[external]; !ok {
+					break
+				}
+				external += "_"
+			}
+			arch.Components = append(arch.Components, newExternalComponent(external))
+		}
+		c := newImportedConnection(external+":"+name, "published ports of "+name, external, name)
+		c.Labels["ports"] = strings.Join(published, ",")
+		arch.Connections = append(arch.Connections, c)
+	}
+
+	return result, nil
+}
+
+// composeServicePorts returns the ports that the service listens on,
+// from its port mappings and exposed ports, separated by commas.
+func composeServicePorts(service composeService) string {
+	var ports []string
+	for _, port := range service.Ports {
+		ports = append(ports, port.String())
+	}
+	for _, port := range service.Expose {
+		ports = append(ports, string(port))
+	}
+	return strings.Join(ports, ",")
+}
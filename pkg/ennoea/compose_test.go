@@ -0,0 +1,187 @@
+package ennoea
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestImportCompose(t *testing.T) {
+	testImports(t, "compose", []importTest{
+		{
+			name: "services on the default network",
+			data: `
+services:
+  web:
+    image: nginx
+    ports: ["8080:80"]
+    depends_on: [api]
+  api:
+    build: .
+    expose: [9000]
+`,
+			want: []string{
+				`component api "api" box`,
+				`component web "web" box`,
+				`component external "External" sphere`,
+				`group default api,web`,
+				`connection web:api out`,
+				`connection external:web out`,
+			},
+		},
+		{
+			name: "networks, conditions and links",
+			data: `
+name: shop
+services:
+  api:
+    networks: [front, back]
+    depends_on:
+      db:
+        condition: service_healthy
+    links: ["db:database", "cache"]
+  db:
+    networks:
+      back: {}
+  cache:
+    network_mode: host
+networks:
+  front:
+  back:
+    driver: overlay
+`,
+			want: []string{
+				`component api "api" box`,
+				`component cache "cache" box`,
+				`component db "db" box`,
+				`group back api,db`,
+				`group front api`,
+				`connection api:db out`,
+				`connection api:cache out`,
+			},
+		},
+		{
+			name: "unknown services and networks",
+			data: `
+services:
+  api:
+    networks: [missing]
+    depends_on: [db]
+    links: [cache]
+    network_mode: service:proxy
+`,
+			want: []string{
+				`component api "api" box`,
+				`group missing api`,
+				`warning network missing is not defined`,
+				`warning service api depends on unknown service db`,
+				`warning service api links to unknown service cache`,
+				`warning service api shares the network of unknown service proxy`,
+			},
+		},
+		{
+			name: "service named external",
+			data: `
+services:
+  external:
+    ports: ["443:443"]
+`,
+			want: []string{
+				`component external "external" box`,
+				`component external_ "External" sphere`,
+				`group default external`,
+				`connection external_:external out`,
+			},
+		},
+		{name: "no services", data: `name: empty`, err: "compose file has no services"},
+		{name: "invalid YAML", data: `services: [`, err: "failed to parse compose file"},
+		{name: "port without a target", data: "services:\n  api:\n    ports: [{published: 80}]\n", err: "port is missing a target"},
+	})
+}
+
+func TestImportComposeLabels(t *testing.T) {
+	data := `
+services:
+  web:
+    container_name: shop-web
+    image: nginx
+    labels: ["tier=front"]
+    deploy:
+      replicas: 3
+    ports:
+      - "127.0.0.1:8080:80/tcp"
+      - target: 443
+        published: "8443"
+    depends_on:
+      api:
+        condition: service_started
+    links: ["api:backend"]
+  api:
+    expose: ["9000"]
+    labels:
+      tier: back
+networks:
+  default:
+    external: true
+`
+	result, err := Import("compose", []byte(data), ImportOptions{ID: "shop", Name: "Shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := result.Architecture
+	if arch.Info.ID != "shop" || arch.Info.Name != "Shop" {
+		t.Errorf("info = %+v", arch.Info)
+	}
+
+	web := arch.Components[1]
+	want := map[string]string{"tier": "front", "image": "nginx", "replicas": "3", "ports": "8080:80/tcp,8443:443"}
+	if web.Name != "shop-web" || !reflect.DeepEqual(web.Labels, want) {
+		t.Errorf("web = %q with labels %v", web.Name, web.Labels)
+	}
+	if got := arch.Groups[0].Labels; !reflect.DeepEqual(got, map[string]string{"external": "true"}) {
+		t.Errorf("default network labels = %v", got)
+	}
+	want = map[string]string{"ports": "9000", "condition": "service_started", "links": "backend"}
+	if got := arch.Connections[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("web:api labels = %v, want %v", got, want)
+	}
+	want = map[string]string{"ports": "8080:80/tcp,8443:443"}
+	if got := arch.Connections[1].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("external:web labels = %v, want %v", got, want)
+	}
+}
+
+func TestPostImport(t *testing.T) {
+	h, _ := newTestHandler(t)
+	imports := NewImportHandler(h)
+	const data = "services:\n  web:\n    depends_on: [api]\n  api: {}\n"
+
+	var result ImportResult
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?id=app&preview=true", data, http.StatusOK, &result)
+	if result.Architecture.Info.ID != "app" {
+		t.Errorf("previewed ID = %q, want app", result.Architecture.Info.ID)
+	}
+	if _, err := h.loadArchitectureByID("app"); err == nil {
+		t.Errorf("previewed architecture was saved")
+	}
+
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?id=app&name=App", data, http.StatusOK, &result)
+	saved, err := h.loadArchitectureByID("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Info.Name != "App" || len(saved.Components) != 2 || len(saved.Connections) != 1 {
+		t.Errorf("saved architecture = %v", summariseNeighbourhood(saved))
+	}
+
+	// Existing architectures are only updated when asked
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?id=shop", data, http.StatusConflict, nil)
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?id=shop&update=true", data, http.StatusOK, nil)
+
+	serveTestJSON(t, imports, http.MethodPost, "/import/swarm", data, http.StatusNotFound, nil)
+	serveTestJSON(t, imports, http.MethodGet, "/import/compose", "", http.StatusMethodNotAllowed, nil)
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?update=maybe", data, http.StatusBadRequest, nil)
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?id=../app", data, http.StatusBadRequest, nil)
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose", "services: {}", http.StatusBadRequest, nil)
+	serveTestJSON(t, imports, http.MethodPost, "/import/compose?layout=spiral", data, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,268 @@
+package ennoea
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Diagram importers read the boxes and arrows of drawing languages, like
+Mermaid, PlantUML and DOT. The parsers fill in a diagram of nodes, edges and
+nested groups, which is then turned into an architecture. Groups can be
+the ends of edges as well, in which case they also become components
+inside their own group.
+*/
+
+// diagram holds the nodes, edges and groups of a diagram while it is
+// parsed.
+type diagram struct {
+	result *ImportResult
+	nodes  []*diagramNode
+	index  map[string]*diagramNode
+	groups []*diagramGroup
+	byID   map[string]*diagramGroup
+	edges  []*diagramEdge
+}
+
+// diagramNode is a node of a diagram.
+type diagramNode struct {
+	id            string
+	name          string
+	componentType string
+	geometry      string
+	color         string
+	labels        map[string]string
+	group         *diagramGroup
+
+	// position is the position of the node, if the diagram has one.
+	position *[3]float64
+}
+
+// diagramGroup is a group of nodes, such as a subgraph or a package.
+type diagramGroup struct {
+	id     string
+	name   string
+	color  string
+	labels map[string]string
+	parent *diagramGroup
+}
+
+// diagramEdge is an edge between two nodes or groups.
+type diagramEdge struct {
+	source, target string
+	name           string
+	flow           string
+	labels         map[string]string
+}
+
+// newDiagram returns an empty diagram.
+func newDiagram(result *ImportResult) *diagram {
+	return &diagram{
+		result: result,
+		index:  make(map[string]*diagramNode),
+		byID:   make(map[string]*diagramGroup),
+	}
+}
+
+// node returns the node with the ID, adding it to the group if it is
+// new, or if it was first seen outside of any group.
+func (d *diagram) node(id string, group *diagramGroup) *diagramNode {
+	n, ok := d.index[id]
+	if !ok {
+		n = &diagramNode{id: id, name: id, componentType: "app", labels: make(map[string]string)}
+		d.nodes = append(d.nodes, n)
+		d.index[id] = n
+	}
+	if n.group == nil {
+		n.group = group
+	}
+	return n
+}
+
+// endpoint returns the ID of the end of an edge. A new node is added
+// for the ID unless it is a group.
+func (d *diagram) endpoint(id string, group *diagramGroup) string {
+	if _, ok := d.byID[id]; !ok {
+		d.node(id, group)
+	}
+	return id
+}
+
+// group adds a group inside the parent group. A group with the same
+// ID as an earlier one is the same group.
+func (d *diagram) group(id, name string, parent *diagramGroup) *diagramGroup {
+	if g, ok := d.byID[id]; ok {
+		return g
+	}
+	if name == "" {
+		name = id
+	}
+	g := &diagramGroup{id: id, name: name, parent: parent, labels: make(map[string]string)}
+	d.groups = append(d.groups, g)
+	d.byID[id] = g
+	return g
+}
+
+// edge adds an edge.
+func (d *diagram) edge(source, target, name, flow string) *diagramEdge {
+	e := &diagramEdge{
+		source: source,
+		target: target,
+		name:   name,
+		flow:   flow,
+		labels: make(map[string]string),
+	}
+	d.edges = append(d.edges, e)
+	return e
+}
+
+// architecture adds the components, groups and connections of the
+// diagram to the architecture of the result.
+func (d *diagram) architecture() {
+	arch := &d.result.Architecture
+
+	// Groups that are the ends of edges become components as well
+	for _, e := range d.edges {
+		for _, id := range []string{e.source, e.target} {
+			if g, ok := d.byID[id]; ok {
+				if _, ok := d.index[id]; !ok {
+					n := d.node(id, g)
+					n.name = g.name
+				}
+			}
+		}
+	}
+
+	for _, n := range d.nodes {
+		c := newImportedComponent(n.id, n.name, n.componentType)
+		if c.Name == "" {
+			c.Name = n.id
+		}
+		if n.geometry != "" {
+			c.Object.Geometry = n.geometry
+		}
+		if n.color != "" {
+			c.Object.Color = n.color
+		}
+		if n.position != nil {
+			c.Object.Position = *n.position
+			d.result.positioned = append(d.result.positioned, n.id)
+		}
+		for k, v := range n.labels {
+			c.Labels[k] = v
+		}
+		arch.Components = append(arch.Components, c)
+	}
+
+	// Groups include the nodes of the groups nested in them
+	members := make(map[*diagramGroup][]string)
+	for _, n := range d.nodes {
+		for g := n.group; g != nil; g = g.parent {
+			members[g] = append(members[g], n.id)
+		}
+	}
+	for _, g := range d.groups {
+		if len(members[g]) == 0 {
+			d.result.warnf("group %s is empty", g.id)
+			continue
+		}
+		group := newImportedGroup(g.id, g.name, len(arch.Groups))
+		if g.color != "" {
+			group.BoundingBox.Color = g.color
+		}
+		for k, v := range g.labels {
+			group.Labels[k] = v
+		}
+		group.Components = members[g]
+		arch.Groups = append(arch.Groups, group)
+	}
+
+	ids := make(map[string]bool)
+	for _, e := range d.edges {
+		base := e.source + "->" + e.target
+		id := base
+		for n := 2; ids[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		ids[id] = true
+		name := e.name
+		if name == "" {
+			name = d.name(e.source) + " to " + d.name(e.target)
+		}
+		c := newImportedConnection(id, name, e.source, e.target)
+		c.Flow = e.flow
+		for k, v := range e.labels {
+			c.Labels[k] = v
+		}
+		arch.Connections = append(arch.Connections, c)
+	}
+}
+
+// name returns the name of the node.
+func (d *diagram) name(id string) string {
+	if n, ok := d.index[id]; ok && n.name != "" {
+		return n.name
+	}
+	return id
+}
+
+// diagramColors maps the names of common colours to their values.
+var diagramColors = map[string]string{
+	"black":      "#000000",
+	"white":      "#ffffff",
+	"gray":       "#808080",
+	"grey":       "#808080",
+	"lightgray":  "#d3d3d3",
+	"lightgrey":  "#d3d3d3",
+	"darkgray":   "#a9a9a9",
+	"darkgrey":   "#a9a9a9",
+	"red":        "#ff0000",
+	"darkred":    "#8b0000",
+	"green":      "#008000",
+	"lightgreen": "#90ee90",
+	"darkgreen":  "#006400",
+	"blue":       "#0000ff",
+	"lightblue":  "#add8e6",
+	"darkblue":   "#00008b",
+	"navy":       "#000080",
+	"yellow":     "#ffff00",
+	"gold":       "#ffd700",
+	"orange":     "#ffa500",
+	"purple":     "#800080",
+	"violet":     "#ee82ee",
+	"pink":       "#ffc0cb",
+	"brown":      "#a52a2a",
+	"cyan":       "#00ffff",
+	"magenta":    "#ff00ff",
+	"teal":       "#008080",
+	"olive":      "#808000",
+	"maroon":     "#800000",
+	"salmon":     "#fa8072",
+	"tomato":     "#ff6347",
+	"khaki":      "#f0e68c",
+	"beige":      "#f5f5dc",
+	"lavender":   "#e6e6fa",
+}
+
+// diagramColor returns a colour of a diagram as a "#rrggbb" string. It
+// can be a name, or a hex colour with three, six or eight digits, in
+// which case the alpha is dropped.
+func diagramColor(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if color, ok := diagramColors[value]; ok {
+		return color, nil
+	}
+	hex := strings.TrimPrefix(value, "#")
+	switch len(hex) {
+	case 3:
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	case 8:
+		hex = hex[:6]
+	}
+	color := "#" + hex
+	if err := isValidColor(color); err != nil || !strings.HasPrefix(value, "#") {
+		return "", fmt.Errorf("unsupported color: %s", value)
+	}
+	return color, nil
+}
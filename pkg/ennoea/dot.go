@@ -0,0 +1,798 @@
+package ennoea
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+The dot importer reads Graphviz DOT graphs and digraphs. Nodes become
+components, edges become connections and clusters, the subgraphs whose
+names start with "cluster", become groups. These attributes are used:
+
+	label      the name of a node, edge, cluster or graph
+	shape      the geometry of a node
+	color      the colour of a node or cluster, unless it has a fillcolor
+	fillcolor  the colour of a node or cluster
+	pos        the position of a node, in points
+	dir        the flow of an edge
+	style      the style label of an edge
+
+Edges of a digraph flow out of their tail, and edges of a graph flow
+both ways, unless dir says otherwise. A strict graph merges the edges
+between the same nodes into one.
+
+Nodes with a pos keep it as their position, with an inch of the drawing
+as the spacing of the layout, and the other nodes are laid out around
+them. That way the output of "dot -Tdot" or "neato -Tdot" keeps the
+layout that Graphviz computed.
+*/
+
+// dotPointScale is the scale from the points of a drawing to the
+// units of a scene.
+const dotPointScale = defaultLayoutSpacing / 72
+
+// dotShapes is a map of node shapes to geometries.
+var dotShapes = map[string]string{
+	"box":           "box",
+	"box3d":         "box",
+	"component":     "box",
+	"folder":        "box",
+	"note":          "box",
+	"rect":          "box",
+	"rectangle":     "box",
+	"square":        "box",
+	"tab":           "box",
+	"msquare":       "box",
+	"circle":        "sphere",
+	"doublecircle":  "sphere",
+	"egg":           "sphere",
+	"ellipse":       "sphere",
+	"oval":          "sphere",
+	"point":         "sphere",
+	"mcircle":       "sphere",
+	"cylinder":      "cylinder",
+	"diamond":       "octahedron",
+	"mdiamond":      "octahedron",
+	"hexagon":       "dodecahedron",
+	"octagon":       "dodecahedron",
+	"doubleoctagon": "dodecahedron",
+	"tripleoctagon": "dodecahedron",
+	"pentagon":      "dodecahedron",
+	"septagon":      "dodecahedron",
+	"polygon":       "icosahedron",
+	"star":          "icosahedron",
+	"triangle":      "cone",
+	"invtriangle":   "cone",
+	"house":         "cone",
+	"invhouse":      "cone",
+	"none":          "plane",
+	"plain":         "plane",
+	"plaintext":     "plane",
+	"underline":     "plane",
+}
+
+// Kinds of DOT tokens.
+const (
+	dotID = iota
+	dotPunct
+	dotEdgeOp
+)
+
+// dotToken is a token of a DOT file. Quoted IDs are never keywords.
+type dotToken struct {
+	kind   int
+	text   string
+	quoted bool
+	line   int
+}
+
+// dotHTMLTagPattern matches the tags of an HTML label.
+var dotHTMLTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// tokenizeDOT splits a DOT file into tokens. Comments and the lines of
+// the C preprocessor are skipped, and quoted strings joined with "+"
+// are concatenated.
+func tokenizeDOT(data string) ([]dotToken, error) {
+	var tokens []dotToken
+	// The file starts on a new line for the preprocessor lines
+	data = "\n" + data
+	line := 0
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+			// Lines starting with # are preprocessor output
+			rest := strings.TrimLeft(data[i:], " \t")
+			if strings.HasPrefix(rest, "#") {
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					return tokens, nil
+				}
+				i = len(data) - len(rest) + end
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(data[i:], "//"):
+			end := strings.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(data[i:i+2+end], "\n")
+			i += end + 4
+		case strings.HasPrefix(data[i:], "->") || strings.HasPrefix(data[i:], "--"):
+			tokens = append(tokens, dotToken{kind: dotEdgeOp, text: data[i : i+2], line: line})
+			i += 2
+		case strings.ContainsRune("{}[];,=:", rune(c)):
+			tokens = append(tokens, dotToken{kind: dotPunct, text: string(c), line: line})
+			i++
+		case c == '"':
+			var text strings.Builder
+			start := line
+			j := i + 1
+			for ; j < len(data) && data[j] != '"'; j++ {
+				switch {
+				case data[j] == '\\' && j+1 < len(data) && data[j+1] == '"':
+					text.WriteByte('"')
+					j++
+				case data[j] == '\\' && j+1 < len(data) && data[j+1] == '\n':
+					// A backslash continues the string on the next line
+					line++
+					j++
+				default:
+					if data[j] == '\n' {
+						line++
+					}
+					text.WriteByte(data[j])
+				}
+			}
+			if j == len(data) {
+				return nil, fmt.Errorf("line %d: unterminated string", start)
+			}
+			i = j + 1
+			if n := len(tokens); n > 0 && tokens[n-1].kind == dotPunct && tokens[n-1].text == "+" {
+				tokens[n-2].text += text.String()
+				tokens = tokens[:n-1]
+				continue
+			}
+			tokens = append(tokens, dotToken{kind: dotID, text: text.String(), quoted: true, line: start})
+		case c == '+':
+			// Only valid between two strings
+			if n := len(tokens); n == 0 || !tokens[n-1].quoted {
+				return nil, fmt.Errorf("line %d: unexpected +", line)
+			}
+			tokens = append(tokens, dotToken{kind: dotPunct, text: "+", line: line})
+			i++
+		case c == '<':
+			// HTML strings nest angle brackets
+			depth := 0
+			j := i
+			for ; j < len(data); j++ {
+				if data[j] == '<' {
+					depth++
+				} else if data[j] == '>' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j == len(data) {
+				return nil, fmt.Errorf("line %d: unterminated HTML string", line)
+			}
+			html := data[i+1 : j]
+			tokens = append(tokens, dotToken{kind: dotID, text: dotHTMLTagPattern.ReplaceAllString(html, " "), quoted: true, line: line})
+			line += strings.Count(html, "\n")
+			i = j + 1
+		default:
+			j := i
+			for j < len(data) && isDOTIDByte(data[j]) && !strings.HasPrefix(data[j:], "->") && !(j > i && strings.HasPrefix(data[j:], "--")) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+			}
+			tokens = append(tokens, dotToken{kind: dotID, text: data[i:j], line: line})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// isDOTIDByte returns true if the byte can be part of an unquoted ID,
+// which is a name, a number or any non-ASCII text.
+func isDOTIDByte(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// dotScope is a graph or subgraph, with the default attributes of the
+// nodes and edges declared in it.
+type dotScope struct {
+	nodeDefaults map[string]string
+	edgeDefaults map[string]string
+
+	// attributes is the attributes of the graph or subgraph itself.
+	attributes map[string]string
+
+	// cluster is the innermost cluster of the scope, or nil if there
+	// is none.
+	cluster *diagramGroup
+
+	// nodes is the IDs of the nodes that are used in the scope, for
+	// edges that have the scope as an end.
+	nodes []string
+}
+
+// child returns a subgraph scope, with the defaults of this scope.
+func (s *dotScope) child() *dotScope {
+	child := &dotScope{
+		nodeDefaults: make(map[string]string, len(s.nodeDefaults)),
+		edgeDefaults: make(map[string]string, len(s.edgeDefaults)),
+		attributes:   make(map[string]string),
+		cluster:      s.cluster,
+	}
+	for k, v := range s.nodeDefaults {
+		child.nodeDefaults[k] = v
+	}
+	for k, v := range s.edgeDefaults {
+		child.edgeDefaults[k] = v
+	}
+	return child
+}
+
+// dotEdge is an edge of a DOT graph with its attributes.
+type dotEdge struct {
+	tail, head string
+	attributes map[string]string
+}
+
+// dotImport holds the state of a DOT import.
+type dotImport struct {
+	diagram  *diagram
+	tokens   []dotToken
+	pos      int
+	depth    int
+	directed bool
+	strict   bool
+
+	// attributes is a map of the IDs of the nodes to their attributes.
+	attributes map[string]map[string]string
+
+	edges []*dotEdge
+
+	// byEnds is a map of the ends of the edges of a strict graph to
+	// the edges.
+	byEnds map[[2]string]*dotEdge
+
+	// warned is the warnings that have been added, so that a shape or
+	// colour used by many nodes is reported once.
+	warned map[string]bool
+}
+
+// importDOT imports a Graphviz DOT graph.
+func importDOT(data, config []byte) (ImportResult, error) {
+	var result ImportResult
+	tokens, err := tokenizeDOT(string(data))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	d := &dotImport{
+		diagram:    newDiagram(&result),
+		tokens:     tokens,
+		attributes: make(map[string]map[string]string),
+		byEnds:     make(map[[2]string]*dotEdge),
+		warned:     make(map[string]bool),
+	}
+	if err := d.graph(); err != nil {
+		return ImportResult{}, err
+	}
+	if d.pos < len(d.tokens) {
+		result.warnf("line %d: only the first graph is imported", d.tokens[d.pos].line)
+	}
+	if len(d.diagram.nodes) == 0 {
+		return ImportResult{}, fmt.Errorf("graph has no nodes")
+	}
+
+	d.nodes()
+	for _, e := range d.edges {
+		d.edge(e)
+	}
+	d.diagram.architecture()
+	return result, nil
+}
+
+// warnOnce adds a warning to the result unless it has been added
+// before.
+func (d *dotImport) warnOnce(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	if !d.warned[warning] {
+		d.warned[warning] = true
+		d.diagram.result.Warnings = append(d.diagram.result.Warnings, warning)
+	}
+}
+
+// peek returns the next token, or an empty token at the end of the
+// file.
+func (d *dotImport) peek() dotToken {
+	if d.pos < len(d.tokens) {
+		return d.tokens[d.pos]
+	}
+	return dotToken{kind: dotPunct, line: -1}
+}
+
+// next returns the next token and moves past it.
+func (d *dotImport) next() dotToken {
+	t := d.peek()
+	d.pos++
+	return t
+}
+
+// is returns true if the next token is the punctuation.
+func (d *dotImport) is(punct string) bool {
+	t := d.peek()
+	return t.kind == dotPunct && t.text == punct
+}
+
+// keyword returns true if the next token is the keyword, which is not
+// case sensitive.
+func (d *dotImport) keyword(keyword string) bool {
+	t := d.peek()
+	return t.kind == dotID && !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// expect moves past the punctuation, or returns an error if it is not
+// next.
+func (d *dotImport) expect(punct string) error {
+	if !d.is(punct) {
+		return d.unexpected(punct)
+	}
+	d.pos++
+	return nil
+}
+
+// unexpected returns an error for the next token.
+func (d *dotImport) unexpected(expected string) error {
+	t := d.peek()
+	if t.line < 0 {
+		return fmt.Errorf("expected %s, found the end of the file", expected)
+	}
+	return fmt.Errorf("line %d: expected %s, found %q", t.line, expected, t.text)
+}
+
+// graph reads "[strict] (graph | digraph) [ID] { statements }".
+func (d *dotImport) graph() error {
+	if d.keyword("strict") {
+		d.strict = true
+		d.pos++
+	}
+	switch {
+	case d.keyword("digraph"):
+		d.directed = true
+	case d.keyword("graph"):
+	default:
+		return d.unexpected("graph or digraph")
+	}
+	d.pos++
+	var id string
+	if d.peek().kind == dotID {
+		id = d.next().text
+	}
+
+	arch := &d.diagram.result.Architecture
+	arch.Info.Name = id
+	root := &dotScope{
+		nodeDefaults: make(map[string]string),
+		edgeDefaults: make(map[string]string),
+		attributes:   make(map[string]string),
+	}
+	if err := d.expect("{"); err != nil {
+		return err
+	}
+	if err := d.statements(root); err != nil {
+		return err
+	}
+
+	if label, ok := root.attributes["label"]; ok {
+		arch.Info.Name = dotLabel(label, id, id)
+	}
+	return nil
+}
+
+// statements reads the statements of a graph or subgraph up to its
+// closing brace.
+func (d *dotImport) statements(scope *dotScope) error {
+	for !d.is("}") {
+		if d.peek().line < 0 {
+			return d.unexpected("}")
+		}
+		if err := d.statement(scope); err != nil {
+			return err
+		}
+		if d.is(";") {
+			d.pos++
+		}
+	}
+	d.pos++
+	return nil
+}
+
+// statement reads an attribute, node, edge or subgraph statement.
+func (d *dotImport) statement(scope *dotScope) error {
+	t := d.peek()
+	if t.kind == dotID && !t.quoted {
+		var defaults map[string]string
+		switch strings.ToLower(t.text) {
+		case "graph":
+			defaults = scope.attributes
+		case "node":
+			defaults = scope.nodeDefaults
+		case "edge":
+			defaults = scope.edgeDefaults
+		}
+		if defaults != nil {
+			d.pos++
+			return d.attributeList(defaults)
+		}
+	}
+
+	if t.kind == dotID && d.pos+1 < len(d.tokens) && d.tokens[d.pos+1].text == "=" && d.tokens[d.pos+1].kind == dotPunct {
+		// An attribute of the graph
+		d.pos += 2
+		value := d.next()
+		if value.kind != dotID {
+			return fmt.Errorf("line %d: expected a value for %s", t.line, t.text)
+		}
+		scope.attributes[t.text] = value.text
+		return nil
+	}
+
+	tail, err := d.endpoint(scope)
+	if err != nil {
+		return err
+	}
+	ends := [][]string{tail}
+	for d.peek().kind == dotEdgeOp {
+		op := d.next()
+		if (op.text == "->") != d.directed {
+			d.warnOnce("line %d: %s is not an edge of this graph", op.line, op.text)
+		}
+		head, err := d.endpoint(scope)
+		if err != nil {
+			return err
+		}
+		ends = append(ends, head)
+	}
+
+	attributes := make(map[string]string)
+	if err := d.attributeList(attributes); err != nil {
+		return err
+	}
+	if len(ends) == 1 {
+		// A node statement, unless it was a subgraph
+		if len(tail) == 1 && !d.subgraphEnd(t) {
+			for k, v := range attributes {
+				d.attributes[tail[0]][k] = v
+			}
+		}
+		return nil
+	}
+
+	for i := 1; i < len(ends); i++ {
+		for _, tailID := range ends[i-1] {
+			for _, headID := range ends[i] {
+				d.addEdge(scope, tailID, headID, attributes)
+			}
+		}
+	}
+	return nil
+}
+
+// subgraphEnd returns true if the token starts a subgraph.
+func (d *dotImport) subgraphEnd(t dotToken) bool {
+	return (t.kind == dotPunct && t.text == "{") || (t.kind == dotID && !t.quoted && strings.EqualFold(t.text, "subgraph"))
+}
+
+// endpoint reads a node ID with an optional port, or a subgraph, and
+// returns the IDs of its nodes.
+func (d *dotImport) endpoint(scope *dotScope) ([]string, error) {
+	t := d.peek()
+	if d.subgraphEnd(t) {
+		return d.subgraph(scope)
+	}
+	if t.kind != dotID {
+		return nil, d.unexpected("a node")
+	}
+	d.pos++
+	// The port and compass point of the node are not imported
+	for i := 0; i < 2 && d.is(":"); i++ {
+		d.pos++
+		if d.peek().kind != dotID {
+			return nil, d.unexpected("a port")
+		}
+		d.pos++
+	}
+	d.node(scope, t.text)
+	return []string{t.text}, nil
+}
+
+// node adds a node to the scope, with the default attributes of the
+// scope if it is new.
+func (d *dotImport) node(scope *dotScope, id string) {
+	if _, ok := d.attributes[id]; !ok {
+		attributes := make(map[string]string, len(scope.nodeDefaults))
+		for k, v := range scope.nodeDefaults {
+			attributes[k] = v
+		}
+		d.attributes[id] = attributes
+	}
+	d.diagram.node(id, scope.cluster)
+	scope.nodes = append(scope.nodes, id)
+}
+
+// subgraph reads "[subgraph [ID]] { statements }" and returns the IDs
+// of the nodes in it. A subgraph whose ID starts with "cluster" is a
+// group.
+func (d *dotImport) subgraph(scope *dotScope) ([]string, error) {
+	var id string
+	if d.keyword("subgraph") {
+		d.pos++
+		if d.peek().kind == dotID {
+			id = d.next().text
+		}
+	}
+	if d.depth == maxImportDepth {
+		return nil, fmt.Errorf("line %d: subgraphs are nested deeper than %d", d.peek().line, maxImportDepth)
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	child := scope.child()
+	var cluster *diagramGroup
+	if strings.HasPrefix(id, "cluster") {
+		cluster = d.diagram.group(id, "", scope.cluster)
+		child.cluster = cluster
+	}
+	if err := d.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := d.statements(child); err != nil {
+		return nil, err
+	}
+
+	if cluster != nil {
+		if label, ok := child.attributes["label"]; ok {
+			cluster.name = dotLabel(label, id, d.diagram.result.Architecture.Info.Name)
+		}
+		if color := d.color(child.attributes); color != "" {
+			cluster.color = color
+		}
+	}
+	scope.nodes = append(scope.nodes, child.nodes...)
+	return child.nodes, nil
+}
+
+// attributeList reads any number of "[a=b, c=d; e]" lists into the
+// attributes.
+func (d *dotImport) attributeList(attributes map[string]string) error {
+	for d.is("[") {
+		d.pos++
+		for !d.is("]") {
+			name := d.next()
+			if name.kind != dotID {
+				return fmt.Errorf("line %d: expected an attribute, found %q", name.line, name.text)
+			}
+			value := "true"
+			if d.is("=") {
+				d.pos++
+				t := d.next()
+				if t.kind != dotID {
+					return fmt.Errorf("line %d: expected a value for %s", name.line, name.text)
+				}
+				value = t.text
+			}
+			attributes[name.text] = value
+			if d.is(",") || d.is(";") {
+				d.pos++
+			}
+		}
+		d.pos++
+	}
+	return nil
+}
+
+// addEdge adds an edge with the default attributes of the scope. The
+// edges between the same nodes of a strict graph are merged.
+func (d *dotImport) addEdge(scope *dotScope, tail, head string, attributes map[string]string) {
+	ends := [2]string{tail, head}
+	if !d.directed && head < tail {
+		ends = [2]string{head, tail}
+	}
+	e, ok := d.byEnds[ends]
+	if !ok || !d.strict {
+		e = &dotEdge{tail: tail, head: head, attributes: make(map[string]string)}
+		for k, v := range scope.edgeDefaults {
+			e.attributes[k] = v
+		}
+		d.edges = append(d.edges, e)
+		d.byEnds[ends] = e
+	}
+	for k, v := range attributes {
+		e.attributes[k] = v
+	}
+}
+
+// nodes fills in the names, geometries, colours and positions of the
+// nodes from their attributes. The positions are centred on the
+// origin.
+func (d *dotImport) nodes() {
+	var positioned []*diagramNode
+	var centre [3]float64
+	for _, n := range d.diagram.nodes {
+		attributes := d.attributes[n.id]
+		if label, ok := attributes["label"]; ok {
+			n.name = dotLabel(label, n.id, d.diagram.result.Architecture.Info.Name)
+		}
+		if shape, ok := attributes["shape"]; ok {
+			geometry, ok := dotShapes[strings.ToLower(shape)]
+			if !ok {
+				d.warnOnce("shape %s is not supported", shape)
+			}
+			n.geometry = geometry
+			if geometry == "cylinder" {
+				n.componentType = "server"
+			}
+		}
+		n.color = d.color(attributes)
+		if pos, ok := attributes["pos"]; ok {
+			position, err := dotPosition(pos)
+			if err != nil {
+				d.diagram.result.warnf("node %s: %v", n.id, err)
+				continue
+			}
+			n.position = &position
+			positioned = append(positioned, n)
+			centre = vecAdd(centre, position)
+		}
+	}
+	if len(positioned) == 0 {
+		return
+	}
+	centre = vecScale(centre, 1/float64(len(positioned)))
+	for _, n := range positioned {
+		*n.position = vecSub(*n.position, centre)
+	}
+}
+
+// edge adds the edge to the diagram with the flow of its direction.
+func (d *dotImport) edge(e *dotEdge) {
+	flow := "bi"
+	dir, ok := e.attributes["dir"]
+	if !ok && d.directed {
+		dir = "forward"
+	}
+	switch dir {
+	case "forward":
+		flow = "out"
+	case "back":
+		flow = "in"
+	}
+	var name string
+	if label, ok := e.attributes["label"]; ok {
+		name = dotLabel(label, e.tail+" to "+e.head, d.diagram.result.Architecture.Info.Name)
+	}
+	edge := d.diagram.edge(e.tail, e.head, name, flow)
+	if style := e.attributes["style"]; style != "" && style != "solid" {
+		edge.labels["style"] = style
+	}
+}
+
+// color returns the fill colour of the attributes, or their colour if
+// they have no fill. An unsupported colour is reported and ignored.
+func (d *dotImport) color(attributes map[string]string) string {
+	value := attributes["fillcolor"]
+	if value == "" {
+		value = attributes["bgcolor"]
+	}
+	if value == "" {
+		value = attributes["color"]
+	}
+	if value == "" {
+		return ""
+	}
+	color, err := dotColor(value)
+	if err != nil {
+		d.warnOnce("%v", err)
+		return ""
+	}
+	return color
+}
+
+// dotLabel returns the text of a label. The escapes \N and \G are the
+// names of the object and the graph, and line breaks become spaces.
+func dotLabel(label, object, graph string) string {
+	label = strings.NewReplacer(`\N`, object, `\E`, object, `\G`, graph, `\T`, object, `\H`, object,
+		`\n`, " ", `\l`, " ", `\r`, " ").Replace(label)
+	return strings.Join(strings.Fields(label), " ")
+}
+
+// dotColor returns a colour of a DOT file as a "#rrggbb" string. It can
+// be a name, a hex colour or an "h,s,v" colour, and only the first
+// colour of a list is used.
+func dotColor(value string) (string, error) {
+	value = strings.SplitN(value, ":", 2)[0]
+	value = strings.SplitN(value, ";", 2)[0]
+	if i := strings.LastIndexByte(value, '/'); i >= 0 {
+		// The colour scheme is dropped
+		value = value[i+1:]
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(fields) == 3 {
+		var hsv [3]float64
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil || v < 0 || v > 1 {
+				return "", fmt.Errorf("unsupported color: %s", value)
+			}
+			hsv[i] = v
+		}
+		r, g, b := hsvToRGB(hsv[0], hsv[1], hsv[2])
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b), nil
+	}
+	return diagramColor(value)
+}
+
+// hsvToRGB converts a colour with a hue, saturation and value between
+// 0 and 1 to RGB.
+func hsvToRGB(h, s, v float64) (uint8, uint8, uint8) {
+	h = math.Mod(h, 1) * 6
+	i := math.Floor(h)
+	f := h - i
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch int(i) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return uint8(math.Round(r * 255)), uint8(math.Round(g * 255)), uint8(math.Round(b * 255))
+}
+
+// dotPosition returns the position of a "x,y" or "x,y,z" pos in the
+// units of a scene. A trailing "!" is ignored.
+func dotPosition(pos string) ([3]float64, error) {
+	var position [3]float64
+	fields := strings.Split(strings.TrimSuffix(strings.TrimSpace(pos), "!"), ",")
+	if len(fields) != 2 && len(fields) != 3 {
+		return position, fmt.Errorf("invalid pos: %s", pos)
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return position, fmt.Errorf("invalid pos: %s", pos)
+		}
+		position[i] = v * dotPointScale
+	}
+	return position, nil
+}
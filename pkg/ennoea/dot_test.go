@@ -0,0 +1,90 @@
+package ennoea
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportDOT(t *testing.T) {
+	testImports(t, "dot", []importTest{
+		{
+			name: "digraph with a cluster",
+			data: `digraph shop {
+				label="Shop"
+				web [shape=box, label="Web"]
+				web -> api
+				subgraph cluster_backend { label=Backend; api; db [shape=cylinder] }
+				api -> db [dir=both]
+			}`,
+			want: []string{
+				`component web "Web" box`,
+				`component api "api" box`,
+				`component db "db" cylinder`,
+				`group cluster_backend api,db`,
+				`connection web->api out`,
+				`connection api->db bi`,
+			},
+		},
+		{
+			name: "strict graph",
+			data: `strict graph { a -- b; b -- a; b -- c [dir=forward] }`,
+			want: []string{
+				`component a "a" box`,
+				`component b "b" box`,
+				`component c "c" box`,
+				`connection a->b bi`,
+				`connection b->c out`,
+			},
+		},
+		{
+			name: "labels",
+			data: `digraph { a [label=<<b>Web</b> app>]; b [label="Back" + "end"]; c [label="\N node"]; a -> b -> c }`,
+			want: []string{
+				`component a "Web app" box`,
+				`component b "Backend" box`,
+				`component c "c node" box`,
+				`connection a->b out`,
+				`connection b->c out`,
+			},
+		},
+		{
+			name: "comments and preprocessor lines",
+			data: "# 1 \"shop.gv\"\n/* the shop */ digraph { // web\n  a -> { b c }\n}",
+			want: []string{
+				`component a "a" box`,
+				`component b "b" box`,
+				`component c "c" box`,
+				`connection a->b out`,
+				`connection a->c out`,
+			},
+		},
+		{
+			name: "unsupported shape and edge",
+			data: `graph { a [shape=blob]; a -> b }`,
+			want: []string{
+				`component a "a" box`,
+				`component b "b" box`,
+				`connection a->b bi`,
+				"warning line 1: -> is not an edge of this graph",
+				"warning shape blob is not supported",
+			},
+		},
+		{name: "unterminated graph", data: `digraph { a -> b`, err: "expected }, found the end of the file"},
+		{name: "unterminated string", data: `digraph { "a -> b }`, err: "line 1: unterminated string"},
+		{name: "unterminated comment", data: "digraph {\n/* a -> b }", err: "line 2: unterminated comment"},
+		{name: "no nodes", data: `digraph { rankdir=LR }`, err: "graph has no nodes"},
+		{name: "nested subgraphs", data: "graph {" + strings.Repeat("{", maxImportDepth+1) + "a" + strings.Repeat("}", maxImportDepth+1) + "}", err: "subgraphs are nested deeper than 100"},
+	})
+}
+
+func FuzzTokenizeDOT(f *testing.F) {
+	f.Add(`digraph shop { label="Shop"; web [shape=box]; web -> api; subgraph cluster_backend { api; db } }`)
+	f.Add(`strict graph { a -- b -- { c d } [dir=back, label=<<i>x</i>>] }`)
+	f.Add("# 1 \"a.gv\"\n/* c */ digraph { a:n -> b:s:e // x\n }")
+	f.Fuzz(func(t *testing.T, data string) {
+		if _, err := tokenizeDOT(data); err != nil {
+			return
+		}
+		importDOT([]byte(data), nil)
+	})
+}
@@ -0,0 +1,1063 @@
+package ennoea
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Architecture expressions are a small language for selecting and testing
+the entities of an architecture. They are used by policies to describe
+the entities that are not allowed, and by queries, views and lint rule
+selectors to pick out entities.
+
+A selector picks the entities of one kind that match an expression:
+
+	component where labels.zone == "public"
+	connection where source in group "prod" and target.name =~ "(?i)db"
+
+Grammar:
+
+	selector := kind ["where" expr]
+	kind     := "component" | "group" | "connection"
+	expr     := and {"or" and}
+	and      := not {"and" not}
+	not      := "not" not | compare
+	compare  := operand [op operand | "in" "group" operand | "in" "(" list ")" | "in" operand]
+	op       := "==" | "!=" | "=~" | "!~" | "<" | "<=" | ">" | ">="
+	operand  := path | string | number | "true" | "false" | call | "(" expr ")"
+	path     := name {"." name | "[" string "]"}
+	call     := name "(" [list] ")"
+	list     := expr {"," expr}
+
+Paths are resolved against the entity being tested, which can also be
+referred to as "self". The fields of each kind are:
+
+	component   id, name, type, geometry, color, visible, capacity,
+	            inDegree, outDegree, degree, labels.<key>
+	group       id, name, size, labels.<key>
+	connection  id, name, flow, outRate, inRate, outPacketSize,
+	            inPacketSize, latency, capacity, labels.<key>,
+	            source.<field>, target.<field>
+
+Missing labels are empty strings. Rates and sizes can be compared with
+unit strings, for example outRate > "10MB/s". The "in group" operator
+matches a group by ID or by name.
+
+The degree of a component is the number of distinct components it is
+connected to. The in and out degrees only count the components that
+send data to it, or that it sends data to.
+
+The graph functions return a set of components that can be tested
+with "in". Each takes the ID of a component and an optional number of
+hops, and the component itself is not part of the set:
+
+	neighbours(id [, hops])  components connected in either direction,
+	                         one hop by default
+	upstream(id [, hops])    components that send data to the component
+	downstream(id [, hops])  components that the component sends data to
+
+For example:
+
+	component where self in downstream("api") and type == "server"
+	component where degree > 5 or not self in neighbours("lb", 2)
+*/
+
+// Kinds of entities that expressions can select.
+const (
+	entityComponent  = "component"
+	entityGroup      = "group"
+	entityConnection = "connection"
+)
+
+// exprTokenKind is the kind of a token in an expression.
+type exprTokenKind int
+
+const (
+	tokenEOF exprTokenKind = iota
+	tokenName
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunctuation
+)
+
+// exprToken is a token in an expression.
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+// lexExpr splits the expression into tokens.
+func lexExpr(input string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isNameStart(c):
+			start := i
+			for i < len(input) && isNamePart(input[i]) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenName, text: input[start:i], pos: start})
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(input) && (input[i] == '.' || (input[i] >= '0' && input[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: input[start:i], pos: start})
+
+		case c == '"':
+			start := i
+			i++
+			for i < len(input) && input[i] != '"' {
+				if input[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(input) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			text, err := strconv.Unquote(input[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", start, err)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: text, pos: start})
+
+		case strings.ContainsRune("=!<>", rune(c)):
+			start := i
+			i++
+			if i < len(input) && (input[i] == '=' || input[i] == '~') {
+				i++
+			}
+			op := input[start:i]
+			switch op {
+			case "==", "!=", "=~", "!~", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("invalid operator %q at %d", op, start)
+			}
+			tokens = append(tokens, exprToken{kind: tokenOperator, text: op, pos: start})
+
+		case strings.ContainsRune("().,[]", rune(c)):
+			tokens = append(tokens, exprToken{kind: tokenPunctuation, text: string(c), pos: i})
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+
+	tokens = append(tokens, exprToken{kind: tokenEOF, pos: len(input)})
+	return tokens, nil
+}
+
+// isNameStart returns true if the character can start a name.
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isNamePart returns true if the character can be part of a name.
+func isNamePart(c byte) bool {
+	return isNameStart(c) || c == '-' || (c >= '0' && c <= '9')
+}
+
+// exprParser is a recursive descent parser for expressions.
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// newExprParser creates a parser for the input.
+func newExprParser(input string) (*exprParser, error) {
+	tokens, err := lexExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{tokens: tokens}, nil
+}
+
+// peek returns the current token without consuming it.
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token.
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword returns true if the current token is the keyword.
+func (p *exprParser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokenName && t.text == word
+}
+
+// isPunctuation returns true if the current token is the punctuation.
+func (p *exprParser) isPunctuation(text string) bool {
+	t := p.peek()
+	return t.kind == tokenPunctuation && t.text == text
+}
+
+// expectKeyword consumes the keyword or returns an error.
+func (p *exprParser) expectKeyword(word string) error {
+	if !p.isKeyword(word) {
+		return p.errorf("expected %q", word)
+	}
+	p.next()
+	return nil
+}
+
+// expectPunctuation consumes the punctuation or returns an error.
+func (p *exprParser) expectPunctuation(text string) error {
+	if !p.isPunctuation(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+// expectEOF returns an error if there are tokens left.
+func (p *exprParser) expectEOF() error {
+	if p.peek().kind != tokenEOF {
+		return p.errorf("unexpected %q", p.peek().text)
+	}
+	return nil
+}
+
+// errorf returns an error at the position of the current token.
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %d", fmt.Sprintf(format, args...), p.peek().pos)
+}
+
+// exprSelector selects the entities of a kind that match an
+// expression.
+type exprSelector struct {
+	// kind is the kind of the entities that are selected.
+	kind string
+
+	// where is the expression that the entities must match, or nil
+	// if every entity of the kind is selected.
+	where exprNode
+}
+
+// parseSelector parses a selector such as "component where name == x".
+func (p *exprParser) parseSelector() (exprSelector, error) {
+	t := p.next()
+	kind := strings.TrimSuffix(t.text, "s")
+	if t.kind != tokenName || (kind != entityComponent && kind != entityGroup && kind != entityConnection) {
+		return exprSelector{}, fmt.Errorf("expected component, group or connection at %d", t.pos)
+	}
+
+	selector := exprSelector{kind: kind}
+	if p.isKeyword("where") {
+		p.next()
+		where, err := p.parseExpr()
+		if err != nil {
+			return exprSelector{}, err
+		}
+		selector.where = where
+	}
+
+	return selector, nil
+}
+
+// parseExpr parses an "or" expression.
+func (p *exprParser) parseExpr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses an "and" expression.
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses a "not" expression.
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+// parseCompare parses a comparison or a membership test.
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == tokenOperator {
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node := compareNode{op: t.text, left: left, right: right}
+
+		// Compile literal regular expressions up front so that
+		// mistakes are reported when the expression is parsed.
+		if lit, ok := right.(literalNode); ok && (t.text == "=~" || t.text == "!~") {
+			pattern, ok := lit.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string pattern at %d", t.pos)
+			}
+			node.re, err = regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern at %d: %v", t.pos, err)
+			}
+		}
+		return node, nil
+	}
+
+	if p.isKeyword("in") {
+		p.next()
+		if p.isKeyword("group") {
+			p.next()
+			group, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return inGroupNode{operand: left, group: group}, nil
+		}
+
+		if p.isPunctuation("(") {
+			p.next()
+			list, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			return inListNode{operand: left, list: list}, nil
+		}
+
+		// A single operand, such as the set returned by a function
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return inListNode{operand: left, list: []exprNode{item}}, nil
+	}
+
+	return left, nil
+}
+
+// parseList parses a comma separated list of expressions up to and
+// including the closing punctuation.
+func (p *exprParser) parseList(closing string) ([]exprNode, error) {
+	var list []exprNode
+	if p.isPunctuation(closing) {
+		p.next()
+		return list, nil
+	}
+	for {
+		item, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		if p.isPunctuation(",") {
+			p.next()
+			continue
+		}
+		if err := p.expectPunctuation(closing); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+}
+
+// parseOperand parses a literal, path, call or parenthesised
+// expression.
+func (p *exprParser) parseOperand() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		return literalNode{value: t.text}, nil
+
+	case t.kind == tokenNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return literalNode{value: f}, nil
+
+	case t.kind == tokenPunctuation && t.text == "(":
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunctuation(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+
+	case t.kind == tokenName:
+		p.next()
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "and", "or", "not", "in", "where":
+			return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+		}
+
+		// Function calls
+		if p.isPunctuation("(") {
+			p.next()
+			fn, ok := exprFunctions[t.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown function %q at %d", t.text, t.pos)
+			}
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			return callNode{name: t.text, fn: fn, args: args}, nil
+		}
+
+		// Paths
+		path := []string{t.text}
+		for {
+			if p.isPunctuation(".") {
+				p.next()
+				name := p.next()
+				if name.kind != tokenName {
+					return nil, fmt.Errorf("expected a field name at %d", name.pos)
+				}
+				path = append(path, name.text)
+				continue
+			}
+			if p.isPunctuation("[") {
+				p.next()
+				key := p.next()
+				if key.kind != tokenString {
+					return nil, fmt.Errorf("expected a string key at %d", key.pos)
+				}
+				if err := p.expectPunctuation("]"); err != nil {
+					return nil, err
+				}
+				path = append(path, key.text)
+				continue
+			}
+			break
+		}
+		return pathNode{path: path}, nil
+	}
+
+	if t.kind == tokenEOF {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// exprEntity refers to an entity in the architecture by its kind and
+// its index in the list of entities of that kind.
+type exprEntity struct {
+	kind  string
+	index int
+}
+
+// exprContext holds the architecture that expressions are evaluated
+// against, along with the indexes needed to evaluate them quickly.
+type exprContext struct {
+	arch       Architecture
+	graph      *architectureGraph
+	groupIndex map[string]int
+	memberOf   map[string]map[int]bool
+
+	// sets caches the results of the graph functions.
+	sets map[string]exprSet
+}
+
+// newExprContext creates the context for evaluating expressions
+// against the architecture.
+func newExprContext(arch Architecture) *exprContext {
+	ctx := &exprContext{
+		arch:       arch,
+		graph:      newArchitectureGraph(arch),
+		groupIndex: make(map[string]int, len(arch.Groups)),
+		memberOf:   make(map[string]map[int]bool),
+		sets:       make(map[string]exprSet),
+	}
+	for i, g := range arch.Groups {
+		ctx.groupIndex[g.ID] = i
+		for _, member := range g.Components {
+			if ctx.memberOf[member] == nil {
+				ctx.memberOf[member] = make(map[int]bool)
+			}
+			ctx.memberOf[member][i] = true
+		}
+	}
+	return ctx
+}
+
+// entities returns every entity of the kind.
+func (ctx *exprContext) entities(kind string) []exprEntity {
+	var n int
+	switch kind {
+	case entityComponent:
+		n = len(ctx.arch.Components)
+	case entityGroup:
+		n = len(ctx.arch.Groups)
+	case entityConnection:
+		n = len(ctx.arch.Connections)
+	}
+
+	entities := make([]exprEntity, n)
+	for i := range entities {
+		entities[i] = exprEntity{kind: kind, index: i}
+	}
+	return entities
+}
+
+// id returns the ID of the entity.
+func (ctx *exprContext) id(e exprEntity) string {
+	switch e.kind {
+	case entityComponent:
+		return ctx.arch.Components[e.index].ID
+	case entityGroup:
+		return ctx.arch.Groups[e.index].ID
+	default:
+		return ctx.arch.Connections[e.index].ID
+	}
+}
+
+// labels returns the labels of the entity.
+func (ctx *exprContext) labels(e exprEntity) map[string]string {
+	switch e.kind {
+	case entityComponent:
+		return ctx.arch.Components[e.index].Labels
+	case entityGroup:
+		return ctx.arch.Groups[e.index].Labels
+	default:
+		return ctx.arch.Connections[e.index].Labels
+	}
+}
+
+// field returns the value of the field of the entity.
+func (ctx *exprContext) field(e exprEntity, name string) (interface{}, error) {
+	switch e.kind {
+	case entityComponent:
+		c := ctx.arch.Components[e.index]
+		switch name {
+		case "id":
+			return c.ID, nil
+		case "name":
+			return c.Name, nil
+		case "type":
+			return c.Type, nil
+		case "geometry":
+			return c.Object.Geometry, nil
+		case "color":
+			return c.Object.Color, nil
+		case "visible":
+			return c.Object.Visible, nil
+		case "capacity":
+			return float64(c.Capacity), nil
+		case "inDegree":
+			return float64(len(ctx.graph.predecessors(e.index))), nil
+		case "outDegree":
+			return float64(len(ctx.graph.successors(e.index))), nil
+		case "degree":
+			return float64(len(ctx.graph.within([]int{e.index}, 1, directionBoth)) - 1), nil
+		}
+
+	case entityGroup:
+		g := ctx.arch.Groups[e.index]
+		switch name {
+		case "id":
+			return g.ID, nil
+		case "name":
+			return g.Name, nil
+		case "size":
+			return float64(len(g.Components)), nil
+		}
+
+	case entityConnection:
+		c := ctx.arch.Connections[e.index]
+		switch name {
+		case "id":
+			return c.ID, nil
+		case "name":
+			return c.Name, nil
+		case "flow":
+			return c.Flow, nil
+		case "outRate":
+			return float64(c.OutRate), nil
+		case "inRate":
+			return float64(c.InRate), nil
+		case "outPacketSize":
+			return float64(c.OutPacketSize), nil
+		case "inPacketSize":
+			return float64(c.InPacketSize), nil
+		case "latency":
+			return c.Latency, nil
+		case "capacity":
+			return float64(c.Capacity), nil
+		case "source", "target":
+			id := c.Source
+			if name == "target" {
+				id = c.Target
+			}
+			i, ok := ctx.graph.index[id]
+			if !ok {
+				return nil, fmt.Errorf("connection %s: unknown %s: %s", c.ID, name, id)
+			}
+			return exprEntity{kind: entityComponent, index: i}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown %s field: %s", e.kind, name)
+}
+
+// matches returns true if the entity is selected by the selector.
+func (ctx *exprContext) matches(selector exprSelector, e exprEntity) (bool, error) {
+	if e.kind != selector.kind {
+		return false, nil
+	}
+	if selector.where == nil {
+		return true, nil
+	}
+	return evalBool(ctx, selector.where, e)
+}
+
+// exprNode is a node in the syntax tree of an expression.
+type exprNode interface {
+	// eval evaluates the node for the entity.
+	eval(ctx *exprContext, self exprEntity) (interface{}, error)
+}
+
+// evalBool evaluates the node and converts the result to a boolean.
+func evalBool(ctx *exprContext, node exprNode, self exprEntity) (bool, error) {
+	v, err := node.eval(ctx, self)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// truthy converts a value to a boolean. Empty strings and zero are
+// false, entities are true.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case exprEntity:
+		return true
+	case exprSet:
+		return len(v) > 0
+	default:
+		return false
+	}
+}
+
+// orNode is true if either operand is true.
+type orNode struct {
+	left, right exprNode
+}
+
+func (n orNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	left, err := evalBool(ctx, n.left, self)
+	if err != nil || left {
+		return left, err
+	}
+	return evalBool(ctx, n.right, self)
+}
+
+// andNode is true if both operands are true.
+type andNode struct {
+	left, right exprNode
+}
+
+func (n andNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	left, err := evalBool(ctx, n.left, self)
+	if err != nil || !left {
+		return false, err
+	}
+	return evalBool(ctx, n.right, self)
+}
+
+// notNode negates its operand.
+type notNode struct {
+	operand exprNode
+}
+
+func (n notNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	v, err := evalBool(ctx, n.operand, self)
+	return !v, err
+}
+
+// literalNode is a string, number or boolean literal.
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	return n.value, nil
+}
+
+// pathNode resolves a path of fields starting at the entity.
+type pathNode struct {
+	path []string
+}
+
+func (n pathNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	var value interface{} = self
+	for i := 0; i < len(n.path); i++ {
+		name := n.path[i]
+		if i == 0 && name == "self" {
+			continue
+		}
+
+		e, ok := value.(exprEntity)
+		if !ok {
+			return nil, fmt.Errorf("cannot access %s of a value in %s", name, strings.Join(n.path, "."))
+		}
+
+		// Labels are followed by the key of the label
+		if name == "labels" {
+			if i+1 >= len(n.path) {
+				return nil, fmt.Errorf("missing label key in %s", strings.Join(n.path, "."))
+			}
+			i++
+			value = ctx.labels(e)[n.path[i]]
+			continue
+		}
+
+		v, err := ctx.field(e, name)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	}
+	return value, nil
+}
+
+// compareNode compares two values.
+type compareNode struct {
+	op          string
+	left, right exprNode
+
+	// re is the compiled pattern for the =~ and !~ operators if the
+	// pattern is a literal.
+	re *regexp.Regexp
+}
+
+func (n compareNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	left, err := n.left.eval(ctx, self)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx, self)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return ctx.equal(left, right), nil
+	case "!=":
+		return !ctx.equal(left, right), nil
+	case "=~", "!~":
+		re := n.re
+		if re == nil {
+			re, err = regexp.Compile(ctx.text(right))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %v", err)
+			}
+		}
+		matched := re.MatchString(ctx.text(left))
+		return matched == (n.op == "=~"), nil
+	}
+
+	a, err := ctx.number(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.number(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
+
+// inGroupNode is true if the component is a member of the group. The
+// group is matched by ID or by name.
+type inGroupNode struct {
+	operand exprNode
+	group   exprNode
+}
+
+func (n inGroupNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	v, err := n.operand.eval(ctx, self)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := v.(exprEntity)
+	if !ok || e.kind != entityComponent {
+		return nil, fmt.Errorf("only components can be in a group")
+	}
+	g, err := n.group.eval(ctx, self)
+	if err != nil {
+		return nil, err
+	}
+	group := ctx.text(g)
+
+	id := ctx.id(e)
+	for i := range ctx.memberOf[id] {
+		if ctx.arch.Groups[i].ID == group || ctx.arch.Groups[i].Name == group {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// inListNode is true if the value is equal to any value in the list.
+type inListNode struct {
+	operand exprNode
+	list    []exprNode
+}
+
+func (n inListNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	v, err := n.operand.eval(ctx, self)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range n.list {
+		w, err := item.eval(ctx, self)
+		if err != nil {
+			return nil, err
+		}
+		if set, ok := w.(exprSet); ok {
+			if i, ok := ctx.componentIndex(v); ok && set[i] {
+				return true, nil
+			}
+			continue
+		}
+		if ctx.equal(v, w) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// exprSet is a set of components, by index, returned by the graph
+// functions.
+type exprSet map[int]bool
+
+// componentIndex returns the index of the component that the value
+// refers to. The value is either a component or the ID of one.
+func (ctx *exprContext) componentIndex(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case exprEntity:
+		return v.index, v.kind == entityComponent
+	case string:
+		i, ok := ctx.graph.index[v]
+		return i, ok
+	default:
+		return 0, false
+	}
+}
+
+// exprFunction is a function that can be called in an expression.
+type exprFunction func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error)
+
+// exprFunctions is the table of functions that can be called in an
+// expression.
+var exprFunctions = map[string]exprFunction{
+	"lower": func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("lower expects 1 argument")
+		}
+		return strings.ToLower(ctx.text(args[0])), nil
+	},
+	"contains": func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("contains expects 2 arguments")
+		}
+		return strings.Contains(ctx.text(args[0]), ctx.text(args[1])), nil
+	},
+	"neighbours": func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error) {
+		return ctx.graphSet(args, directionBoth, 1)
+	},
+	"upstream": func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error) {
+		return ctx.graphSet(args, directionUpstream, -1)
+	},
+	"downstream": func(ctx *exprContext, self exprEntity, args []interface{}) (interface{}, error) {
+		return ctx.graphSet(args, directionDownstream, -1)
+	},
+}
+
+// graphSet returns the components that are within a number of hops of
+// the component in the direction, not including the component itself.
+// The arguments are the component and an optional number of hops. A
+// negative number of hops follows the connections as far as they go.
+func (ctx *exprContext) graphSet(args []interface{}, direction string, hops int) (exprSet, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("expects a component and an optional number of hops")
+	}
+	start, ok := ctx.componentIndex(args[0])
+	if !ok {
+		return nil, fmt.Errorf("unknown component: %s", ctx.text(args[0]))
+	}
+	if len(args) == 2 {
+		n, err := ctx.number(args[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("hops must be a positive number")
+		}
+		hops = int(n)
+	}
+
+	// The sets are cached because the same call is usually made for
+	// every entity that is tested
+	key := fmt.Sprintf("%s:%d:%d", direction, start, hops)
+	if set, ok := ctx.sets[key]; ok {
+		return set, nil
+	}
+
+	var set exprSet
+	if hops < 0 {
+		set = exprSet(ctx.graph.reachable([]int{start}, direction == directionUpstream))
+	} else {
+		set = exprSet(ctx.graph.within([]int{start}, hops, direction))
+	}
+	delete(set, start)
+
+	ctx.sets[key] = set
+	return set, nil
+}
+
+// callNode calls a function with the values of its arguments.
+type callNode struct {
+	name string
+	fn   exprFunction
+	args []exprNode
+}
+
+func (n callNode) eval(ctx *exprContext, self exprEntity) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(ctx, self)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(ctx, self, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+// text converts a value to a string. Entities are converted to their
+// ID.
+func (ctx *exprContext) text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case exprEntity:
+		return ctx.id(v)
+	default:
+		return ""
+	}
+}
+
+// number converts a value to a number. Strings can be plain numbers
+// or rates and sizes with units.
+func (ctx *exprContext) number(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+		if rate, err := parseRate(v); err == nil {
+			return float64(rate), nil
+		}
+		if size, err := parseSize(v); err == nil {
+			return float64(size), nil
+		}
+		return 0, fmt.Errorf("%q is not a number", v)
+	default:
+		return 0, fmt.Errorf("%q is not a number", ctx.text(v))
+	}
+}
+
+// equal returns true if the values are equal. Numbers are compared
+// with strings by converting the string to a number, and entities are
+// compared by their ID.
+func (ctx *exprContext) equal(a, b interface{}) bool {
+	_, aNumber := a.(float64)
+	_, bNumber := b.(float64)
+	if aNumber || bNumber {
+		x, errA := ctx.number(a)
+		y, errB := ctx.number(b)
+		return errA == nil && errB == nil && x == y
+	}
+	return ctx.text(a) == ctx.text(b)
+}
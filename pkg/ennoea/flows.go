@@ -0,0 +1,594 @@
+package ennoea
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+The flows importer builds an architecture from the traffic seen on a
+network. It reads NetFlow v5 export packets, IPFIX messages and AWS VPC
+flow log text files, which can be gzip compressed, and tells them apart
+by their first bytes.
+
+The endpoints of the flows are mapped to components by the networks of
+the configuration, with the longest matching prefix winning:
+
+	{"networks": {"10.0.1.0/24": "web", "10.0.2.15": "orders-db"}}
+
+Without networks every address is a component of its own. With them,
+the addresses that are in none of the networks are merged into an
+"external" component and summarised in the warnings, and the flows
+between two of them are not imported. Servers can also be split into a
+component per port with "ports": true.
+
+Flow records only go one way, so the server of each flow is the end
+with the lower port, which is the service port of most connections.
+The connections go from the clients to the servers, with the bytes sent
+to the server as the out rate and the bytes sent back as the in rate,
+over the time covered by all the flows. The labels are:
+
+	labels.flows      the number of flow records
+	labels.protocols  the IP protocols of the flows
+	labels.ports      the server ports of the flows
+*/
+
+const (
+	// maxFlowPorts is the largest number of server ports listed in the
+	// ports label of a connection.
+	maxFlowPorts = 10
+
+	// maxUnmappedSummary is the number of unmapped addresses that are
+	// listed by name in the warnings.
+	maxUnmappedSummary = 10
+)
+
+// FlowConfig represents the configuration of a flows or pcap import.
+type FlowConfig struct {
+	// Networks is a map of IP addresses or CIDR blocks to the names of
+	// the components they belong to.
+	Networks map[string]string `json:"networks"`
+
+	// Ports makes a component of each port of a server, such as
+	// "10.0.2.15:5432", instead of one for the whole host.
+	Ports bool `json:"ports"`
+}
+
+// flowNetwork is a network of the configuration.
+type flowNetwork struct {
+	prefix    netip.Prefix
+	component string
+}
+
+// flowRecord is a flow of packets from one endpoint to another. The
+// times are in milliseconds since the epoch.
+type flowRecord struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	protocol         uint8
+	packets, bytes   uint64
+	start, end       int64
+}
+
+// flowConversation is the traffic between a client and a server. The
+// out bytes and packets are sent to the server and the in bytes and
+// packets are sent back. The times are in milliseconds since the
+// epoch.
+type flowConversation struct {
+	client, server         netip.Addr
+	clientPort, serverPort uint16
+	protocol               uint8
+	outBytes, outPackets   uint64
+	inBytes, inPackets     uint64
+	start, end             int64
+}
+
+// flowComponent is a component of the endpoints of the traffic.
+type flowComponent struct {
+	// address is the address of a component that is one address, or
+	// empty for a component of the networks of the configuration.
+	address string
+
+	// port is the port of a server component, if the components are
+	// made per port.
+	port uint16
+
+	// networks is the networks that matched the addresses of the
+	// component.
+	networks map[string]bool
+}
+
+// flowEdge accumulates the conversations between a client and a
+// server.
+type flowEdge struct {
+	client, server       string
+	outBytes, outPackets uint64
+	inBytes, inPackets   uint64
+	count                int
+	protocols            map[uint8]bool
+	ports                map[uint16]bool
+}
+
+// flowUnmapped accumulates the traffic of an address that is not in the
+// networks of the configuration.
+type flowUnmapped struct {
+	address netip.Addr
+	bytes   uint64
+	count   int
+}
+
+// flowImport holds the state of a flows import.
+type flowImport struct {
+	result   *ImportResult
+	networks []flowNetwork
+	ports    bool
+
+	// counted is the label of the number of flow records or
+	// conversations of a connection.
+	counted string
+
+	components map[string]*flowComponent
+
+	edges    map[[2]string]*flowEdge
+	order    []*flowEdge
+	unmapped map[netip.Addr]*flowUnmapped
+
+	// dropped is the number of flows between unmapped addresses.
+	dropped int
+
+	// start and end are the times covered by the flows.
+	start, end int64
+}
+
+// newFlowImport returns a flow import with the configuration, if there
+// is one.
+func newFlowImport(result *ImportResult, config []byte, counted string) (*flowImport, error) {
+	f := &flowImport{
+		result:     result,
+		counted:    counted,
+		components: make(map[string]*flowComponent),
+		edges:      make(map[[2]string]*flowEdge),
+		unmapped:   make(map[netip.Addr]*flowUnmapped),
+		start:      math.MaxInt64,
+	}
+	if len(config) > 0 {
+		var c FlowConfig
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %v", err)
+		}
+		networks, err := c.networks()
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+		f.networks = networks
+		f.ports = c.Ports
+	}
+	return f, nil
+}
+
+// importFlows imports NetFlow v5, IPFIX or AWS VPC flow log records.
+func importFlows(data, config []byte) (ImportResult, error) {
+	var result ImportResult
+	f, err := newFlowImport(&result, config, "flows")
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	data, err = gunzipImport(data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var records []flowRecord
+	switch {
+	case len(data) >= 2 && binary.BigEndian.Uint16(data) == 5:
+		records, err = parseNetFlowV5(data)
+	case len(data) >= 2 && binary.BigEndian.Uint16(data) == 10:
+		records, err = parseIPFIX(data, &result)
+	default:
+		records, err = parseVPCFlowLogs(data, &result)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if len(records) == 0 {
+		return ImportResult{}, fmt.Errorf("no flow records found")
+	}
+
+	for _, r := range records {
+		f.add(r)
+	}
+	if err := f.complete(); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// complete adds the components and connections of the traffic to the
+// architecture and summarises the unmapped addresses.
+func (f *flowImport) complete() error {
+	if len(f.order) == 0 {
+		return fmt.Errorf("no traffic between mapped endpoints")
+	}
+	f.architecture()
+	f.summarise()
+	return nil
+}
+
+// networks returns the networks of the configuration with the longest
+// prefixes first.
+func (c FlowConfig) networks() ([]flowNetwork, error) {
+	var networks []flowNetwork
+	for _, network := range sortedKeys(c.Networks) {
+		component := c.Networks[network]
+		if component == "" {
+			return nil, fmt.Errorf("network %s has no component", network)
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid network: %s", network)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		networks = append(networks, flowNetwork{prefix: prefix.Masked(), component: component})
+	}
+	sort.SliceStable(networks, func(i, j int) bool {
+		return networks[i].prefix.Bits() > networks[j].prefix.Bits()
+	})
+	return networks, nil
+}
+
+// endpoint returns the component of an address and port, or false if
+// the address is not in the networks of the configuration.
+func (f *flowImport) endpoint(addr netip.Addr, port uint16, server bool) (string, bool) {
+	addr = addr.Unmap()
+	id := addr.String()
+	var network string
+	if len(f.networks) > 0 {
+		i := 0
+		for i < len(f.networks) && !f.networks[i].prefix.Contains(addr) {
+			i++
+		}
+		if i == len(f.networks) {
+			return "", false
+		}
+		id, network = f.networks[i].component, f.networks[i].prefix.String()
+	}
+	if !server || !f.ports || port == 0 {
+		port = 0
+	} else if len(f.networks) == 0 {
+		id = netip.AddrPortFrom(addr, port).String()
+	} else {
+		id = fmt.Sprintf("%s:%d", id, port)
+	}
+
+	c, ok := f.components[id]
+	if !ok {
+		c = &flowComponent{port: port, networks: make(map[string]bool)}
+		if len(f.networks) == 0 {
+			c.address = addr.String()
+		}
+		f.components[id] = c
+	}
+	if network != "" {
+		c.networks[network] = true
+	}
+	return id, true
+}
+
+// add adds a flow record to the edge between its client and server.
+// The end with the lower port is the server.
+func (f *flowImport) add(r flowRecord) {
+	c := flowConversation{protocol: r.protocol, start: r.start, end: r.end}
+	if r.dstPort <= r.srcPort {
+		c.client, c.clientPort, c.server, c.serverPort = r.src, r.srcPort, r.dst, r.dstPort
+		c.outBytes, c.outPackets = r.bytes, r.packets
+	} else {
+		c.client, c.clientPort, c.server, c.serverPort = r.dst, r.dstPort, r.src, r.srcPort
+		c.inBytes, c.inPackets = r.bytes, r.packets
+	}
+	f.addConversation(c)
+}
+
+// addConversation adds a conversation to the edge between its client
+// and server.
+func (f *flowImport) addConversation(c flowConversation) {
+	if c.start < f.start {
+		f.start = c.start
+	}
+	if c.end > f.end {
+		f.end = c.end
+	}
+
+	client, clientMapped := f.endpoint(c.client, c.clientPort, false)
+	server, serverMapped := f.endpoint(c.server, c.serverPort, true)
+	for _, end := range []struct {
+		addr   netip.Addr
+		mapped bool
+	}{{c.client, clientMapped}, {c.server, serverMapped}} {
+		if end.mapped {
+			continue
+		}
+		addr := end.addr.Unmap()
+		u, ok := f.unmapped[addr]
+		if !ok {
+			u = &flowUnmapped{address: addr}
+			f.unmapped[addr] = u
+		}
+		u.bytes += c.outBytes + c.inBytes
+		u.count++
+	}
+	switch {
+	case !clientMapped && !serverMapped:
+		f.dropped++
+		return
+	case !clientMapped:
+		client = "external"
+	case !serverMapped:
+		server = "external"
+	}
+
+	e, ok := f.edges[[2]string{client, server}]
+	if !ok {
+		e = &flowEdge{
+			client:    client,
+			server:    server,
+			protocols: make(map[uint8]bool),
+			ports:     make(map[uint16]bool),
+		}
+		f.edges[[2]string{client, server}] = e
+		f.order = append(f.order, e)
+	}
+	e.outBytes += c.outBytes
+	e.outPackets += c.outPackets
+	e.inBytes += c.inBytes
+	e.inPackets += c.inPackets
+	e.count++
+	e.protocols[c.protocol] = true
+	if c.serverPort != 0 {
+		e.ports[c.serverPort] = true
+	}
+}
+
+// architecture adds the components and connections of the flows.
+func (f *flowImport) architecture() {
+	arch := &f.result.Architecture
+	window := math.Max(1, float64(f.end-f.start)/1000)
+
+	seen := make(map[string]bool)
+	addComponent := func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if id == "external" {
+			arch.Components = append(arch.Components, newExternalComponent(id))
+			return
+		}
+		component := f.components[id]
+		var c Component
+		if component.address != "" {
+			c = newImportedComponent(id, id, "server")
+			c.Labels["address"] = component.address
+		} else {
+			c = newImportedComponent(id, id, "app")
+			c.Labels["networks"] = strings.Join(sortedKeys(component.networks), ",")
+		}
+		if component.port != 0 {
+			c.Labels["port"] = strconv.Itoa(int(component.port))
+		}
+		arch.Components = append(arch.Components, c)
+	}
+
+	for _, e := range f.order {
+		addComponent(e.client)
+		addComponent(e.server)
+
+		c := newImportedConnection(e.client+"->"+e.server, e.client+" to "+e.server, e.client, e.server)
+		c.OutRate = Rate(math.Round(float64(e.outBytes) / window))
+		c.InRate = Rate(math.Round(float64(e.inBytes) / window))
+		if e.outPackets > 0 {
+			c.OutPacketSize = Size(e.outBytes / e.outPackets)
+		}
+		if e.inPackets > 0 {
+			c.InPacketSize = Size(e.inBytes / e.inPackets)
+		}
+		if e.inBytes > 0 {
+			c.Flow = "bi"
+		}
+
+		var protocols []string
+		for protocol := range e.protocols {
+			protocols = append(protocols, flowProtocol(protocol))
+		}
+		sort.Strings(protocols)
+		var ports []int
+		for port := range e.ports {
+			ports = append(ports, int(port))
+		}
+		sort.Ints(ports)
+		var portNames []string
+		for i, port := range ports {
+			if i == maxFlowPorts {
+				portNames = append(portNames, fmt.Sprintf("and %d more", len(ports)-maxFlowPorts))
+				break
+			}
+			portNames = append(portNames, strconv.Itoa(port))
+		}
+		c.Labels[f.counted] = strconv.Itoa(e.count)
+		c.Labels["protocols"] = strings.Join(protocols, ",")
+		if len(portNames) > 0 {
+			c.Labels["ports"] = strings.Join(portNames, ",")
+		}
+		arch.Connections = append(arch.Connections, c)
+	}
+}
+
+// summarise adds a warning that lists the unmapped addresses with the
+// most bytes.
+func (f *flowImport) summarise() {
+	if len(f.unmapped) == 0 {
+		return
+	}
+	unmapped := make([]*flowUnmapped, 0, len(f.unmapped))
+	for _, u := range f.unmapped {
+		unmapped = append(unmapped, u)
+	}
+	sort.Slice(unmapped, func(i, j int) bool {
+		if unmapped[i].bytes != unmapped[j].bytes {
+			return unmapped[i].bytes > unmapped[j].bytes
+		}
+		return unmapped[i].address.Less(unmapped[j].address)
+	})
+
+	var listed []string
+	for i, u := range unmapped {
+		if i == maxUnmappedSummary {
+			listed = append(listed, fmt.Sprintf("and %d more", len(unmapped)-maxUnmappedSummary))
+			break
+		}
+		listed = append(listed, fmt.Sprintf("%s (%s in %d %s)", u.address, Size(u.bytes), u.count, f.counted))
+	}
+	f.result.warnf("%d addresses are not in the networks of the config and are external: %s", len(unmapped), strings.Join(listed, ", "))
+	if f.dropped > 0 {
+		f.result.warnf("%d %s between external addresses were not imported", f.dropped, f.counted)
+	}
+}
+
+// flowProtocols is a map of the common IP protocol numbers to their
+// names.
+var flowProtocols = map[uint8]string{
+	1:   "icmp",
+	6:   "tcp",
+	17:  "udp",
+	47:  "gre",
+	50:  "esp",
+	58:  "icmpv6",
+	132: "sctp",
+}
+
+// flowProtocol returns the name of an IP protocol, or its number if it
+// has no name.
+func flowProtocol(protocol uint8) string {
+	if name, ok := flowProtocols[protocol]; ok {
+		return name
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// vpcDefaultFields is the fields of the default format of AWS VPC flow
+// logs, which is used for files without a header.
+var vpcDefaultFields = []string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+}
+
+// parseVPCFlowLogs parses the records of AWS VPC flow log text files.
+// A header line names the fields of the records after it, and the
+// records of rejected traffic and without data are skipped.
+func parseVPCFlowLogs(data []byte, result *ImportResult) ([]flowRecord, error) {
+	var records []flowRecord
+	fields := vpcDefaultFields
+	rejected := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		values := strings.Fields(scanner.Text())
+		if len(values) == 0 {
+			continue
+		}
+		if containsString(values, "srcaddr") && containsString(values, "dstaddr") {
+			// A header, which names the fields
+			fields = values
+			continue
+		}
+		if len(values) != len(fields) {
+			if line == 1 {
+				return nil, fmt.Errorf("expected netflow v5, ipfix or vpc flow logs")
+			}
+			result.warnf("line %d: expected %d fields, found %d", line, len(fields), len(values))
+			continue
+		}
+
+		record := make(map[string]string, len(fields))
+		for i, field := range fields {
+			record[field] = values[i]
+		}
+		if record["action"] == "REJECT" {
+			rejected++
+			continue
+		}
+		if record["log-status"] == "NODATA" || record["log-status"] == "SKIPDATA" {
+			continue
+		}
+		r, err := vpcFlowRecord(record)
+		if err != nil {
+			if line == 1 {
+				return nil, fmt.Errorf("expected netflow v5, ipfix or vpc flow logs")
+			}
+			result.warnf("line %d: %v", line, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read flow logs: %v", err)
+	}
+	if rejected > 0 {
+		result.warnf("%d records of rejected traffic were skipped", rejected)
+	}
+	return records, nil
+}
+
+// vpcFlowRecord returns the flow of a record of a VPC flow log. The
+// addresses of the packets are used if the format has them, since they
+// are the real ends of traffic through a NAT or a load balancer.
+func vpcFlowRecord(record map[string]string) (flowRecord, error) {
+	var r flowRecord
+	var err error
+	address := func(packetField, field string) netip.Addr {
+		value := record[packetField]
+		if value == "" || value == "-" {
+			value = record[field]
+		}
+		addr, parseErr := netip.ParseAddr(value)
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("invalid %s: %s", field, value)
+		}
+		return addr
+	}
+	number := func(field string, bits int) uint64 {
+		value := record[field]
+		if value == "" || value == "-" {
+			return 0
+		}
+		n, parseErr := strconv.ParseUint(value, 10, bits)
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("invalid %s: %s", field, value)
+		}
+		return n
+	}
+
+	r.src = address("pkt-srcaddr", "srcaddr")
+	r.dst = address("pkt-dstaddr", "dstaddr")
+	r.srcPort = uint16(number("srcport", 16))
+	r.dstPort = uint16(number("dstport", 16))
+	r.protocol = uint8(number("protocol", 8))
+	r.packets = number("packets", 64)
+	r.bytes = number("bytes", 64)
+	r.start = int64(number("start", 63)) * 1000
+	r.end = int64(number("end", 63)) * 1000
+	return r, err
+}
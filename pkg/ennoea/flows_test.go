@@ -0,0 +1,67 @@
+package ennoea
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// testVPCFlowLogs is a VPC flow log with a header, the conversation of
+// testFlows and a rejected connection.
+const testVPCFlowLogs = `version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status
+2 123456789012 eni-1 10.0.0.1 10.0.0.2 50000 443 6 10 1000 1700000000 1700000010 ACCEPT OK
+2 123456789012 eni-1 10.0.0.2 10.0.0.1 443 50000 6 8 5000 1700000000 1700000010 ACCEPT OK
+2 123456789012 eni-1 10.0.0.9 10.0.0.2 40000 22 6 1 40 1700000000 1700000010 REJECT OK
+`
+
+func TestImportFlows(t *testing.T) {
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(testNetFlowV5(0, testFlows...))
+	w.Close()
+
+	conversation := []string{
+		`component 10.0.0.1 "10.0.0.1" box`,
+		`component 10.0.0.2 "10.0.0.2" box`,
+		`connection 10.0.0.1->10.0.0.2 bi`,
+	}
+	testImports(t, "flows", []importTest{
+		{name: "netflow v5", data: string(testNetFlowV5(0, testFlows...)), want: conversation},
+		{name: "gzipped netflow v5", data: gzipped.String(), want: conversation},
+		{name: "ipfix", data: string(testIPFIX(testFlows...)), want: conversation},
+		{
+			name: "vpc flow logs",
+			data: testVPCFlowLogs,
+			want: append(conversation, "warning 1 records of rejected traffic were skipped"),
+		},
+		{
+			name:   "networks",
+			data:   testVPCFlowLogs + "2 123456789012 eni-1 10.0.1.5 10.0.0.2 40000 443 6 1 40 1700000000 1700000010 ACCEPT OK\n",
+			config: `{"networks": {"10.0.0.1": "web", "10.0.0.0/24": "api"}, "ports": true}`,
+			want: []string{
+				`component web "web" box`,
+				`component api:443 "api:443" box`,
+				`component external "External" sphere`,
+				`connection web->api:443 bi`,
+				`connection external->api:443 out`,
+				"warning 1 records of rejected traffic were skipped",
+				"warning 1 addresses are not in the networks of the config and are external: 10.0.1.5 (40B in 1 flows)",
+			},
+		},
+		{name: "not flows", data: "hello world\n", err: "expected netflow v5, ipfix or vpc flow logs"},
+		{name: "no records", data: "", err: "no flow records found"},
+	})
+
+	// The bytes of both directions are averaged over the ten seconds
+	result, err := Import("flows", testNetFlowV5(0, testFlows...), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := result.Architecture.Connections[0]
+	if c.OutRate != 100 || c.InRate != 500 || c.OutPacketSize != 100 || c.InPacketSize != 625 {
+		t.Errorf("rates = %v and %v, sizes = %v and %v, want 100B/s, 500B/s, 100B and 625B", c.OutRate, c.InRate, c.OutPacketSize, c.InPacketSize)
+	}
+	if c.Labels["flows"] != "2" || c.Labels["protocols"] != "tcp" || c.Labels["ports"] != "443" {
+		t.Errorf("labels = %v, want 2 tcp flows on port 443", c.Labels)
+	}
+}
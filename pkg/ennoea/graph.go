@@ -0,0 +1,233 @@
+package ennoea
+
+import "sort"
+
+// graphEdge represents a directed edge between two components. Each
+// edge is created from a connection, and a connection with a "bi"
+// flow creates an edge in each direction.
+type graphEdge struct {
+	// from is the index of the component that the data flows from.
+	from int
+
+	// to is the index of the component that the data flows to.
+	to int
+
+	// connection is the index of the connection that created the edge.
+	connection int
+
+	// forward is true if the edge goes from the source to the target
+	// of the connection, and false if it goes from the target to the
+	// source.
+	forward bool
+}
+
+// architectureGraph is a directed graph of the components in an
+// architecture. The edges follow the flow of data through the
+// connections:
+//
+//	"out" creates an edge from the source to the target.
+//	"in" creates an edge from the target to the source.
+//	"bi" creates an edge in both directions.
+type architectureGraph struct {
+	// arch is the architecture that the graph was built from.
+	arch Architecture
+
+	// index is a map of component IDs to their index in the
+	// architecture components list.
+	index map[string]int
+
+	// out is the list of outgoing edges of each component.
+	out [][]graphEdge
+
+	// in is the list of incoming edges of each component.
+	in [][]graphEdge
+}
+
+// newArchitectureGraph builds the directed graph of the architecture.
+// Connections that refer to unknown components are ignored.
+func newArchitectureGraph(arch Architecture) *architectureGraph {
+	g := &architectureGraph{
+		arch:  arch,
+		index: make(map[string]int, len(arch.Components)),
+		out:   make([][]graphEdge, len(arch.Components)),
+		in:    make([][]graphEdge, len(arch.Components)),
+	}
+	for i, c := range arch.Components {
+		g.index[c.ID] = i
+	}
+
+	for i, c := range arch.Connections {
+		source, ok := g.index[c.Source]
+		if !ok {
+			continue
+		}
+		target, ok := g.index[c.Target]
+		if !ok {
+			continue
+		}
+
+		if c.Flow == "out" || c.Flow == "bi" {
+			g.addEdge(graphEdge{from: source, to: target, connection: i, forward: true})
+		}
+		if c.Flow == "in" || c.Flow == "bi" {
+			g.addEdge(graphEdge{from: target, to: source, connection: i, forward: false})
+		}
+	}
+
+	return g
+}
+
+// addEdge adds the edge to the graph.
+func (g *architectureGraph) addEdge(e graphEdge) {
+	g.out[e.from] = append(g.out[e.from], e)
+	g.in[e.to] = append(g.in[e.to], e)
+}
+
+// size returns the number of components in the graph.
+func (g *architectureGraph) size() int {
+	return len(g.arch.Components)
+}
+
+// id returns the ID of the component at the index.
+func (g *architectureGraph) id(i int) string {
+	return g.arch.Components[i].ID
+}
+
+// ids returns the IDs of the components at the indexes.
+func (g *architectureGraph) ids(indexes []int) []string {
+	ids := make([]string, len(indexes))
+	for i, index := range indexes {
+		ids[i] = g.id(index)
+	}
+	return ids
+}
+
+// connectionID returns the ID of the connection that created the edge.
+func (g *architectureGraph) connectionID(e graphEdge) string {
+	return g.arch.Connections[e.connection].ID
+}
+
+// successors returns the distinct components that the component
+// sends data to.
+func (g *architectureGraph) successors(i int) []int {
+	seen := make(map[int]bool)
+	var result []int
+	for _, e := range g.out[i] {
+		if !seen[e.to] {
+			seen[e.to] = true
+			result = append(result, e.to)
+		}
+	}
+	return result
+}
+
+// predecessors returns the distinct components that the component
+// receives data from.
+func (g *architectureGraph) predecessors(i int) []int {
+	seen := make(map[int]bool)
+	var result []int
+	for _, e := range g.in[i] {
+		if !seen[e.from] {
+			seen[e.from] = true
+			result = append(result, e.from)
+		}
+	}
+	return result
+}
+
+// reachable returns the set of components that can be reached from
+// the start components by following the edges forwards, or backwards
+// if reverse is true. The start components are only included if they
+// can be reached from another start component.
+func (g *architectureGraph) reachable(start []int, reverse bool) map[int]bool {
+	seen := make(map[int]bool)
+	queue := append([]int{}, start...)
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+
+		next := g.successors(i)
+		if reverse {
+			next = g.predecessors(i)
+		}
+		for _, j := range next {
+			if !seen[j] {
+				seen[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	return seen
+}
+
+// stronglyConnectedComponents returns the strongly connected
+// components of the graph using Tarjan's algorithm. The components
+// are returned in reverse topological order, so a component only
+// has edges to components that come before it in the list.
+func (g *architectureGraph) stronglyConnectedComponents() [][]int {
+	index := 0
+	indexes := make([]int, g.size())
+	lowlinks := make([]int, g.size())
+	visited := make([]bool, g.size())
+	onStack := make([]bool, g.size())
+	var stack []int
+	var result [][]int
+
+	var connect func(v int)
+	connect = func(v int) {
+		indexes[v] = index
+		lowlinks[v] = index
+		visited[v] = true
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.successors(v) {
+			if !visited[w] {
+				connect(w)
+				lowlinks[v] = min(lowlinks[v], lowlinks[w])
+			} else if onStack[w] {
+				lowlinks[v] = min(lowlinks[v], indexes[w])
+			}
+		}
+
+		if lowlinks[v] == indexes[v] {
+			var scc []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Ints(scc)
+			result = append(result, scc)
+		}
+	}
+
+	for v := 0; v < g.size(); v++ {
+		if !visited[v] {
+			connect(v)
+		}
+	}
+
+	return result
+}
+
+// min returns the smaller of the two integers.
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// max returns the larger of the two integers.
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
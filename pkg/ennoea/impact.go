@@ -0,0 +1,348 @@
+package ennoea
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Colours used to paint the components in the derived impact view.
+const (
+	impactFailedColor     = "#ff0000"
+	impactDirectColor     = "#ff8000"
+	impactIndirectColor   = "#ffd000"
+	impactDegradedColor   = "#3080ff"
+	impactUnaffectedColor = "#808080"
+)
+
+// Dependency models of the impact analysis.
+const (
+	// ImpactDependencyCalls makes the source of each connection depend
+	// on its target, whatever the flow of the data, so a service
+	// depends on the database that it calls.
+	ImpactDependencyCalls = "calls"
+
+	// ImpactDependencyData makes each component depend on the
+	// components that send data to it through the connections.
+	ImpactDependencyData = "data"
+)
+
+// Ways of finding the redundant peers in the impact analysis.
+const (
+	// ImpactPeersLabel only makes the components with the same role
+	// label redundant peers of each other.
+	ImpactPeersLabel = "label"
+
+	// ImpactPeersNames also makes the components without a role label
+	// peers when their names only differ by a trailing replica
+	// number, such as app-1 and app-2. Names are not reliable, so
+	// this must be asked for.
+	ImpactPeersNames = "names"
+)
+
+// ImpactAnalysis represents the blast radius of the failure of one or
+// more components.
+type ImpactAnalysis struct {
+	// Dependency is the dependency model that was used, either
+	// "calls" or "data".
+	Dependency string `json:"dependency"`
+
+	// Peers is the way the redundant peers were found, either "label"
+	// or "names".
+	Peers string `json:"peers"`
+
+	// Model describes how the components were classified.
+	Model string `json:"model"`
+
+	// Failed is the list of the components that have failed.
+	Failed []string `json:"failed"`
+
+	// DirectlyAffected is the list of the affected components that
+	// depend on a failed component.
+	DirectlyAffected []string `json:"directlyAffected"`
+
+	// IndirectlyAffected is the list of the affected components that
+	// only depend on failed components through other affected
+	// components.
+	IndirectlyAffected []string `json:"indirectlyAffected"`
+
+	// Degraded is the list of the components that depend on a failed
+	// component, directly or not, but still have a redundant peer of
+	// every dependency that they lost.
+	Degraded []string `json:"degraded"`
+
+	// Architecture is a derived view of the architecture with the
+	// components recoloured by how they are affected.
+	Architecture Architecture `json:"architecture"`
+}
+
+// impactModels describe the classification of the components for the
+// response by the way the peers are found, since the answer depends on
+// it.
+var impactModels = map[string]string{
+	ImpactPeersLabel: "A component is affected when every component of one of its dependency roles has failed or is affected. " +
+		"The components of a role are redundant peers: the components with the same role label. A component without a role label is a role of its own. " +
+		"Dependencies with different roles are all required. A component that depends on a failed component, directly or not, and is not affected is degraded.",
+	ImpactPeersNames: "A component is affected when every component of one of its dependency roles has failed or is affected. " +
+		"The components of a role are redundant peers: the components with the same role label, or else the same name without a trailing replica number, such as app-1 and app-2. " +
+		"Dependencies with different roles are all required. A component that depends on a failed component, directly or not, and is not affected is degraded.",
+}
+
+// impactRolePattern matches a component name with a replica number at
+// the end. The number must follow a letter, so that addresses such as
+// 10.0.1.5 are not taken for replicas.
+var impactRolePattern = regexp.MustCompile(`^(.*[A-Za-z])[\s_-]*[0-9]+$`)
+
+// impactRole returns the role of the component. Components with the
+// same role are redundant peers of each other. Without a role label,
+// the role is taken from the name if the peers are found by name, and
+// is otherwise the ID so that the component has no peers.
+func impactRole(c Component, peers string) string {
+	if role := c.Labels["role"]; role != "" {
+		return "label:" + role
+	}
+	if peers != ImpactPeersNames {
+		return "id:" + c.ID
+	}
+	if match := impactRolePattern.FindStringSubmatch(c.Name); match != nil {
+		return "name:" + strings.ToLower(match[1])
+	}
+	return "name:" + strings.ToLower(c.Name)
+}
+
+// impactDependencies returns the distinct components that each
+// component depends on in the dependency model.
+func impactDependencies(g *architectureGraph, dependency string) ([][]int, error) {
+	dependencies := make([][]int, g.size())
+	switch dependency {
+	case ImpactDependencyCalls:
+		seen := make(map[[2]int]bool)
+		for _, c := range g.arch.Connections {
+			source, ok := g.index[c.Source]
+			if !ok {
+				continue
+			}
+			target, ok := g.index[c.Target]
+			if !ok || source == target || seen[[2]int{source, target}] {
+				continue
+			}
+			seen[[2]int{source, target}] = true
+			dependencies[source] = append(dependencies[source], target)
+		}
+	case ImpactDependencyData:
+		for i := range dependencies {
+			dependencies[i] = g.predecessors(i)
+		}
+	default:
+		return nil, fmt.Errorf("unknown dependency model: %s", dependency)
+	}
+	return dependencies, nil
+}
+
+// analyseImpact computes the impact of the failure of the components
+// in the dependency model, finding the redundant peers in the way
+// given.
+//
+// Every component that depends on a failed component, directly or
+// through other components, is considered. The dependencies of each
+// component are split into roles of redundant peers, and a component
+// is affected if all of the peers of any one role have failed or are
+// affected themselves. Otherwise it is only degraded. This is computed
+// as a greatest fixed point, so components in a cycle that only
+// depends on failed components are affected.
+func analyseImpact(arch Architecture, failed []string, dependency, peers string) (ImpactAnalysis, error) {
+	model, ok := impactModels[peers]
+	if !ok {
+		return ImpactAnalysis{}, fmt.Errorf("unknown peers: %s", peers)
+	}
+	g := newArchitectureGraph(arch)
+	dependencies, err := impactDependencies(g, dependency)
+	if err != nil {
+		return ImpactAnalysis{}, err
+	}
+
+	// Find the indexes of the failed components
+	isFailed := make(map[int]bool, len(failed))
+	for _, id := range failed {
+		i, ok := g.index[id]
+		if !ok {
+			return ImpactAnalysis{}, fmt.Errorf("unknown component: %s", id)
+		}
+		isFailed[i] = true
+	}
+
+	// Find the components that depend on the failed components by
+	// following the dependencies backwards
+	dependents := make([][]int, g.size())
+	for i, deps := range dependencies {
+		for _, j := range deps {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	downstream := make(map[int]bool)
+	var queue []int
+	for i := range isFailed {
+		queue = append(queue, i)
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, j := range dependents[i] {
+			if !downstream[j] && !isFailed[j] {
+				downstream[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	// Start by assuming everything downstream is affected, then
+	// remove the components that still have a live peer in every
+	// role until nothing changes.
+	affected := make(map[int]bool, len(downstream))
+	for i := range downstream {
+		affected[i] = true
+	}
+	lostRole := func(i int) bool {
+		live := make(map[string]bool)
+		for _, j := range dependencies[i] {
+			role := impactRole(arch.Components[j], peers)
+			live[role] = live[role] || (!isFailed[j] && !affected[j])
+		}
+		for _, ok := range live {
+			if !ok {
+				return true
+			}
+		}
+		return false
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range affected {
+			if !lostRole(i) {
+				delete(affected, i)
+				changed = true
+			}
+		}
+	}
+
+	impact := ImpactAnalysis{
+		Dependency:         dependency,
+		Peers:              peers,
+		Model:              model,
+		Failed:             []string{},
+		DirectlyAffected:   []string{},
+		IndirectlyAffected: []string{},
+		Degraded:           []string{},
+		Architecture:       arch,
+	}
+	impact.Architecture.Info.ID = arch.Info.ID + "-impact"
+	impact.Architecture.Info.Name = arch.Info.Name + " (impact)"
+	impact.Architecture.Components = make([]Component, len(arch.Components))
+
+	// Classify and recolour the components in architecture order
+	for i, c := range arch.Components {
+		switch {
+		case isFailed[i]:
+			impact.Failed = append(impact.Failed, c.ID)
+			c.Object.Color = impactFailedColor
+		case affected[i] && dependsOn(dependencies[i], isFailed):
+			impact.DirectlyAffected = append(impact.DirectlyAffected, c.ID)
+			c.Object.Color = impactDirectColor
+		case affected[i]:
+			impact.IndirectlyAffected = append(impact.IndirectlyAffected, c.ID)
+			c.Object.Color = impactIndirectColor
+		case downstream[i]:
+			impact.Degraded = append(impact.Degraded, c.ID)
+			c.Object.Color = impactDegradedColor
+		default:
+			c.Object.Color = impactUnaffectedColor
+		}
+		impact.Architecture.Components[i] = c
+	}
+
+	return impact, nil
+}
+
+// dependsOn returns true if any of the dependencies are in the set.
+func dependsOn(dependencies []int, set map[int]bool) bool {
+	for _, j := range dependencies {
+		if set[j] {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetImpact handles GET requests for the blast radius of a
+// failed component or group.
+// GET /architectures/${architectureID}/impact?component=${componentID}
+// GET /architectures/${architectureID}/impact?group=${groupID}
+//
+//	return the components affected by the failure. The optional
+//	dependency parameter is "calls" to make the source of each
+//	connection depend on its target (calls), or "data" to make each
+//	component depend on the components that send data to it. The
+//	optional peers parameter is "label" to only make the components
+//	with the same role label redundant peers, or "names" to also make
+//	the components named like app-1 and app-2 peers. If
+//	view=architecture is given, only the recoloured architecture is
+//	returned so that it can be loaded straight into the viewer.
+func (h *ArchitectureHandler) handleGetImpact(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	// The failed components are either a single component or all
+	// of the components in a group.
+	query := r.URL.Query()
+	var failed []string
+	switch {
+	case query.Get("component") != "":
+		failed = []string{query.Get("component")}
+	case query.Get("group") != "":
+		group, ok := findGroup(arch.Groups, query.Get("group"))
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unknown group: %s", query.Get("group"))
+			return
+		}
+		failed = group.Components
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Missing component or group")
+		return
+	}
+
+	dependency := query.Get("dependency")
+	if dependency == "" {
+		dependency = ImpactDependencyCalls
+	}
+	peers := query.Get("peers")
+	if peers == "" {
+		peers = ImpactPeersLabel
+	}
+	impact, err := analyseImpact(arch, failed, dependency, peers)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to analyse impact: %v", err)
+		return
+	}
+
+	if query.Get("view") == "architecture" {
+		writeJSONResponse(w, http.StatusOK, impact.Architecture)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, impact)
+}
+
+// findGroup returns the group with the ID.
+func findGroup(groups []Group, groupID string) (Group, bool) {
+	for _, g := range groups {
+		if g.ID == groupID {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
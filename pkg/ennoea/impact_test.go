@@ -0,0 +1,159 @@
+package ennoea
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyseImpact(t *testing.T) {
+	tests := []struct {
+		name        string
+		components  []string
+		connections []string
+		roles       map[string]string
+		failed      []string
+		dependency  string
+		peers       string
+		direct      []string
+		indirect    []string
+		degraded    []string
+	}{
+		{
+			name:        "caller depends on the database",
+			components:  []string{"app", "db"},
+			connections: []string{"app>db"},
+			failed:      []string{"db"},
+			dependency:  ImpactDependencyCalls,
+			direct:      []string{"app"},
+		},
+		{
+			name:        "database does not depend on the caller",
+			components:  []string{"app", "db"},
+			connections: []string{"app>db"},
+			failed:      []string{"app"},
+			dependency:  ImpactDependencyCalls,
+		},
+		{
+			name:        "distinct dependencies are all required",
+			components:  []string{"lb", "app", "db"},
+			connections: []string{"lb=app", "app=db"},
+			failed:      []string{"db"},
+			dependency:  ImpactDependencyCalls,
+			direct:      []string{"app"},
+			indirect:    []string{"lb"},
+		},
+		{
+			name:        "redundant peers are alternatives",
+			components:  []string{"lb", "blue", "green", "db"},
+			connections: []string{"lb>blue", "lb>green", "blue>db", "green>db"},
+			roles:       map[string]string{"blue": "app", "green": "app"},
+			failed:      []string{"blue"},
+			dependency:  ImpactDependencyCalls,
+			degraded:    []string{"lb"},
+		},
+		{
+			name:        "losing every peer affects the caller",
+			components:  []string{"lb", "blue", "green", "db"},
+			connections: []string{"lb>blue", "lb>green", "blue>db", "green>db"},
+			roles:       map[string]string{"blue": "app", "green": "app"},
+			failed:      []string{"db"},
+			dependency:  ImpactDependencyCalls,
+			direct:      []string{"blue", "green"},
+			indirect:    []string{"lb"},
+		},
+		{
+			name:        "names are not peers by default",
+			components:  []string{"lb", "app-1", "app-2"},
+			connections: []string{"lb>app-1", "lb>app-2"},
+			failed:      []string{"app-1"},
+			dependency:  ImpactDependencyCalls,
+			direct:      []string{"lb"},
+		},
+		{
+			name:        "replica names are peers when asked",
+			components:  []string{"lb", "app-1", "app-2"},
+			connections: []string{"lb>app-1", "lb>app-2"},
+			failed:      []string{"app-1"},
+			dependency:  ImpactDependencyCalls,
+			peers:       ImpactPeersNames,
+			degraded:    []string{"lb"},
+		},
+		{
+			name:        "role labels win over names",
+			components:  []string{"lb", "app-1", "app-2"},
+			connections: []string{"lb>app-1", "lb>app-2"},
+			roles:       map[string]string{"app-2": "cache"},
+			failed:      []string{"app-1"},
+			dependency:  ImpactDependencyCalls,
+			peers:       ImpactPeersNames,
+			direct:      []string{"lb"},
+		},
+		{
+			name:        "addresses are not replicas",
+			components:  []string{"client", "10.0.1.5", "10.0.1.6"},
+			connections: []string{"client>10.0.1.5", "client>10.0.1.6"},
+			failed:      []string{"10.0.1.5"},
+			dependency:  ImpactDependencyCalls,
+			peers:       ImpactPeersNames,
+			direct:      []string{"client"},
+		},
+		{
+			name:        "data flows to the receiver",
+			components:  []string{"source", "sink"},
+			connections: []string{"source>sink"},
+			failed:      []string{"source"},
+			dependency:  ImpactDependencyData,
+			direct:      []string{"sink"},
+		},
+		{
+			name:        "cycle only fed by a failed component",
+			components:  []string{"a", "b", "c"},
+			connections: []string{"a>c", "b>a", "c>b"},
+			failed:      []string{"c"},
+			dependency:  ImpactDependencyCalls,
+			direct:      []string{"a"},
+			indirect:    []string{"b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			arch := testArchitecture(test.components, test.connections...)
+			for i, c := range arch.Components {
+				if role, ok := test.roles[c.ID]; ok {
+					arch.Components[i].Labels = map[string]string{"role": role}
+				}
+			}
+			peers := test.peers
+			if peers == "" {
+				peers = ImpactPeersLabel
+			}
+			impact, err := analyseImpact(arch, test.failed, test.dependency, peers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, check := range []struct {
+				name      string
+				got, want []string
+			}{
+				{"direct", impact.DirectlyAffected, test.direct},
+				{"indirect", impact.IndirectlyAffected, test.indirect},
+				{"degraded", impact.Degraded, test.degraded},
+			} {
+				if check.want == nil {
+					check.want = []string{}
+				}
+				if !reflect.DeepEqual(check.got, check.want) {
+					t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyseImpactUnknownPeers(t *testing.T) {
+	arch := testArchitecture([]string{"a"})
+	if _, err := analyseImpact(arch, []string{"a"}, ImpactDependencyCalls, "hostnames"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,600 @@
+package ennoea
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+Importers turn the files of other tools into architectures. Each
+importer reads one format and returns the components, groups and
+connections that it found. The common parts of an import are done
+afterwards: the info is filled in, the components are laid out, and
+the scene is sized to fit them.
+
+An import can update a previous import of the same file. The
+components and groups that are still there keep their objects and
+bounding boxes, so hand-tuned positions are not lost, and only the new
+components are laid out around them. The info, scene and views of the
+previous architecture are kept as well. Formats that only measure an
+architecture, like traces, can update it in place instead, changing the
+rates of the connections it already has.
+
+Importers can be run with the "ennoea import" command or by posting
+the file to the import route of the server.
+*/
+
+// importer reads a file of one format and returns the architecture it
+// describes. The config is the format specific configuration file,
+// which is empty if there is none. The info, scene and positions of the
+// architecture are filled in by Import.
+type importer func(data, config []byte) (ImportResult, error)
+
+// importers is a map of format names to their importers.
+var importers = map[string]importer{
+	"compose":     importCompose,
+	"dot":         importDOT,
+	"flows":       importFlows,
+	"kubernetes":  importKubernetes,
+	"mermaid":     importMermaid,
+	"otlp":        importOTLP,
+	"pcap":        importPCAP,
+	"plantuml":    importPlantUML,
+	"structurizr": importStructurizr,
+	"terraform":   importTerraform,
+}
+
+// updater updates the previous architecture from a file of one format
+// instead of importing it again.
+type updater func(previous Architecture, data, config []byte) (ImportResult, error)
+
+// updaters is a map of the formats that update architectures in place
+// to their updaters.
+var updaters = map[string]updater{
+	"otlp": updateOTLP,
+}
+
+// importDirectoryExtensions is a map of the formats that can be read
+// from a directory to the extensions of the files that are read.
+var importDirectoryExtensions = map[string][]string{
+	"kubernetes": {".yaml", ".yml", ".json"},
+}
+
+// importLayouts is a map of the formats that have a layout algorithm
+// other than the default one to their layout algorithm. Diagrams have
+// no direction to layer them by, so they are laid out in 3D by force.
+var importLayouts = map[string]string{
+	"dot":      LayoutForce,
+	"mermaid":  LayoutForce,
+	"plantuml": LayoutForce,
+}
+
+const (
+	// maxImportSize is the largest file that can be posted to the
+	// import route.
+	maxImportSize = 64 << 20
+
+	// maxImportDepth is the deepest nesting of the blocks of a file
+	// that the recursive parsers read, so that a file of braces
+	// cannot overflow the stack.
+	maxImportDepth = 100
+
+	// defaultImportLayout is the layout algorithm used for imported
+	// architectures.
+	defaultImportLayout = LayoutLayered
+)
+
+// importPalette is the list of colours that are given to imported
+// groups in turn.
+var importPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2",
+	"#59a14f", "#edc948", "#b07aa1", "#ff9da7",
+}
+
+// ImportOptions represents the options of an import.
+type ImportOptions struct {
+	// ID is the ID of the imported architecture. An ID is generated
+	// if it is empty.
+	ID string `json:"id"`
+
+	// Name is the name of the imported architecture. It defaults to
+	// a name from the file, or the name of the format.
+	Name string `json:"name"`
+
+	// Layout is the layout algorithm used to position the
+	// components. It defaults to the layout of the format, which is
+	// "layered" for most formats.
+	Layout string `json:"layout"`
+
+	// Config is the format specific configuration file, such as the
+	// type mappings of a terraform import.
+	Config []byte `json:"-"`
+
+	// Previous is the architecture that the import updates, or nil
+	// if the import creates a new architecture.
+	Previous *Architecture `json:"-"`
+}
+
+// ImportResult represents the result of an import.
+type ImportResult struct {
+	// Architecture is the imported architecture.
+	Architecture Architecture `json:"architecture"`
+
+	// Warnings is a list of the parts of the file that could not be
+	// imported.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// positioned is the IDs of the components whose positions were
+	// given by the file. They keep their positions in the layout.
+	positioned []string
+}
+
+// warnf adds a warning to the result.
+func (r *ImportResult) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Import reads a file of the format and returns the architecture it
+// describes, laid out and ready to save.
+func Import(format string, data []byte, options ImportOptions) (ImportResult, error) {
+	read, ok := importers[format]
+	if !ok {
+		return ImportResult{}, fmt.Errorf("unknown import format: %s", format)
+	}
+
+	if update, ok := updaters[format]; ok && options.Previous != nil {
+		result, err := update(*options.Previous, data, options.Config)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+		}
+		if options.ID != "" {
+			result.Architecture.Info.ID = options.ID
+		}
+		if options.Name != "" {
+			result.Architecture.Info.Name = options.Name
+		}
+		if err := result.Architecture.isValid(); err != nil {
+			return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+		}
+		if err := result.Architecture.isConsistent(); err != nil {
+			return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+		}
+		return result, nil
+	}
+
+	result, err := read(data, options.Config)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+	}
+	return completeImport(format, result, options)
+}
+
+// completeImport fills in the info of an imported architecture, lays
+// it out around the components of the previous architecture, and
+// checks that it is valid.
+func completeImport(format string, result ImportResult, options ImportOptions) (ImportResult, error) {
+	if options.Layout == "" {
+		options.Layout = importLayouts[format]
+		if options.Layout == LayoutForce && !canLayoutForce(len(result.Architecture.Components), defaultLayoutIterations) {
+			result.warnf("%d components are too many for the force layout, so they are laid out in layers", len(result.Architecture.Components))
+			options.Layout = defaultImportLayout
+		}
+	}
+	if options.Layout == "" {
+		options.Layout = defaultImportLayout
+	}
+
+	var err error
+	arch := &result.Architecture
+	var pinned []string
+	if options.Previous != nil {
+		pinned = keepPrevious(arch, *options.Previous)
+	}
+	pinned = append(pinned, result.positioned...)
+	if options.ID != "" {
+		arch.Info.ID = options.ID
+	}
+	if arch.Info.ID == "" {
+		arch.Info.ID = generateID()
+	}
+	if options.Name != "" {
+		arch.Info.Name = options.Name
+	}
+	if arch.Info.Name == "" {
+		arch.Info.Name = format
+	}
+	if arch.Info.Description == "" {
+		arch.Info.Description = fmt.Sprintf("Imported from %s", format)
+	}
+
+	*arch, err = LayoutArchitecture(*arch, LayoutOptions{Algorithm: options.Layout, Pinned: pinned})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if options.Previous == nil {
+		arch.Scene = importScene(*arch)
+	} else {
+		// The new components are moved out of the way of the kept
+		// ones
+		nudged, err := nudgeApart(*arch, NudgeOptions{Pinned: pinned})
+		if err != nil {
+			return ImportResult{}, err
+		}
+		*arch = nudged.Architecture
+	}
+
+	if err := arch.isValid(); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+	}
+	if err := arch.isConsistent(); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to import %s: %v", format, err)
+	}
+
+	return result, nil
+}
+
+// keepPrevious copies the info, scene and views of the previous
+// architecture, and the objects of the components and the bounding
+// boxes of the groups that are in both. The IDs of the components
+// that were kept are returned so that they are not moved.
+func keepPrevious(arch *Architecture, previous Architecture) []string {
+	arch.Info = previous.Info
+	arch.Scene = previous.Scene
+	arch.Views = previous.Views
+
+	objects := make(map[string]Object3D, len(previous.Components))
+	for _, c := range previous.Components {
+		objects[c.ID] = c.Object
+	}
+	var pinned []string
+	for i := range arch.Components {
+		c := &arch.Components[i]
+		if object, ok := objects[c.ID]; ok {
+			c.Object = object
+			pinned = append(pinned, c.ID)
+		}
+	}
+
+	boxes := make(map[string]BoundingBox, len(previous.Groups))
+	for _, g := range previous.Groups {
+		boxes[g.ID] = g.BoundingBox
+	}
+	for i := range arch.Groups {
+		if box, ok := boxes[arch.Groups[i].ID]; ok {
+			arch.Groups[i].BoundingBox = box
+		}
+	}
+
+	return pinned
+}
+
+// importScene returns a scene with the camera far enough away to see
+// every component.
+func importScene(arch Architecture) Scene {
+	var centre [3]float64
+	for _, c := range arch.Components {
+		centre = vecAdd(centre, c.Object.Position)
+	}
+	if len(arch.Components) > 0 {
+		centre = vecScale(centre, 1/float64(len(arch.Components)))
+	}
+	radius := 0.0
+	for _, c := range arch.Components {
+		radius = math.Max(radius, vecLength(vecSub(c.Object.Position, centre)))
+	}
+
+	distance := math.Max(10, radius*2)
+	return Scene{
+		Camera: Camera{Position: vecAdd(centre, [3]float64{0, 0, distance})},
+		Fog:    Fog{Near: 0, Far: math.Max(100, distance*4)},
+		Text:   Text{Scale: 0.4, Rotate: true},
+	}
+}
+
+// newImportedComponent returns a component with a default object.
+func newImportedComponent(id, name, componentType string) Component {
+	return Component{
+		ID:   id,
+		Type: componentType,
+		Name: name,
+		Object: Object3D{
+			Visible:  true,
+			Scale:    [3]float64{1, 1, 1},
+			Geometry: "box",
+			Color:    "#ffffff",
+		},
+		Labels: make(map[string]string),
+	}
+}
+
+// newExternalComponent returns the component that stands for the
+// clients outside of the imported architecture.
+func newExternalComponent(id string) Component {
+	c := newImportedComponent(id, "External", "app")
+	c.Object.Geometry = "sphere"
+	c.Object.Color = "#999999"
+	return c
+}
+
+// newImportedGroup returns a group with a bounding box coloured from
+// the palette by its index.
+func newImportedGroup(id, name string, index int) Group {
+	return Group{
+		ID:   id,
+		Name: name,
+		BoundingBox: BoundingBox{
+			Padding: 1,
+			Color:   importPalette[index%len(importPalette)],
+			Visible: true,
+		},
+		Labels: make(map[string]string),
+	}
+}
+
+// newImportedConnection returns a connection with the data flowing
+// from the source to the target.
+func newImportedConnection(id, name, source, target string) Connection {
+	return Connection{
+		ID:     id,
+		Name:   name,
+		Source: source,
+		Target: target,
+		Flow:   "out",
+		Labels: make(map[string]string),
+	}
+}
+
+// ReadImportDirectory reads the files of the format in the directory
+// and its subdirectories, in order, and joins them into one YAML
+// stream.
+func ReadImportDirectory(format, dir string) ([]byte, error) {
+	extensions, ok := importDirectoryExtensions[format]
+	if !ok {
+		return nil, fmt.Errorf("%s cannot be imported from a directory", format)
+	}
+
+	var data []byte
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !containsString(extensions, filepath.Ext(path)) {
+			return nil
+		}
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data = append(data, "\n---\n"...)
+		data = append(data, file...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+	return data, nil
+}
+
+// ImportFormats returns the names of the formats that can be
+// imported, in order.
+func ImportFormats() []string {
+	return sortedKeys(importers)
+}
+
+// gunzipImport returns the decompressed data of a gzip file, or the
+// data itself if it is not compressed.
+func gunzipImport(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %v", err)
+	}
+	data, err = io.ReadAll(io.LimitReader(reader, maxImportSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip: %v", err)
+	}
+	return data, nil
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ImportHandler is the handler for the import routes. Imported
+// architectures are saved by the architecture handler.
+type ImportHandler struct {
+	// architectures is the handler that the imported architectures
+	// are saved with.
+	architectures *ArchitectureHandler
+}
+
+// NewImportHandler creates a new ImportHandler that saves the
+// imported architectures with the architecture handler.
+func NewImportHandler(architectures *ArchitectureHandler) *ImportHandler {
+	return &ImportHandler{architectures: architectures}
+}
+
+// ServeHTTP handles requests to the import routes.
+//
+// POST /import/${format}
+//
+//	import the file in the request body and save it as an
+//	architecture. The optional parameters are:
+//	  id       the ID of the architecture (generated)
+//	  name     the name of the architecture
+//	  layout   the layout algorithm (layered)
+//	  update   "true" to update an existing architecture, keeping
+//	           the objects of the components that are still there
+//	  preview  "true" to return the architecture without saving it
+//	The request body is the file, or a multipart form with the file in
+//	the "file" field and the configuration in the "config" field. The
+//	import result is returned with the architecture and any warnings.
+func (h *ImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := strings.Trim(strings.TrimPrefix(r.URL.Path, "/import"), "/")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Method not allowed")
+		return
+	}
+	if _, ok := importers[format]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Unknown import format: %s", format)
+		return
+	}
+
+	query := r.URL.Query()
+	update, err := queryBool(query.Get("update"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid update: %v", err)
+		return
+	}
+	preview, err := queryBool(query.Get("preview"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid preview: %v", err)
+		return
+	}
+	if id := query.Get("id"); id != "" {
+		if err := isValidArchitectureID(id); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid architecture: %v", err)
+			return
+		}
+	}
+
+	data, config, err := readImportRequest(w, r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to read file: %v", err)
+		return
+	}
+
+	// An existing architecture is only updated when asked, and
+	// overlays are never updated
+	options := ImportOptions{
+		ID:     query.Get("id"),
+		Name:   query.Get("name"),
+		Layout: query.Get("layout"),
+		Config: config,
+	}
+	if options.ID != "" && !preview {
+		// Hold the architecture from loading the previous version
+		// until the import is saved
+		defer h.architectures.lockArchitecture(options.ID)()
+	}
+	if save, ok := h.architectures.architectureSave(options.ID); ok {
+		if save.Base != "" {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "Architecture %s is an overlay of %s", save.ID, save.Base)
+			return
+		}
+		if !update {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "Architecture %s already exists", options.ID)
+			return
+		}
+		previous, err := h.architectures.loadArchitectureByID(options.ID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Failed to load architecture: %v", err)
+			return
+		}
+		options.Previous = &previous
+	}
+
+	result, err := Import(format, data, options)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%v", err)
+		return
+	}
+	if preview {
+		writeJSONResponse(w, http.StatusOK, result)
+		return
+	}
+	arch := result.Architecture
+
+	if _, ok := h.architectures.enforcePolicies(w, arch); !ok {
+		return
+	}
+
+	err = h.architectures.saveArchitecture(arch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save architecture: %v", err)
+		return
+	}
+
+	// Flag any overlays that can no longer be resolved against the
+	// updated architecture
+	err = h.architectures.checkOverlays(arch.Info.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to check overlays: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, result)
+}
+
+// readImportRequest reads the file and the configuration from the
+// request. The body is either the file, or a multipart form with the
+// file and the configuration.
+func readImportRequest(w http.ResponseWriter, r *http.Request) ([]byte, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err := io.ReadAll(r.Body)
+		return data, nil, err
+	}
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, nil, err
+	}
+	data, err := readFormFile(r, "file")
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("missing file")
+	}
+	config, err := readFormFile(r, "config")
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, config, nil
+}
+
+// readFormFile returns the contents of the file or the value of the
+// field in the multipart form, or nil if it is missing.
+func readFormFile(r *http.Request, field string) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		if value := r.FormValue(field); value != "" {
+			return []byte(value), nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
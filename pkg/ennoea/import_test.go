@@ -0,0 +1,85 @@
+package ennoea
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// importTest is a file of a format and the summary of the architecture
+// that it imports as with the config, or the error that the import
+// returns.
+type importTest struct {
+	name   string
+	data   string
+	config string
+	want   []string
+	err    string
+}
+
+// testImports imports the files of the tests and compares the
+// summaries of the architectures.
+func testImports(t *testing.T, format string, tests []importTest) {
+	t.Helper()
+	for _, test := range tests {
+		result, err := Import(format, []byte(test.data), ImportOptions{Config: []byte(test.config)})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := summariseImport(result); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: imported\n\t%s\nwant\n\t%s", test.name, strings.Join(got, "\n\t"), strings.Join(test.want, "\n\t"))
+		}
+	}
+}
+
+// summariseImport returns a line for each component, group,
+// connection and view of the architecture, and for each warning.
+func summariseImport(result ImportResult) []string {
+	var lines []string
+	arch := result.Architecture
+	for _, c := range arch.Components {
+		lines = append(lines, fmt.Sprintf("component %s %q %s", c.ID, c.Name, c.Object.Geometry))
+	}
+	for _, g := range arch.Groups {
+		lines = append(lines, fmt.Sprintf("group %s %s", g.ID, strings.Join(g.Components, ",")))
+	}
+	for _, c := range arch.Connections {
+		lines = append(lines, fmt.Sprintf("connection %s %s", c.ID, c.Flow))
+	}
+	for _, v := range arch.Views {
+		lines = append(lines, fmt.Sprintf("view %s %s", v.ID, v.Query))
+	}
+	for _, w := range result.Warnings {
+		lines = append(lines, "warning "+w)
+	}
+	return lines
+}
+
+func TestImportLayoutLimit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "  n%d --> n%d\n", i, i+1)
+	}
+	result, err := Import("mermaid", []byte(b.String()), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1001 components are too many for the force layout, so they are laid out in layers"}; !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("warnings = %v, want %v", result.Warnings, want)
+	}
+
+	// The force layout can still be asked for, but not for as many
+	// components
+	if _, err := Import("mermaid", []byte(b.String()), ImportOptions{Layout: LayoutForce}); err == nil {
+		t.Errorf("force layout of 1001 components: expected an error")
+	}
+}
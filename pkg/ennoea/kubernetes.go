@@ -0,0 +1,789 @@
+package ennoea
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+The kubernetes importer reads manifests, as a multi-document YAML file
+or as a directory of them. Deployments, StatefulSets and DaemonSets
+become components, and namespaces become groups of the workloads in
+them. Deployments are apps, and StatefulSets and DaemonSets, which are
+usually databases and node agents, are servers.
+
+Services do not say which workloads use them, so the clients of a
+service are found from the host names in the environment, command and
+arguments of the containers, including values from ConfigMaps. A name
+is a reference to a service if it is the whole value, follows "://"
+or "@", comes before a port, or is qualified with the namespace, for
+example "api.shop.svc.cluster.local". The connection goes from the
+client to each workload that is selected by the service.
+
+Ingresses become connections from an "external" component to the
+workloads behind their backend services.
+
+NetworkPolicies inform the connections in two ways. The pods that a
+policy allows to connect, with a pod or namespace selector, become
+connections. And when a policy isolates a workload, every connection to
+or from it is labelled with whether it is allowed:
+
+	labels.services         the services the connection goes through
+	labels.ports            the ports of the services
+	labels.ingresses        the ingresses the connection comes from
+	labels.hosts            the hosts of the ingress rules
+	labels.networkPolicies  the policies that allow the connection
+	labels.allowed          "true" or "false" if a policy applies
+*/
+
+const (
+	// k8sDefaultNamespace is the namespace of objects without one.
+	k8sDefaultNamespace = "default"
+
+	// k8sExternalID is the ID of the component that stands for the
+	// clients of ingresses.
+	k8sExternalID = "external"
+
+	// k8sNamespaceLabel is the label that every namespace has with
+	// its name, which namespace selectors can match.
+	k8sNamespaceLabel = "kubernetes.io/metadata.name"
+)
+
+// k8sWorkloadKinds maps the kinds of workloads that are imported to
+// their component types and geometries.
+var k8sWorkloadKinds = map[string][2]string{
+	"Deployment":  {"app", "box"},
+	"StatefulSet": {"server", "cylinder"},
+	"DaemonSet":   {"server", "cone"},
+}
+
+// k8sIgnoredWorkloadKinds is the kinds of workloads that are not
+// imported. They are reported because they usually belong in the
+// architecture.
+var k8sIgnoredWorkloadKinds = map[string]bool{
+	"Pod":                   true,
+	"ReplicaSet":            true,
+	"ReplicationController": true,
+	"Job":                   true,
+	"CronJob":               true,
+}
+
+// k8sHostPattern matches host names in the values of containers.
+var k8sHostPattern = regexp.MustCompile(`[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*`)
+
+// k8sObject represents the parts of a kubernetes object that are
+// imported. The spec is decoded by kind.
+type k8sObject struct {
+	Kind     string            `yaml:"kind"`
+	Metadata k8sMetadata       `yaml:"metadata"`
+	Spec     yaml.Node         `yaml:"spec"`
+	Data     map[string]string `yaml:"data"`
+	Items    []k8sObject       `yaml:"items"`
+}
+
+// k8sMetadata represents the metadata of an object.
+type k8sMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// k8sLabelSelector represents a label selector.
+type k8sLabelSelector struct {
+	MatchLabels      map[string]string `yaml:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `yaml:"key"`
+		Operator string   `yaml:"operator"`
+		Values   []string `yaml:"values"`
+	} `yaml:"matchExpressions"`
+}
+
+// matches returns true if the labels match the selector. An empty
+// selector matches everything.
+func (s k8sLabelSelector) matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for _, e := range s.MatchExpressions {
+		value, ok := labels[e.Key]
+		switch e.Operator {
+		case "In":
+			if !ok || !containsString(e.Values, value) {
+				return false
+			}
+		case "NotIn":
+			if ok && containsString(e.Values, value) {
+				return false
+			}
+		case "Exists":
+			if !ok {
+				return false
+			}
+		case "DoesNotExist":
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// k8sWorkloadSpec represents the spec of a workload.
+type k8sWorkloadSpec struct {
+	Replicas *int `yaml:"replicas"`
+	Template struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     struct {
+			Containers     []k8sContainer `yaml:"containers"`
+			InitContainers []k8sContainer `yaml:"initContainers"`
+		} `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+// k8sContainer represents a container of a workload.
+type k8sContainer struct {
+	Image   string   `yaml:"image"`
+	Command []string `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Env     []struct {
+		Value     string `yaml:"value"`
+		ValueFrom struct {
+			ConfigMapKeyRef struct {
+				Name string `yaml:"name"`
+				Key  string `yaml:"key"`
+			} `yaml:"configMapKeyRef"`
+		} `yaml:"valueFrom"`
+	} `yaml:"env"`
+	EnvFrom []struct {
+		ConfigMapRef struct {
+			Name string `yaml:"name"`
+		} `yaml:"configMapRef"`
+	} `yaml:"envFrom"`
+}
+
+// k8sServiceSpec represents the spec of a service.
+type k8sServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []struct {
+		Port     int    `yaml:"port"`
+		Protocol string `yaml:"protocol"`
+	} `yaml:"ports"`
+}
+
+// k8sIngressBackend represents the backend of an ingress, in either
+// the networking.k8s.io/v1 or the older v1beta1 form.
+type k8sIngressBackend struct {
+	Service struct {
+		Name string `yaml:"name"`
+	} `yaml:"service"`
+	ServiceName string `yaml:"serviceName"`
+}
+
+// name returns the name of the service of the backend.
+func (b k8sIngressBackend) name() string {
+	if b.Service.Name != "" {
+		return b.Service.Name
+	}
+	return b.ServiceName
+}
+
+// k8sIngressSpec represents the spec of an ingress.
+type k8sIngressSpec struct {
+	DefaultBackend *k8sIngressBackend `yaml:"defaultBackend"`
+	Backend        *k8sIngressBackend `yaml:"backend"`
+	Rules          []struct {
+		Host string `yaml:"host"`
+		HTTP struct {
+			Paths []struct {
+				Backend k8sIngressBackend `yaml:"backend"`
+			} `yaml:"paths"`
+		} `yaml:"http"`
+	} `yaml:"rules"`
+}
+
+// k8sPolicyPeer represents a peer of a network policy rule.
+type k8sPolicyPeer struct {
+	PodSelector       *k8sLabelSelector `yaml:"podSelector"`
+	NamespaceSelector *k8sLabelSelector `yaml:"namespaceSelector"`
+	IPBlock           *struct{}         `yaml:"ipBlock"`
+}
+
+// k8sPolicySpec represents the spec of a network policy.
+type k8sPolicySpec struct {
+	PodSelector k8sLabelSelector `yaml:"podSelector"`
+	PolicyTypes []string         `yaml:"policyTypes"`
+	Ingress     []struct {
+		From []k8sPolicyPeer `yaml:"from"`
+	} `yaml:"ingress"`
+	Egress []struct {
+		To []k8sPolicyPeer `yaml:"to"`
+	} `yaml:"egress"`
+}
+
+// k8sWorkload is a workload that was imported as a component.
+type k8sWorkload struct {
+	id        string
+	namespace string
+	labels    map[string]string
+
+	// values is the environment, commands and arguments of the
+	// containers.
+	values []string
+}
+
+// k8sPolicy is a network policy.
+type k8sPolicy struct {
+	name      string
+	namespace string
+	spec      k8sPolicySpec
+}
+
+// hasType returns true if the policy applies to the direction. A
+// policy without types applies to ingress, and to egress if it has
+// egress rules.
+func (p k8sPolicy) hasType(policyType string) bool {
+	if len(p.spec.PolicyTypes) == 0 {
+		return policyType == "Ingress" || len(p.spec.Egress) > 0
+	}
+	return containsString(p.spec.PolicyTypes, policyType)
+}
+
+// k8sEdge accumulates the evidence for a connection.
+type k8sEdge struct {
+	source, target string
+	services       []string
+	ports          []string
+	ingresses      []string
+	hosts          []string
+}
+
+// k8sImport holds the state of a kubernetes import.
+type k8sImport struct {
+	result     *ImportResult
+	workloads  []k8sWorkload
+	index      map[string]int
+	namespaces map[string]map[string]string
+	configMaps map[string]map[string]string
+	services   map[string]k8sServiceSpec
+	policies   []k8sPolicy
+	edges      map[[2]string]*k8sEdge
+	order      [][2]string
+}
+
+// importKubernetes imports kubernetes manifests.
+func importKubernetes(data, config []byte) (ImportResult, error) {
+	var objects []k8sObject
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for n := 1; ; n++ {
+		var object k8sObject
+		err := decoder.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("failed to parse document %d: %v", n, err)
+		}
+		if object.Kind == "List" {
+			objects = append(objects, object.Items...)
+		} else if object.Kind != "" {
+			objects = append(objects, object)
+		}
+	}
+
+	var result ImportResult
+	k := &k8sImport{
+		result:     &result,
+		index:      make(map[string]int),
+		namespaces: make(map[string]map[string]string),
+		configMaps: make(map[string]map[string]string),
+		services:   make(map[string]k8sServiceSpec),
+		edges:      make(map[[2]string]*k8sEdge),
+	}
+	result.Architecture.Info.Name = "kubernetes"
+	result.Architecture.Info.Description = "Imported from kubernetes manifests"
+
+	// The namespaces and config maps are read first because the
+	// workloads refer to them
+	for i := range objects {
+		if objects[i].Metadata.Namespace == "" {
+			objects[i].Metadata.Namespace = k8sDefaultNamespace
+		}
+	}
+	for _, object := range objects {
+		switch object.Kind {
+		case "Namespace":
+			labels := map[string]string{k8sNamespaceLabel: object.Metadata.Name}
+			for k, v := range object.Metadata.Labels {
+				labels[k] = v
+			}
+			k.namespaces[object.Metadata.Name] = labels
+		case "ConfigMap":
+			k.configMaps[object.Metadata.Namespace+"/"+object.Metadata.Name] = object.Data
+		}
+	}
+
+	for _, object := range objects {
+		if err := k.add(object); err != nil {
+			return ImportResult{}, fmt.Errorf("%s %s/%s: %v", object.Kind, object.Metadata.Namespace, object.Metadata.Name, err)
+		}
+	}
+	if len(k.workloads) == 0 {
+		return ImportResult{}, fmt.Errorf("manifests have no deployments, statefulsets or daemonsets")
+	}
+
+	k.addNamespaceGroups()
+	k.addReferences()
+	if err := k.addIngresses(objects); err != nil {
+		return ImportResult{}, err
+	}
+	k.addPolicyEdges()
+	k.addConnections()
+
+	return result, nil
+}
+
+// add adds the object to the import.
+func (k *k8sImport) add(object k8sObject) error {
+	namespace := object.Metadata.Namespace
+	id := namespace + "/" + object.Metadata.Name
+
+	if _, ok := k8sWorkloadKinds[object.Kind]; ok {
+		var spec k8sWorkloadSpec
+		if err := object.Spec.Decode(&spec); err != nil {
+			return err
+		}
+		k.addWorkload(object, spec)
+		return nil
+	}
+
+	switch {
+	case object.Kind == "Service":
+		var spec k8sServiceSpec
+		if err := object.Spec.Decode(&spec); err != nil {
+			return err
+		}
+		if len(spec.Selector) == 0 {
+			k.result.warnf("service %s has no selector", id)
+			return nil
+		}
+		k.services[id] = spec
+
+	case object.Kind == "NetworkPolicy":
+		var spec k8sPolicySpec
+		if err := object.Spec.Decode(&spec); err != nil {
+			return err
+		}
+		k.policies = append(k.policies, k8sPolicy{name: id, namespace: namespace, spec: spec})
+
+	case k8sIgnoredWorkloadKinds[object.Kind]:
+		k.result.warnf("%s %s is not imported", object.Kind, id)
+	}
+	return nil
+}
+
+// addWorkload adds the workload as a component.
+func (k *k8sImport) addWorkload(object k8sObject, spec k8sWorkloadSpec) {
+	namespace := object.Metadata.Namespace
+	id := namespace + "/" + object.Metadata.Name
+	if _, ok := k.index[id]; ok {
+		k.result.warnf("%s %s has the same name as another workload", object.Kind, id)
+		return
+	}
+
+	kind := k8sWorkloadKinds[object.Kind]
+	c := newImportedComponent(id, object.Metadata.Name, kind[0])
+	c.Object.Geometry = kind[1]
+	for key, value := range object.Metadata.Labels {
+		c.Labels[key] = value
+	}
+	c.Labels["kind"] = object.Kind
+	c.Labels["namespace"] = namespace
+	if spec.Replicas != nil {
+		c.Labels["replicas"] = strconv.Itoa(*spec.Replicas)
+	}
+
+	w := k8sWorkload{id: id, namespace: namespace, labels: spec.Template.Metadata.Labels}
+	var images []string
+	containers := append(spec.Template.Spec.InitContainers, spec.Template.Spec.Containers...)
+	for _, container := range containers {
+		if container.Image != "" && !containsString(images, container.Image) {
+			images = append(images, container.Image)
+		}
+		w.values = append(w.values, container.Command...)
+		w.values = append(w.values, container.Args...)
+		for _, env := range container.Env {
+			w.values = append(w.values, env.Value)
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref.Name != "" {
+				w.values = append(w.values, k.configMaps[namespace+"/"+ref.Name][ref.Key])
+			}
+		}
+		for _, env := range container.EnvFrom {
+			data := k.configMaps[namespace+"/"+env.ConfigMapRef.Name]
+			for _, key := range sortedKeys(data) {
+				w.values = append(w.values, data[key])
+			}
+		}
+	}
+	if len(images) > 0 {
+		c.Labels["image"] = strings.Join(images, ",")
+	}
+
+	k.result.Architecture.Components = append(k.result.Architecture.Components, c)
+	k.index[id] = len(k.workloads)
+	k.workloads = append(k.workloads, w)
+}
+
+// addNamespaceGroups adds a group for each namespace with workloads.
+func (k *k8sImport) addNamespaceGroups() {
+	members := make(map[string][]string)
+	for _, w := range k.workloads {
+		members[w.namespace] = append(members[w.namespace], w.id)
+	}
+	arch := &k.result.Architecture
+	for _, namespace := range sortedKeys(members) {
+		g := newImportedGroup(namespace, namespace, len(arch.Groups))
+		for key, value := range k.namespaces[namespace] {
+			if key != k8sNamespaceLabel {
+				g.Labels[key] = value
+			}
+		}
+		g.Components = members[namespace]
+		arch.Groups = append(arch.Groups, g)
+	}
+}
+
+// selected returns the workloads in the namespace that are selected by
+// the service.
+func (k *k8sImport) selected(namespace string, spec k8sServiceSpec) []k8sWorkload {
+	selector := k8sLabelSelector{MatchLabels: spec.Selector}
+	var selected []k8sWorkload
+	for _, w := range k.workloads {
+		if w.namespace == namespace && selector.matches(w.labels) {
+			selected = append(selected, w)
+		}
+	}
+	return selected
+}
+
+// edge returns the edge from the source to the target, creating it if
+// it does not exist.
+func (k *k8sImport) edge(source, target string) *k8sEdge {
+	key := [2]string{source, target}
+	e, ok := k.edges[key]
+	if !ok {
+		e = &k8sEdge{source: source, target: target}
+		k.edges[key] = e
+		k.order = append(k.order, key)
+	}
+	return e
+}
+
+// addService adds the edges from the client to the workloads of the
+// service.
+func (k *k8sImport) addService(client, service string) {
+	spec := k.services[service]
+	namespace, _, _ := strings.Cut(service, "/")
+	for _, w := range k.selected(namespace, spec) {
+		if w.id == client {
+			continue
+		}
+		e := k.edge(client, w.id)
+		if !containsString(e.services, service) {
+			e.services = append(e.services, service)
+			for _, port := range spec.Ports {
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = "TCP"
+				}
+				e.ports = append(e.ports, fmt.Sprintf("%d/%s", port.Port, protocol))
+			}
+		}
+	}
+}
+
+// addReferences adds the edges from each workload to the services that
+// it refers to.
+func (k *k8sImport) addReferences() {
+	for _, w := range k.workloads {
+		for _, value := range w.values {
+			for _, service := range k.references(w.namespace, value) {
+				k.addService(w.id, service)
+			}
+		}
+	}
+}
+
+// references returns the services that the value refers to.
+func (k *k8sImport) references(namespace, value string) []string {
+	value = strings.ToLower(value)
+	var services []string
+	for _, match := range k8sHostPattern.FindAllStringIndex(value, -1) {
+		host := value[match[0]:match[1]]
+		parts := strings.Split(host, ".")
+		name, ns := parts[0], namespace
+		if len(parts) > 1 {
+			if len(parts) > 2 && parts[2] != "svc" {
+				continue
+			}
+			ns = parts[1]
+		} else {
+			before, after := value[:match[0]], value[match[1]:]
+			whole := before == "" && after == ""
+			scheme := strings.HasSuffix(before, "://") || strings.HasSuffix(before, "@")
+			port := len(after) > 1 && after[0] == ':' && after[1] >= '0' && after[1] <= '9'
+			if !whole && !scheme && !port {
+				continue
+			}
+		}
+		service := ns + "/" + name
+		if _, ok := k.services[service]; ok && !containsString(services, service) {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// addIngresses adds the edges from the external component to the
+// workloads behind the backends of the ingresses.
+func (k *k8sImport) addIngresses(objects []k8sObject) error {
+	for _, object := range objects {
+		if object.Kind != "Ingress" {
+			continue
+		}
+		var spec k8sIngressSpec
+		if err := object.Spec.Decode(&spec); err != nil {
+			return err
+		}
+		var backends []k8sIngressBackend
+		var hosts []string
+		for _, b := range []*k8sIngressBackend{spec.DefaultBackend, spec.Backend} {
+			if b != nil {
+				backends = append(backends, *b)
+				hosts = append(hosts, "")
+			}
+		}
+		for _, rule := range spec.Rules {
+			for _, path := range rule.HTTP.Paths {
+				backends = append(backends, path.Backend)
+				hosts = append(hosts, rule.Host)
+			}
+		}
+
+		ingress := object.Metadata.Namespace + "/" + object.Metadata.Name
+		for i, b := range backends {
+			service := object.Metadata.Namespace + "/" + b.name()
+			if _, ok := k.services[service]; !ok {
+				k.result.warnf("ingress %s has unknown backend service %s", ingress, service)
+				continue
+			}
+			for _, w := range k.selected(object.Metadata.Namespace, k.services[service]) {
+				e := k.edge(k8sExternalID, w.id)
+				if !containsString(e.ingresses, ingress) {
+					e.ingresses = append(e.ingresses, ingress)
+				}
+				if !containsString(e.services, service) {
+					e.services = append(e.services, service)
+				}
+				if hosts[i] != "" && !containsString(e.hosts, hosts[i]) {
+					e.hosts = append(e.hosts, hosts[i])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// peerMatches returns true if the workload is a peer of a rule of the
+// policy. An external client only matches IP blocks.
+func (k *k8sImport) peerMatches(policy k8sPolicy, peer k8sPolicyPeer, w *k8sWorkload) bool {
+	if w == nil {
+		return peer.IPBlock != nil
+	}
+	if peer.IPBlock != nil {
+		return false
+	}
+	if peer.NamespaceSelector == nil {
+		if w.namespace != policy.namespace {
+			return false
+		}
+	} else {
+		labels := k.namespaces[w.namespace]
+		if labels == nil {
+			labels = map[string]string{k8sNamespaceLabel: w.namespace}
+		}
+		if !peer.NamespaceSelector.matches(labels) {
+			return false
+		}
+	}
+	return peer.PodSelector == nil || peer.PodSelector.matches(w.labels)
+}
+
+// workload returns the workload with the ID, or nil for the external
+// component.
+func (k *k8sImport) workload(id string) *k8sWorkload {
+	i, ok := k.index[id]
+	if !ok {
+		return nil
+	}
+	return &k.workloads[i]
+}
+
+// addPolicyEdges adds the edges between the workloads that the
+// network policies allow to connect with pod or namespace selectors.
+func (k *k8sImport) addPolicyEdges() {
+	for _, p := range k.policies {
+		for i := range k.workloads {
+			w := &k.workloads[i]
+			if w.namespace != p.namespace || !p.spec.PodSelector.matches(w.labels) {
+				continue
+			}
+			for _, rule := range p.spec.Ingress {
+				for _, peer := range rule.From {
+					for j := range k.workloads {
+						source := &k.workloads[j]
+						if source.id != w.id && peer.IPBlock == nil && k.peerMatches(p, peer, source) {
+							k.edge(source.id, w.id)
+						}
+					}
+				}
+			}
+			for _, rule := range p.spec.Egress {
+				for _, peer := range rule.To {
+					for j := range k.workloads {
+						target := &k.workloads[j]
+						if target.id != w.id && peer.IPBlock == nil && k.peerMatches(p, peer, target) {
+							k.edge(w.id, target.id)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// allowed returns whether the network policies allow the connection,
+// the policies that allow it, and whether any policy applies to it.
+func (k *k8sImport) allowed(source, target *k8sWorkload) (bool, []string, bool) {
+	var allowing []string
+	applies := false
+	ingressAllowed, egressAllowed := true, true
+
+	// Ingress to the target
+	if target != nil {
+		isolated, ok := false, false
+		for _, p := range k.policies {
+			if p.namespace != target.namespace || !p.spec.PodSelector.matches(target.labels) || !p.hasType("Ingress") {
+				continue
+			}
+			isolated = true
+			for _, rule := range p.spec.Ingress {
+				match := len(rule.From) == 0
+				for _, peer := range rule.From {
+					match = match || k.peerMatches(p, peer, source)
+				}
+				if match {
+					ok = true
+					allowing = append(allowing, p.name)
+					break
+				}
+			}
+		}
+		applies = applies || isolated
+		ingressAllowed = !isolated || ok
+	}
+
+	// Egress from the source
+	if source != nil {
+		isolated, ok := false, false
+		for _, p := range k.policies {
+			if p.namespace != source.namespace || !p.spec.PodSelector.matches(source.labels) || !p.hasType("Egress") {
+				continue
+			}
+			isolated = true
+			for _, rule := range p.spec.Egress {
+				match := len(rule.To) == 0
+				for _, peer := range rule.To {
+					match = match || k.peerMatches(p, peer, target)
+				}
+				if match {
+					ok = true
+					if !containsString(allowing, p.name) {
+						allowing = append(allowing, p.name)
+					}
+					break
+				}
+			}
+		}
+		applies = applies || isolated
+		egressAllowed = !isolated || ok
+	}
+
+	return ingressAllowed && egressAllowed, allowing, applies
+}
+
+// addConnections adds a connection for each edge.
+func (k *k8sImport) addConnections() {
+	arch := &k.result.Architecture
+	external := false
+	for _, key := range k.order {
+		e := k.edges[key]
+		if e.source == k8sExternalID && !external {
+			arch.Components = append(arch.Components, newExternalComponent(k8sExternalID))
+			external = true
+		}
+
+		name := e.source + " to " + e.target
+		if len(e.services) > 0 {
+			name = strings.Join(e.services, ", ")
+		}
+		c := newImportedConnection(e.source+":"+e.target, name, e.source, e.target)
+		if len(e.services) > 0 {
+			c.Labels["services"] = strings.Join(e.services, ",")
+		}
+		if len(e.ports) > 0 {
+			c.Labels["ports"] = strings.Join(e.ports, ",")
+		}
+		if len(e.ingresses) > 0 {
+			c.Labels["ingresses"] = strings.Join(e.ingresses, ",")
+		}
+		if len(e.hosts) > 0 {
+			c.Labels["hosts"] = strings.Join(e.hosts, ",")
+		}
+
+		allowed, policies, applies := k.allowed(k.workload(e.source), k.workload(e.target))
+		if applies {
+			c.Labels["allowed"] = strconv.FormatBool(allowed)
+			if !allowed {
+				k.result.warnf("connection from %s to %s is not allowed by the network policies", e.source, e.target)
+			}
+		}
+		if len(policies) > 0 {
+			sort.Strings(policies)
+			c.Labels["networkPolicies"] = strings.Join(policies, ",")
+		}
+
+		arch.Connections = append(arch.Connections, c)
+	}
+}
+
+// containsString returns true if the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
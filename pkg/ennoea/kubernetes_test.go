@@ -0,0 +1,355 @@
+package ennoea
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// k8sTestManifests is a shop with an ingress to web, web calling api
+// by its service name, api calling db through a config map, and a
+// network policy that only lets api connect to db.
+const k8sTestManifests = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+  labels:
+    team: checkout
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api-config
+  namespace: shop
+data:
+  DB_URL: postgres://user@db.shop.svc.cluster.local:5432/orders
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      containers:
+        - image: shop/web:1.0
+          env:
+            - name: API_URL
+              value: http://api:8080
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: shop
+spec:
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      containers:
+        - image: shop/api:1.0
+          envFrom:
+            - configMapRef: {name: api-config}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: shop
+spec:
+  template:
+    metadata:
+      labels: {app: db}
+    spec:
+      containers:
+        - image: postgres:15
+---
+apiVersion: v1
+kind: List
+items:
+  - kind: Service
+    metadata: {name: web, namespace: shop}
+    spec:
+      selector: {app: web}
+      ports: [{port: 80}]
+  - kind: Service
+    metadata: {name: api, namespace: shop}
+    spec:
+      selector: {app: api}
+      ports: [{port: 8080}]
+  - kind: Service
+    metadata: {name: db, namespace: shop}
+    spec:
+      selector: {app: db}
+      ports: [{port: 5432, protocol: TCP}]
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: shop
+  namespace: shop
+spec:
+  rules:
+    - host: shop.example.com
+      http:
+        paths:
+          - backend:
+              service: {name: web}
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: db-clients
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels: {app: db}
+  ingress:
+    - from:
+        - podSelector:
+            matchLabels: {app: api}
+`
+
+func TestImportKubernetes(t *testing.T) {
+	testImports(t, "kubernetes", []importTest{
+		{
+			name: "shop",
+			data: k8sTestManifests,
+			want: []string{
+				`component shop/web "web" box`,
+				`component shop/api "api" box`,
+				`component shop/db "db" cylinder`,
+				`component external "External" sphere`,
+				`group shop shop/web,shop/api,shop/db`,
+				`connection shop/web:shop/api out`,
+				`connection shop/api:shop/db out`,
+				`connection external:shop/web out`,
+			},
+		},
+		{
+			name: "policies",
+			data: k8sTestManifests + `
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+  namespace: monitoring
+spec:
+  template:
+    metadata:
+      labels: {app: agent}
+    spec:
+      containers:
+        - args: ["--scrape=db.shop:5432", "--interval=10s"]
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: monitoring
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels: {app: web}
+  ingress:
+    - from:
+        - namespaceSelector:
+            matchLabels: {kubernetes.io/metadata.name: monitoring}
+`,
+			want: []string{
+				`component shop/web "web" box`,
+				`component shop/api "api" box`,
+				`component shop/db "db" cylinder`,
+				`component monitoring/agent "agent" cone`,
+				`component external "External" sphere`,
+				`group monitoring monitoring/agent`,
+				`group shop shop/web,shop/api,shop/db`,
+				`connection shop/web:shop/api out`,
+				`connection shop/api:shop/db out`,
+				`connection monitoring/agent:shop/db out`,
+				`connection external:shop/web out`,
+				`connection monitoring/agent:shop/web out`,
+				`warning connection from monitoring/agent to shop/db is not allowed by the network policies`,
+				`warning connection from external to shop/web is not allowed by the network policies`,
+			},
+		},
+		{
+			name: "objects that are not imported",
+			data: `
+kind: Deployment
+metadata: {name: web}
+spec:
+  template:
+    metadata:
+      labels: {app: web}
+---
+kind: CronJob
+metadata: {name: report}
+---
+kind: Service
+metadata: {name: legacy}
+spec:
+  ports: [{port: 80}]
+---
+kind: Deployment
+metadata: {name: web}
+---
+kind: Ingress
+metadata: {name: web}
+spec:
+  defaultBackend:
+    service: {name: missing}
+`,
+			want: []string{
+				`component default/web "web" box`,
+				`group default default/web`,
+				`warning CronJob default/report is not imported`,
+				`warning service default/legacy has no selector`,
+				`warning Deployment default/web has the same name as another workload`,
+				`warning ingress default/web has unknown backend service default/missing`,
+			},
+		},
+		{name: "no workloads", data: "kind: Service\nmetadata: {name: web}\n", err: "manifests have no deployments, statefulsets or daemonsets"},
+		{name: "invalid YAML", data: "kind: Deployment\n---\nkind: [", err: "failed to parse document 2"},
+		{name: "invalid spec", data: "kind: Deployment\nmetadata: {name: web}\nspec: [1]\n", err: "Deployment default/web"},
+	})
+}
+
+func TestImportKubernetesLabels(t *testing.T) {
+	result, err := Import("kubernetes", []byte(k8sTestManifests), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := result.Architecture
+
+	want := map[string]string{"kind": "Deployment", "namespace": "shop", "replicas": "2", "image": "shop/web:1.0"}
+	if got := arch.Components[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("web labels = %v, want %v", got, want)
+	}
+	if got := arch.Groups[0].Labels; !reflect.DeepEqual(got, map[string]string{"team": "checkout"}) {
+		t.Errorf("shop labels = %v", got)
+	}
+
+	tests := []struct {
+		connection string
+		want       map[string]string
+	}{
+		{"shop/web:shop/api", map[string]string{"services": "shop/api", "ports": "8080/TCP"}},
+		{"shop/api:shop/db", map[string]string{"services": "shop/db", "ports": "5432/TCP", "allowed": "true", "networkPolicies": "shop/db-clients"}},
+		{"external:shop/web", map[string]string{"services": "shop/web", "ingresses": "shop/shop", "hosts": "shop.example.com"}},
+	}
+	for i, test := range tests {
+		c := arch.Connections[i]
+		if c.ID != test.connection || !reflect.DeepEqual(c.Labels, test.want) {
+			t.Errorf("connection %s has labels %v, want %s with %v", c.ID, c.Labels, test.connection, test.want)
+		}
+	}
+}
+
+func TestK8sLabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "api", "tier": "back"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{`{}`, true},
+		{`{matchLabels: {app: api}}`, true},
+		{`{matchLabels: {app: web}}`, false},
+		{`{matchExpressions: [{key: tier, operator: In, values: [front, back]}]}`, true},
+		{`{matchExpressions: [{key: tier, operator: NotIn, values: [back]}]}`, false},
+		{`{matchExpressions: [{key: zone, operator: NotIn, values: [a]}]}`, true},
+		{`{matchExpressions: [{key: app, operator: Exists}]}`, true},
+		{`{matchExpressions: [{key: app, operator: DoesNotExist}]}`, false},
+		{`{matchExpressions: [{key: app, operator: Like}]}`, false},
+	}
+	for _, test := range tests {
+		var node struct {
+			Selector k8sLabelSelector `yaml:"selector"`
+		}
+		if err := yaml.Unmarshal([]byte("selector: "+test.selector), &node); err != nil {
+			t.Fatal(err)
+		}
+		if got := node.Selector.matches(labels); got != test.want {
+			t.Errorf("%s: matches = %v, want %v", test.selector, got, test.want)
+		}
+	}
+}
+
+func TestImportKubernetesUpdate(t *testing.T) {
+	first, err := Import("kubernetes", []byte(k8sTestManifests), ImportOptions{ID: "shop", Name: "Shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := first.Architecture
+	previous.Components[0].Object.Position = [3]float64{100, 0, 0}
+	previous.Views = []View{{ID: "all", Name: "All", Query: "component"}}
+
+	// Remove db and add a worker
+	data := strings.Replace(k8sTestManifests, "kind: StatefulSet\nmetadata:\n  name: db", "kind: StatefulSet\nmetadata:\n  name: worker", 1)
+	result, err := Import("kubernetes", []byte(data), ImportOptions{Previous: &previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := result.Architecture
+	if arch.Info.ID != "shop" || arch.Info.Name != "Shop" || len(arch.Views) != 1 {
+		t.Errorf("info %+v and views %v were not kept", arch.Info, arch.Views)
+	}
+	positions := layoutPositions(arch)
+	if got := positions["shop/web"]; got != [3]float64{100, 0, 0} {
+		t.Errorf("web moved to %v", got)
+	}
+	if got := positions["shop/api"]; got != previous.Components[1].Object.Position {
+		t.Errorf("api moved from %v to %v", previous.Components[1].Object.Position, got)
+	}
+	if _, ok := positions["shop/worker"]; !ok {
+		t.Errorf("worker was not imported: %v", positions)
+	}
+	if _, ok := positions["shop/db"]; ok {
+		t.Errorf("db was not removed")
+	}
+}
+
+func TestReadImportDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"b.yaml":        "kind: B\n",
+		"a/c.yml":       "kind: C\n",
+		"a.json":        `{"kind": "A"}`,
+		"README.md":     "kind: README\n",
+		"a/d/e.yaml.gz": "kind: E\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ReadImportDirectory("kubernetes", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\n---\nkind: C\n\n---\n{\"kind\": \"A\"}\n---\nkind: B\n"; string(data) != want {
+		t.Errorf("directory = %q, want %q", data, want)
+	}
+
+	if _, err := ReadImportDirectory("compose", dir); err == nil {
+		t.Errorf("compose directory: expected an error")
+	}
+	if _, err := ReadImportDirectory("kubernetes", filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing directory: expected an error")
+	}
+}
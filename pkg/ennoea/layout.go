@@ -0,0 +1,581 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+)
+
+// Layout algorithms that can be used to position the components.
+const (
+	// LayoutForce is a force-directed layout in three dimensions.
+	// Connected components attract each other and every component
+	// repels every other component.
+	LayoutForce = "force"
+
+	// LayoutLayered places the components in layers by their
+	// dependency depth, from left to right along the x axis.
+	LayoutLayered = "layered"
+
+	// LayoutGrouped places the members of each group together in a
+	// cluster, and places the clusters on a grid.
+	LayoutGrouped = "grouped"
+
+	// LayoutGrid places the components on a grid.
+	LayoutGrid = "grid"
+)
+
+const (
+	// defaultLayoutSpacing is the default distance between
+	// neighbouring components.
+	defaultLayoutSpacing = 5.0
+
+	// defaultLayoutIterations is the default number of iterations of
+	// the force-directed layout.
+	defaultLayoutIterations = 300
+
+	// maxLayoutIterations is the largest number of iterations that
+	// can be requested.
+	maxLayoutIterations = 5000
+
+	// forceGravity is the strength of the pull towards the centre of
+	// the force-directed layout. Without it, components that are not
+	// connected to anything drift away.
+	forceGravity = 0.1
+
+	// maxForceWork is the largest number of pairs of components
+	// times the number of iterations that the force-directed layout
+	// computes. Every pair is visited in every iteration, so this
+	// keeps a layout to about a second.
+	maxForceWork = 30000000
+
+	// layeredSweeps is the number of times the layers are reordered
+	// to reduce crossing connections.
+	layeredSweeps = 8
+)
+
+// LayoutOptions represents the options of a layout.
+type LayoutOptions struct {
+	// Algorithm is the layout algorithm. It is either "force",
+	// "layered", "grouped" or "grid".
+	Algorithm string `json:"algorithm"`
+
+	// Pinned is a list of the IDs of the components that keep their
+	// positions.
+	Pinned []string `json:"pinned,omitempty"`
+
+	// Spacing is the distance between neighbouring components. It
+	// defaults to 5.
+	Spacing float64 `json:"spacing,omitempty"`
+
+	// Iterations is the number of iterations of the force-directed
+	// layout. It defaults to 300.
+	Iterations int `json:"iterations,omitempty"`
+
+	// Seed seeds the random starting positions of the
+	// force-directed layout so that the layout can be reproduced.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// isValid returns an error if the layout options are invalid.
+func (o LayoutOptions) isValid() error {
+	switch o.Algorithm {
+	case LayoutForce, LayoutLayered, LayoutGrouped, LayoutGrid:
+	default:
+		return fmt.Errorf("invalid layout: invalid algorithm: %s", o.Algorithm)
+	}
+	if o.Spacing < 0 || math.IsNaN(o.Spacing) || math.IsInf(o.Spacing, 0) {
+		return fmt.Errorf("invalid layout: spacing is not a positive number")
+	}
+	if o.Iterations < 0 || o.Iterations > maxLayoutIterations {
+		return fmt.Errorf("invalid layout: iterations is not between 0 and %d", maxLayoutIterations)
+	}
+	return nil
+}
+
+// layout holds the state of a layout while the positions are
+// computed.
+type layout struct {
+	g       *architectureGraph
+	options LayoutOptions
+
+	// positions is the position of each component.
+	positions [][3]float64
+
+	// pinned is set for the components that keep their positions.
+	pinned []bool
+}
+
+// LayoutArchitecture computes the positions of the components using
+// the layout algorithm and returns a copy of the architecture with
+// the new positions. Pinned components keep their positions.
+func LayoutArchitecture(arch Architecture, options LayoutOptions) (Architecture, error) {
+	if err := options.isValid(); err != nil {
+		return Architecture{}, err
+	}
+	if options.Spacing == 0 {
+		options.Spacing = defaultLayoutSpacing
+	}
+	if options.Iterations == 0 {
+		options.Iterations = defaultLayoutIterations
+	}
+	if options.Algorithm == LayoutForce && !canLayoutForce(len(arch.Components), options.Iterations) {
+		return Architecture{}, fmt.Errorf("invalid layout: %d components are too many for %d iterations of the force layout", len(arch.Components), options.Iterations)
+	}
+
+	l := &layout{
+		g:         newArchitectureGraph(arch),
+		options:   options,
+		positions: make([][3]float64, len(arch.Components)),
+		pinned:    make([]bool, len(arch.Components)),
+	}
+	for i, c := range arch.Components {
+		l.positions[i] = c.Object.Position
+	}
+	for _, id := range options.Pinned {
+		i, ok := l.g.index[id]
+		if !ok {
+			return Architecture{}, fmt.Errorf("invalid layout: unknown pinned component: %s", id)
+		}
+		l.pinned[i] = true
+	}
+
+	switch options.Algorithm {
+	case LayoutForce:
+		l.force()
+	case LayoutLayered:
+		l.layered()
+	case LayoutGrouped:
+		l.grouped()
+	case LayoutGrid:
+		l.grid()
+	}
+
+	// Copy the components so that the caller's architecture is not
+	// changed
+	components := make([]Component, len(arch.Components))
+	for i, c := range arch.Components {
+		if !l.pinned[i] {
+			c.Object.Position = l.positions[i]
+		}
+		components[i] = c
+	}
+	arch.Components = components
+
+	return arch, nil
+}
+
+// canLayoutForce returns true if the force-directed layout of the
+// number of components is within maxForceWork.
+func canLayoutForce(components, iterations int) bool {
+	pairs := components * (components - 1) / 2
+	return pairs <= maxForceWork/iterations
+}
+
+// unpinned returns the indexes of the components that can be moved.
+func (l *layout) unpinned() []int {
+	var indexes []int
+	for i, pinned := range l.pinned {
+		if !pinned {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// isOccupied returns true if a pinned component is closer to the
+// position than half of the spacing.
+func (l *layout) isOccupied(position [3]float64) bool {
+	for i, pinned := range l.pinned {
+		if pinned && vecLength(vecSub(l.positions[i], position)) < l.options.Spacing/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// grid places the components on a square grid in the x-z plane,
+// centred on the origin. Cells that are taken by pinned components
+// are skipped.
+func (l *layout) grid() {
+	indexes := l.unpinned()
+	cells := l.gridCells(len(indexes), [3]float64{}, true)
+	for n, i := range indexes {
+		l.positions[i] = cells[n]
+	}
+}
+
+// gridCells returns the positions of count cells of a square grid in
+// the x-z plane, centred on the centre. If skipOccupied is set, cells
+// that are taken by pinned components are skipped.
+func (l *layout) gridCells(count int, centre [3]float64, skipOccupied bool) [][3]float64 {
+	columns := int(math.Ceil(math.Sqrt(float64(count))))
+	offset := float64(columns-1) / 2 * l.options.Spacing
+
+	cells := make([][3]float64, 0, count)
+	for n := 0; len(cells) < count; n++ {
+		cell := [3]float64{
+			centre[0] + float64(n%columns)*l.options.Spacing - offset,
+			centre[1],
+			centre[2] + float64(n/columns)*l.options.Spacing - offset,
+		}
+		if skipOccupied && l.isOccupied(cell) {
+			continue
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// layered places the components in layers by their dependency depth
+// along the x axis, in the style of a Sugiyama layout. Components in
+// the same cycle are placed in the same layer. The components in each
+// layer are spread along the z axis and ordered by the barycentre of
+// their neighbours to reduce crossing connections.
+func (l *layout) layered() {
+	// The strongly connected components are in reverse topological
+	// order, so walking them backwards visits every component before
+	// the components it sends data to.
+	sccs := l.g.stronglyConnectedComponents()
+	sccOf := make([]int, l.g.size())
+	for s, scc := range sccs {
+		for _, v := range scc {
+			sccOf[v] = s
+		}
+	}
+	depth := make([]int, len(sccs))
+	for s := len(sccs) - 1; s >= 0; s-- {
+		for _, v := range sccs[s] {
+			for _, w := range l.g.successors(v) {
+				if t := sccOf[w]; t != s {
+					depth[t] = max(depth[t], depth[s]+1)
+				}
+			}
+		}
+	}
+
+	var layers [][]int
+	layerOf := make([]int, l.g.size())
+	for v := 0; v < l.g.size(); v++ {
+		d := depth[sccOf[v]]
+		for len(layers) <= d {
+			layers = append(layers, nil)
+		}
+		layers[d] = append(layers[d], v)
+		layerOf[v] = d
+	}
+
+	// Reorder each layer by the average order of the neighbours in
+	// the previous layer, sweeping down and then back up.
+	order := make([]float64, l.g.size())
+	for _, layer := range layers {
+		for n, v := range layer {
+			order[v] = float64(n)
+		}
+	}
+	for sweep := 0; sweep < layeredSweeps; sweep++ {
+		down := sweep%2 == 0
+		for n := range layers {
+			d := n
+			neighbours := l.g.predecessors
+			if !down {
+				d = len(layers) - 1 - n
+				neighbours = l.g.successors
+			}
+
+			layer := layers[d]
+			barycentre := make(map[int]float64, len(layer))
+			for _, v := range layer {
+				sum, count := 0.0, 0
+				for _, w := range neighbours(v) {
+					if layerOf[w] != d {
+						sum += order[w]
+						count++
+					}
+				}
+				barycentre[v] = order[v]
+				if count > 0 {
+					barycentre[v] = sum / float64(count)
+				}
+			}
+			sort.SliceStable(layer, func(i, j int) bool {
+				return barycentre[layer[i]] < barycentre[layer[j]]
+			})
+			for n, v := range layer {
+				order[v] = float64(n)
+			}
+		}
+	}
+
+	for d, layer := range layers {
+		offset := float64(len(layer)-1) / 2
+		for n, v := range layer {
+			if !l.pinned[v] {
+				l.positions[v] = [3]float64{
+					float64(d) * l.options.Spacing,
+					0,
+					(float64(n) - offset) * l.options.Spacing,
+				}
+			}
+		}
+	}
+}
+
+// grouped places the members of each group in a cluster, and places
+// the clusters on a grid in the x-z plane. Components that are in
+// more than one group are placed with the first group, and
+// components that are not in any group form a cluster of their own.
+func (l *layout) grouped() {
+	var clusters [][]int
+	placed := make([]bool, l.g.size())
+	for _, group := range l.g.arch.Groups {
+		var cluster []int
+		for _, id := range group.Components {
+			if i, ok := l.g.index[id]; ok && !placed[i] && !l.pinned[i] {
+				cluster = append(cluster, i)
+				placed[i] = true
+			}
+		}
+		if len(cluster) > 0 {
+			clusters = append(clusters, cluster)
+		}
+	}
+	var ungrouped []int
+	for _, i := range l.unpinned() {
+		if !placed[i] {
+			ungrouped = append(ungrouped, i)
+		}
+	}
+	if len(ungrouped) > 0 {
+		clusters = append(clusters, ungrouped)
+	}
+
+	// Every cluster gets a cell as wide as the largest cluster plus
+	// a gap of one spacing on each side.
+	largest := 0
+	for _, cluster := range clusters {
+		largest = max(largest, len(cluster))
+	}
+	width := (math.Ceil(math.Sqrt(float64(largest))) + 1) * l.options.Spacing
+
+	outer := &layout{options: l.options}
+	outer.options.Spacing = width
+	centres := outer.gridCells(len(clusters), [3]float64{}, false)
+	for n, cluster := range clusters {
+		cells := l.gridCells(len(cluster), centres[n], false)
+		for m, i := range cluster {
+			l.positions[i] = cells[m]
+		}
+	}
+}
+
+// force places the components using the Fruchterman-Reingold
+// force-directed algorithm in three dimensions. The components start
+// at random positions and move less with every iteration as the
+// layout cools down. Pinned components are not moved, but still push
+// and pull the other components.
+func (l *layout) force() {
+	n := l.g.size()
+	k := l.options.Spacing
+	rng := rand.New(rand.NewSource(l.options.Seed))
+
+	// Start the unpinned components at random positions in a cube
+	// that is large enough to hold them all
+	side := k * math.Cbrt(float64(n))
+	for _, i := range l.unpinned() {
+		for axis := range l.positions[i] {
+			l.positions[i][axis] = (rng.Float64() - 0.5) * side
+		}
+	}
+
+	// Connected components attract each other regardless of the
+	// direction of the flow, and only once per pair.
+	type pair struct{ a, b int }
+	var edges []pair
+	seen := make(map[pair]bool)
+	for v := 0; v < n; v++ {
+		for _, w := range l.g.successors(v) {
+			p := pair{min(v, w), max(v, w)}
+			if v != w && !seen[p] {
+				seen[p] = true
+				edges = append(edges, p)
+			}
+		}
+	}
+
+	temperature := side
+	cooling := temperature / float64(l.options.Iterations)
+	displacement := make([][3]float64, n)
+	for iteration := 0; iteration < l.options.Iterations; iteration++ {
+		for i := range displacement {
+			displacement[i] = [3]float64{}
+		}
+
+		// Every pair of components repel each other
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				delta := vecSub(l.positions[i], l.positions[j])
+				distance := vecLength(delta)
+				if distance < 0.01 {
+					// Separate components at the same position in a
+					// random direction
+					delta = [3]float64{rng.Float64() - 0.5, rng.Float64() - 0.5, rng.Float64() - 0.5}
+					distance = 0.01
+				}
+				force := vecScale(delta, k*k/(distance*distance))
+				displacement[i] = vecAdd(displacement[i], force)
+				displacement[j] = vecSub(displacement[j], force)
+			}
+		}
+
+		// Connected components attract each other
+		for _, e := range edges {
+			delta := vecSub(l.positions[e.a], l.positions[e.b])
+			distance := vecLength(delta)
+			force := vecScale(delta, distance/k)
+			displacement[e.a] = vecSub(displacement[e.a], force)
+			displacement[e.b] = vecAdd(displacement[e.b], force)
+		}
+
+		// Every component is pulled towards the centre
+		var centre [3]float64
+		for _, p := range l.positions {
+			centre = vecAdd(centre, p)
+		}
+		centre = vecScale(centre, 1/float64(n))
+		for i := 0; i < n; i++ {
+			force := vecScale(vecSub(l.positions[i], centre), forceGravity)
+			displacement[i] = vecSub(displacement[i], force)
+		}
+
+		// Move each component no further than the temperature
+		for i := 0; i < n; i++ {
+			if l.pinned[i] {
+				continue
+			}
+			length := vecLength(displacement[i])
+			if length > 0 {
+				step := math.Min(length, temperature)
+				l.positions[i] = vecAdd(l.positions[i], vecScale(displacement[i], step/length))
+			}
+		}
+		temperature = math.Max(temperature-cooling, k/100)
+	}
+
+	// Centre the layout on the origin unless components are pinned,
+	// in which case the layout has to stay where they are.
+	indexes := l.unpinned()
+	if len(indexes) == n && n > 0 {
+		var centre [3]float64
+		for _, p := range l.positions {
+			centre = vecAdd(centre, p)
+		}
+		centre = vecScale(centre, 1/float64(n))
+		for i := range l.positions {
+			l.positions[i] = vecSub(l.positions[i], centre)
+		}
+	}
+}
+
+// vecAdd returns the sum of the vectors.
+func vecAdd(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+// vecSub returns the difference of the vectors.
+func vecSub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+// vecScale returns the vector multiplied by the scalar.
+func vecScale(a [3]float64, s float64) [3]float64 {
+	return [3]float64{a[0] * s, a[1] * s, a[2] * s}
+}
+
+// vecLength returns the length of the vector.
+func vecLength(a [3]float64) float64 {
+	return math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+}
+
+// vecDot returns the dot product of the vectors.
+func vecDot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// vecCross returns the cross product of the vectors.
+func vecCross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// vecNormalize returns the vector scaled to a length of one. The zero
+// vector is returned unchanged.
+func vecNormalize(a [3]float64) [3]float64 {
+	length := vecLength(a)
+	if length == 0 {
+		return a
+	}
+	return vecScale(a, 1/length)
+}
+
+// layoutRequest represents the body of a layout request.
+type layoutRequest struct {
+	LayoutOptions
+
+	// Preview determines whether or not the architecture is saved.
+	// A preview only returns the architecture with the new
+	// positions.
+	Preview bool `json:"preview"`
+}
+
+// handlePostLayout handles POST requests to lay out an architecture.
+// POST /architectures/${architectureID}/layout
+//
+//	compute the positions of the components and save them. The
+//	request body contains the layout options:
+//	{
+//		"algorithm": "force" | "layered" | "grouped" | "grid",
+//		"pinned": ["componentID"],
+//		"spacing": 5,
+//		"iterations": 300,
+//		"seed": 1,
+//		"preview": false
+//	}
+//	The architecture with the new positions is returned. If preview is
+//	set, the architecture is not saved.
+func (h *ArchitectureHandler) handlePostLayout(w http.ResponseWriter, r *http.Request, architectureID string) {
+	var req layoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to decode layout: %v", err)
+		return
+	}
+
+	if !req.Preview {
+		defer h.lockArchitecture(architectureID)()
+	}
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	arch, err = LayoutArchitecture(arch, req.LayoutOptions)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to lay out architecture: %v", err)
+		return
+	}
+
+	if !req.Preview && !h.saveArchitectureForRequest(w, arch) {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, arch)
+}
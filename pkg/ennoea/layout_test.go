@@ -0,0 +1,208 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// layoutTestArchitecture returns an architecture in which a and d
+// send to b, b and c send to each other, and a and b are grouped.
+func layoutTestArchitecture() Architecture {
+	return Architecture{
+		Components: []Component{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}},
+		Groups:     []Group{{ID: "ab", Components: []string{"a", "b"}}},
+		Connections: []Connection{
+			{ID: "a-b", Source: "a", Target: "b", Flow: "out"},
+			{ID: "d-b", Source: "d", Target: "b", Flow: "out"},
+			{ID: "b-c", Source: "b", Target: "c", Flow: "bi"},
+		},
+	}
+}
+
+// layoutPositions returns the positions of the components by ID.
+func layoutPositions(arch Architecture) map[string][3]float64 {
+	positions := make(map[string][3]float64, len(arch.Components))
+	for _, c := range arch.Components {
+		positions[c.ID] = c.Object.Position
+	}
+	return positions
+}
+
+func TestLayoutArchitecture(t *testing.T) {
+	tests := []struct {
+		name    string
+		options LayoutOptions
+		pin     [3]float64
+		want    map[string][3]float64
+	}{
+		{
+			name:    "grid",
+			options: LayoutOptions{Algorithm: LayoutGrid, Spacing: 2},
+			want: map[string][3]float64{
+				"a": {-1, 0, -1}, "b": {1, 0, -1}, "c": {-1, 0, 1}, "d": {1, 0, 1},
+			},
+		},
+		{
+			name:    "grid around a pinned component",
+			options: LayoutOptions{Algorithm: LayoutGrid, Spacing: 2, Pinned: []string{"d"}},
+			pin:     [3]float64{-1, 0, -1},
+			want: map[string][3]float64{
+				"a": {1, 0, -1}, "b": {-1, 0, 1}, "c": {1, 0, 1}, "d": {-1, 0, -1},
+			},
+		},
+		{
+			// b and c are in a cycle, so they share a layer
+			name:    "layered",
+			options: LayoutOptions{Algorithm: LayoutLayered},
+			want: map[string][3]float64{
+				"a": {0, 0, -2.5}, "d": {0, 0, 2.5}, "b": {5, 0, -2.5}, "c": {5, 0, 2.5},
+			},
+		},
+		{
+			name:    "grouped",
+			options: LayoutOptions{Algorithm: LayoutGrouped},
+			want: map[string][3]float64{
+				"a": {-10, 0, -10}, "b": {-5, 0, -10}, "c": {5, 0, -10}, "d": {10, 0, -10},
+			},
+		},
+	}
+	for _, test := range tests {
+		arch := layoutTestArchitecture()
+		arch.Components[3].Object.Position = test.pin
+		laid, err := LayoutArchitecture(arch, test.options)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := layoutPositions(laid); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: positions = %v, want %v", test.name, got, test.want)
+		}
+		if arch.Components[0].Object.Position != ([3]float64{}) {
+			t.Errorf("%s: the architecture of the caller was changed", test.name)
+		}
+	}
+}
+
+func TestLayoutForce(t *testing.T) {
+	arch := layoutTestArchitecture()
+	arch.Components = append(arch.Components, Component{ID: "e"})
+	options := LayoutOptions{Algorithm: LayoutForce, Seed: 7}
+
+	laid, err := LayoutArchitecture(arch, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := LayoutArchitecture(arch, options)
+	if !reflect.DeepEqual(laid, again) {
+		t.Errorf("layouts with the same seed differ")
+	}
+
+	positions := layoutPositions(laid)
+	var centre [3]float64
+	for id, p := range positions {
+		for _, v := range p {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("%s has position %v", id, p)
+			}
+		}
+		centre = vecAdd(centre, p)
+	}
+	if vecLength(centre) > 1e-9 {
+		t.Errorf("layout is centred on %v, want the origin", vecScale(centre, 0.2))
+	}
+
+	distance := func(a, b string) float64 {
+		return vecLength(vecSub(positions[a], positions[b]))
+	}
+	if distance("a", "b") >= distance("a", "e") {
+		t.Errorf("connected a and b are %.2f apart, further than unconnected a and e at %.2f", distance("a", "b"), distance("a", "e"))
+	}
+	for _, a := range []string{"a", "b", "c", "d", "e"} {
+		for _, b := range []string{"a", "b", "c", "d", "e"} {
+			if a < b && distance(a, b) < defaultLayoutSpacing/4 {
+				t.Errorf("%s and %s are only %.2f apart", a, b, distance(a, b))
+			}
+		}
+	}
+
+	// Pinned components keep their positions and the rest move
+	arch.Components[0].Object.Position = [3]float64{20, 0, 0}
+	options.Pinned = []string{"a"}
+	laid, err = LayoutArchitecture(arch, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := laid.Components[0].Object.Position; p != [3]float64{20, 0, 0} {
+		t.Errorf("pinned a moved to %v", p)
+	}
+	if vecLength(vecSub(laid.Components[1].Object.Position, [3]float64{20, 0, 0})) > 3*defaultLayoutSpacing {
+		t.Errorf("b at %v was not pulled towards the pinned a", laid.Components[1].Object.Position)
+	}
+}
+
+func TestLayoutForceLimit(t *testing.T) {
+	var arch Architecture
+	for i := 0; i < 1000; i++ {
+		arch.Components = append(arch.Components, Component{ID: fmt.Sprintf("c%d", i)})
+	}
+	if _, err := LayoutArchitecture(arch, LayoutOptions{Algorithm: LayoutForce}); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("force layout of 1000 components: error = %v", err)
+	}
+	if _, err := LayoutArchitecture(arch, LayoutOptions{Algorithm: LayoutForce, Iterations: 50}); err != nil {
+		t.Errorf("force layout of 1000 components in 50 iterations: %v", err)
+	}
+}
+
+func TestLayoutOptionsIsValid(t *testing.T) {
+	tests := []LayoutOptions{
+		{Algorithm: "circle"},
+		{Algorithm: LayoutGrid, Spacing: -1},
+		{Algorithm: LayoutGrid, Spacing: math.Inf(1)},
+		{Algorithm: LayoutForce, Iterations: maxLayoutIterations + 1},
+		{Algorithm: LayoutGrid, Pinned: []string{"missing"}},
+	}
+	for _, options := range tests {
+		if _, err := LayoutArchitecture(layoutTestArchitecture(), options); err == nil {
+			t.Errorf("%+v: expected an error", options)
+		}
+	}
+}
+
+func TestPostLayout(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var preview Architecture
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/layout", `{"algorithm": "grid", "spacing": 2, "preview": true}`, http.StatusOK, &preview)
+	if p := preview.Components[0].Object.Position; p != [3]float64{-1, 0, -1} {
+		t.Errorf("preview placed web at %v", p)
+	}
+	saved, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := saved.Components[0].Object.Position; p != [3]float64{-5, 0, 0} {
+		t.Errorf("preview saved web at %v", p)
+	}
+
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/layout", `{"algorithm": "layered", "pinned": ["db"]}`, http.StatusOK, nil)
+	saved, err = h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connections are "bi", so the components are in one layer
+	// and the pinned db stays where it was
+	want := map[string][3]float64{"web": {0, 0, -5}, "api": {0, 0, 0}, "db": {5, 0, 0}}
+	if got := layoutPositions(saved); !reflect.DeepEqual(got, want) {
+		body, _ := json.Marshal(got)
+		t.Errorf("saved positions = %s, want %v", body, want)
+	}
+
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/layout", `{"algorithm": "spiral"}`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/layout", `{`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/none/layout", `{"algorithm": "grid"}`, http.StatusNotFound, nil)
+}
@@ -0,0 +1,524 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+)
+
+/*
+Lint rules are soft checks on an architecture. Unlike validation, a
+lint finding does not stop an architecture from being saved. Each rule
+has a default severity and can be configured per deployment with a
+JSON configuration file. A rule can be restricted to the entities that
+match a query with a selector.
+
+lint.json
+{
+	"rules": {
+		"orphaned-component": {
+			"enabled": false
+		},
+		"name-pattern": {
+			"severity": "error",
+			"options": {
+				"components": "^[A-Z][A-Za-z0-9-]*$"
+			}
+		},
+		"max-inbound": {
+			"selector": "component where labels.env == \"prod\"",
+			"options": {
+				"max": 5
+			}
+		}
+	}
+}
+*/
+
+// Lint severities in increasing order of importance.
+const (
+	LintInfo    = "info"
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+// lintSeverityRank maps each severity to its order of importance.
+var lintSeverityRank = map[string]int{
+	LintInfo:    0,
+	LintWarning: 1,
+	LintError:   2,
+}
+
+// LintFinding represents a single problem found by a lint rule.
+type LintFinding struct {
+	// Rule is the ID of the rule that produced the finding.
+	Rule string `json:"rule"`
+
+	// Severity is the severity of the finding. The severity is
+	// either "info", "warning" or "error".
+	Severity string `json:"severity"`
+
+	// Entity is the ID of the component, group or connection that
+	// the finding is about. It is empty for the whole architecture.
+	Entity string `json:"entity,omitempty"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// String returns the finding formatted on a single line.
+func (f LintFinding) String() string {
+	if f.Entity == "" {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", f.Severity, f.Rule, f.Entity, f.Message)
+}
+
+// LintConfig represents the per deployment configuration of the lint
+// rules. Rules that are not configured use their defaults.
+type LintConfig struct {
+	// Rules is a map of rule IDs to their configuration.
+	Rules map[string]LintRuleConfig `json:"rules"`
+}
+
+// LintRuleConfig represents the configuration of a single lint rule.
+type LintRuleConfig struct {
+	// Enabled determines whether or not the rule runs. Rules are
+	// enabled by default.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Severity overrides the default severity of the rule.
+	Severity string `json:"severity,omitempty"`
+
+	// Options contains the rule specific options.
+	Options json.RawMessage `json:"options,omitempty"`
+
+	// Selector is a query that restricts the rule to the entities it
+	// selects, for example `component where labels.env == "prod"`.
+	// Findings about the whole architecture are always kept.
+	Selector string `json:"selector,omitempty"`
+}
+
+// isValid returns an error if the lint configuration is invalid.
+func (c LintConfig) isValid() error {
+	for id, rule := range c.Rules {
+		lint, ok := findLintRule(id)
+		if !ok {
+			return fmt.Errorf("invalid lint config: unknown rule: %s", id)
+		}
+		if _, ok := lintSeverityRank[rule.Severity]; rule.Severity != "" && !ok {
+			return fmt.Errorf("invalid lint config: %s: invalid severity: %s", id, rule.Severity)
+		}
+		if rule.Selector != "" {
+			if _, err := compileQuery(rule.Selector); err != nil {
+				return fmt.Errorf("invalid lint config: %s: invalid selector: %v", id, err)
+			}
+		}
+
+		// The rules parse their options before they look at the
+		// architecture, so running them on an empty one checks the
+		// options without waiting for the first save
+		if _, err := lint.check(Architecture{}, rule.Options); err != nil {
+			return fmt.Errorf("invalid lint config: %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// LoadLintConfig loads the lint configuration from a JSON file.
+func LoadLintConfig(path string) (LintConfig, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return LintConfig{}, fmt.Errorf("failed to read lint config: %v", err)
+	}
+
+	var config LintConfig
+	err = json.Unmarshal(file, &config)
+	if err != nil {
+		return LintConfig{}, fmt.Errorf("failed to unmarshal lint config: %v", err)
+	}
+
+	if err := config.isValid(); err != nil {
+		return LintConfig{}, err
+	}
+
+	return config, nil
+}
+
+// lintRule represents a built-in lint rule.
+type lintRule struct {
+	// id is the unique identifier of the rule.
+	id string
+
+	// severity is the default severity of the findings.
+	severity string
+
+	// check runs the rule against the architecture. The options
+	// are the rule specific options from the configuration, which
+	// may be empty.
+	check func(arch Architecture, options json.RawMessage) ([]LintFinding, error)
+}
+
+// lintRules is the list of built-in lint rules.
+var lintRules = []lintRule{
+	{id: "orphaned-component", severity: LintWarning, check: lintOrphanedComponents},
+	{id: "name-pattern", severity: LintWarning, check: lintNamePattern},
+	{id: "server-in-group", severity: LintWarning, check: lintServerInGroup},
+	{id: "max-inbound", severity: LintWarning, check: lintMaxInbound},
+	{id: "database-to-database", severity: LintError, check: lintDatabaseToDatabase},
+}
+
+// findLintRule returns the built-in rule with the ID.
+func findLintRule(id string) (lintRule, bool) {
+	for _, rule := range lintRules {
+		if rule.id == id {
+			return rule, true
+		}
+	}
+	return lintRule{}, false
+}
+
+// LintArchitecture validates the architecture and runs the enabled
+// lint rules against it. Validation errors are reported as findings
+// of the "validation" rule with an error severity. The findings are
+// sorted by severity, most important first.
+func LintArchitecture(arch Architecture, config LintConfig) ([]LintFinding, error) {
+	findings := []LintFinding{}
+
+	if err := arch.isValid(); err != nil {
+		findings = append(findings, LintFinding{Rule: "validation", Severity: LintError, Message: err.Error()})
+	}
+	if err := arch.isConsistent(); err != nil {
+		findings = append(findings, LintFinding{Rule: "validation", Severity: LintError, Message: err.Error()})
+	}
+
+	// The expression context is only built if a rule has a selector
+	var ctx *exprContext
+
+	for _, rule := range lintRules {
+		ruleConfig := config.Rules[rule.id]
+		if ruleConfig.Enabled != nil && !*ruleConfig.Enabled {
+			continue
+		}
+
+		ruleFindings, err := rule.check(arch, ruleConfig.Options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run lint rule %s: %w", rule.id, err)
+		}
+
+		if ruleConfig.Selector != "" {
+			if ctx == nil {
+				ctx = newExprContext(arch)
+			}
+			ruleFindings, err = selectLintFindings(ctx, ruleFindings, ruleConfig.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("failed to select lint rule %s: %w", rule.id, err)
+			}
+		}
+
+		severity := rule.severity
+		if ruleConfig.Severity != "" {
+			severity = ruleConfig.Severity
+		}
+		for _, f := range ruleFindings {
+			f.Rule = rule.id
+			f.Severity = severity
+			findings = append(findings, f)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return lintSeverityRank[findings[i].Severity] > lintSeverityRank[findings[j].Severity]
+	})
+
+	return findings, nil
+}
+
+// selectLintFindings returns the findings that are about the whole
+// architecture or about an entity that is selected by the selector.
+func selectLintFindings(ctx *exprContext, findings []LintFinding, query string) ([]LintFinding, error) {
+	selector, err := compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := selectEntities(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		selected[ctx.id(e)] = true
+	}
+
+	var kept []LintFinding
+	for _, f := range findings {
+		if f.Entity == "" || selected[f.Entity] {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
+// LintFailed returns true if any of the findings are at or above the
+// severity.
+func LintFailed(findings []LintFinding, severity string) bool {
+	for _, f := range findings {
+		if lintSeverityRank[f.Severity] >= lintSeverityRank[severity] {
+			return true
+		}
+	}
+	return false
+}
+
+// lintOrphanedComponents reports the components without connections.
+func lintOrphanedComponents(arch Architecture, options json.RawMessage) ([]LintFinding, error) {
+	g := newArchitectureGraph(arch)
+	var findings []LintFinding
+	for i, c := range arch.Components {
+		if len(g.in[i]) == 0 && len(g.out[i]) == 0 {
+			findings = append(findings, LintFinding{
+				Entity:  c.ID,
+				Message: fmt.Sprintf("component %q has no connections", c.Name),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// namePatternOptions represents the options of the name-pattern rule.
+// Each pattern is a regular expression that the names must match.
+// Entities without a pattern are not checked.
+type namePatternOptions struct {
+	Components  string `json:"components"`
+	Groups      string `json:"groups"`
+	Connections string `json:"connections"`
+}
+
+// lintNamePattern reports the entities whose names do not match the
+// configured regular expressions.
+func lintNamePattern(arch Architecture, options json.RawMessage) ([]LintFinding, error) {
+	var opts namePatternOptions
+	if err := unmarshalLintOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	var findings []LintFinding
+	check := func(pattern, kind string, ids, names []string) error {
+		if pattern == "" {
+			return nil
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid %s pattern: %v", kind, err)
+		}
+		for i, name := range names {
+			if !re.MatchString(name) {
+				findings = append(findings, LintFinding{
+					Entity:  ids[i],
+					Message: fmt.Sprintf("%s name %q does not match %q", kind, name, pattern),
+				})
+			}
+		}
+		return nil
+	}
+
+	var ids, names []string
+	for _, c := range arch.Components {
+		ids, names = append(ids, c.ID), append(names, c.Name)
+	}
+	if err := check(opts.Components, "component", ids, names); err != nil {
+		return nil, err
+	}
+
+	ids, names = nil, nil
+	for _, g := range arch.Groups {
+		ids, names = append(ids, g.ID), append(names, g.Name)
+	}
+	if err := check(opts.Groups, "group", ids, names); err != nil {
+		return nil, err
+	}
+
+	ids, names = nil, nil
+	for _, c := range arch.Connections {
+		ids, names = append(ids, c.ID), append(names, c.Name)
+	}
+	if err := check(opts.Connections, "connection", ids, names); err != nil {
+		return nil, err
+	}
+
+	return findings, nil
+}
+
+// lintServerInGroup reports the servers that are not in any group.
+func lintServerInGroup(arch Architecture, options json.RawMessage) ([]LintFinding, error) {
+	grouped := make(map[string]bool)
+	for _, g := range arch.Groups {
+		for _, member := range g.Components {
+			grouped[member] = true
+		}
+	}
+
+	var findings []LintFinding
+	for _, c := range arch.Components {
+		if c.Type == "server" && !grouped[c.ID] {
+			findings = append(findings, LintFinding{
+				Entity:  c.ID,
+				Message: fmt.Sprintf("server %q does not belong to a group", c.Name),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// maxInboundOptions represents the options of the max-inbound rule.
+type maxInboundOptions struct {
+	// Max is the maximum number of inbound connections. The default
+	// is 10.
+	Max int `json:"max"`
+}
+
+// lintMaxInbound reports the components with more inbound connections
+// than the maximum. A connection is inbound if data flows into the
+// component through it.
+func lintMaxInbound(arch Architecture, options json.RawMessage) ([]LintFinding, error) {
+	opts := maxInboundOptions{Max: 10}
+	if err := unmarshalLintOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	g := newArchitectureGraph(arch)
+	var findings []LintFinding
+	for i, c := range arch.Components {
+		if inbound := len(g.in[i]); inbound > opts.Max {
+			findings = append(findings, LintFinding{
+				Entity:  c.ID,
+				Message: fmt.Sprintf("component %q has %d inbound connections, more than %d", c.Name, inbound, opts.Max),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// databaseOptions represents the options of the database-to-database
+// rule.
+type databaseOptions struct {
+	// Match is an optional regular expression that matches the names
+	// of the server components that are also databases, such as
+	// "(?i)postgres|mysql". Names are not reliable, so no names are
+	// matched by default.
+	Match string `json:"match"`
+}
+
+// isDatabase returns true if the component is a database. A database
+// is a server that is drawn as a cylinder or has a database label
+// that is not "false", or whose name matches the pattern if there is
+// one.
+func isDatabase(c Component, match *regexp.Regexp) bool {
+	if c.Type != "server" {
+		return false
+	}
+	if c.Object.Geometry == "cylinder" {
+		return true
+	}
+	if label, ok := c.Labels["database"]; ok && label != "false" {
+		return true
+	}
+	return match != nil && match.MatchString(c.Name)
+}
+
+// lintDatabaseToDatabase reports the connections between two
+// databases.
+func lintDatabaseToDatabase(arch Architecture, options json.RawMessage) ([]LintFinding, error) {
+	var opts databaseOptions
+	if err := unmarshalLintOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	var match *regexp.Regexp
+	if opts.Match != "" {
+		re, err := regexp.Compile(opts.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match pattern: %v", err)
+		}
+		match = re
+	}
+
+	databases := make(map[string]bool)
+	for _, c := range arch.Components {
+		if isDatabase(c, match) {
+			databases[c.ID] = true
+		}
+	}
+
+	var findings []LintFinding
+	for _, c := range arch.Connections {
+		if databases[c.Source] && databases[c.Target] {
+			findings = append(findings, LintFinding{
+				Entity:  c.ID,
+				Message: fmt.Sprintf("connection %q connects two databases", c.Name),
+			})
+		}
+	}
+	return findings, nil
+}
+
+// unmarshalLintOptions unmarshals the rule options into the value if
+// any options were configured.
+func unmarshalLintOptions(options json.RawMessage, v interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(options, v); err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	return nil
+}
+
+// LintReport represents the result of linting an architecture.
+type LintReport struct {
+	// Findings is the list of findings, most important first.
+	Findings []LintFinding `json:"findings"`
+
+	// Counts is the number of findings for each severity.
+	Counts map[string]int `json:"counts"`
+}
+
+// newLintReport creates the report for the findings.
+func newLintReport(findings []LintFinding) LintReport {
+	report := LintReport{
+		Findings: findings,
+		Counts:   map[string]int{LintInfo: 0, LintWarning: 0, LintError: 0},
+	}
+	for _, f := range findings {
+		report.Counts[f.Severity]++
+	}
+	return report
+}
+
+// SetLintConfig sets the lint configuration that is used when
+// architectures are linted on save and at the lint endpoint.
+func (h *ArchitectureHandler) SetLintConfig(config LintConfig) {
+	h.lintConfig = config
+}
+
+// handleGetLint handles GET requests to lint an architecture.
+// GET /architectures/${architectureID}/lint
+//
+//	return the lint findings of the architecture.
+func (h *ArchitectureHandler) handleGetLint(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	findings, err := LintArchitecture(arch, h.lintConfig)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to lint architecture: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, newLintReport(findings))
+}
@@ -0,0 +1,80 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLintConfigIsValidOptions(t *testing.T) {
+	tests := []struct {
+		rule    string
+		options string
+		err     string
+	}{
+		{rule: "name-pattern", options: `{"components": "^[a-z-]+$"}`},
+		{rule: "name-pattern", options: `{"components": "("}`, err: "invalid component pattern"},
+		{rule: "name-pattern", options: `{"groups": "[a-"}`, err: "invalid group pattern"},
+		{rule: "database-to-database", options: `{"match": "*"}`, err: "invalid match pattern"},
+		{rule: "max-inbound", options: `{"max": 3}`},
+		{rule: "max-inbound", options: `{"max": "three"}`, err: "invalid options"},
+	}
+
+	for _, test := range tests {
+		config := LintConfig{Rules: map[string]LintRuleConfig{
+			test.rule: {Options: json.RawMessage(test.options)},
+		}}
+		err := config.isValid()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s %s: isValid() = %v", test.rule, test.options, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s %s: isValid() = %v, want %q", test.rule, test.options, err, test.err)
+		}
+	}
+}
+
+func TestLintDatabaseToDatabase(t *testing.T) {
+	arch := testArchitecture([]string{"orders", "replica", "cache", "db-proxy", "mysql", "audit"},
+		"orders>replica", "replica>cache", "db-proxy>orders", "orders>mysql", "mysql>audit")
+	for i, c := range arch.Components {
+		c.Type = "server"
+		switch c.ID {
+		case "orders", "replica":
+			c.Object.Geometry = "cylinder"
+		case "cache":
+			c.Labels = map[string]string{"database": "redis"}
+		case "db-proxy":
+			// Named like a database, but an app
+			c.Type = "app"
+			c.Object.Geometry = "cylinder"
+		case "audit":
+			c.Labels = map[string]string{"database": "false"}
+		}
+		arch.Components[i] = c
+	}
+
+	tests := []struct {
+		options string
+		want    []string
+	}{
+		{options: ``, want: []string{"c0", "c1"}},
+		{options: `{"match": "(?i)mysql|audit"}`, want: []string{"c0", "c1", "c3", "c4"}},
+	}
+	for _, test := range tests {
+		findings, err := lintDatabaseToDatabase(arch, json.RawMessage(test.options))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range findings {
+			got = append(got, f.Entity)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("options %s: findings = %v, want %v", test.options, got, test.want)
+		}
+	}
+}
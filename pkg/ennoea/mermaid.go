@@ -0,0 +1,429 @@
+package ennoea
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+The mermaid importer reads Mermaid flowcharts, which start with
+"flowchart" or "graph". Nodes become components, links become
+connections and subgraphs become groups. The shape of a node picks the
+geometry of its component:
+
+	A[box]  A(rounded)  A[[subroutine]]  A>asymmetric]  box
+	A[(database)]                                       cylinder
+	A((circle))  A(((double circle)))                   sphere
+	A([stadium])                                        capsule
+	A{rhombus}                                          octahedron
+	A{{hexagon}}                                        dodecahedron
+	A[/trapezoid\]  A[\trapezoid/]                      cone
+
+Links with an arrow at one end flow out of the source, and links with
+arrows at both ends or none flow both ways. Invisible links are only
+used for placement and are not imported. The fill colours of the style
+and classDef statements colour the components. The diagram can be
+wrapped in a code fence and have a front matter title, like it is in a
+wiki page.
+*/
+
+// mermaidShape is the delimiters of a node shape.
+type mermaidShape struct {
+	open     string
+	closes   []string
+	geometry string
+}
+
+// mermaidShapes is the node shapes, with the longer delimiters first
+// so that they are matched before the shorter ones they start with.
+var mermaidShapes = []mermaidShape{
+	{"(((", []string{")))"}, "sphere"},
+	{"([", []string{"])"}, "capsule"},
+	{"[[", []string{"]]"}, "box"},
+	{"[(", []string{")]"}, "cylinder"},
+	{"((", []string{"))"}, "sphere"},
+	{"{{", []string{"}}"}, "dodecahedron"},
+	{"[/", []string{"\\]", "/]"}, "cone"},
+	{"[\\", []string{"/]", "\\]"}, "cone"},
+	{">", []string{"]"}, "box"},
+	{"[", []string{"]"}, "box"},
+	{"(", []string{")"}, "box"},
+	{"{", []string{"}"}, "octahedron"},
+}
+
+var (
+	// mermaidIDPattern matches the ID of a node.
+	mermaidIDPattern = regexp.MustCompile(`^[A-Za-z0-9_]+(?:[-.][A-Za-z0-9_]+)*`)
+
+	// mermaidLinkPattern matches a link, with an optional |text|.
+	mermaidLinkPattern = regexp.MustCompile(`^\s*([<xo]?)(-{2,}|={2,}|-\.+-|~{3,})(>|[xo]\b)?\s*(?:\|([^|]*)\|)?`)
+
+	// mermaidTextLinkPattern matches a link with the text inside it,
+	// such as "-- text -->".
+	mermaidTextLinkPattern = regexp.MustCompile(`^\s*([<xo]?)(--|==|-\.)\s+([^>|]*?)\s+(-{2,}|={2,}|\.+-)(>|[xo]\b)?`)
+)
+
+// mermaidImport holds the state of a Mermaid import.
+type mermaidImport struct {
+	diagram *diagram
+	groups  []*diagramGroup
+	classes map[string]string
+	line    int
+}
+
+// importMermaid imports a Mermaid flowchart.
+func importMermaid(data, config []byte) (ImportResult, error) {
+	var result ImportResult
+	m := &mermaidImport{
+		diagram: newDiagram(&result),
+		classes: make(map[string]string),
+	}
+
+	lines := strings.Split(string(data), "\n")
+	header := false
+	frontMatter := false
+	for i, line := range lines {
+		m.line = i + 1
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "```"):
+			continue
+		case line == "---" && !header:
+			frontMatter = !frontMatter
+			continue
+		case frontMatter:
+			if title, ok := strings.CutPrefix(line, "title:"); ok {
+				result.Architecture.Info.Name = strings.Trim(strings.TrimSpace(title), `"'`)
+			}
+			continue
+		}
+
+		for _, statement := range splitMermaidStatements(line) {
+			if statement == "" || strings.HasPrefix(statement, "%%") {
+				continue
+			}
+			if !header {
+				keyword := strings.Fields(statement)[0]
+				if keyword != "flowchart" && keyword != "graph" {
+					return ImportResult{}, fmt.Errorf("line %d: expected a flowchart or graph, found %s", m.line, keyword)
+				}
+				header = true
+				continue
+			}
+			if err := m.statement(statement); err != nil {
+				result.warnf("line %d: %v", m.line, err)
+			}
+		}
+	}
+	if !header {
+		return ImportResult{}, fmt.Errorf("expected a flowchart or graph")
+	}
+	if len(m.groups) > 0 {
+		result.warnf("subgraph %s is missing an end", m.groups[len(m.groups)-1].id)
+	}
+	if len(m.diagram.nodes) == 0 {
+		return ImportResult{}, fmt.Errorf("flowchart has no nodes")
+	}
+
+	// Classes can be defined after the nodes that use them, and the
+	// colours of style statements are kept
+	for _, n := range m.diagram.nodes {
+		if color, ok := m.classes[n.labels["class"]]; ok && n.color == "" {
+			n.color = color
+		}
+	}
+
+	m.diagram.architecture()
+	return result, nil
+}
+
+// splitMermaidStatements splits a line into the statements separated
+// by semicolons outside of quotes.
+func splitMermaidStatements(line string) []string {
+	var statements []string
+	quoted := false
+	start := 0
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			statements = append(statements, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	return append(statements, strings.TrimSpace(line[start:]))
+}
+
+// group returns the innermost subgraph, or nil if there is none.
+func (m *mermaidImport) group() *diagramGroup {
+	if len(m.groups) == 0 {
+		return nil
+	}
+	return m.groups[len(m.groups)-1]
+}
+
+// statement reads a statement of the flowchart.
+func (m *mermaidImport) statement(statement string) error {
+	fields := strings.Fields(statement)
+	switch fields[0] {
+	case "subgraph":
+		return m.subgraph(strings.TrimSpace(strings.TrimPrefix(statement, "subgraph")))
+	case "end":
+		if len(m.groups) == 0 {
+			return fmt.Errorf("end without a subgraph")
+		}
+		m.groups = m.groups[:len(m.groups)-1]
+		return nil
+	case "direction", "click", "linkStyle", "accTitle", "accDescr", "accTitle:", "accDescr:":
+		// These only change how the diagram is drawn
+		return nil
+	case "style":
+		if len(fields) < 3 {
+			return fmt.Errorf("style is missing a node or styles")
+		}
+		color, ok := m.fill(fields[2])
+		if !ok {
+			return nil
+		}
+		if g, ok := m.diagram.byID[fields[1]]; ok {
+			g.color = color
+		} else {
+			m.diagram.node(fields[1], m.group()).color = color
+		}
+		return nil
+	case "classDef":
+		if len(fields) < 3 {
+			return fmt.Errorf("classDef is missing a class or styles")
+		}
+		if color, ok := m.fill(fields[2]); ok {
+			for _, class := range strings.Split(fields[1], ",") {
+				m.classes[class] = color
+			}
+		}
+		return nil
+	case "class":
+		if len(fields) < 3 {
+			return fmt.Errorf("class is missing nodes or a class")
+		}
+		for _, id := range strings.Split(fields[1], ",") {
+			m.diagram.node(id, m.group()).labels["class"] = fields[2]
+		}
+		return nil
+	}
+	return m.chain(statement)
+}
+
+// fill returns the fill colour of a list of styles such as
+// "fill:#f9f,stroke:#333".
+func (m *mermaidImport) fill(styles string) (string, bool) {
+	for _, style := range strings.Split(styles, ",") {
+		if value, ok := strings.CutPrefix(style, "fill:"); ok {
+			color, err := diagramColor(value)
+			if err != nil {
+				m.diagram.result.warnf("line %d: %v", m.line, err)
+				return "", false
+			}
+			return color, true
+		}
+	}
+	return "", false
+}
+
+// subgraph starts a subgraph, which is "id", "id [title]", "id[title]"
+// or a title on its own.
+func (m *mermaidImport) subgraph(spec string) error {
+	if spec == "" {
+		return fmt.Errorf("subgraph is missing an id")
+	}
+	id, title := spec, ""
+	if i := strings.IndexByte(spec, '['); i >= 0 && strings.HasSuffix(spec, "]") {
+		id = strings.TrimSpace(spec[:i])
+		title = strings.Trim(strings.TrimSpace(spec[i+1:len(spec)-1]), `"`)
+	} else if strings.HasPrefix(spec, `"`) || strings.Contains(spec, " ") {
+		title = strings.Trim(spec, `"`)
+		id = title
+	}
+	m.groups = append(m.groups, m.diagram.group(id, title, m.group()))
+	return nil
+}
+
+// mermaidNode is a node of a statement, with its shape if it has one.
+type mermaidNode struct {
+	id       string
+	name     string
+	geometry string
+	class    string
+}
+
+// chain reads a statement of nodes and links, such as
+// "A[Web] --> B & C -.-> D". Each link connects every node before it to
+// every node after it. Nothing is added unless the whole statement can
+// be read.
+func (m *mermaidImport) chain(statement string) error {
+	first, rest, err := m.nodes(statement)
+	if err != nil {
+		return err
+	}
+	var links []mermaidLink
+	var targets [][]mermaidNode
+	for strings.TrimSpace(rest) != "" {
+		link, after, err := m.link(rest)
+		if err != nil {
+			return err
+		}
+		next, after, err := m.nodes(after)
+		if err != nil {
+			return err
+		}
+		links = append(links, link)
+		targets = append(targets, next)
+		rest = after
+	}
+
+	previous := m.add(first)
+	for i, link := range links {
+		next := m.add(targets[i])
+		if !link.invisible {
+			for _, source := range previous {
+				for _, target := range next {
+					e := m.diagram.edge(source, target, link.text, link.flow)
+					if link.style != "" {
+						e.labels["style"] = link.style
+					}
+				}
+			}
+		}
+		previous = next
+	}
+	return nil
+}
+
+// add adds the nodes to the diagram and returns their IDs. The shape
+// of a node that is a subgraph is ignored.
+func (m *mermaidImport) add(nodes []mermaidNode) []string {
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		ids[i] = m.diagram.endpoint(node.id, m.group())
+		n, ok := m.diagram.index[node.id]
+		if !ok {
+			continue
+		}
+		if node.geometry != "" {
+			n.name = node.name
+			n.geometry = node.geometry
+			if node.geometry == "cylinder" {
+				n.componentType = "server"
+			}
+		}
+		if node.class != "" {
+			n.labels["class"] = node.class
+		}
+	}
+	return ids
+}
+
+// nodes reads a list of nodes joined with "&", and returns them with
+// the rest of the statement.
+func (m *mermaidImport) nodes(s string) ([]mermaidNode, string, error) {
+	var nodes []mermaidNode
+	for {
+		node, rest, err := m.node(s)
+		if err != nil {
+			return nil, "", err
+		}
+		nodes = append(nodes, node)
+		trimmed := strings.TrimSpace(rest)
+		if !strings.HasPrefix(trimmed, "&") {
+			return nodes, rest, nil
+		}
+		s = trimmed[1:]
+	}
+}
+
+// node reads a node with an optional shape and class, and returns it
+// with the rest of the statement.
+func (m *mermaidImport) node(s string) (mermaidNode, string, error) {
+	s = strings.TrimLeft(s, " \t")
+	node := mermaidNode{id: mermaidIDPattern.FindString(s)}
+	if node.id == "" {
+		return node, "", fmt.Errorf("expected a node at %q", s)
+	}
+	s = s[len(node.id):]
+
+	for _, shape := range mermaidShapes {
+		if !strings.HasPrefix(s, shape.open) {
+			continue
+		}
+		body := s[len(shape.open):]
+		start := 0
+		if strings.HasPrefix(body, `"`) {
+			// The close of a quoted label is after the quote
+			if end := strings.IndexByte(body[1:], '"'); end >= 0 {
+				start = end + 2
+			}
+		}
+		end, close := -1, ""
+		for _, c := range shape.closes {
+			if i := strings.Index(body[start:], c); i >= 0 && (end < 0 || start+i < end) {
+				end, close = start+i, c
+			}
+		}
+		if end < 0 {
+			return node, "", fmt.Errorf("node %s is missing a %s", node.id, shape.closes[0])
+		}
+		node.name = strings.Trim(strings.TrimSpace(body[:end]), "\"`")
+		node.geometry = shape.geometry
+		s = body[end+len(close):]
+		break
+	}
+
+	if rest, ok := strings.CutPrefix(s, ":::"); ok {
+		node.class = mermaidIDPattern.FindString(rest)
+		s = rest[len(node.class):]
+	}
+	return node, s, nil
+}
+
+// mermaidLink is a link between nodes.
+type mermaidLink struct {
+	flow      string
+	text      string
+	style     string
+	invisible bool
+}
+
+// link reads a link, and returns it with the rest of the statement.
+func (m *mermaidImport) link(s string) (mermaidLink, string, error) {
+	var link mermaidLink
+	var left, line, right string
+	if match := mermaidTextLinkPattern.FindStringSubmatch(s); match != nil {
+		left, line, link.text, right = match[1], match[2]+match[4], match[3], match[5]
+		s = s[len(match[0]):]
+	} else if match := mermaidLinkPattern.FindStringSubmatch(s); match != nil {
+		left, line, right, link.text = match[1], match[2], match[3], match[4]
+		s = s[len(match[0]):]
+	} else {
+		return link, "", fmt.Errorf("expected a link at %q", strings.TrimSpace(s))
+	}
+
+	link.text = strings.Trim(strings.TrimSpace(link.text), `"`)
+	switch {
+	case strings.HasPrefix(line, "~"):
+		link.invisible = true
+	case strings.Contains(line, "."):
+		link.style = "dotted"
+	case strings.HasPrefix(line, "="):
+		link.style = "thick"
+	}
+	switch {
+	case left != "" && right == "":
+		link.flow = "in"
+	case left == "" && right != "":
+		link.flow = "out"
+	default:
+		link.flow = "bi"
+	}
+	return link, s, nil
+}
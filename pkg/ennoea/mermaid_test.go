@@ -0,0 +1,79 @@
+package ennoea
+
+import "testing"
+
+func TestImportMermaid(t *testing.T) {
+	testImports(t, "mermaid", []importTest{
+		{
+			name: "flowchart with a subgraph",
+			data: "```mermaid\n---\ntitle: Shop\n---\nflowchart LR\n  web[Web] --> api(API)\n  subgraph backend [Backend]\n    api <--> db[(Database)]\n  end\n  style db fill:#f00\n```",
+			want: []string{
+				`component web "Web" box`,
+				`component api "API" box`,
+				`component db "Database" cylinder`,
+				`group backend api,db`,
+				`connection web->api out`,
+				`connection api->db bi`,
+			},
+		},
+		{
+			name: "shapes and links",
+			data: "graph TD\n  a((Circle)) -- calls --> b{Rhombus}; b --- c([Stadium])\n  c ~~~ d{{Hexagon}}\n  d -.-> e[/Trapezoid\\]\n  %% a comment\n  e ==>|x| a",
+			want: []string{
+				`component a "Circle" sphere`,
+				`component b "Rhombus" octahedron`,
+				`component c "Stadium" capsule`,
+				`component d "Hexagon" dodecahedron`,
+				`component e "Trapezoid" cone`,
+				`connection a->b out`,
+				`connection b->c bi`,
+				`connection d->e out`,
+				`connection e->a out`,
+			},
+		},
+		{
+			name: "classes",
+			data: "flowchart LR\n  a:::db --> b\n  classDef db fill:#00ff00,stroke:#333\n  class b missing\n  end",
+			want: []string{
+				`component a "a" box`,
+				`component b "b" box`,
+				`connection a->b out`,
+				"warning line 5: end without a subgraph",
+			},
+		},
+		{
+			name: "missing end",
+			data: "flowchart LR\n  subgraph one\n    a --> b\n",
+			want: []string{
+				`component a "a" box`,
+				`component b "b" box`,
+				`group one a,b`,
+				`connection a->b out`,
+				"warning subgraph one is missing an end",
+			},
+		},
+		{
+			name: "repeated links",
+			data: "graph TD; a --> b-2; a --> b; a --> b",
+			want: []string{
+				`component a "a" box`,
+				`component b-2 "b-2" box`,
+				`component b "b" box`,
+				`connection a->b-2 out`,
+				`connection a->b out`,
+				`connection a->b-3 out`,
+			},
+		},
+		{name: "sequence diagram", data: "sequenceDiagram\n  a->>b: hello", err: "line 1: expected a flowchart or graph, found sequenceDiagram"},
+		{name: "empty", data: "%% nothing\n", err: "expected a flowchart or graph"},
+		{name: "no nodes", data: "flowchart LR\n", err: "flowchart has no nodes"},
+	})
+}
+
+func FuzzImportMermaid(f *testing.F) {
+	f.Add("flowchart LR\n  web[Web] --> api(API)\n  subgraph backend [Backend]\n    api <--> db[(Database)]\n  end\n  style db fill:#f00")
+	f.Add("graph TD\n  a((A)) -- calls --> b{B}; b --- c([C]) & d\n  classDef x fill:red\n  class a,b x")
+	f.Fuzz(func(t *testing.T, data string) {
+		importMermaid([]byte(data), nil)
+	})
+}
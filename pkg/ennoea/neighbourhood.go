@@ -0,0 +1,255 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Directions that can be followed from a component.
+const (
+	// directionUpstream follows the connections against the flow of
+	// data, to the components that send data to the component.
+	directionUpstream = "upstream"
+
+	// directionDownstream follows the connections with the flow of
+	// data, to the components that the component sends data to.
+	directionDownstream = "downstream"
+
+	// directionBoth follows the connections in either direction.
+	directionBoth = "both"
+)
+
+const (
+	// defaultNeighbourhoodHops is the default number of connections
+	// that are followed from the seed components.
+	defaultNeighbourhoodHops = 1
+
+	// maxNeighbourhoodHops is the largest number of hops that can be
+	// requested.
+	maxNeighbourhoodHops = 32
+)
+
+// within returns the components that are at most hops connections
+// away from the start components in the direction, including the
+// start components.
+func (g *architectureGraph) within(start []int, hops int, direction string) map[int]bool {
+	seen := make(map[int]bool, len(start))
+	frontier := make([]int, 0, len(start))
+	for _, i := range start {
+		if !seen[i] {
+			seen[i] = true
+			frontier = append(frontier, i)
+		}
+	}
+
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		var next []int
+		for _, v := range frontier {
+			var neighbours []int
+			if direction == directionDownstream || direction == directionBoth {
+				neighbours = append(neighbours, g.successors(v)...)
+			}
+			if direction == directionUpstream || direction == directionBoth {
+				neighbours = append(neighbours, g.predecessors(v)...)
+			}
+			for _, w := range neighbours {
+				if !seen[w] {
+					seen[w] = true
+					next = append(next, w)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return seen
+}
+
+// NeighbourhoodOptions represents the options used to extract the
+// neighbourhood of components.
+type NeighbourhoodOptions struct {
+	// Seeds is a list of the IDs of the components at the centre of
+	// the neighbourhood.
+	Seeds []string `json:"seeds"`
+
+	// Hops is the number of connections that are followed from the
+	// seeds. It defaults to 1.
+	Hops int `json:"hops"`
+
+	// Direction is the direction the connections are followed in. It
+	// is either "upstream", "downstream" or "both", which is the
+	// default.
+	Direction string `json:"direction"`
+}
+
+// extractNeighbourhood returns a self-contained architecture with the
+// components within the hops of the seeds, the connections between
+// them, and the groups trimmed to the components that remain. Groups
+// without any remaining components are removed.
+func extractNeighbourhood(arch Architecture, options NeighbourhoodOptions) (Architecture, error) {
+	if options.Direction == "" {
+		options.Direction = directionBoth
+	}
+	if options.Direction != directionUpstream && options.Direction != directionDownstream && options.Direction != directionBoth {
+		return Architecture{}, fmt.Errorf("invalid direction: %s", options.Direction)
+	}
+	if options.Hops < 0 || options.Hops > maxNeighbourhoodHops {
+		return Architecture{}, fmt.Errorf("hops is not between 0 and %d", maxNeighbourhoodHops)
+	}
+	if len(options.Seeds) == 0 {
+		return Architecture{}, fmt.Errorf("missing seeds")
+	}
+
+	g := newArchitectureGraph(arch)
+	start := make([]int, 0, len(options.Seeds))
+	for _, id := range options.Seeds {
+		i, ok := g.index[id]
+		if !ok {
+			return Architecture{}, fmt.Errorf("unknown component: %s", id)
+		}
+		start = append(start, i)
+	}
+	ids := make(map[string]bool)
+	for i := range g.within(start, options.Hops, options.Direction) {
+		ids[g.id(i)] = true
+	}
+
+	derived := subArchitecture(arch, ids)
+	derived.Info.ID = arch.Info.ID + "-neighbourhood"
+	derived.Info.Name = arch.Info.Name + " (neighbourhood)"
+	along := options.Direction
+	if along == directionBoth {
+		along = "in either direction"
+	}
+	derived.Info.Description = fmt.Sprintf("Components of %s within %d hops %s of %s",
+		arch.Info.Name, options.Hops, along, strings.Join(options.Seeds, ", "))
+	derived.Info.Source = arch.Info.ID
+
+	return derived, nil
+}
+
+// handleGetNeighbourhood handles GET requests for the neighbourhood of
+// components.
+// GET /architectures/${architectureID}/neighbourhood?seeds=${componentID},${componentID}
+//
+//	return an architecture with the components within a number of hops
+//	of the seeds. The optional parameters are:
+//	  hops       the number of connections to follow (1)
+//	  direction  "upstream", "downstream" or "both" (both)
+func (h *ArchitectureHandler) handleGetNeighbourhood(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	hops, err := queryInt(query.Get("hops"), defaultNeighbourhoodHops, 0, maxNeighbourhoodHops)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid hops: %v", err)
+		return
+	}
+	var seeds []string
+	if query.Get("seeds") != "" {
+		seeds = strings.Split(query.Get("seeds"), ",")
+	}
+
+	derived, err := extractNeighbourhood(arch, NeighbourhoodOptions{
+		Seeds:     seeds,
+		Hops:      hops,
+		Direction: query.Get("direction"),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to extract neighbourhood: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, derived)
+}
+
+// neighbourhoodRequest represents the body of a request to save a
+// neighbourhood as a new architecture.
+type neighbourhoodRequest struct {
+	NeighbourhoodOptions
+
+	// ID is the ID of the new architecture. An ID is generated if it
+	// is empty.
+	ID string `json:"id"`
+
+	// Name is the name of the new architecture. It defaults to the
+	// name of the source architecture followed by "(neighbourhood)".
+	Name string `json:"name"`
+}
+
+// handlePostNeighbourhood handles POST requests to save the
+// neighbourhood of components as a new architecture.
+// POST /architectures/${architectureID}/neighbourhood
+//
+//	extract the neighbourhood and save it as a new architecture whose
+//	info links back to the source architecture. The request body
+//	contains the options:
+//	{
+//		"seeds": ["componentID"],
+//		"hops": 2,
+//		"direction": "upstream" | "downstream" | "both",
+//		"id": "newArchitectureID",
+//		"name": "newArchitectureName"
+//	}
+//	The new architecture is returned.
+func (h *ArchitectureHandler) handlePostNeighbourhood(w http.ResponseWriter, r *http.Request, architectureID string) {
+	req := neighbourhoodRequest{NeighbourhoodOptions: NeighbourhoodOptions{Hops: defaultNeighbourhoodHops}}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to decode neighbourhood: %v", err)
+		return
+	}
+
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	derived, err := extractNeighbourhood(arch, req.NeighbourhoodOptions)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to extract neighbourhood: %v", err)
+		return
+	}
+
+	// The new architecture must not replace an existing one
+	derived.Info.ID = req.ID
+	if derived.Info.ID == "" {
+		derived.Info.ID = generateID()
+	}
+	if err := isValidArchitectureID(derived.Info.ID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid neighbourhood: %v", err)
+		return
+	}
+	if req.Name != "" {
+		derived.Info.Name = req.Name
+	}
+	defer h.lockArchitecture(derived.Info.ID)()
+	if _, ok := h.architectureSave(derived.Info.ID); ok {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Architecture %s already exists", derived.Info.ID)
+		return
+	}
+
+	if _, ok := h.enforcePolicies(w, derived); !ok {
+		return
+	}
+
+	err = h.saveArchitecture(derived)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save architecture: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, derived)
+}
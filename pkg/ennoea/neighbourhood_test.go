@@ -0,0 +1,152 @@
+package ennoea
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// neighbourhoodTestArchitecture returns an architecture with the chain
+// a -> b -> c -> d, an unconnected e, and groups of a and e and of d.
+func neighbourhoodTestArchitecture() Architecture {
+	return Architecture{
+		Info:       Info{ID: "chain", Name: "Chain"},
+		Components: []Component{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}, {ID: "e"}},
+		Groups: []Group{
+			{ID: "ae", Components: []string{"a", "e"}},
+			{ID: "d", Components: []string{"d"}},
+		},
+		Connections: []Connection{
+			{ID: "a-b", Source: "a", Target: "b", Flow: "out"},
+			{ID: "b-c", Source: "b", Target: "c", Flow: "out"},
+			{ID: "d-c", Source: "d", Target: "c", Flow: "in"},
+		},
+	}
+}
+
+// summariseNeighbourhood returns the IDs of the components, the groups
+// with their members and the connections of the architecture.
+func summariseNeighbourhood(arch Architecture) []string {
+	var lines []string
+	for _, c := range arch.Components {
+		lines = append(lines, "component "+c.ID)
+	}
+	for _, g := range arch.Groups {
+		lines = append(lines, "group "+g.ID+" "+strings.Join(g.Components, ","))
+	}
+	for _, c := range arch.Connections {
+		lines = append(lines, "connection "+c.ID)
+	}
+	return lines
+}
+
+func TestExtractNeighbourhood(t *testing.T) {
+	tests := []struct {
+		name    string
+		options NeighbourhoodOptions
+		want    []string
+		err     string
+	}{
+		{
+			name:    "both directions",
+			options: NeighbourhoodOptions{Seeds: []string{"b"}, Hops: 1},
+			want:    []string{"component a", "component b", "component c", "group ae a", "connection a-b", "connection b-c"},
+		},
+		{
+			name:    "downstream",
+			options: NeighbourhoodOptions{Seeds: []string{"a"}, Hops: 2, Direction: directionDownstream},
+			want:    []string{"component a", "component b", "component c", "group ae a", "connection a-b", "connection b-c"},
+		},
+		{
+			// d-c flows from c to d
+			name:    "downstream against the order of the connection",
+			options: NeighbourhoodOptions{Seeds: []string{"c"}, Hops: 1, Direction: directionDownstream},
+			want:    []string{"component c", "component d", "group d d", "connection d-c"},
+		},
+		{
+			name:    "upstream",
+			options: NeighbourhoodOptions{Seeds: []string{"d"}, Hops: 32, Direction: directionUpstream},
+			want:    []string{"component a", "component b", "component c", "component d", "group ae a", "group d d", "connection a-b", "connection b-c", "connection d-c"},
+		},
+		{
+			name:    "several seeds without hops",
+			options: NeighbourhoodOptions{Seeds: []string{"e", "a", "e"}},
+			want:    []string{"component a", "component e", "group ae a,e"},
+		},
+		{name: "no seeds", options: NeighbourhoodOptions{Hops: 1}, err: "missing seeds"},
+		{name: "unknown seed", options: NeighbourhoodOptions{Seeds: []string{"x"}}, err: "unknown component: x"},
+		{name: "too many hops", options: NeighbourhoodOptions{Seeds: []string{"a"}, Hops: 33}, err: "hops is not between 0 and 32"},
+		{name: "invalid direction", options: NeighbourhoodOptions{Seeds: []string{"a"}, Direction: "sideways"}, err: "invalid direction: sideways"},
+	}
+	for _, test := range tests {
+		derived, err := extractNeighbourhood(neighbourhoodTestArchitecture(), test.options)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := summariseNeighbourhood(derived); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: neighbourhood = %v, want %v", test.name, got, test.want)
+		}
+		if derived.Info.ID != "chain-neighbourhood" || derived.Info.Source != "chain" {
+			t.Errorf("%s: info = %+v", test.name, derived.Info)
+		}
+	}
+}
+
+func TestGetNeighbourhood(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var derived Architecture
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/neighbourhood?seeds=web", "", http.StatusOK, &derived)
+	want := []string{"component web", "component api", "group backend api", "connection web-api"}
+	if got := summariseNeighbourhood(derived); !reflect.DeepEqual(got, want) {
+		t.Errorf("neighbourhood = %v, want %v", got, want)
+	}
+	if want := "Components of Shop within 1 hops in either direction of web"; derived.Info.Description != want {
+		t.Errorf("description = %q, want %q", derived.Info.Description, want)
+	}
+
+	for _, query := range []string{"", "?seeds=web&hops=x", "?seeds=web&hops=33", "?seeds=cache", "?seeds=web&direction=up"} {
+		serveTestJSON(t, h, http.MethodGet, "/architectures/shop/neighbourhood"+query, "", http.StatusBadRequest, nil)
+	}
+	serveTestJSON(t, h, http.MethodGet, "/architectures/none/neighbourhood?seeds=web", "", http.StatusNotFound, nil)
+}
+
+func TestPostNeighbourhood(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	body := `{"seeds": ["db"], "hops": 0, "id": "shop-db", "name": "Database"}`
+	var derived Architecture
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", body, http.StatusOK, &derived)
+	saved, err := h.loadArchitectureByID("shop-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summariseNeighbourhood(saved), []string{"component db", "group backend db"}) {
+		t.Errorf("saved neighbourhood = %v", summariseNeighbourhood(saved))
+	}
+	if saved.Info.Name != "Database" || saved.Info.Source != "shop" {
+		t.Errorf("saved info = %+v", saved.Info)
+	}
+
+	// Existing architectures are not replaced
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", body, http.StatusConflict, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", `{"seeds": ["db"], "id": "shop"}`, http.StatusConflict, nil)
+
+	// The default hops are used when they are missing
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", `{"seeds": ["db"]}`, http.StatusOK, &derived)
+	if got := summariseNeighbourhood(derived); len(got) != 4 || derived.Info.ID == "" {
+		t.Errorf("neighbourhood with the default hops = %v with ID %q", got, derived.Info.ID)
+	}
+
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", `{"seeds": []}`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", `{`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/neighbourhood", `{"seeds": ["db"], "id": "../db"}`, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,353 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+/*
+NetFlow v5 and IPFIX are binary export formats. A NetFlow v5 file is a
+sequence of export packets, each a header and up to 30 fixed records.
+An IPFIX file is a sequence of messages, as written by RFC 5655 file
+writers or captured from an exporter, whose data records are described
+by the templates sent before them. Only the information elements of
+the flows that are imported are decoded, and every other field is
+skipped.
+*/
+
+const (
+	// netflowV5HeaderSize is the size of the header of a NetFlow v5
+	// export packet.
+	netflowV5HeaderSize = 24
+
+	// netflowV5RecordSize is the size of a NetFlow v5 flow record.
+	netflowV5RecordSize = 48
+
+	// ipfixHeaderSize is the size of the header of an IPFIX message.
+	ipfixHeaderSize = 16
+
+	// ntpEpochOffset is the number of seconds from the NTP epoch in
+	// 1900 to the Unix epoch.
+	ntpEpochOffset = 2208988800
+)
+
+// IPFIX information elements that are imported.
+const (
+	ipfixOctetDeltaCount          = 1
+	ipfixPacketDeltaCount         = 2
+	ipfixProtocolIdentifier       = 4
+	ipfixSourceTransportPort      = 7
+	ipfixSourceIPv4Address        = 8
+	ipfixDestinationTransportPort = 11
+	ipfixDestinationIPv4Address   = 12
+	ipfixFlowEndSysUpTime         = 21
+	ipfixFlowStartSysUpTime       = 22
+	ipfixSourceIPv6Address        = 27
+	ipfixDestinationIPv6Address   = 28
+	ipfixOctetTotalCount          = 85
+	ipfixPacketTotalCount         = 86
+	ipfixFlowStartSeconds         = 150
+	ipfixFlowEndSeconds           = 151
+	ipfixFlowStartMilliseconds    = 152
+	ipfixFlowEndMilliseconds      = 153
+	ipfixFlowStartMicroseconds    = 154
+	ipfixFlowEndMicroseconds      = 155
+	ipfixFlowStartNanoseconds     = 156
+	ipfixFlowEndNanoseconds       = 157
+	ipfixSystemInitTimeMillis     = 160
+)
+
+// parseNetFlowV5 parses a sequence of NetFlow v5 export packets. The
+// counts of sampled exports are scaled up by the sampling interval.
+func parseNetFlowV5(data []byte) ([]flowRecord, error) {
+	var records []flowRecord
+	for offset := 0; offset < len(data); {
+		packet := data[offset:]
+		if len(packet) < netflowV5HeaderSize {
+			return nil, fmt.Errorf("truncated netflow v5 header at byte %d", offset)
+		}
+		if version := binary.BigEndian.Uint16(packet); version != 5 {
+			return nil, fmt.Errorf("unexpected netflow version %d at byte %d", version, offset)
+		}
+		count := int(binary.BigEndian.Uint16(packet[2:]))
+		size := netflowV5HeaderSize + count*netflowV5RecordSize
+		if len(packet) < size {
+			return nil, fmt.Errorf("truncated netflow v5 packet at byte %d", offset)
+		}
+
+		// The times of the flows are in milliseconds since the exporter
+		// booted
+		uptime := int64(binary.BigEndian.Uint32(packet[4:]))
+		now := int64(binary.BigEndian.Uint32(packet[8:]))*1000 + int64(binary.BigEndian.Uint32(packet[12:]))/1e6
+		boot := now - uptime
+		sampling := uint64(binary.BigEndian.Uint16(packet[22:]) & 0x3fff)
+		if sampling == 0 {
+			sampling = 1
+		}
+
+		for i := 0; i < count; i++ {
+			record := packet[netflowV5HeaderSize+i*netflowV5RecordSize:]
+			records = append(records, flowRecord{
+				src:      netip.AddrFrom4([4]byte(record[0:4])),
+				dst:      netip.AddrFrom4([4]byte(record[4:8])),
+				packets:  uint64(binary.BigEndian.Uint32(record[16:])) * sampling,
+				bytes:    uint64(binary.BigEndian.Uint32(record[20:])) * sampling,
+				start:    boot + int64(binary.BigEndian.Uint32(record[24:])),
+				end:      boot + int64(binary.BigEndian.Uint32(record[28:])),
+				srcPort:  binary.BigEndian.Uint16(record[32:]),
+				dstPort:  binary.BigEndian.Uint16(record[34:]),
+				protocol: record[38],
+			})
+		}
+		offset += size
+	}
+	return records, nil
+}
+
+// ipfixField is a field of an IPFIX template. The enterprise number is
+// zero for the standard information elements, and the length is 65535
+// for fields of variable length.
+type ipfixField struct {
+	element    uint16
+	enterprise uint32
+	length     uint16
+}
+
+// ipfixTemplate is an IPFIX template. The records of options templates
+// are skipped.
+type ipfixTemplate struct {
+	fields  []ipfixField
+	options bool
+}
+
+// ipfixKey identifies a template by its observation domain and ID.
+type ipfixKey struct {
+	domain   uint32
+	template uint16
+}
+
+// parseIPFIX parses a sequence of IPFIX messages. Data sets whose
+// template has not been seen are skipped with a warning.
+func parseIPFIX(data []byte, result *ImportResult) ([]flowRecord, error) {
+	var records []flowRecord
+	templates := make(map[ipfixKey]ipfixTemplate)
+	missing := make(map[ipfixKey]bool)
+	for offset := 0; offset < len(data); {
+		message := data[offset:]
+		if len(message) < ipfixHeaderSize {
+			return nil, fmt.Errorf("truncated ipfix header at byte %d", offset)
+		}
+		if version := binary.BigEndian.Uint16(message); version != 10 {
+			return nil, fmt.Errorf("unexpected ipfix version %d at byte %d", version, offset)
+		}
+		length := int(binary.BigEndian.Uint16(message[2:]))
+		if length < ipfixHeaderSize || length > len(message) {
+			return nil, fmt.Errorf("invalid ipfix message length %d at byte %d", length, offset)
+		}
+		exported := int64(binary.BigEndian.Uint32(message[4:])) * 1000
+		domain := binary.BigEndian.Uint32(message[12:])
+
+		sets := message[ipfixHeaderSize:length]
+		for len(sets) > 0 {
+			if len(sets) < 4 {
+				return nil, fmt.Errorf("truncated ipfix set at byte %d", offset)
+			}
+			id := binary.BigEndian.Uint16(sets)
+			setLength := int(binary.BigEndian.Uint16(sets[2:]))
+			if setLength < 4 || setLength > len(sets) {
+				return nil, fmt.Errorf("invalid ipfix set length %d at byte %d", setLength, offset)
+			}
+			body := sets[4:setLength]
+			sets = sets[setLength:]
+
+			switch {
+			case id == 2 || id == 3:
+				if err := readIPFIXTemplates(body, domain, id == 3, templates); err != nil {
+					return nil, fmt.Errorf("failed to read ipfix template at byte %d: %v", offset, err)
+				}
+			case id >= 256:
+				key := ipfixKey{domain: domain, template: id}
+				template, ok := templates[key]
+				if !ok {
+					if !missing[key] {
+						missing[key] = true
+						result.warnf("ipfix data set of unknown template %d in domain %d was skipped", id, domain)
+					}
+					continue
+				}
+				if template.options {
+					continue
+				}
+				read, err := readIPFIXRecords(body, template, exported)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read ipfix data at byte %d: %v", offset, err)
+				}
+				records = append(records, read...)
+			}
+		}
+		offset += length
+	}
+	return records, nil
+}
+
+// readIPFIXTemplates reads the template records of a template or
+// options template set.
+func readIPFIXTemplates(body []byte, domain uint32, options bool, templates map[ipfixKey]ipfixTemplate) error {
+	// The set may be padded with fewer bytes than a template header
+	for len(body) >= 4 {
+		id := binary.BigEndian.Uint16(body)
+		count := int(binary.BigEndian.Uint16(body[2:]))
+		body = body[4:]
+		if count == 0 {
+			// A template withdrawal
+			delete(templates, ipfixKey{domain: domain, template: id})
+			continue
+		}
+		if options {
+			// The scope field count is not needed to skip the records
+			if len(body) < 2 {
+				return fmt.Errorf("truncated options template %d", id)
+			}
+			body = body[2:]
+		}
+
+		template := ipfixTemplate{options: options}
+		for i := 0; i < count; i++ {
+			if len(body) < 4 {
+				return fmt.Errorf("truncated template %d", id)
+			}
+			f := ipfixField{
+				element: binary.BigEndian.Uint16(body),
+				length:  binary.BigEndian.Uint16(body[2:]),
+			}
+			body = body[4:]
+			if f.element&0x8000 != 0 {
+				if len(body) < 4 {
+					return fmt.Errorf("truncated template %d", id)
+				}
+				f.element &= 0x7fff
+				f.enterprise = binary.BigEndian.Uint32(body)
+				body = body[4:]
+			}
+			template.fields = append(template.fields, f)
+		}
+		templates[ipfixKey{domain: domain, template: id}] = template
+	}
+	return nil
+}
+
+// readIPFIXRecords reads the data records of a data set. Flows without
+// times are given the export time of their message.
+func readIPFIXRecords(body []byte, template ipfixTemplate, exported int64) ([]flowRecord, error) {
+	minimum := 0
+	for _, f := range template.fields {
+		if f.length == 65535 {
+			minimum++
+		} else {
+			minimum += int(f.length)
+		}
+	}
+	if minimum == 0 {
+		return nil, fmt.Errorf("template has no fields")
+	}
+
+	var records []flowRecord
+	// The set may be padded with fewer bytes than a record
+	for len(body) >= minimum {
+		var r flowRecord
+		var startUptime, endUptime, boot int64
+		for _, f := range template.fields {
+			length := int(f.length)
+			if f.length == 65535 {
+				if len(body) < 1 {
+					return nil, fmt.Errorf("truncated record")
+				}
+				length = int(body[0])
+				body = body[1:]
+				if length == 255 {
+					if len(body) < 2 {
+						return nil, fmt.Errorf("truncated record")
+					}
+					length = int(binary.BigEndian.Uint16(body))
+					body = body[2:]
+				}
+			}
+			if len(body) < length {
+				return nil, fmt.Errorf("truncated record")
+			}
+			value := body[:length]
+			body = body[length:]
+			if f.enterprise != 0 {
+				continue
+			}
+
+			switch f.element {
+			case ipfixOctetDeltaCount, ipfixOctetTotalCount:
+				r.bytes = ipfixUnsigned(value)
+			case ipfixPacketDeltaCount, ipfixPacketTotalCount:
+				r.packets = ipfixUnsigned(value)
+			case ipfixProtocolIdentifier:
+				r.protocol = uint8(ipfixUnsigned(value))
+			case ipfixSourceTransportPort:
+				r.srcPort = uint16(ipfixUnsigned(value))
+			case ipfixDestinationTransportPort:
+				r.dstPort = uint16(ipfixUnsigned(value))
+			case ipfixSourceIPv4Address, ipfixSourceIPv6Address:
+				r.src, _ = netip.AddrFromSlice(value)
+			case ipfixDestinationIPv4Address, ipfixDestinationIPv6Address:
+				r.dst, _ = netip.AddrFromSlice(value)
+			case ipfixFlowStartSeconds:
+				r.start = int64(ipfixUnsigned(value)) * 1000
+			case ipfixFlowEndSeconds:
+				r.end = int64(ipfixUnsigned(value)) * 1000
+			case ipfixFlowStartMilliseconds:
+				r.start = int64(ipfixUnsigned(value))
+			case ipfixFlowEndMilliseconds:
+				r.end = int64(ipfixUnsigned(value))
+			case ipfixFlowStartMicroseconds, ipfixFlowStartNanoseconds:
+				r.start = ntpMilliseconds(ipfixUnsigned(value))
+			case ipfixFlowEndMicroseconds, ipfixFlowEndNanoseconds:
+				r.end = ntpMilliseconds(ipfixUnsigned(value))
+			case ipfixFlowStartSysUpTime:
+				startUptime = int64(ipfixUnsigned(value))
+			case ipfixFlowEndSysUpTime:
+				endUptime = int64(ipfixUnsigned(value))
+			case ipfixSystemInitTimeMillis:
+				boot = int64(ipfixUnsigned(value))
+			}
+		}
+
+		if r.start == 0 && boot != 0 {
+			r.start, r.end = boot+startUptime, boot+endUptime
+		}
+		if r.start == 0 {
+			r.start = exported
+		}
+		if r.end == 0 {
+			r.end = r.start
+		}
+		if r.src.IsValid() && r.dst.IsValid() {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// ipfixUnsigned decodes an unsigned integer, which may be sent with
+// fewer bytes than its type.
+func ipfixUnsigned(value []byte) uint64 {
+	var n uint64
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// ntpMilliseconds converts a 64 bit NTP timestamp to milliseconds since
+// the Unix epoch.
+func ntpMilliseconds(ntp uint64) int64 {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	fraction := int64((ntp & 0xffffffff) * 1000 >> 32)
+	return seconds*1000 + fraction
+}
@@ -0,0 +1,195 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testFlows is the records of a TCP conversation from 10.0.0.1 to
+// 10.0.0.2 over ten seconds, one for each direction. The times are in
+// milliseconds since the epoch.
+var testFlows = []flowRecord{
+	{
+		src: netip.MustParseAddr("10.0.0.1"), dst: netip.MustParseAddr("10.0.0.2"),
+		srcPort: 50000, dstPort: 443, protocol: 6,
+		packets: 10, bytes: 1000, start: 1700000000000, end: 1700000010000,
+	},
+	{
+		src: netip.MustParseAddr("10.0.0.2"), dst: netip.MustParseAddr("10.0.0.1"),
+		srcPort: 443, dstPort: 50000, protocol: 6,
+		packets: 8, bytes: 5000, start: 1700000000000, end: 1700000010000,
+	},
+}
+
+// testNetFlowV5 returns a NetFlow v5 export packet of the records,
+// exported when the last flow ended by an exporter that booted an hour
+// before the first one started.
+func testNetFlowV5(sampling uint16, records ...flowRecord) []byte {
+	const boot = 1700000000000 - 3600000
+	data := make([]byte, netflowV5HeaderSize+len(records)*netflowV5RecordSize)
+	binary.BigEndian.PutUint16(data, 5)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(records)))
+	binary.BigEndian.PutUint32(data[4:], uint32(1700000010000-boot))
+	binary.BigEndian.PutUint32(data[8:], 1700000010)
+	binary.BigEndian.PutUint16(data[22:], sampling)
+	for i, r := range records {
+		record := data[netflowV5HeaderSize+i*netflowV5RecordSize:]
+		src, dst := r.src.As4(), r.dst.As4()
+		copy(record, src[:])
+		copy(record[4:], dst[:])
+		binary.BigEndian.PutUint32(record[16:], uint32(r.packets))
+		binary.BigEndian.PutUint32(record[20:], uint32(r.bytes))
+		binary.BigEndian.PutUint32(record[24:], uint32(r.start-boot))
+		binary.BigEndian.PutUint32(record[28:], uint32(r.end-boot))
+		binary.BigEndian.PutUint16(record[32:], r.srcPort)
+		binary.BigEndian.PutUint16(record[34:], r.dstPort)
+		record[38] = r.protocol
+	}
+	return data
+}
+
+// testIPFIX returns an IPFIX message with a template and a data set of
+// the records. The template has a variable length enterprise field,
+// which is skipped, before the fields of the records.
+func testIPFIX(records ...flowRecord) []byte {
+	fields := []uint16{
+		0x8000 | 100, 65535,
+		ipfixSourceIPv4Address, 4,
+		ipfixDestinationIPv4Address, 4,
+		ipfixSourceTransportPort, 2,
+		ipfixDestinationTransportPort, 2,
+		ipfixProtocolIdentifier, 1,
+		ipfixOctetDeltaCount, 8,
+		ipfixPacketDeltaCount, 4,
+		ipfixFlowStartMilliseconds, 8,
+		ipfixFlowEndMilliseconds, 8,
+	}
+	template := binary.BigEndian.AppendUint16(nil, 256)
+	template = binary.BigEndian.AppendUint16(template, uint16(len(fields)/2))
+	for i, f := range fields {
+		template = binary.BigEndian.AppendUint16(template, f)
+		if i == 1 {
+			template = binary.BigEndian.AppendUint32(template, 9)
+		}
+	}
+
+	var body []byte
+	for _, r := range records {
+		body = append(body, 3, 'a', 'b', 'c')
+		src, dst := r.src.As4(), r.dst.As4()
+		body = append(append(body, src[:]...), dst[:]...)
+		body = binary.BigEndian.AppendUint16(body, r.srcPort)
+		body = binary.BigEndian.AppendUint16(body, r.dstPort)
+		body = append(body, r.protocol)
+		body = binary.BigEndian.AppendUint64(body, r.bytes)
+		body = binary.BigEndian.AppendUint32(body, uint32(r.packets))
+		body = binary.BigEndian.AppendUint64(body, uint64(r.start))
+		body = binary.BigEndian.AppendUint64(body, uint64(r.end))
+	}
+
+	set := func(id uint16, body []byte) []byte {
+		s := binary.BigEndian.AppendUint16(nil, id)
+		s = binary.BigEndian.AppendUint16(s, uint16(4+len(body)))
+		return append(s, body...)
+	}
+	sets := append(set(2, template), set(256, body)...)
+	message := make([]byte, ipfixHeaderSize, ipfixHeaderSize+len(sets))
+	binary.BigEndian.PutUint16(message, 10)
+	binary.BigEndian.PutUint16(message[2:], uint16(ipfixHeaderSize+len(sets)))
+	binary.BigEndian.PutUint32(message[4:], 1700000010)
+	return append(message, sets...)
+}
+
+func TestParseNetFlowV5(t *testing.T) {
+	records, err := parseNetFlowV5(append(testNetFlowV5(0, testFlows[0]), testNetFlowV5(0, testFlows[1])...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, testFlows) {
+		t.Errorf("records = %+v, want %+v", records, testFlows)
+	}
+
+	// Sampled counts are scaled up by the interval
+	records, err = parseNetFlowV5(testNetFlowV5(10, testFlows[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0].bytes != 10000 || records[0].packets != 100 {
+		t.Errorf("sampled bytes = %d, packets = %d, want 10000 and 100", records[0].bytes, records[0].packets)
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated header", testNetFlowV5(0)[:10], "truncated netflow v5 header"},
+		{"truncated record", testNetFlowV5(0, testFlows...)[:100], "truncated netflow v5 packet"},
+		{"netflow v9", append([]byte{0, 9}, testNetFlowV5(0)[2:]...), "unexpected netflow version 9"},
+	} {
+		if _, err := parseNetFlowV5(test.data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestParseIPFIX(t *testing.T) {
+	var result ImportResult
+	records, err := parseIPFIX(testIPFIX(testFlows...), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, testFlows) {
+		t.Errorf("records = %+v, want %+v", records, testFlows)
+	}
+
+	// The data sets of a template that has not been seen are skipped
+	message := testIPFIX(testFlows...)
+	binary.BigEndian.PutUint16(message[ipfixHeaderSize+4:], 257)
+	records, err = parseIPFIX(message, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || len(result.Warnings) != 1 {
+		t.Errorf("records = %+v, warnings = %v, want the data set skipped with a warning", records, result.Warnings)
+	}
+
+	// The variable length field of the first record is longer than the
+	// data set
+	truncated := testIPFIX(testFlows[0])
+	truncated[len(testIPFIX())] = 200
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated header", testIPFIX()[:10], "truncated ipfix header"},
+		{"message length", testIPFIX(testFlows...)[:60], "invalid ipfix message length"},
+		{"truncated record", truncated, "truncated record"},
+		{"netflow v5", testNetFlowV5(0, testFlows...), "unexpected ipfix version 5"},
+	} {
+		if _, err := parseIPFIX(test.data, &result); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func FuzzParseNetFlowV5(f *testing.F) {
+	f.Add(testNetFlowV5(0, testFlows...))
+	f.Add(testNetFlowV5(10, testFlows[0]))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseNetFlowV5(data)
+	})
+}
+
+func FuzzParseIPFIX(f *testing.F) {
+	f.Add(testIPFIX(testFlows...))
+	f.Add(append(testIPFIX(testFlows[0]), testIPFIX(testFlows[1])...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseIPFIX(data, &ImportResult{})
+	})
+}
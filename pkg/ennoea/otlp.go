@@ -0,0 +1,749 @@
+package ennoea
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+The otlp importer reads OpenTelemetry traces exported as OTLP/JSON,
+either one export request or a file of them, such as the output of the
+file exporter of the collector. Each service becomes a component, and
+services with a service.namespace are grouped by it.
+
+Connections are found by pairing the spans of different services: a
+server or consumer span whose parent is a span of another service is a
+call from that service. Client and producer spans without a paired
+span are calls to services that are not traced, which are named by
+the peer.service, db.system or server.address attributes of the span.
+Requests and responses flow both ways, and messages only flow out.
+
+The rates of a connection are the request and response payload sizes
+summed over the time covered by the traces, and its latency is the
+median duration of the calls:
+
+	labels.requests     the number of calls
+	labels.errors       the number of calls with an error status
+	labels.requestRate  the number of calls per second
+	labels.latencyP50   the percentiles of the call durations in
+	labels.latencyP95   milliseconds
+	labels.latencyP99
+
+An import can also update an existing architecture instead of
+replacing it. The components are matched to the services by their ID,
+their "service" label or their name, and only the rates, sizes,
+latency and labels of the connections between them are updated.
+
+Calls whose spans have no start time, no end time or end before they
+start are skipped with a warning, instead of giving negative latencies.
+*/
+
+// OTLP span kinds.
+const (
+	spanKindUnspecified = iota
+	spanKindInternal
+	spanKindServer
+	spanKindClient
+	spanKindProducer
+	spanKindConsumer
+)
+
+// otlpStatusError is the status code of a span that failed.
+const otlpStatusError = 2
+
+// otlpTraces represents an OTLP/JSON export request.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpResourceSpans represents the spans of a resource. Older
+// exporters call the scope spans instrumentation library spans.
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans                  []otlpScopeSpans `json:"scopeSpans"`
+	InstrumentationLibrarySpans []otlpScopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+// otlpScopeSpans represents the spans of an instrumentation scope.
+type otlpScopeSpans struct {
+	Spans []otlpSpan `json:"spans"`
+}
+
+// otlpSpan represents a span.
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId"`
+	Name              string         `json:"name"`
+	Kind              otlpEnum       `json:"kind"`
+	StartTimeUnixNano otlpInt        `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpInt        `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            struct {
+		Code otlpEnum `json:"code"`
+	} `json:"status"`
+}
+
+// otlpKeyValue represents an attribute.
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue represents the value of an attribute.
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue"`
+	BoolValue   *bool    `json:"boolValue"`
+	IntValue    *otlpInt `json:"intValue"`
+	DoubleValue *float64 `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpAnyValue `json:"values"`
+	} `json:"arrayValue"`
+}
+
+// String returns the value as a string. Arrays are joined with
+// commas.
+func (v otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64)
+	case v.ArrayValue != nil:
+		var values []string
+		for _, value := range v.ArrayValue.Values {
+			values = append(values, value.String())
+		}
+		return strings.Join(values, ",")
+	default:
+		return ""
+	}
+}
+
+// otlpInt is a 64 bit integer, which OTLP/JSON writes as a string.
+type otlpInt int64
+
+// UnmarshalJSON unmarshals the integer from a number or a string.
+func (i *otlpInt) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		return nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer: %s", data)
+	}
+	*i = otlpInt(value)
+	return nil
+}
+
+// otlpEnum is an enum such as a span kind, which can be written as a
+// number or as the name of the value.
+type otlpEnum int
+
+// otlpEnumNames maps the names of the enum values to their numbers.
+var otlpEnumNames = map[string]int{
+	"SPAN_KIND_UNSPECIFIED": spanKindUnspecified,
+	"SPAN_KIND_INTERNAL":    spanKindInternal,
+	"SPAN_KIND_SERVER":      spanKindServer,
+	"SPAN_KIND_CLIENT":      spanKindClient,
+	"SPAN_KIND_PRODUCER":    spanKindProducer,
+	"SPAN_KIND_CONSUMER":    spanKindConsumer,
+	"STATUS_CODE_UNSET":     0,
+	"STATUS_CODE_OK":        1,
+	"STATUS_CODE_ERROR":     otlpStatusError,
+}
+
+// UnmarshalJSON unmarshals the enum from a number or a name.
+func (e *otlpEnum) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		value, ok := otlpEnumNames[name]
+		if !ok {
+			return fmt.Errorf("unknown enum value: %s", name)
+		}
+		*e = otlpEnum(value)
+		return nil
+	}
+	var value int
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid enum value: %s", data)
+	}
+	*e = otlpEnum(value)
+	return nil
+}
+
+// traceSpan is a span with the service that recorded it.
+type traceSpan struct {
+	traceID, spanID, parentID string
+	service                   string
+	kind                      int
+	start, end                int64
+	failed                    bool
+	attributes                map[string]string
+}
+
+// duration returns the duration of the span in milliseconds.
+func (s traceSpan) duration() float64 {
+	return float64(s.end-s.start) / 1e6
+}
+
+// timed returns true if the span has a start and an end time, and does
+// not end before it starts.
+func (s traceSpan) timed() bool {
+	return s.start > 0 && s.end >= s.start
+}
+
+// timedSpan returns the first of the spans that is timed, or nil if
+// none of them is.
+func timedSpan(spans ...*traceSpan) *traceSpan {
+	for _, s := range spans {
+		if s != nil && s.timed() {
+			return s
+		}
+	}
+	return nil
+}
+
+// traceService is the resource of a service.
+type traceService struct {
+	name       string
+	attributes map[string]string
+}
+
+// parseOTLP parses the spans of one or more OTLP/JSON export requests
+// and returns them with the services that recorded them.
+func parseOTLP(data []byte) ([]traceSpan, map[string]traceService, error) {
+	var spans []traceSpan
+	services := make(map[string]traceService)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var traces otlpTraces
+		err := decoder.Decode(&traces)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse otlp json: %v", err)
+		}
+		spans = append(spans, traces.spans(services)...)
+	}
+	return spans, services, nil
+}
+
+// spans returns the spans of the export request, and adds their
+// services to the map.
+func (t otlpTraces) spans(services map[string]traceService) []traceSpan {
+	var spans []traceSpan
+	for _, rs := range t.ResourceSpans {
+		resource := otlpAttributes(rs.Resource.Attributes)
+		name := resource["service.name"]
+		if name == "" {
+			name = "unknown_service"
+		}
+		if _, ok := services[name]; !ok {
+			services[name] = traceService{name: name, attributes: resource}
+		}
+		for _, scope := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+			for _, span := range scope.Spans {
+				spans = append(spans, traceSpan{
+					traceID:    span.TraceID,
+					spanID:     span.SpanID,
+					parentID:   span.ParentSpanID,
+					service:    name,
+					kind:       int(span.Kind),
+					start:      int64(span.StartTimeUnixNano),
+					end:        int64(span.EndTimeUnixNano),
+					failed:     int(span.Status.Code) == otlpStatusError,
+					attributes: otlpAttributes(span.Attributes),
+				})
+			}
+		}
+	}
+	return spans
+}
+
+// otlpAttributes returns the attributes as a map of strings.
+func otlpAttributes(attributes []otlpKeyValue) map[string]string {
+	m := make(map[string]string, len(attributes))
+	for _, a := range attributes {
+		m[a.Key] = a.Value.String()
+	}
+	return m
+}
+
+// The attributes that hold the sizes of the request and response
+// payloads, in order of preference. Older semantic conventions are
+// still supported.
+var (
+	traceRequestSizeKeys = []string{
+		"http.request.body.size",
+		"http.request_content_length",
+		"messaging.message.body.size",
+		"messaging.message_payload_size_bytes",
+		"rpc.request.size",
+	}
+	traceResponseSizeKeys = []string{
+		"http.response.body.size",
+		"http.response_content_length",
+		"rpc.response.size",
+	}
+)
+
+// traceEdge accumulates the calls from one service to another.
+type traceEdge struct {
+	source, target string
+
+	// messaging is true if the calls are messages, which only flow
+	// from the source to the target.
+	messaging bool
+
+	// peer is the attributes of a client span if the target is not
+	// traced, and nil otherwise.
+	peer map[string]string
+
+	requests, errors            int
+	requestBytes, responseBytes int
+	requestSizes, responseSizes int
+	durations                   []float64
+}
+
+// add adds a call to the edge. The spans are the client and server
+// sides of the call, either of which may be missing, and at least one
+// of them must be timed.
+func (e *traceEdge) add(client, server *traceSpan) {
+	e.requests++
+	e.durations = append(e.durations, timedSpan(client, server).duration())
+	if (client != nil && client.failed) || (server != nil && server.failed) {
+		e.errors++
+	}
+	if size, ok := traceSize(traceRequestSizeKeys, client, server); ok {
+		e.requestBytes += size
+		e.requestSizes++
+	}
+	if size, ok := traceSize(traceResponseSizeKeys, client, server); ok {
+		e.responseBytes += size
+		e.responseSizes++
+	}
+}
+
+// traceSize returns the first of the size attributes that is set on
+// either span.
+func traceSize(keys []string, spans ...*traceSpan) (int, bool) {
+	for _, key := range keys {
+		for _, span := range spans {
+			if span == nil {
+				continue
+			}
+			if size, err := strconv.Atoi(span.attributes[key]); err == nil && size >= 0 {
+				return size, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// apply sets the rates, sizes, latency and labels of the connection
+// from the calls, averaged over the window in seconds. The flow of the
+// connection is left as it is.
+func (e *traceEdge) apply(c *Connection, window float64) {
+	c.OutRate = Rate(math.Round(float64(e.requestBytes) / window))
+	c.InRate = Rate(math.Round(float64(e.responseBytes) / window))
+	c.OutPacketSize, c.InPacketSize = 0, 0
+	if e.requestSizes > 0 {
+		c.OutPacketSize = Size(e.requestBytes / e.requestSizes)
+	}
+	if e.responseSizes > 0 {
+		c.InPacketSize = Size(e.responseBytes / e.responseSizes)
+	}
+
+	sort.Float64s(e.durations)
+	c.Latency = math.Round(percentile(e.durations, 50)*100) / 100
+	if c.Labels == nil {
+		c.Labels = make(map[string]string)
+	}
+	c.Labels["requests"] = strconv.Itoa(e.requests)
+	c.Labels["errors"] = strconv.Itoa(e.errors)
+	c.Labels["requestRate"] = strconv.FormatFloat(float64(e.requests)/window, 'f', 2, 64)
+	for _, p := range []int{50, 95, 99} {
+		c.Labels["latencyP"+strconv.Itoa(p)] = strconv.FormatFloat(percentile(e.durations, float64(p)), 'f', 2, 64)
+	}
+}
+
+// percentile returns the nearest rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// traceEdges pairs the spans of different services and returns the
+// edges between the services in order. The window is the time covered
+// by the timed spans in seconds, which is at least a second. Calls
+// without a timed span are skipped and counted.
+func traceEdges(spans []traceSpan) ([]*traceEdge, float64, int) {
+	type spanKey struct{ traceID, spanID string }
+	index := make(map[spanKey]*traceSpan, len(spans))
+	var first, last int64
+	for i := range spans {
+		s := &spans[i]
+		index[spanKey{s.traceID, s.spanID}] = s
+		if !s.timed() {
+			continue
+		}
+		if first == 0 || s.start < first {
+			first = s.start
+		}
+		if s.end > last {
+			last = s.end
+		}
+	}
+
+	edges := make(map[[2]string]*traceEdge)
+	var order []*traceEdge
+	edge := func(source, target string, messaging bool) *traceEdge {
+		key := [2]string{source, target}
+		e, ok := edges[key]
+		if !ok {
+			e = &traceEdge{source: source, target: target, messaging: messaging}
+			edges[key] = e
+			order = append(order, e)
+		}
+		return e
+	}
+
+	// Server and consumer spans are paired with their parents in other
+	// services
+	skipped := 0
+	paired := make(map[*traceSpan]bool)
+	for i := range spans {
+		s := &spans[i]
+		if s.kind != spanKindServer && s.kind != spanKindConsumer {
+			continue
+		}
+		parent, ok := index[spanKey{s.traceID, s.parentID}]
+		if !ok || parent.service == s.service {
+			continue
+		}
+		paired[parent] = true
+		var client *traceSpan
+		if parent.kind == spanKindClient || parent.kind == spanKindProducer {
+			client = parent
+		}
+		if timedSpan(client, s) == nil {
+			skipped++
+			continue
+		}
+		edge(parent.service, s.service, s.kind == spanKindConsumer).add(client, s)
+	}
+
+	// Client and producer spans without a pair call services that are
+	// not traced
+	for i := range spans {
+		s := &spans[i]
+		if (s.kind != spanKindClient && s.kind != spanKindProducer) || paired[s] {
+			continue
+		}
+		peer := tracePeer(s.attributes)
+		if peer == "" || peer == s.service {
+			continue
+		}
+		if !s.timed() {
+			skipped++
+			continue
+		}
+		e := edge(s.service, peer, s.kind == spanKindProducer)
+		if e.peer == nil {
+			e.peer = s.attributes
+		}
+		e.add(s, nil)
+	}
+
+	window := math.Max(1, float64(last-first)/1e9)
+	return order, window, skipped
+}
+
+// tracePeer returns the name of the service that a client span calls,
+// or an empty string if it is not known.
+func tracePeer(attributes map[string]string) string {
+	for _, key := range []string{
+		"peer.service",
+		"db.system",
+		"messaging.destination.name",
+		"messaging.destination",
+		"server.address",
+		"net.peer.name",
+	} {
+		if value := attributes[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// importOTLP imports OTLP/JSON traces.
+func importOTLP(data, config []byte) (ImportResult, error) {
+	spans, services, err := parseOTLP(data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if len(spans) == 0 {
+		return ImportResult{}, fmt.Errorf("no spans")
+	}
+	return traceArchitecture(spans, services), nil
+}
+
+// traceArchitecture returns the architecture of the services and the
+// calls between them.
+func traceArchitecture(spans []traceSpan, services map[string]traceService) ImportResult {
+	edges, window, skipped := traceEdges(spans)
+
+	var result ImportResult
+	result.warnSkippedCalls(skipped)
+	arch := &result.Architecture
+	arch.Info.Name = "traces"
+	arch.Info.Description = fmt.Sprintf("Imported from %d spans over %.0f seconds", len(spans), window)
+
+	// Each service becomes a component, and the services that are not
+	// traced become components as well
+	namespaces := make(map[string][]string)
+	for _, name := range sortedKeys(services) {
+		arch.Components = append(arch.Components, newTraceComponent(name, services[name].attributes, nil))
+		if namespace := services[name].attributes["service.namespace"]; namespace != "" {
+			namespaces[namespace] = append(namespaces[namespace], name)
+		}
+	}
+	added := make(map[string]bool)
+	for _, e := range edges {
+		if _, ok := services[e.target]; ok || e.peer == nil || added[e.target] {
+			continue
+		}
+		added[e.target] = true
+		arch.Components = append(arch.Components, newTraceComponent(e.target, nil, e.peer))
+	}
+
+	for _, namespace := range sortedKeys(namespaces) {
+		g := newImportedGroup(namespace, namespace, len(arch.Groups))
+		g.Components = namespaces[namespace]
+		arch.Groups = append(arch.Groups, g)
+	}
+
+	// Each pair of services that call each other becomes a connection
+	for _, e := range edges {
+		c := newTraceConnection(e.source+":"+e.target, e)
+		e.apply(&c, window)
+		arch.Connections = append(arch.Connections, c)
+	}
+
+	return result
+}
+
+// newTraceComponent returns the component of a service, labelled from
+// the attributes of its resource. A service that is not traced is
+// described by the attributes of the client span that called it
+// instead.
+func newTraceComponent(name string, resource, peer map[string]string) Component {
+	c := newImportedComponent(name, name, "app")
+	for key, label := range map[string]string{
+		"service.namespace":      "namespace",
+		"service.version":        "version",
+		"deployment.environment": "environment",
+		"telemetry.sdk.language": "language",
+	} {
+		if value := resource[key]; value != "" {
+			c.Labels[label] = value
+		}
+	}
+	if peer != nil {
+		if system := peer["db.system"]; system != "" {
+			c.Type = "server"
+			c.Object.Geometry = "cylinder"
+			c.Labels["system"] = system
+		} else if system := peer["messaging.system"]; system != "" {
+			c.Object.Geometry = "torus"
+			c.Labels["system"] = system
+		}
+		c.Labels["traced"] = "false"
+	}
+	return c
+}
+
+// newTraceConnection returns the connection of an edge.
+func newTraceConnection(id string, e *traceEdge) Connection {
+	if e.messaging {
+		return newImportedConnection(id, e.source+" sends to "+e.target, e.source, e.target)
+	}
+	c := newImportedConnection(id, e.source+" calls "+e.target, e.source, e.target)
+	c.Flow = "bi"
+	return c
+}
+
+// warnSkippedCalls adds a warning to the result if calls without a
+// timed span were skipped.
+func (r *ImportResult) warnSkippedCalls(skipped int) {
+	if skipped > 0 {
+		r.warnf("%d calls were skipped because their spans have no valid start and end time", skipped)
+	}
+}
+
+// updateOTLP updates the connections of the architecture with the
+// rates of OTLP/JSON traces.
+func updateOTLP(previous Architecture, data, config []byte) (ImportResult, error) {
+	spans, _, err := parseOTLP(data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if len(spans) == 0 {
+		return ImportResult{}, fmt.Errorf("no spans")
+	}
+	return updateTraceRates(previous, spans, nil), nil
+}
+
+// updateTraceRates returns a copy of the architecture with the rates
+// of the calls between the services set on its connections. When
+// services is nil, the calls between services that are not in the
+// architecture, or between components that are not connected, are
+// reported as warnings. Otherwise the missing services and connections
+// are added and labelled as unconfirmed, and the connections that had
+// rates from earlier traces but no calls in these ones are idle.
+func updateTraceRates(previous Architecture, spans []traceSpan, services map[string]traceService) ImportResult {
+	edges, window, skipped := traceEdges(spans)
+
+	result := ImportResult{Architecture: previous}
+	result.warnSkippedCalls(skipped)
+	arch := &result.Architecture
+	arch.Components = append([]Component(nil), previous.Components...)
+	arch.Connections = make([]Connection, len(previous.Connections))
+	connectionIDs := make(map[string]bool, len(previous.Connections))
+	for i, c := range previous.Connections {
+		c.Labels = copyLabels(c.Labels)
+		arch.Connections[i] = c
+		connectionIDs[c.ID] = true
+	}
+
+	components := traceServiceComponents(*arch)
+	missing := make(map[string]bool)
+	observed := make(map[string]bool)
+	for _, e := range edges {
+		for _, service := range []string{e.source, e.target} {
+			if _, ok := components[service]; ok {
+				continue
+			}
+			if services == nil {
+				if !missing[service] {
+					missing[service] = true
+					result.warnf("service %s is not in the architecture", service)
+				}
+				continue
+			}
+			var peer map[string]string
+			if _, traced := services[service]; !traced {
+				peer = e.peer
+			}
+			c := newTraceComponent(service, services[service].attributes, peer)
+			c.Labels["confirmed"] = "false"
+			arch.Components = append(arch.Components, c)
+			components[service] = c.ID
+		}
+		source, sourceOK := components[e.source]
+		target, targetOK := components[e.target]
+		if !sourceOK || !targetOK {
+			continue
+		}
+
+		c := findConnection(arch.Connections, source, target)
+		if c == nil && services == nil {
+			result.warnf("%s calls %s, but there is no connection from %s to %s", e.source, e.target, source, target)
+			continue
+		}
+		if c == nil {
+			id := source + ":" + target
+			for connectionIDs[id] {
+				id += "_"
+			}
+			connectionIDs[id] = true
+			connection := newTraceConnection(id, e)
+			connection.Source, connection.Target = source, target
+			connection.Labels["confirmed"] = "false"
+			arch.Connections = append(arch.Connections, connection)
+			c = &arch.Connections[len(arch.Connections)-1]
+		}
+		e.apply(c, window)
+		observed[c.ID] = true
+	}
+
+	if services != nil {
+		for i := range arch.Connections {
+			c := &arch.Connections[i]
+			if observed[c.ID] || c.Labels["requestRate"] == "" {
+				continue
+			}
+			c.OutRate, c.InRate = 0, 0
+			c.Labels["requests"] = "0"
+			c.Labels["errors"] = "0"
+			c.Labels["requestRate"] = "0.00"
+		}
+	}
+
+	return result
+}
+
+// traceServiceComponents maps the names of services to the IDs of the
+// components of the architecture. A service matches a component by its
+// ID first, then by its "service" label, and then by its name.
+func traceServiceComponents(arch Architecture) map[string]string {
+	services := make(map[string]string, len(arch.Components))
+	for _, key := range []func(Component) string{
+		func(c Component) string { return c.ID },
+		func(c Component) string { return c.Labels["service"] },
+		func(c Component) string { return c.Name },
+	} {
+		for _, c := range arch.Components {
+			name := key(c)
+			if _, ok := services[name]; !ok && name != "" {
+				services[name] = c.ID
+			}
+		}
+	}
+	return services
+}
+
+// findConnection returns the first connection from the source to the
+// target, or nil if there is none.
+func findConnection(connections []Connection, source, target string) *Connection {
+	for i := range connections {
+		if connections[i].Source == source && connections[i].Target == target {
+			return &connections[i]
+		}
+	}
+	return nil
+}
+
+// copyLabels returns a copy of the labels, or nil if there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,99 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// otlpTestTraces returns an OTLP/JSON export with one call from web to
+// api, with the start and end times of the client and server spans in
+// nanoseconds. A zero time is left out.
+func otlpTestTraces(clientStart, clientEnd, serverStart, serverEnd int64) []byte {
+	times := func(start, end int64) string {
+		var fields []string
+		if start != 0 {
+			fields = append(fields, fmt.Sprintf(`"startTimeUnixNano": "%d"`, start))
+		}
+		if end != 0 {
+			fields = append(fields, fmt.Sprintf(`"endTimeUnixNano": "%d"`, end))
+		}
+		return strings.Join(append(fields, `"traceId": "01"`), ", ")
+	}
+	return []byte(`{"resourceSpans": [
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "web"}}]},
+	 "scopeSpans": [{"spans": [{"spanId": "a", "kind": 3, ` + times(clientStart, clientEnd) + `}]}]},
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+	 "scopeSpans": [{"spans": [{"spanId": "b", "parentSpanId": "a", "kind": 2, ` + times(serverStart, serverEnd) + `}]}]}
+]}`)
+}
+
+func TestImportOTLPSpanTimes(t *testing.T) {
+	const start = 1700000000000000000
+	tests := []struct {
+		name    string
+		traces  []byte
+		latency float64
+		skipped bool
+	}{
+		{name: "client", traces: otlpTestTraces(start, start+20e6, start+1e6, start+19e6), latency: 20},
+		{name: "client ends before it starts", traces: otlpTestTraces(start, start-20e6, start+1e6, start+19e6), latency: 18},
+		{name: "client without an end", traces: otlpTestTraces(start, 0, start+1e6, start+19e6), latency: 18},
+		{name: "no timed span", traces: otlpTestTraces(start, 0, start+1e6, 0), skipped: true},
+	}
+
+	for _, test := range tests {
+		result, err := Import("otlp", test.traces, ImportOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		connections := result.Architecture.Connections
+		if test.skipped {
+			if len(connections) != 0 || len(result.Warnings) != 1 {
+				t.Errorf("%s: connections = %v, warnings = %v, want the call skipped with a warning", test.name, connections, result.Warnings)
+			}
+			continue
+		}
+		if len(connections) != 1 || len(result.Warnings) != 0 {
+			t.Errorf("%s: connections = %v, warnings = %v, want one call", test.name, connections, result.Warnings)
+			continue
+		}
+		if connections[0].Latency != test.latency {
+			t.Errorf("%s: latency = %v, want %v", test.name, connections[0].Latency, test.latency)
+		}
+	}
+}
+
+func TestUpdateOTLPKeepsFlow(t *testing.T) {
+	const start = 1700000000000000000
+	file, err := os.ReadFile("testdata/shop.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var previous Architecture
+	if err := json.Unmarshal(file, &previous); err != nil {
+		t.Fatal(err)
+	}
+
+	// The call from web to api is a request and a response, but the
+	// connection was curated to only flow out
+	previous.Connections[0].Flow = "out"
+
+	result, err := Import("otlp", otlpTestTraces(start, start+20e6, start+1e6, start+19e6), ImportOptions{Previous: &previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := result.Architecture.Connections[0]
+	if c.Flow != "out" {
+		t.Errorf("flow = %s, want the curated flow out", c.Flow)
+	}
+	if c.Latency != 20 || c.Labels["requests"] != "1" {
+		t.Errorf("latency = %v, requests = %s, want the call applied", c.Latency, c.Labels["requests"])
+	}
+	if previous.Connections[0].Labels["requests"] != "" {
+		t.Errorf("the previous architecture was changed")
+	}
+}
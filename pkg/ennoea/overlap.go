@@ -0,0 +1,528 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+const (
+	// defaultNudgeMargin is the default gap that is left between
+	// objects that are nudged apart.
+	defaultNudgeMargin = 0.1
+
+	// maxNudgeIterations is the maximum number of passes that are
+	// made over the scene when nudging objects apart. Moving one
+	// object can push it into another, so several passes may be
+	// needed.
+	maxNudgeIterations = 100
+
+	// overlapEpsilon is the depth below which objects are considered
+	// to be touching rather than overlapping.
+	overlapEpsilon = 1e-9
+)
+
+// geometryExtents is the half size of each geometry along the x, y
+// and z axis before it is scaled or rotated. The sizes match the
+// geometries that the viewer creates in scene.js.
+var geometryExtents = map[string][3]float64{
+	"box":          {0.5, 0.5, 0.5},
+	"capsule":      {1, 1.5, 1},
+	"circle":       {1, 1, 0},
+	"cone":         {1, 1, 1},
+	"cylinder":     {1, 1.25, 1},
+	"dodecahedron": {1, 1, 1},
+	"icosahedron":  {1, 1, 1},
+	"octahedron":   {1, 1, 1},
+	"plane":        {0.5, 0.5, 0},
+	"ring":         {1, 1, 0},
+	"sphere":       {1, 1, 1},
+	"tetrahedron":  {1, 1, 1},
+	"torus":        {1.4, 1.4, 0.4},
+	"torusKnot":    {1.9, 1.9, 0.9},
+}
+
+// aabb is an axis-aligned bounding box.
+type aabb struct {
+	min [3]float64
+	max [3]float64
+}
+
+// objectAABB returns the axis-aligned bounding box of the 3D object
+// after it has been scaled and rotated. The rotation is applied in
+// the same order as the viewer, around the x, then y, then z axis of
+// the object.
+func objectAABB(o Object3D) aabb {
+	extents, ok := geometryExtents[o.Geometry]
+	if !ok {
+		extents = geometryExtents["box"]
+	}
+	for axis := range extents {
+		extents[axis] *= math.Abs(o.Scale[axis])
+	}
+
+	// The half size of the rotated box along each world axis is the
+	// sum of the rotated half sizes along each object axis
+	r := rotationMatrix(o.Rotation)
+	var box aabb
+	for i := 0; i < 3; i++ {
+		half := 0.0
+		for j := 0; j < 3; j++ {
+			half += math.Abs(r[i][j]) * extents[j]
+		}
+		box.min[i] = o.Position[i] - half
+		box.max[i] = o.Position[i] + half
+	}
+	return box
+}
+
+// rotationMatrix returns the matrix of the rotation in degrees around
+// the x, then y, then z axis of the object.
+func rotationMatrix(degrees [3]float64) [3][3]float64 {
+	x := degrees[0] * math.Pi / 180
+	y := degrees[1] * math.Pi / 180
+	z := degrees[2] * math.Pi / 180
+
+	rx := [3][3]float64{{1, 0, 0}, {0, math.Cos(x), -math.Sin(x)}, {0, math.Sin(x), math.Cos(x)}}
+	ry := [3][3]float64{{math.Cos(y), 0, math.Sin(y)}, {0, 1, 0}, {-math.Sin(y), 0, math.Cos(y)}}
+	rz := [3][3]float64{{math.Cos(z), -math.Sin(z), 0}, {math.Sin(z), math.Cos(z), 0}, {0, 0, 1}}
+
+	return matMul(matMul(rx, ry), rz)
+}
+
+// matMul returns the product of the matrices.
+func matMul(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+// centre returns the centre of the box.
+func (b aabb) centre() [3]float64 {
+	return vecScale(vecAdd(b.min, b.max), 0.5)
+}
+
+// union returns the smallest box that contains both boxes.
+func (b aabb) union(o aabb) aabb {
+	for axis := 0; axis < 3; axis++ {
+		b.min[axis] = math.Min(b.min[axis], o.min[axis])
+		b.max[axis] = math.Max(b.max[axis], o.max[axis])
+	}
+	return b
+}
+
+// expand returns the box grown by the amount on every side.
+func (b aabb) expand(amount float64) aabb {
+	for axis := 0; axis < 3; axis++ {
+		b.min[axis] -= amount
+		b.max[axis] += amount
+	}
+	return b
+}
+
+// translate returns the box moved by the offset.
+func (b aabb) translate(offset [3]float64) aabb {
+	return aabb{min: vecAdd(b.min, offset), max: vecAdd(b.max, offset)}
+}
+
+// contains returns true if the other box is entirely inside the box.
+func (b aabb) contains(o aabb) bool {
+	for axis := 0; axis < 3; axis++ {
+		if o.min[axis] < b.min[axis] || o.max[axis] > b.max[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPoint returns true if the point is inside the box.
+func (b aabb) containsPoint(p [3]float64) bool {
+	for axis := 0; axis < 3; axis++ {
+		if p[axis] < b.min[axis] || p[axis] > b.max[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// penetration returns the axis along which the boxes overlap the
+// least and the depth of the overlap along it. The depth is zero or
+// negative if the boxes do not overlap.
+func (b aabb) penetration(o aabb) (int, float64) {
+	axis, depth := 0, math.Inf(1)
+	for i := 0; i < 3; i++ {
+		d := math.Min(b.max[i], o.max[i]) - math.Max(b.min[i], o.min[i])
+		if d < depth {
+			axis, depth = i, d
+		}
+	}
+	return axis, depth
+}
+
+// OverlapReport represents the scene objects that intersect each
+// other. The bounding volumes are approximate, so objects that are
+// close together may be reported even if their surfaces do not touch.
+type OverlapReport struct {
+	// Components is a list of the pairs of components that overlap.
+	Components []ComponentOverlap `json:"components"`
+
+	// Groups is a list of the components that are inside the padded
+	// bounding box of a group that they are not a member of.
+	Groups []GroupIntrusion `json:"groups"`
+}
+
+// ComponentOverlap represents two components that overlap.
+type ComponentOverlap struct {
+	// Components is the IDs of the two components.
+	Components [2]string `json:"components"`
+
+	// Depth is the distance that one component has to move to no
+	// longer overlap the other.
+	Depth float64 `json:"depth"`
+}
+
+// GroupIntrusion represents a component inside the bounding box of a
+// group that it is not a member of.
+type GroupIntrusion struct {
+	// Group is the ID of the group.
+	Group string `json:"group"`
+
+	// Component is the ID of the component.
+	Component string `json:"component"`
+
+	// Contained is set when the component is entirely inside the
+	// bounding box of the group.
+	Contained bool `json:"contained"`
+
+	// Depth is the distance that the component has to move to no
+	// longer overlap the bounding box.
+	Depth float64 `json:"depth"`
+}
+
+// sceneBoxes holds the bounding boxes of the visible components of an
+// architecture.
+type sceneBoxes struct {
+	arch Architecture
+
+	// boxes is the bounding box of each component.
+	boxes []aabb
+
+	// visible is set for the components that are drawn by the viewer.
+	visible []bool
+
+	// index is a map of component IDs to their index.
+	index map[string]int
+}
+
+// newSceneBoxes computes the bounding boxes of the components.
+func newSceneBoxes(arch Architecture) *sceneBoxes {
+	s := &sceneBoxes{
+		arch:    arch,
+		boxes:   make([]aabb, len(arch.Components)),
+		visible: make([]bool, len(arch.Components)),
+		index:   make(map[string]int, len(arch.Components)),
+	}
+	for i, c := range arch.Components {
+		s.boxes[i] = objectAABB(c.Object)
+		s.visible[i] = c.Object.Visible
+		s.index[c.ID] = i
+	}
+	return s
+}
+
+// groupBox returns the padded bounding box of the group in the same
+// way as the viewer, which adds the padding to the size of the box.
+// False is returned if the group box is not drawn.
+func (s *sceneBoxes) groupBox(g Group) (aabb, map[int]bool, bool) {
+	if !g.BoundingBox.Visible {
+		return aabb{}, nil, false
+	}
+
+	var box aabb
+	members := make(map[int]bool, len(g.Components))
+	for _, id := range g.Components {
+		i, ok := s.index[id]
+		if !ok || !s.visible[i] {
+			continue
+		}
+		if len(members) == 0 {
+			box = s.boxes[i]
+		} else {
+			box = box.union(s.boxes[i])
+		}
+		members[i] = true
+	}
+	if len(members) == 0 {
+		return aabb{}, nil, false
+	}
+
+	return box.expand(g.BoundingBox.Padding / 2), members, true
+}
+
+// detectOverlaps returns the overlapping components and the group
+// intrusions of the scene.
+func (s *sceneBoxes) detectOverlaps() OverlapReport {
+	report := OverlapReport{
+		Components: []ComponentOverlap{},
+		Groups:     []GroupIntrusion{},
+	}
+
+	for i := range s.boxes {
+		if !s.visible[i] {
+			continue
+		}
+		for j := i + 1; j < len(s.boxes); j++ {
+			if !s.visible[j] {
+				continue
+			}
+			if _, depth := s.boxes[i].penetration(s.boxes[j]); depth > overlapEpsilon {
+				report.Components = append(report.Components, ComponentOverlap{
+					Components: [2]string{s.arch.Components[i].ID, s.arch.Components[j].ID},
+					Depth:      depth,
+				})
+			}
+		}
+	}
+
+	for _, g := range s.arch.Groups {
+		box, members, ok := s.groupBox(g)
+		if !ok {
+			continue
+		}
+		for i := range s.boxes {
+			if !s.visible[i] || members[i] {
+				continue
+			}
+			if _, depth := box.penetration(s.boxes[i]); depth > overlapEpsilon {
+				report.Groups = append(report.Groups, GroupIntrusion{
+					Group:     g.ID,
+					Component: s.arch.Components[i].ID,
+					Contained: box.contains(s.boxes[i]),
+					Depth:     depth,
+				})
+			}
+		}
+	}
+
+	return report
+}
+
+// move moves the component and its bounding box by the offset.
+func (s *sceneBoxes) move(i int, offset [3]float64) {
+	s.boxes[i] = s.boxes[i].translate(offset)
+	s.arch.Components[i].Object.Position = vecAdd(s.arch.Components[i].Object.Position, offset)
+}
+
+// NudgeOptions represents the options used to nudge overlapping
+// objects apart.
+type NudgeOptions struct {
+	// Pinned is a list of the IDs of the components that must not
+	// be moved.
+	Pinned []string `json:"pinned,omitempty"`
+
+	// Margin is the gap that is left between objects that are moved
+	// apart. It defaults to 0.1.
+	Margin float64 `json:"margin,omitempty"`
+}
+
+// NudgeResult represents the result of nudging the objects apart.
+type NudgeResult struct {
+	// Moved is a list of the IDs of the components that were moved.
+	Moved []string `json:"moved"`
+
+	// Remaining is the overlaps that could not be removed, for
+	// example because both components are pinned.
+	Remaining OverlapReport `json:"remaining"`
+
+	// Architecture is the architecture with the new positions.
+	Architecture Architecture `json:"architecture"`
+}
+
+// nudgeApart moves overlapping components apart. Each overlap is
+// resolved by moving along the axis with the smallest overlap, which
+// is the smallest change that separates the bounding boxes. Both
+// components move half of the distance unless one of them is pinned.
+// Components inside the box of a group they are not a member of are
+// pushed out of the box.
+func nudgeApart(arch Architecture, options NudgeOptions) (NudgeResult, error) {
+	if options.Margin < 0 || math.IsNaN(options.Margin) || math.IsInf(options.Margin, 0) {
+		return NudgeResult{}, fmt.Errorf("invalid nudge: margin is not a positive number")
+	}
+	if options.Margin == 0 {
+		options.Margin = defaultNudgeMargin
+	}
+
+	// Copy the components so that the caller's architecture is not
+	// changed
+	arch.Components = append([]Component(nil), arch.Components...)
+	s := newSceneBoxes(arch)
+
+	pinned := make([]bool, len(arch.Components))
+	for _, id := range options.Pinned {
+		i, ok := s.index[id]
+		if !ok {
+			return NudgeResult{}, fmt.Errorf("invalid nudge: unknown pinned component: %s", id)
+		}
+		pinned[i] = true
+	}
+
+	moved := make([]bool, len(arch.Components))
+	for iteration := 0; iteration < maxNudgeIterations; iteration++ {
+		changed := false
+
+		// Separate the overlapping components
+		for i := range s.boxes {
+			for j := i + 1; j < len(s.boxes); j++ {
+				if !s.visible[i] || !s.visible[j] || (pinned[i] && pinned[j]) {
+					continue
+				}
+				axis, depth := s.boxes[i].penetration(s.boxes[j])
+				if depth <= overlapEpsilon {
+					continue
+				}
+
+				// Move j away from i along the axis
+				direction := 1.0
+				if s.boxes[j].centre()[axis] < s.boxes[i].centre()[axis] {
+					direction = -1
+				}
+				var offset [3]float64
+				offset[axis] = direction * (depth + options.Margin)
+
+				switch {
+				case pinned[i]:
+					s.move(j, offset)
+					moved[j] = true
+				case pinned[j]:
+					s.move(i, vecScale(offset, -1))
+					moved[i] = true
+				default:
+					s.move(i, vecScale(offset, -0.5))
+					s.move(j, vecScale(offset, 0.5))
+					moved[i], moved[j] = true, true
+				}
+				changed = true
+			}
+		}
+
+		// Push the components out of the groups they are not in
+		for _, g := range arch.Groups {
+			box, members, ok := s.groupBox(g)
+			if !ok {
+				continue
+			}
+			for i := range s.boxes {
+				if !s.visible[i] || members[i] || pinned[i] {
+					continue
+				}
+				axis, depth := box.penetration(s.boxes[i])
+				if depth <= overlapEpsilon {
+					continue
+				}
+
+				direction := 1.0
+				if s.boxes[i].centre()[axis] < box.centre()[axis] {
+					direction = -1
+				}
+				var offset [3]float64
+				offset[axis] = direction * (depth + options.Margin)
+				s.move(i, offset)
+				moved[i] = true
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	result := NudgeResult{
+		Moved:        []string{},
+		Remaining:    s.detectOverlaps(),
+		Architecture: s.arch,
+	}
+	for i, m := range moved {
+		if m {
+			result.Moved = append(result.Moved, arch.Components[i].ID)
+		}
+	}
+
+	return result, nil
+}
+
+// handleGetOverlaps handles GET requests for the overlapping objects
+// of an architecture.
+// GET /architectures/${architectureID}/overlaps
+//
+//	return the pairs of components whose bounding volumes overlap, and
+//	the components that are inside the padded bounding box of a group
+//	that they are not a member of.
+func (h *ArchitectureHandler) handleGetOverlaps(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, newSceneBoxes(arch).detectOverlaps())
+}
+
+// nudgeRequest represents the body of a nudge request.
+type nudgeRequest struct {
+	NudgeOptions
+
+	// Preview determines whether or not the architecture is saved.
+	Preview bool `json:"preview"`
+}
+
+// handlePostNudge handles POST requests to nudge overlapping objects
+// apart.
+// POST /architectures/${architectureID}/nudge
+//
+//	move the overlapping components apart and save the architecture.
+//	The request body contains the options:
+//	{
+//		"pinned": ["componentID"],
+//		"margin": 0.1,
+//		"preview": false
+//	}
+//	The moved components, any overlaps that remain and the
+//	architecture with the new positions are returned. If preview is
+//	set, the architecture is not saved.
+func (h *ArchitectureHandler) handlePostNudge(w http.ResponseWriter, r *http.Request, architectureID string) {
+	var req nudgeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to decode nudge: %v", err)
+		return
+	}
+
+	if !req.Preview {
+		defer h.lockArchitecture(architectureID)()
+	}
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	result, err := nudgeApart(arch, req.NudgeOptions)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to nudge architecture: %v", err)
+		return
+	}
+
+	if !req.Preview && len(result.Moved) > 0 && !h.saveArchitectureForRequest(w, result.Architecture) {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, result)
+}
@@ -0,0 +1,199 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// overlapTestBox returns a visible unit box at the position.
+func overlapTestBox(id string, x float64) Component {
+	return Component{ID: id, Object: Object3D{
+		Visible:  true,
+		Position: [3]float64{x, 0, 0},
+		Scale:    [3]float64{1, 1, 1},
+		Geometry: "box",
+	}}
+}
+
+// aabbNear returns true if the corners of the boxes are equal to
+// within rounding errors.
+func aabbNear(a, b aabb) bool {
+	for axis := 0; axis < 3; axis++ {
+		if math.Abs(a.min[axis]-b.min[axis]) > 1e-9 || math.Abs(a.max[axis]-b.max[axis]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestObjectAABB(t *testing.T) {
+	tests := []struct {
+		name   string
+		object Object3D
+		want   aabb
+	}{
+		{
+			name:   "scaled box",
+			object: Object3D{Position: [3]float64{1, 0, 0}, Scale: [3]float64{2, 2, 2}, Geometry: "box"},
+			want:   aabb{min: [3]float64{0, -1, -1}, max: [3]float64{2, 1, 1}},
+		},
+		{
+			name:   "box rotated around z",
+			object: Object3D{Scale: [3]float64{4, 1, 1}, Rotation: [3]float64{0, 0, 90}, Geometry: "box"},
+			want:   aabb{min: [3]float64{-0.5, -2, -0.5}, max: [3]float64{0.5, 2, 0.5}},
+		},
+		{
+			name:   "cylinder with a negative scale",
+			object: Object3D{Position: [3]float64{0, 1, 0}, Scale: [3]float64{1, -2, 1}, Geometry: "cylinder"},
+			want:   aabb{min: [3]float64{-1, -1.5, -1}, max: [3]float64{1, 3.5, 1}},
+		},
+		{
+			name:   "unknown geometry",
+			object: Object3D{Scale: [3]float64{1, 1, 1}, Geometry: "teapot"},
+			want:   aabb{min: [3]float64{-0.5, -0.5, -0.5}, max: [3]float64{0.5, 0.5, 0.5}},
+		},
+	}
+	for _, test := range tests {
+		if got := objectAABB(test.object); !aabbNear(got, test.want) {
+			t.Errorf("%s: box = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDetectOverlaps(t *testing.T) {
+	hidden := overlapTestBox("hidden", 0)
+	hidden.Object.Visible = false
+	arch := Architecture{
+		Components: []Component{
+			overlapTestBox("a", 0),
+			overlapTestBox("b", 0.5),
+			overlapTestBox("c", 5),
+			overlapTestBox("d", 6.5),
+			hidden,
+		},
+		Groups: []Group{{
+			ID:          "g",
+			Components:  []string{"c"},
+			BoundingBox: BoundingBox{Visible: true, Padding: 2},
+		}},
+	}
+
+	report := newSceneBoxes(arch).detectOverlaps()
+	want := OverlapReport{
+		Components: []ComponentOverlap{{Components: [2]string{"a", "b"}, Depth: 0.5}},
+		Groups:     []GroupIntrusion{{Group: "g", Component: "d", Depth: 0.5}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
+
+func TestNudgeApart(t *testing.T) {
+	arch := Architecture{Components: []Component{overlapTestBox("a", 0), overlapTestBox("b", 0.5)}}
+	tests := []struct {
+		name      string
+		options   NudgeOptions
+		want      [2]float64
+		moved     []string
+		remaining int
+	}{
+		{"both move", NudgeOptions{}, [2]float64{-0.3, 0.8}, []string{"a", "b"}, 0},
+		{"one pinned", NudgeOptions{Pinned: []string{"a"}, Margin: 0.5}, [2]float64{0, 1.5}, []string{"b"}, 0},
+		{"both pinned", NudgeOptions{Pinned: []string{"a", "b"}}, [2]float64{0, 0.5}, []string{}, 1},
+	}
+	for _, test := range tests {
+		result, err := nudgeApart(arch, test.options)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for i, x := range test.want {
+			if got := result.Architecture.Components[i].Object.Position[0]; math.Abs(got-x) > 1e-9 {
+				t.Errorf("%s: %s moved to x = %v, want %v", test.name, arch.Components[i].ID, got, x)
+			}
+		}
+		if !reflect.DeepEqual(result.Moved, test.moved) {
+			t.Errorf("%s: moved = %v, want %v", test.name, result.Moved, test.moved)
+		}
+		if len(result.Remaining.Components) != test.remaining {
+			t.Errorf("%s: remaining overlaps = %+v, want %d", test.name, result.Remaining.Components, test.remaining)
+		}
+	}
+	if arch.Components[1].Object.Position[0] != 0.5 {
+		t.Errorf("the architecture of the caller was changed")
+	}
+
+	for _, options := range []NudgeOptions{{Margin: -1}, {Margin: math.NaN()}, {Pinned: []string{"missing"}}} {
+		if _, err := nudgeApart(arch, options); err == nil {
+			t.Errorf("%+v: expected an error", options)
+		}
+	}
+}
+
+func TestNudgeApartGroups(t *testing.T) {
+	arch := Architecture{
+		Components: []Component{overlapTestBox("member", 0), overlapTestBox("intruder", 1.5)},
+		Groups: []Group{{
+			ID:          "g",
+			Components:  []string{"member"},
+			BoundingBox: BoundingBox{Visible: true, Padding: 2},
+		}},
+	}
+	result, err := nudgeApart(arch, NudgeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Architecture.Components[1].Object.Position[0]; math.Abs(got-2.1) > 1e-9 {
+		t.Errorf("intruder moved to x = %v, want 2.1", got)
+	}
+	if want := []string{"intruder"}; !reflect.DeepEqual(result.Moved, want) {
+		t.Errorf("moved = %v, want %v", result.Moved, want)
+	}
+	if len(result.Remaining.Groups) != 0 {
+		t.Errorf("remaining intrusions = %+v", result.Remaining.Groups)
+	}
+}
+
+func TestPostNudge(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	// Move web into api and the backend group
+	arch, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch.Components[0].Object.Position = [3]float64{-0.75, 0, 0}
+	body, _ := json.Marshal(arch)
+	serveTestJSON(t, h, http.MethodPut, "/architectures/", string(body), http.StatusOK, nil)
+
+	var report OverlapReport
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/overlaps", "", http.StatusOK, &report)
+	if len(report.Components) != 1 || report.Components[0].Components != [2]string{"web", "api"} {
+		t.Errorf("overlapping components = %+v", report.Components)
+	}
+	if len(report.Groups) != 1 || report.Groups[0].Component != "web" {
+		t.Errorf("group intrusions = %+v", report.Groups)
+	}
+
+	var result NudgeResult
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/nudge", `{"pinned": ["api", "db"], "preview": true}`, http.StatusOK, &result)
+	if !reflect.DeepEqual(result.Moved, []string{"web"}) || len(result.Remaining.Components)+len(result.Remaining.Groups) != 0 {
+		t.Errorf("preview moved %v and left %+v", result.Moved, result.Remaining)
+	}
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/overlaps", "", http.StatusOK, &report)
+	if len(report.Components) != 1 {
+		t.Errorf("preview was saved")
+	}
+
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/nudge", `{}`, http.StatusOK, nil)
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/overlaps", "", http.StatusOK, &report)
+	if len(report.Components)+len(report.Groups) != 0 {
+		t.Errorf("overlaps after the nudge = %+v", report)
+	}
+
+	serveTestJSON(t, h, http.MethodPost, "/architectures/shop/nudge", `{"margin": -1}`, http.StatusBadRequest, nil)
+	serveTestJSON(t, h, http.MethodPost, "/architectures/none/nudge", `{}`, http.StatusNotFound, nil)
+}
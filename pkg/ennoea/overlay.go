@@ -0,0 +1,591 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+Overlays describe an environment, such as staging or production, as a
+set of changes on top of a base architecture. The overlay is stored in
+the save directory next to the concrete architectures, but instead of
+an architecture.json file the directory contains an overlay.json file.
+The saveInfo.json file records the ID of the base architecture.
+
+Overlay save directory structure:
+saves/
+	${overlayID}/
+		saveInfo.json
+		overlay.json
+
+The overlay is resolved against the latest version of its base every
+time it is loaded. Changes are applied in the following order:
+
+ 1. Entities listed in "remove" are removed from the base. Removing a
+    component also removes it from any groups and removes any
+    connections that use it.
+ 2. Entities listed in "patch" are patched. A patch is a partial JSON
+    document that is merged onto the entity. Fields that are not in
+    the patch keep their base value. Lists are replaced as a whole.
+ 3. Entities listed in "add" are added.
+
+overlay.json
+{
+	"info": {
+		"id": "overlayID",
+		"name": "overlayName",
+		"description": "overlayDescription"
+	},
+	"base": "architectureID",
+	"remove": {
+		"components": ["componentID"]
+	},
+	"patch": {
+		"components": {
+			"componentID": {"name": "newName"}
+		},
+		"connections": {
+			"connectionID": {"outRate": 2000}
+		}
+	},
+	"add": {
+		"components": [...],
+		"groups": [...],
+		"connections": [...]
+	}
+}
+*/
+
+// maxOverlayDepth is the maximum number of overlays that can be
+// stacked on top of each other.
+const maxOverlayDepth = 8
+
+// Overlay represents a set of changes that are applied on top of a
+// base architecture to produce a concrete architecture.
+type Overlay struct {
+	// Info represents the information of the resolved architecture.
+	// The info of the base architecture is replaced by this info.
+	Info Info `json:"info"`
+
+	// Base is the ID of the architecture that the overlay is
+	// applied to.
+	Base string `json:"base"`
+
+	// Remove lists the IDs of the entities that are removed from
+	// the base architecture.
+	Remove OverlayRemovals `json:"remove"`
+
+	// Patch contains the partial entities that are merged onto the
+	// entities of the base architecture.
+	Patch OverlayPatches `json:"patch"`
+
+	// Add contains the entities that are added to the base
+	// architecture.
+	Add OverlayAdditions `json:"add"`
+}
+
+// OverlayRemovals lists the IDs of the entities that an overlay
+// removes from its base architecture.
+type OverlayRemovals struct {
+	// Components is a list of the component IDs to remove.
+	Components []string `json:"components"`
+
+	// Groups is a list of the group IDs to remove.
+	Groups []string `json:"groups"`
+
+	// Connections is a list of the connection IDs to remove.
+	Connections []string `json:"connections"`
+}
+
+// OverlayPatches contains the partial entities that an overlay
+// merges onto the entities of its base architecture. Each map is
+// keyed by the ID of the entity that is patched.
+type OverlayPatches struct {
+	// Scene is merged onto the scene of the base architecture.
+	Scene json.RawMessage `json:"scene,omitempty"`
+
+	// Components is a map of component IDs to component patches.
+	Components map[string]json.RawMessage `json:"components"`
+
+	// Groups is a map of group IDs to group patches.
+	Groups map[string]json.RawMessage `json:"groups"`
+
+	// Connections is a map of connection IDs to connection patches.
+	Connections map[string]json.RawMessage `json:"connections"`
+}
+
+// OverlayAdditions contains the entities that an overlay adds to
+// its base architecture.
+type OverlayAdditions struct {
+	// Components is a list of the components to add.
+	Components []Component `json:"components"`
+
+	// Groups is a list of the groups to add.
+	Groups []Group `json:"groups"`
+
+	// Connections is a list of the connections to add.
+	Connections []Connection `json:"connections"`
+}
+
+// resolve applies the overlay to the base architecture and returns
+// the resulting architecture. An error is returned if the overlay
+// refers to entities that do not exist in the base, or if the
+// resulting architecture is not valid.
+func (o Overlay) resolve(base Architecture) (Architecture, error) {
+	arch := base
+	arch.Info = o.Info
+
+	// Remove the entities from the base
+	if err := o.applyRemovals(&arch); err != nil {
+		return Architecture{}, fmt.Errorf("failed to resolve overlay %s: %w", o.Info.ID, err)
+	}
+
+	// Patch the entities in the base
+	if err := o.applyPatches(&arch); err != nil {
+		return Architecture{}, fmt.Errorf("failed to resolve overlay %s: %w", o.Info.ID, err)
+	}
+
+	// Add the new entities
+	arch.Components = append(arch.Components, o.Add.Components...)
+	arch.Groups = append(arch.Groups, o.Add.Groups...)
+	arch.Connections = append(arch.Connections, o.Add.Connections...)
+
+	// Check that the resolved architecture is valid
+	if err := arch.isValid(); err != nil {
+		return Architecture{}, fmt.Errorf("failed to resolve overlay %s: %w", o.Info.ID, err)
+	}
+	if err := arch.isConsistent(); err != nil {
+		return Architecture{}, fmt.Errorf("failed to resolve overlay %s: %w", o.Info.ID, err)
+	}
+
+	return arch, nil
+}
+
+// applyRemovals removes the entities listed in the overlay from the
+// architecture. Removing a component also removes it from the
+// groups and removes the connections that use it.
+func (o Overlay) applyRemovals(arch *Architecture) error {
+	removedComponents, err := idSet("component", o.Remove.Components, componentIDs(arch.Components))
+	if err != nil {
+		return err
+	}
+	removedGroups, err := idSet("group", o.Remove.Groups, groupIDs(arch.Groups))
+	if err != nil {
+		return err
+	}
+	removedConnections, err := idSet("connection", o.Remove.Connections, connectionIDs(arch.Connections))
+	if err != nil {
+		return err
+	}
+
+	components := make([]Component, 0, len(arch.Components))
+	for _, c := range arch.Components {
+		if !removedComponents[c.ID] {
+			components = append(components, c)
+		}
+	}
+
+	groups := make([]Group, 0, len(arch.Groups))
+	for _, g := range arch.Groups {
+		if removedGroups[g.ID] {
+			continue
+		}
+		members := make([]string, 0, len(g.Components))
+		for _, member := range g.Components {
+			if !removedComponents[member] {
+				members = append(members, member)
+			}
+		}
+		g.Components = members
+		groups = append(groups, g)
+	}
+
+	connections := make([]Connection, 0, len(arch.Connections))
+	for _, c := range arch.Connections {
+		if removedConnections[c.ID] || removedComponents[c.Source] || removedComponents[c.Target] {
+			continue
+		}
+		connections = append(connections, c)
+	}
+
+	arch.Components = components
+	arch.Groups = groups
+	arch.Connections = connections
+
+	return nil
+}
+
+// applyPatches merges the patches in the overlay onto the entities
+// of the architecture. The ID of an entity cannot be patched.
+func (o Overlay) applyPatches(arch *Architecture) error {
+	if len(o.Patch.Scene) > 0 {
+		if err := json.Unmarshal(o.Patch.Scene, &arch.Scene); err != nil {
+			return fmt.Errorf("failed to patch scene: %v", err)
+		}
+	}
+
+	if err := checkPatchTargets("component", o.Patch.Components, componentIDs(arch.Components)); err != nil {
+		return err
+	}
+	for i, c := range arch.Components {
+		patch, ok := o.Patch.Components[c.ID]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(patch, &arch.Components[i]); err != nil {
+			return fmt.Errorf("failed to patch component %s: %v", c.ID, err)
+		}
+		if arch.Components[i].ID != c.ID {
+			return fmt.Errorf("failed to patch component %s: id cannot be patched", c.ID)
+		}
+	}
+
+	if err := checkPatchTargets("group", o.Patch.Groups, groupIDs(arch.Groups)); err != nil {
+		return err
+	}
+	for i, g := range arch.Groups {
+		patch, ok := o.Patch.Groups[g.ID]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(patch, &arch.Groups[i]); err != nil {
+			return fmt.Errorf("failed to patch group %s: %v", g.ID, err)
+		}
+		if arch.Groups[i].ID != g.ID {
+			return fmt.Errorf("failed to patch group %s: id cannot be patched", g.ID)
+		}
+	}
+
+	if err := checkPatchTargets("connection", o.Patch.Connections, connectionIDs(arch.Connections)); err != nil {
+		return err
+	}
+	for i, c := range arch.Connections {
+		patch, ok := o.Patch.Connections[c.ID]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(patch, &arch.Connections[i]); err != nil {
+			return fmt.Errorf("failed to patch connection %s: %v", c.ID, err)
+		}
+		if arch.Connections[i].ID != c.ID {
+			return fmt.Errorf("failed to patch connection %s: id cannot be patched", c.ID)
+		}
+	}
+
+	return nil
+}
+
+// idSet returns the IDs as a set. An error is returned if any of the
+// IDs are not in the set of known IDs.
+func idSet(kind string, ids []string, known map[string]bool) (map[string]bool, error) {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !known[id] {
+			return nil, fmt.Errorf("cannot remove unknown %s: %s", kind, id)
+		}
+		set[id] = true
+	}
+	return set, nil
+}
+
+// checkPatchTargets returns an error if any of the patches refer to
+// an entity that is not in the set of known IDs.
+func checkPatchTargets(kind string, patches map[string]json.RawMessage, known map[string]bool) error {
+	for id := range patches {
+		if !known[id] {
+			return fmt.Errorf("cannot patch unknown %s: %s", kind, id)
+		}
+	}
+	return nil
+}
+
+// componentIDs returns the set of IDs of the components.
+func componentIDs(components []Component) map[string]bool {
+	ids := make(map[string]bool, len(components))
+	for _, c := range components {
+		ids[c.ID] = true
+	}
+	return ids
+}
+
+// groupIDs returns the set of IDs of the groups.
+func groupIDs(groups []Group) map[string]bool {
+	ids := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		ids[g.ID] = true
+	}
+	return ids
+}
+
+// connectionIDs returns the set of IDs of the connections.
+func connectionIDs(connections []Connection) map[string]bool {
+	ids := make(map[string]bool, len(connections))
+	for _, c := range connections {
+		ids[c.ID] = true
+	}
+	return ids
+}
+
+// handleGetResolvedOverlay handles GET requests for an overlay
+// architecture. The overlay is resolved against its base and the
+// resulting architecture is returned.
+func (h *ArchitectureHandler) handleGetResolvedOverlay(w http.ResponseWriter, r *http.Request, save ArchitectureSave) {
+	arch, err := h.loadArchitectureByID(save.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Failed to resolve overlay: %v", err)
+		return
+	}
+
+	file, err := json.Marshal(arch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to marshal architecture: %v", err)
+		return
+	}
+
+	h.writeArchitectureResponse(w, r, file)
+}
+
+// handleGetOverlay handles GET requests for the unresolved overlay
+// document.
+// GET /architectures/${architectureID}/overlay
+//
+//	load the overlay document so that it can be edited.
+func (h *ArchitectureHandler) handleGetOverlay(w http.ResponseWriter, r *http.Request, architectureID string) {
+	save, ok := h.architectureSave(architectureID)
+	if !ok || save.Base == "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Overlay not found")
+		return
+	}
+
+	filePath := filepath.Join(h.filePath, save.ID, "overlay.json")
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to read overlay file: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(file)
+}
+
+// handlePutOverlay handles PUT requests to save an overlay.
+// PUT /architectures/${architectureID}/overlays
+//
+//	save an overlay of the architecture. The overlay must resolve
+//	against the current version of the base architecture.
+func (h *ArchitectureHandler) handlePutOverlay(w http.ResponseWriter, r *http.Request, baseID string) {
+	// Load the overlay from the request body
+	var overlay Overlay
+	err := json.NewDecoder(r.Body).Decode(&overlay)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to load overlay: %v", err)
+		return
+	}
+
+	// The base in the path always wins, but a conflicting base in
+	// the body is most likely a mistake.
+	if overlay.Base != "" && overlay.Base != baseID {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Overlay base %s does not match %s", overlay.Base, baseID)
+		return
+	}
+	overlay.Base = baseID
+
+	if _, ok := h.architectureSave(baseID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Architecture not found")
+		return
+	}
+
+	// Check that the overlay info is valid
+	if err := overlay.Info.isValid(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid overlay: %v", err)
+		return
+	}
+	if overlay.Info.ID == "" {
+		overlay.Info.ID = generateID()
+	}
+	if err := isValidArchitectureID(overlay.Info.ID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid overlay: %v", err)
+		return
+	}
+	if overlay.Info.ID == baseID {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid overlay: overlay cannot be its own base")
+		return
+	}
+	defer h.lockArchitecture(overlay.Info.ID)()
+	if save, ok := h.architectureSave(overlay.Info.ID); ok && save.Base == "" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Architecture %s is not an overlay", save.ID)
+		return
+	}
+
+	// A saved overlay must not be moved onto one of its own overlays,
+	// since neither could then be resolved
+	visited := map[string]bool{}
+	for id := baseID; id != "" && !visited[id]; {
+		if id == overlay.Info.ID {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "Overlay %s cannot be based on its own overlay %s", overlay.Info.ID, baseID)
+			return
+		}
+		visited[id] = true
+		save, ok := h.architectureSave(id)
+		if !ok {
+			break
+		}
+		id = save.Base
+	}
+
+	// Check that the overlay resolves against the current base
+	base, err := h.loadArchitectureByID(baseID)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Failed to load base architecture: %v", err)
+		return
+	}
+	resolved, err := overlay.resolve(base)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid overlay: %v", err)
+		return
+	}
+
+	// The policies apply to the resolved architecture, since that is
+	// what the overlay is loaded as
+	violations, ok := h.enforcePolicies(w, resolved)
+	if !ok {
+		return
+	}
+
+	// Save the overlay
+	err = h.saveOverlay(overlay)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save overlay: %v", err)
+		return
+	}
+
+	// Overlays can be stacked, so check the overlays of this one
+	err = h.checkOverlays(overlay.Info.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to check overlays: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Overlay saved")
+	if len(violations) > 0 {
+		fmt.Fprintf(w, "\nPolicy violations:")
+		for _, v := range violations {
+			fmt.Fprintf(w, "\n%s", v)
+		}
+	}
+}
+
+// loadOverlay loads the overlay document from the save directory.
+func (h *ArchitectureHandler) loadOverlay(architectureID string) (Overlay, error) {
+	filePath := filepath.Join(h.filePath, architectureID, "overlay.json")
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return Overlay{}, fmt.Errorf("failed to read overlay file: %v", err)
+	}
+
+	var overlay Overlay
+	err = json.Unmarshal(file, &overlay)
+	if err != nil {
+		return Overlay{}, fmt.Errorf("failed to unmarshal overlay file: %v", err)
+	}
+
+	return overlay, nil
+}
+
+// saveOverlay saves the overlay document and its save information
+// to the save directory.
+func (h *ArchitectureHandler) saveOverlay(overlay Overlay) error {
+	dirPath := filepath.Join(h.filePath, overlay.Info.ID)
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create overlay directory: %v", err)
+	}
+
+	file, err := json.Marshal(overlay)
+	if err != nil {
+		return fmt.Errorf("failed to marshal overlay: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dirPath, "overlay.json"), file, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save overlay file: %v", err)
+	}
+
+	save := ArchitectureSave{
+		ID:        overlay.Info.ID,
+		Name:      overlay.Info.Name,
+		LastSaved: time.Now(),
+		Base:      overlay.Base,
+	}
+	err = h.writeArchitectureSave(save)
+	if err != nil {
+		return err
+	}
+	h.setArchitectureSave(save)
+
+	return nil
+}
+
+// checkOverlays resolves every overlay of the base architecture and
+// records whether it is still valid, which includes not violating an
+// enforced policy. Overlays of overlays are checked as well.
+func (h *ArchitectureHandler) checkOverlays(baseID string) error {
+	return h.checkOverlaysVisited(baseID, map[string]bool{})
+}
+
+// checkOverlaysVisited checks the overlays of the base architecture,
+// skipping any architectures that have already been visited.
+func (h *ArchitectureHandler) checkOverlaysVisited(baseID string, visited map[string]bool) error {
+	visited[baseID] = true
+
+	for _, save := range h.architectureSaves() {
+		id := save.ID
+		if save.Base != baseID || visited[id] {
+			continue
+		}
+
+		overlayError := ""
+		if resolved, err := h.loadArchitectureByID(id); err != nil {
+			overlayError = err.Error()
+		} else if _, enforced, err := h.evaluatePolicies(resolved); err != nil {
+			return err
+		} else if len(enforced) > 0 {
+			overlayError = fmt.Sprintf("overlay violates an enforced policy: %s", enforced[0])
+		}
+
+		if overlayError != save.OverlayError {
+			save.OverlayError = overlayError
+			if err := h.writeArchitectureSave(save); err != nil {
+				return err
+			}
+			h.setArchitectureSave(save)
+		}
+
+		if err := h.checkOverlaysVisited(id, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package ennoea
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// putTestPolicy saves an enforced policy with the rule.
+func putTestPolicy(t *testing.T, policies *PolicyHandler, id, rule string) {
+	t.Helper()
+	body, _ := json.Marshal(Policy{ID: id, Enforce: true, Rule: rule})
+	w := httptest.NewRecorder()
+	policies.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/policies/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT policy returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestPutOverlayEnforcesPolicies(t *testing.T) {
+	h, policies := newTestHandler(t)
+	putTestPolicy(t, policies, "no-web-to-db", `deny connection where source == "web" and target == "db"`)
+
+	overlay := `{
+		"info": {"id": "shop-direct", "name": "Shop with a direct connection", "description": "Web reads the database."},
+		"add": {"connections": [{"id": "web-db", "name": "Web to Database", "source": "web", "target": "db", "flow": "out"}]}
+	}`
+	w := serveTest(h, http.MethodPut, "/architectures/shop/overlays", overlay)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PUT overlay returned %d: %s", w.Code, w.Body)
+	}
+	if _, ok := h.architectureSave("shop-direct"); ok {
+		t.Errorf("overlay that violates an enforced policy was saved")
+	}
+}
+
+func TestCheckOverlaysEnforcesPolicies(t *testing.T) {
+	h, policies := newTestHandler(t)
+
+	overlay := `{
+		"info": {"id": "shop-staging", "name": "Shop in staging", "description": "The staging environment."},
+		"patch": {"components": {"db": {"name": "Staging database"}}}
+	}`
+	w := serveTest(h, http.MethodPut, "/architectures/shop/overlays", overlay)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT overlay returned %d: %s", w.Code, w.Body)
+	}
+
+	// The base can still be saved, since the policy is only violated
+	// by the name in the overlay
+	putTestPolicy(t, policies, "no-staging", `deny component where name =~ "Staging"`)
+	file, err := os.ReadFile("testdata/shop.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serveTest(h, http.MethodPut, "/architectures/", string(file))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT shop returned %d: %s", w.Code, w.Body)
+	}
+
+	save, _ := h.architectureSave("shop-staging")
+	if !strings.Contains(save.OverlayError, "no-staging") {
+		t.Errorf("overlay error = %q, want the violated policy", save.OverlayError)
+	}
+}
+
+func TestPostLayoutChecksOverlays(t *testing.T) {
+	h, policies := newTestHandler(t)
+
+	overlay := `{
+		"info": {"id": "shop-staging", "name": "Shop in staging", "description": "The staging environment."},
+		"patch": {"components": {"db": {"name": "Staging database"}}}
+	}`
+	w := serveTest(h, http.MethodPut, "/architectures/shop/overlays", overlay)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT overlay returned %d: %s", w.Code, w.Body)
+	}
+	putTestPolicy(t, policies, "no-staging", `deny component where name =~ "Staging"`)
+
+	// The positions cannot be saved into the overlay itself
+	w = serveTest(h, http.MethodPost, "/architectures/shop-staging/layout", `{"algorithm": "grid"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("POST overlay layout returned %d: %s", w.Code, w.Body)
+	}
+
+	// Saving the base checks its overlays against the policies
+	w = serveTest(h, http.MethodPost, "/architectures/shop/layout", `{"algorithm": "grid"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST layout returned %d: %s", w.Code, w.Body)
+	}
+	save, _ := h.architectureSave("shop-staging")
+	if !strings.Contains(save.OverlayError, "no-staging") {
+		t.Errorf("overlay error = %q, want the violated policy", save.OverlayError)
+	}
+}
+
+func TestPutOverlayRejectsCycles(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	overlay := `{"info": {"id": "%s", "name": "Shop in staging", "description": "The staging environment."}}`
+	w := serveTest(h, http.MethodPut, "/architectures/shop/overlays", strings.Replace(overlay, "%s", "staging", 1))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT overlay returned %d: %s", w.Code, w.Body)
+	}
+	w = serveTest(h, http.MethodPut, "/architectures/staging/overlays", strings.Replace(overlay, "%s", "staging-eu", 1))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT stacked overlay returned %d: %s", w.Code, w.Body)
+	}
+
+	// Moving staging onto its own overlay would make a cycle
+	w = serveTest(h, http.MethodPut, "/architectures/staging-eu/overlays", strings.Replace(overlay, "%s", "staging", 1))
+	if w.Code != http.StatusConflict {
+		t.Errorf("PUT overlay onto its own overlay returned %d: %s", w.Code, w.Body)
+	}
+	if save, _ := h.architectureSave("staging"); save.Base != "shop" {
+		t.Errorf("staging base = %q, want shop", save.Base)
+	}
+}
+
+func TestPutOverlayValidatesID(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	for _, id := range []string{"../escaped", "a/b", `a\b`, "."} {
+		overlay := `{"info": {"id": "` + strings.ReplaceAll(id, `\`, `\\`) + `", "name": "Escaped", "description": "Outside the save directory."}}`
+		w := serveTest(h, http.MethodPut, "/architectures/shop/overlays", overlay)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PUT overlay %q returned %d: %s", id, w.Code, w.Body)
+		}
+	}
+	file, err := os.ReadFile("testdata/shop.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveTest(h, http.MethodPut, "/architectures/", strings.Replace(string(file), `"id": "shop"`, `"id": "../escaped"`, 1))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid ID") {
+		t.Errorf("PUT architecture returned %d: %s", w.Code, w.Body)
+	}
+}
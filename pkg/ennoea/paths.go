@@ -0,0 +1,381 @@
+package ennoea
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+const (
+	// defaultPathDepth is the default maximum number of connections
+	// in a path.
+	defaultPathDepth = 10
+
+	// maxPathDepth is the largest maximum depth that can be requested.
+	maxPathDepth = 32
+
+	// defaultPathLimit is the default maximum number of paths that
+	// are listed.
+	defaultPathLimit = 50
+
+	// maxPathLimit is the largest limit that can be requested.
+	maxPathLimit = 1000
+
+	// maxPathSteps is the maximum number of partial paths that are
+	// searched for the paths between two components.
+	maxPathSteps = 100000
+)
+
+// Path weightings that can be used to find the shortest path.
+const (
+	pathWeightHops    = "hops"
+	pathWeightLatency = "latency"
+	pathWeightRate    = "rate"
+)
+
+// PathResult represents the paths between two components.
+type PathResult struct {
+	// Source is the ID of the component the paths start at.
+	Source string `json:"source"`
+
+	// Target is the ID of the component the paths end at.
+	Target string `json:"target"`
+
+	// Weight is the weighting used to compute the cost of the paths.
+	Weight string `json:"weight"`
+
+	// Shortest is the path with the lowest cost, or null if the
+	// target cannot be reached from the source.
+	Shortest *ArchitecturePath `json:"shortest"`
+
+	// Paths is the list of simple paths from the source to the
+	// target, ordered by cost.
+	Paths []ArchitecturePath `json:"paths"`
+
+	// PathsTruncated is set when there were more paths than the
+	// limit, when paths were longer than the maximum depth, or when
+	// the search gave up before every path was found. The paths that
+	// are listed are always the cheapest ones that were found.
+	PathsTruncated bool `json:"pathsTruncated"`
+}
+
+// ArchitecturePath represents a route through the connections.
+type ArchitecturePath struct {
+	// Components is the list of component IDs along the path,
+	// including the source and the target.
+	Components []string `json:"components"`
+
+	// Connections is the list of connection IDs along the path.
+	Connections []string `json:"connections"`
+
+	// Cost is the total cost of the path in the chosen weighting.
+	Cost float64 `json:"cost"`
+}
+
+// edgeWeight returns the cost of following the edge using the
+// weighting. The rate weighting uses the inverse of the rate in the
+// direction of the edge, and a rate of zero is treated as one byte
+// per second.
+func (g *architectureGraph) edgeWeight(e graphEdge, weight string) float64 {
+	c := g.arch.Connections[e.connection]
+	switch weight {
+	case pathWeightLatency:
+		return c.Latency
+	case pathWeightRate:
+		rate := c.OutRate
+		if !e.forward {
+			rate = c.InRate
+		}
+		return 1 / math.Max(float64(rate), 1)
+	default:
+		return 1
+	}
+}
+
+// findPaths finds the simple paths and the shortest path between the
+// source and the target components.
+func findPaths(arch Architecture, source, target, weight string, maxDepth, limit int) (PathResult, error) {
+	if weight != pathWeightHops && weight != pathWeightLatency && weight != pathWeightRate {
+		return PathResult{}, fmt.Errorf("invalid weight: %s", weight)
+	}
+
+	g := newArchitectureGraph(arch)
+	from, ok := g.index[source]
+	if !ok {
+		return PathResult{}, fmt.Errorf("unknown source: %s", source)
+	}
+	to, ok := g.index[target]
+	if !ok {
+		return PathResult{}, fmt.Errorf("unknown target: %s", target)
+	}
+
+	result := PathResult{
+		Source:   source,
+		Target:   target,
+		Weight:   weight,
+		Shortest: g.shortestPath(from, to, weight),
+	}
+	result.Paths, result.PathsTruncated = g.simplePaths(from, to, weight, maxDepth, limit)
+
+	return result, nil
+}
+
+// simplePaths returns the cheapest paths from one component to
+// another that do not visit any component twice, in order of cost. No
+// more than limit paths with at most maxDepth connections are
+// returned.
+//
+// The partial paths are searched best first, ordered by their cost
+// plus the lowest cost from their end to the target, so the paths are
+// found in order of cost. Partial paths that can no longer reach the
+// target within the maximum depth are never extended, and the search
+// gives up after maxPathSteps partial paths.
+func (g *architectureGraph) simplePaths(from, to int, weight string, maxDepth, limit int) ([]ArchitecturePath, bool) {
+	paths := []ArchitecturePath{}
+	truncated := false
+
+	costs := g.distancesTo(to, weight)
+	hops := g.distancesTo(to, pathWeightHops)
+	if math.IsInf(costs[from], 1) {
+		return paths, false
+	}
+
+	queue := &partialPathQueue{{end: from, priority: costs[from]}}
+	for steps := 0; queue.Len() > 0; steps++ {
+		if steps >= maxPathSteps {
+			truncated = true
+			break
+		}
+
+		p := heap.Pop(queue).(partialPath)
+		if p.end == to {
+			if len(paths) >= limit {
+				truncated = true
+				break
+			}
+			paths = append(paths, g.pathFromEdges(from, p.edges, weight))
+			continue
+		}
+
+		for _, e := range g.out[p.end] {
+			if math.IsInf(costs[e.to], 1) || p.visits(from, e.to) {
+				continue
+			}
+			if len(p.edges)+1+int(hops[e.to]) > maxDepth {
+				truncated = true
+				continue
+			}
+			edges := make([]graphEdge, len(p.edges), len(p.edges)+1)
+			copy(edges, p.edges)
+			cost := p.cost + g.edgeWeight(e, weight)
+			heap.Push(queue, partialPath{
+				edges:    append(edges, e),
+				end:      e.to,
+				cost:     cost,
+				priority: cost + costs[e.to],
+			})
+		}
+	}
+
+	return paths, truncated
+}
+
+// distancesTo returns the lowest cost from every component to the
+// target using Dijkstra's algorithm on the reversed edges. Components
+// that cannot reach the target have an infinite cost.
+func (g *architectureGraph) distancesTo(to int, weight string) []float64 {
+	dist := make([]float64, g.size())
+	done := make([]bool, g.size())
+	for i := range dist {
+		dist[i] = math.Inf(1)
+	}
+	dist[to] = 0
+
+	queue := &pathQueue{{node: to, dist: 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathQueueItem)
+		if done[item.node] {
+			continue
+		}
+		done[item.node] = true
+
+		for _, e := range g.in[item.node] {
+			d := dist[item.node] + g.edgeWeight(e, weight)
+			if d < dist[e.from] {
+				dist[e.from] = d
+				heap.Push(queue, pathQueueItem{node: e.from, dist: d})
+			}
+		}
+	}
+
+	return dist
+}
+
+// shortestPath returns the path with the lowest cost from one
+// component to another using Dijkstra's algorithm, or nil if there is
+// no path.
+func (g *architectureGraph) shortestPath(from, to int, weight string) *ArchitecturePath {
+	dist := make([]float64, g.size())
+	prev := make([]graphEdge, g.size())
+	done := make([]bool, g.size())
+	for i := range dist {
+		dist[i] = math.Inf(1)
+	}
+	dist[from] = 0
+
+	queue := &pathQueue{{node: from, dist: 0}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathQueueItem)
+		if done[item.node] {
+			continue
+		}
+		done[item.node] = true
+		if item.node == to {
+			break
+		}
+
+		for _, e := range g.out[item.node] {
+			d := dist[item.node] + g.edgeWeight(e, weight)
+			if d < dist[e.to] {
+				dist[e.to] = d
+				prev[e.to] = e
+				heap.Push(queue, pathQueueItem{node: e.to, dist: d})
+			}
+		}
+	}
+
+	if math.IsInf(dist[to], 1) {
+		return nil
+	}
+
+	// Walk back from the target to recover the edges of the path
+	var edges []graphEdge
+	for v := to; v != from; v = prev[v].from {
+		edges = append([]graphEdge{prev[v]}, edges...)
+	}
+	path := g.pathFromEdges(from, edges, weight)
+
+	return &path
+}
+
+// pathFromEdges converts the edges starting at the component into a
+// path and computes its cost.
+func (g *architectureGraph) pathFromEdges(from int, edges []graphEdge, weight string) ArchitecturePath {
+	path := ArchitecturePath{
+		Components:  []string{g.id(from)},
+		Connections: []string{},
+	}
+	for _, e := range edges {
+		path.Components = append(path.Components, g.id(e.to))
+		path.Connections = append(path.Connections, g.connectionID(e))
+		path.Cost += g.edgeWeight(e, weight)
+	}
+	return path
+}
+
+// partialPath is a path from the source that has not reached the
+// target yet.
+type partialPath struct {
+	edges []graphEdge
+	end   int
+	cost  float64
+
+	// priority is the cost of the path plus the lowest cost from its
+	// end to the target.
+	priority float64
+}
+
+// visits returns true if the path, which starts at the component,
+// already visits the other component.
+func (p partialPath) visits(from, i int) bool {
+	if i == from {
+		return true
+	}
+	for _, e := range p.edges {
+		if e.to == i {
+			return true
+		}
+	}
+	return false
+}
+
+// partialPathQueue is a priority queue of partial paths ordered by
+// priority.
+type partialPathQueue []partialPath
+
+func (q partialPathQueue) Len() int            { return len(q) }
+func (q partialPathQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q partialPathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *partialPathQueue) Push(x interface{}) { *q = append(*q, x.(partialPath)) }
+func (q *partialPathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// pathQueueItem is an item in the priority queue used by Dijkstra's
+// algorithm.
+type pathQueueItem struct {
+	node int
+	dist float64
+}
+
+// pathQueue is a priority queue of components ordered by distance.
+type pathQueue []pathQueueItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathQueueItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// handleGetPaths handles GET requests for the paths between two
+// components.
+// GET /architectures/${architectureID}/paths?source=${componentID}&target=${componentID}
+//
+//	return the cheapest simple paths, in order of cost, and the
+//	shortest path from the source to the target. The optional
+//	parameters are:
+//	  weight    "hops" (default), "latency" or "rate"
+//	  maxDepth  the maximum number of connections in a path
+//	  limit     the maximum number of paths to list
+func (h *ArchitectureHandler) handleGetPaths(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	weight := query.Get("weight")
+	if weight == "" {
+		weight = pathWeightHops
+	}
+	maxDepth, err := queryInt(query.Get("maxDepth"), defaultPathDepth, 1, maxPathDepth)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid maxDepth: %v", err)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultPathLimit, 1, maxPathLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid limit: %v", err)
+		return
+	}
+
+	result, err := findPaths(arch, query.Get("source"), query.Get("target"), weight, maxDepth, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to find paths: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, result)
+}
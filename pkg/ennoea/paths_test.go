@@ -0,0 +1,129 @@
+package ennoea
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindPaths(t *testing.T) {
+	// a reaches d directly, through b, and through b and c, and e
+	// cannot reach d at all
+	arch := testArchitecture([]string{"a", "b", "c", "d", "e"},
+		"a>b", "b>c", "c>d", "b>d", "a>d", "a>e", "e>a")
+
+	tests := []struct {
+		name      string
+		maxDepth  int
+		limit     int
+		paths     [][]string
+		truncated bool
+	}{
+		{
+			name:     "every path in order of hops",
+			maxDepth: 10,
+			limit:    10,
+			paths:    [][]string{{"a", "d"}, {"a", "b", "d"}, {"a", "b", "c", "d"}},
+		},
+		{
+			name:      "cheapest paths up to the limit",
+			maxDepth:  10,
+			limit:     2,
+			paths:     [][]string{{"a", "d"}, {"a", "b", "d"}},
+			truncated: true,
+		},
+		{
+			name:      "paths up to the depth",
+			maxDepth:  2,
+			limit:     10,
+			paths:     [][]string{{"a", "d"}, {"a", "b", "d"}},
+			truncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := findPaths(arch, "a", "d", pathWeightHops, test.maxDepth, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths [][]string
+			for _, p := range result.Paths {
+				paths = append(paths, p.Components)
+			}
+			if !reflect.DeepEqual(paths, test.paths) {
+				t.Errorf("paths = %v, want %v", paths, test.paths)
+			}
+			if result.PathsTruncated != test.truncated {
+				t.Errorf("truncated = %v, want %v", result.PathsTruncated, test.truncated)
+			}
+		})
+	}
+}
+
+func TestFindPathsUnreachableTarget(t *testing.T) {
+	// Every component can be reached from a, but none of them reach z,
+	// so the paths through the layers must not be searched
+	var components, connections []string
+	components = append(components, "a", "z")
+	for layer := 0; layer < 30; layer++ {
+		for i := 0; i < 3; i++ {
+			components = append(components, fmt.Sprintf("%d-%d", layer, i))
+			if layer == 0 {
+				connections = append(connections, fmt.Sprintf("a>0-%d", i))
+				continue
+			}
+			for j := 0; j < 3; j++ {
+				connections = append(connections, fmt.Sprintf("%d-%d>%d-%d", layer-1, j, layer, i))
+			}
+		}
+	}
+	connections = append(connections, "z>a")
+
+	start := time.Now()
+	result, err := findPaths(testArchitecture(components, connections...), "a", "z", pathWeightHops, maxPathDepth, maxPathLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Paths) != 0 || result.PathsTruncated || result.Shortest != nil {
+		t.Errorf("got %d paths, truncated %v", len(result.Paths), result.PathsTruncated)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("paths took %v", elapsed)
+	}
+}
+
+func TestFindPathsWorkBudget(t *testing.T) {
+	// A layered graph that reaches the target has too many paths to
+	// search, so the search stops with the cheapest paths it found
+	var components, connections []string
+	components = append(components, "a", "z")
+	for layer := 0; layer < 30; layer++ {
+		for i := 0; i < 3; i++ {
+			components = append(components, fmt.Sprintf("%d-%d", layer, i))
+			if layer == 0 {
+				connections = append(connections, fmt.Sprintf("a>0-%d", i))
+			} else {
+				for j := 0; j < 3; j++ {
+					connections = append(connections, fmt.Sprintf("%d-%d>%d-%d", layer-1, j, layer, i))
+				}
+			}
+			if layer == 29 {
+				connections = append(connections, fmt.Sprintf("29-%d>z", i))
+			}
+		}
+	}
+
+	start := time.Now()
+	result, err := findPaths(testArchitecture(components, connections...), "a", "z", pathWeightHops, maxPathDepth, maxPathLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Paths) == 0 || !result.PathsTruncated {
+		t.Errorf("got %d paths, truncated %v", len(result.Paths), result.PathsTruncated)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("paths took %v", elapsed)
+	}
+}
@@ -0,0 +1,493 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+/*
+The pcap importer builds an architecture from a packet capture, in the
+classic pcap or the pcapng format, which can be gzip compressed. The
+TCP and UDP packets are put together into conversations between two
+endpoints, and each conversation is added to the connection from its
+client to its server like the flows of the flows importer, with the
+same configuration. Hosts become components, or ports of hosts with
+"ports": true.
+
+The client of a TCP conversation is the end that sent the SYN, or the
+end that did not send the SYN-ACK. Conversations whose handshake is not
+in the capture, and UDP conversations, have the end with the lower port
+as their server, or the end that sent the first packet as their client
+if the ports are the same. The bytes of a packet are its IP length, so
+packets cut short by the snapshot length are counted in full. The
+connections have the labels of the flows importer, with the number of
+conversations in labels.conversations instead of labels.flows.
+
+Ethernet, with VLAN tags, Linux cooked captures, loopback and raw IP
+captures can be read.
+*/
+
+// Magic numbers of capture files.
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+	pcapngSectionHeader   = 0x0a0d0d0a
+	pcapngByteOrderMagic  = 0x1a2b3c4d
+)
+
+// Link types of capture files.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// TCP flags that make up the handshake.
+const (
+	tcpSYN = 0x02
+	tcpACK = 0x10
+)
+
+// pcapConversation accumulates the packets between two endpoints. The
+// first endpoint sent the first packet.
+type pcapConversation struct {
+	ends     [2]netip.AddrPort
+	protocol uint8
+	bytes    [2]uint64
+	packets  [2]uint64
+
+	// client is the index of the end that is the client, or -1 if the
+	// handshake has not been seen.
+	client int
+
+	// start and end are the times of the first and last packets in
+	// nanoseconds since the epoch.
+	start, end int64
+}
+
+// pcapKey identifies a conversation by its ends, in order, and its
+// protocol.
+type pcapKey struct {
+	low, high netip.AddrPort
+	protocol  uint8
+}
+
+// pcapImport holds the state of a pcap import.
+type pcapImport struct {
+	result        *ImportResult
+	conversations map[pcapKey]*pcapConversation
+	order         []*pcapConversation
+
+	// skipped is the number of packets that are not TCP or UDP, or
+	// that are fragments after the first.
+	skipped int
+
+	// malformed is the number of packets that could not be decoded.
+	malformed int
+
+	// linkTypes is the unsupported link types that have been warned
+	// about.
+	linkTypes map[uint32]bool
+
+	// last is the time of the last packet, which is the time given to
+	// simple packets.
+	last int64
+}
+
+// importPCAP imports the TCP and UDP conversations of a classic pcap
+// or pcapng capture.
+func importPCAP(data, config []byte) (ImportResult, error) {
+	var result ImportResult
+	f, err := newFlowImport(&result, config, "conversations")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	data, err = gunzipImport(data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	p := &pcapImport{
+		result:        &result,
+		conversations: make(map[pcapKey]*pcapConversation),
+		linkTypes:     make(map[uint32]bool),
+	}
+	if len(data) < 4 {
+		return ImportResult{}, fmt.Errorf("expected a pcap or pcapng capture")
+	}
+	switch {
+	case binary.LittleEndian.Uint32(data) == pcapngSectionHeader:
+		err = p.readPcapng(data)
+	default:
+		err = p.readPcap(data)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if p.skipped > 0 {
+		result.warnf("%d packets that are not TCP or UDP, or are later fragments, were skipped", p.skipped)
+	}
+	if p.malformed > 0 {
+		result.warnf("%d packets could not be decoded", p.malformed)
+	}
+	if len(p.order) == 0 {
+		return ImportResult{}, fmt.Errorf("no tcp or udp packets found")
+	}
+
+	for _, c := range p.order {
+		f.addConversation(c.conversation())
+	}
+	if err := f.complete(); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// readPcap reads the packets of a classic pcap file.
+func (p *pcapImport) readPcap(data []byte) error {
+	if len(data) < 24 {
+		return fmt.Errorf("expected a pcap or pcapng capture")
+	}
+	var order binary.ByteOrder
+	var scale int64
+	for _, o := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch o.Uint32(data) {
+		case pcapMagicMicroseconds:
+			order, scale = o, 1000
+		case pcapMagicNanoseconds:
+			order, scale = o, 1
+		}
+	}
+	if order == nil {
+		return fmt.Errorf("expected a pcap or pcapng capture")
+	}
+	linkType := order.Uint32(data[20:]) & 0xffff
+
+	for offset := 24; offset < len(data); {
+		if len(data)-offset < 16 {
+			return fmt.Errorf("truncated packet header at byte %d", offset)
+		}
+		header := data[offset:]
+		seconds := int64(order.Uint32(header))
+		fraction := int64(order.Uint32(header[4:]))
+		length := int(order.Uint32(header[8:]))
+		if length > len(data)-offset-16 {
+			return fmt.Errorf("truncated packet at byte %d", offset)
+		}
+		p.packet(linkType, seconds*1e9+fraction*scale, header[16:16+length])
+		offset += 16 + length
+	}
+	return nil
+}
+
+// pcapngInterface is an interface of a pcapng section. The resolution
+// is the number of nanoseconds of a timestamp unit.
+type pcapngInterface struct {
+	linkType   uint32
+	resolution float64
+}
+
+// readPcapng reads the packets of a pcapng file.
+func (p *pcapImport) readPcapng(data []byte) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 12 {
+			return fmt.Errorf("truncated block at byte %d", offset)
+		}
+		block := data[offset:]
+		blockType := order.Uint32(block)
+		if blockType == pcapngSectionHeader {
+			// Each section has its own byte order and interfaces
+			switch {
+			case binary.LittleEndian.Uint32(block[8:]) == pcapngByteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(block[8:]) == pcapngByteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return fmt.Errorf("invalid byte order magic at byte %d", offset)
+			}
+			interfaces = nil
+		}
+		length := int(order.Uint32(block[4:]))
+		if length < 12 || length%4 != 0 || length > len(block) {
+			return fmt.Errorf("invalid block length %d at byte %d", length, offset)
+		}
+		body := block[8 : length-4]
+		offset += length
+
+		switch blockType {
+		case 1:
+			// An interface description
+			if len(body) < 8 {
+				return fmt.Errorf("truncated interface description at byte %d", offset-length)
+			}
+			interfaces = append(interfaces, pcapngInterface{
+				linkType:   uint32(order.Uint16(body)),
+				resolution: pcapngResolution(order, body[8:]),
+			})
+		case 6:
+			// An enhanced packet
+			if len(body) < 20 {
+				return fmt.Errorf("truncated packet at byte %d", offset-length)
+			}
+			id := int(order.Uint32(body))
+			captured := int(order.Uint32(body[12:]))
+			if id >= len(interfaces) || captured > len(body)-20 {
+				p.malformed++
+				continue
+			}
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			i := interfaces[id]
+			p.packet(i.linkType, int64(float64(ts)*i.resolution), body[20:20+captured])
+		case 3:
+			// A simple packet, which has no time
+			if len(body) < 4 || len(interfaces) == 0 {
+				p.malformed++
+				continue
+			}
+			p.packet(interfaces[0].linkType, p.last, body[4:])
+		}
+	}
+	return nil
+}
+
+// pcapngResolution returns the number of nanoseconds of a timestamp
+// unit from the options of an interface description. The default is a
+// microsecond.
+func pcapngResolution(order binary.ByteOrder, options []byte) float64 {
+	for len(options) >= 4 {
+		code := order.Uint16(options)
+		length := int(order.Uint16(options[2:]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+		if code == 9 && length >= 1 {
+			// The if_tsresol option is a power of 10, or of 2 if the
+			// top bit is set
+			value := options[4]
+			if value&0x80 != 0 {
+				return 1e9 / math.Pow(2, float64(value&0x7f))
+			}
+			return 1e9 / math.Pow(10, float64(value))
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return 1000
+}
+
+// packet adds a captured packet to its conversation.
+func (p *pcapImport) packet(linkType uint32, time int64, data []byte) {
+	p.last = time
+	ip, ok := p.linkPayload(linkType, data)
+	if !ok {
+		return
+	}
+	if len(ip) == 0 {
+		p.malformed++
+		return
+	}
+
+	var src, dst netip.Addr
+	var protocol uint8
+	var length int
+	var transport []byte
+	switch ip[0] >> 4 {
+	case 4:
+		headerLength := int(ip[0]&0x0f) * 4
+		if len(ip) < 20 || headerLength < 20 || len(ip) < headerLength {
+			p.malformed++
+			return
+		}
+		length = int(binary.BigEndian.Uint16(ip[2:]))
+		protocol = ip[9]
+		src = netip.AddrFrom4([4]byte(ip[12:16]))
+		dst = netip.AddrFrom4([4]byte(ip[16:20]))
+		if binary.BigEndian.Uint16(ip[6:])&0x1fff != 0 {
+			// Only the first fragment has the ports
+			p.skipped++
+			return
+		}
+		transport = ip[headerLength:]
+	case 6:
+		if len(ip) < 40 {
+			p.malformed++
+			return
+		}
+		length = 40 + int(binary.BigEndian.Uint16(ip[4:]))
+		src = netip.AddrFrom16([16]byte(ip[8:24]))
+		dst = netip.AddrFrom16([16]byte(ip[24:40]))
+		protocol = ip[6]
+		transport = ip[40:]
+		// Skip the extension headers
+		for protocol == 0 || protocol == 43 || protocol == 44 || protocol == 51 || protocol == 60 {
+			if len(transport) < 8 {
+				p.malformed++
+				return
+			}
+			size := (int(transport[1]) + 1) * 8
+			switch protocol {
+			case 44:
+				if binary.BigEndian.Uint16(transport[2:])&0xfff8 != 0 {
+					p.skipped++
+					return
+				}
+				size = 8
+			case 51:
+				size = (int(transport[1]) + 2) * 4
+			}
+			if len(transport) < size {
+				p.malformed++
+				return
+			}
+			protocol = transport[0]
+			transport = transport[size:]
+		}
+	default:
+		p.malformed++
+		return
+	}
+
+	if protocol != 6 && protocol != 17 {
+		p.skipped++
+		return
+	}
+	if len(transport) < 4 || (protocol == 6 && len(transport) < 14) {
+		p.malformed++
+		return
+	}
+	from := netip.AddrPortFrom(src, binary.BigEndian.Uint16(transport))
+	to := netip.AddrPortFrom(dst, binary.BigEndian.Uint16(transport[2:]))
+
+	key := pcapKey{low: from, high: to, protocol: protocol}
+	if to.Addr().Less(from.Addr()) || (to.Addr() == from.Addr() && to.Port() < from.Port()) {
+		key.low, key.high = to, from
+	}
+	c, ok := p.conversations[key]
+	if !ok {
+		c = &pcapConversation{ends: [2]netip.AddrPort{from, to}, protocol: protocol, client: -1, start: time}
+		p.conversations[key] = c
+		p.order = append(p.order, c)
+	}
+	side := 0
+	if from != c.ends[0] {
+		side = 1
+	}
+	c.bytes[side] += uint64(length)
+	c.packets[side]++
+	if time < c.start {
+		c.start = time
+	}
+	if time > c.end {
+		c.end = time
+	}
+
+	if protocol == 6 && c.client < 0 {
+		flags := transport[13]
+		switch {
+		case flags&tcpSYN != 0 && flags&tcpACK == 0:
+			c.client = side
+		case flags&tcpSYN != 0:
+			c.client = 1 - side
+		}
+	}
+}
+
+// linkPayload returns the IP packet of a frame, or false if the frame
+// does not carry IP or its link type is not supported.
+func (p *pcapImport) linkPayload(linkType uint32, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			p.malformed++
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 || etherType == 0x9100 {
+			// VLAN tags
+			if len(data) < 4 {
+				p.malformed++
+				return nil, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		return p.etherPayload(etherType, data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			p.malformed++
+			return nil, false
+		}
+		return p.etherPayload(binary.BigEndian.Uint16(data[14:]), data[16:])
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			p.malformed++
+			return nil, false
+		}
+		return p.etherPayload(binary.BigEndian.Uint16(data), data[20:])
+	case linkTypeNull, linkTypeLoop:
+		// The address family is in the byte order of the host that
+		// captured it, so either end of the word holds it
+		if len(data) < 4 {
+			p.malformed++
+			return nil, false
+		}
+		family := data[0] | data[3]
+		switch family {
+		case 2, 24, 28, 30:
+			return data[4:], true
+		}
+		p.skipped++
+		return nil, false
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return data, true
+	}
+	if !p.linkTypes[linkType] {
+		p.linkTypes[linkType] = true
+		p.result.warnf("packets of link type %d are not supported", linkType)
+	}
+	return nil, false
+}
+
+// etherPayload returns the payload of an IPv4 or IPv6 ether type.
+func (p *pcapImport) etherPayload(etherType uint16, data []byte) ([]byte, bool) {
+	if etherType != 0x0800 && etherType != 0x86dd {
+		p.skipped++
+		return nil, false
+	}
+	return data, true
+}
+
+// conversation returns the traffic of the conversation from its client
+// to its server.
+func (c *pcapConversation) conversation() flowConversation {
+	client := c.client
+	if client < 0 {
+		client = 0
+		if c.ends[1].Port() > c.ends[0].Port() {
+			client = 1
+		}
+	}
+	server := 1 - client
+	return flowConversation{
+		client:     c.ends[client].Addr(),
+		clientPort: c.ends[client].Port(),
+		server:     c.ends[server].Addr(),
+		serverPort: c.ends[server].Port(),
+		protocol:   c.protocol,
+		outBytes:   c.bytes[client],
+		outPackets: c.packets[client],
+		inBytes:    c.bytes[server],
+		inPackets:  c.packets[server],
+		start:      c.start / 1e6,
+		end:        c.end / 1e6,
+	}
+}
@@ -0,0 +1,228 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// testIPv4 returns an IPv4 packet from the source to the destination
+// address that carries the transport header and payload.
+func testIPv4(src, dst string, protocol byte, transport []byte) []byte {
+	ip := make([]byte, 20, 20+len(transport))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(transport)))
+	ip[8] = 64
+	ip[9] = protocol
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(ip[12:], s[:])
+	copy(ip[16:], d[:])
+	return append(ip, transport...)
+}
+
+// testTCP returns a TCP header with the flags, followed by a payload of
+// the size.
+func testTCP(srcPort, dstPort uint16, flags byte, payload int) []byte {
+	tcp := make([]byte, 20+payload)
+	binary.BigEndian.PutUint16(tcp, srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	return tcp
+}
+
+// testUDP returns a UDP header followed by a payload of the size.
+func testUDP(srcPort, dstPort uint16, payload int) []byte {
+	udp := make([]byte, 8+payload)
+	binary.BigEndian.PutUint16(udp, srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+payload))
+	return udp
+}
+
+// testEthernet returns an Ethernet frame of the ether type.
+func testEthernet(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+// testPcap returns a little endian classic pcap capture of the frames,
+// one second apart.
+func testPcap(linkType uint32, frames ...[]byte) []byte {
+	order := binary.LittleEndian
+	data := make([]byte, 24)
+	order.PutUint32(data, pcapMagicMicroseconds)
+	order.PutUint16(data[4:], 2)
+	order.PutUint16(data[6:], 4)
+	order.PutUint32(data[16:], 65535)
+	order.PutUint32(data[20:], linkType)
+	for i, frame := range frames {
+		header := make([]byte, 16)
+		order.PutUint32(header, uint32(1700000000+i))
+		order.PutUint32(header[8:], uint32(len(frame)))
+		order.PutUint32(header[12:], uint32(len(frame)))
+		data = append(append(data, header...), frame...)
+	}
+	return data
+}
+
+// testPcapng returns a little endian pcapng capture of the frames, one
+// second apart, with one interface of the link type.
+func testPcapng(linkType uint32, frames ...[]byte) []byte {
+	order := binary.LittleEndian
+	block := func(blockType uint32, body []byte) []byte {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		b := make([]byte, 8, 12+len(body))
+		order.PutUint32(b, blockType)
+		order.PutUint32(b[4:], uint32(12+len(body)))
+		b = append(b, body...)
+		return order.AppendUint32(b, uint32(12+len(body)))
+	}
+
+	section := make([]byte, 16)
+	order.PutUint32(section, pcapngByteOrderMagic)
+	order.PutUint16(section[4:], 1)
+	order.PutUint64(section[8:], ^uint64(0))
+	data := block(pcapngSectionHeader, section)
+
+	description := make([]byte, 8)
+	order.PutUint16(description, uint16(linkType))
+	order.PutUint32(description[4:], 65535)
+	data = append(data, block(1, description)...)
+
+	for i, frame := range frames {
+		packet := make([]byte, 20, 20+len(frame))
+		ts := uint64(1700000000+i) * 1e6
+		order.PutUint32(packet[4:], uint32(ts>>32))
+		order.PutUint32(packet[8:], uint32(ts))
+		order.PutUint32(packet[12:], uint32(len(frame)))
+		order.PutUint32(packet[16:], uint32(len(frame)))
+		data = append(data, block(6, append(packet, frame...))...)
+	}
+	return data
+}
+
+// testHandshake returns the frames of a TCP conversation from the
+// client to the server that starts with a handshake, if it is true.
+func testHandshake(client, server string, clientPort, serverPort uint16, handshake bool) [][]byte {
+	var frames [][]byte
+	if handshake {
+		frames = append(frames,
+			testIPv4(client, server, 6, testTCP(clientPort, serverPort, tcpSYN, 0)),
+			testIPv4(server, client, 6, testTCP(serverPort, clientPort, tcpSYN|tcpACK, 0)))
+	}
+	return append(frames,
+		testIPv4(client, server, 6, testTCP(clientPort, serverPort, tcpACK, 100)),
+		testIPv4(server, client, 6, testTCP(serverPort, clientPort, tcpACK, 1000)))
+}
+
+// testEthernetFrames wraps the IPv4 packets in Ethernet frames.
+func testEthernetFrames(packets [][]byte) [][]byte {
+	frames := make([][]byte, len(packets))
+	for i, p := range packets {
+		frames[i] = testEthernet(0x0800, p)
+	}
+	return frames
+}
+
+func TestImportPCAP(t *testing.T) {
+	handshake := testHandshake("10.0.0.1", "10.0.0.2", 50000, 443, true)
+	reversed := testHandshake("10.0.0.1", "10.0.0.2", 80, 8080, true)
+	midstream := testHandshake("10.0.0.1", "10.0.0.2", 80, 8080, false)
+	dns := testIPv4("10.0.0.3", "10.0.0.4", 17, testUDP(53000, 53, 40))
+	arp := testEthernet(0x0806, make([]byte, 28))
+
+	testImports(t, "pcap", []importTest{
+		{
+			name: "ethernet handshake",
+			data: string(testPcap(linkTypeEthernet, testEthernetFrames(handshake)...)),
+			want: []string{
+				`component 10.0.0.1 "10.0.0.1" box`,
+				`component 10.0.0.2 "10.0.0.2" box`,
+				`connection 10.0.0.1->10.0.0.2 bi`,
+			},
+		},
+		{
+			name: "client on the lower port",
+			data: string(testPcap(linkTypeRaw, reversed...)),
+			want: []string{
+				`component 10.0.0.1 "10.0.0.1" box`,
+				`component 10.0.0.2 "10.0.0.2" box`,
+				`connection 10.0.0.1->10.0.0.2 bi`,
+			},
+		},
+		{
+			name: "no handshake",
+			data: string(testPcap(linkTypeRaw, midstream...)),
+			want: []string{
+				`component 10.0.0.2 "10.0.0.2" box`,
+				`component 10.0.0.1 "10.0.0.1" box`,
+				`connection 10.0.0.2->10.0.0.1 bi`,
+			},
+		},
+		{
+			name: "pcapng udp",
+			data: string(testPcapng(linkTypeRaw, dns)),
+			want: []string{
+				`component 10.0.0.3 "10.0.0.3" box`,
+				`component 10.0.0.4 "10.0.0.4" box`,
+				`connection 10.0.0.3->10.0.0.4 out`,
+			},
+		},
+		{
+			name: "pcapng ethernet with arp",
+			data: string(testPcapng(linkTypeEthernet, append(testEthernetFrames(handshake), arp)...)),
+			want: []string{
+				`component 10.0.0.1 "10.0.0.1" box`,
+				`component 10.0.0.2 "10.0.0.2" box`,
+				`connection 10.0.0.1->10.0.0.2 bi`,
+				`warning 1 packets that are not TCP or UDP, or are later fragments, were skipped`,
+			},
+		},
+		{
+			name: "unsupported link type",
+			data: string(testPcap(147, dns)),
+			err:  "no tcp or udp packets found",
+		},
+		{
+			name: "truncated packet",
+			data: string(testPcap(linkTypeRaw, dns)[:50]),
+			err:  "truncated packet",
+		},
+		{
+			name: "not a capture",
+			data: "GET / HTTP/1.1\r\n\r\n",
+			err:  "expected a pcap or pcapng capture",
+		},
+	})
+}
+
+func FuzzReadPcap(f *testing.F) {
+	f.Add(testPcap(linkTypeEthernet, testEthernetFrames(testHandshake("10.0.0.1", "10.0.0.2", 50000, 443, true))...))
+	f.Add(testPcap(linkTypeRaw, testIPv4("10.0.0.3", "10.0.0.4", 17, testUDP(53000, 53, 40))))
+	f.Add(testPcap(linkTypeLinuxSLL, make([]byte, 16)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := &pcapImport{
+			result:        &ImportResult{},
+			conversations: make(map[pcapKey]*pcapConversation),
+			linkTypes:     make(map[uint32]bool),
+		}
+		p.readPcap(data)
+	})
+}
+
+func FuzzReadPcapng(f *testing.F) {
+	f.Add(testPcapng(linkTypeEthernet, testEthernetFrames(testHandshake("10.0.0.1", "10.0.0.2", 50000, 443, true))...))
+	f.Add(testPcapng(linkTypeRaw, testIPv4("10.0.0.3", "10.0.0.4", 17, testUDP(53000, 53, 40))))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := &pcapImport{
+			result:        &ImportResult{},
+			conversations: make(map[pcapKey]*pcapConversation),
+			linkTypes:     make(map[uint32]bool),
+		}
+		p.readPcapng(data)
+	})
+}
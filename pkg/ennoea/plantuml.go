@@ -0,0 +1,479 @@
+package ennoea
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+The plantuml importer reads PlantUML component and deployment
+diagrams. Elements become components, relations become connections,
+and elements that contain other elements, such as packages and nodes
+with braces, become groups. The keyword of an element picks the type
+and geometry of its component, and the shorthands [Component],
+() Interface and :Actor: can be used on their own or in relations.
+
+Relations with an arrow at one end flow in its direction, and relations
+with arrows at both ends or none flow both ways. Direction hints and
+styles in the arrows, such as -up-> and -[#red]->, are ignored. Notes,
+skinparams and other parts that only change how the diagram is drawn
+are skipped.
+*/
+
+// plantumlElement is the type and geometry of the components of an
+// element keyword.
+type plantumlElement struct {
+	componentType string
+	geometry      string
+}
+
+// plantumlElements is a map of element keywords to the type and
+// geometry of their components.
+var plantumlElements = map[string]plantumlElement{
+	"action":      {"app", "box"},
+	"actor":       {"app", "capsule"},
+	"actor/":      {"app", "capsule"},
+	"agent":       {"app", "box"},
+	"artifact":    {"app", "box"},
+	"boundary":    {"app", "ring"},
+	"card":        {"app", "box"},
+	"circle":      {"app", "sphere"},
+	"cloud":       {"app", "sphere"},
+	"collections": {"app", "box"},
+	"component":   {"app", "box"},
+	"control":     {"app", "sphere"},
+	"database":    {"server", "cylinder"},
+	"entity":      {"app", "box"},
+	"file":        {"app", "plane"},
+	"folder":      {"app", "box"},
+	"frame":       {"app", "box"},
+	"hexagon":     {"app", "dodecahedron"},
+	"interface":   {"app", "sphere"},
+	"label":       {"app", "plane"},
+	"node":        {"server", "box"},
+	"person":      {"app", "capsule"},
+	"process":     {"app", "box"},
+	"queue":       {"app", "torus"},
+	"rectangle":   {"app", "box"},
+	"stack":       {"app", "box"},
+	"storage":     {"server", "cylinder"},
+	"usecase":     {"app", "sphere"},
+}
+
+// plantumlContainers is the keywords that can only contain elements.
+var plantumlContainers = map[string]bool{
+	"package":  true,
+	"together": true,
+}
+
+// plantumlSkipped is the keywords of the statements that only change
+// how the diagram is drawn.
+var plantumlSkipped = map[string]bool{
+	"skinparam":   true,
+	"hide":        true,
+	"show":        true,
+	"remove":      true,
+	"left":        true,
+	"top":         true,
+	"scale":       true,
+	"allowmixing": true,
+	"sprite":      true,
+}
+
+// plantumlBlocks is a map of the keywords of the blocks that are
+// skipped to the lines that end them.
+var plantumlBlocks = map[string]string{
+	"note":    "end note",
+	"legend":  "endlegend",
+	"header":  "endheader",
+	"footer":  "endfooter",
+	"rnote":   "endrnote",
+	"hnote":   "endhnote",
+	"caption": "endcaption",
+}
+
+var (
+	// plantumlEndpointPattern matches the end of a relation: a
+	// [component], an :actor:, an () interface, a "quoted" name or an
+	// alias.
+	plantumlEndpointPattern = regexp.MustCompile(`^\s*(\[[^\]]+\]|:[^:]+:|\(\)\s*(?:"[^"]+"|[\w.]+)|"[^"]+"|\w+(?:\.\w+)*)`)
+
+	// plantumlArrowPattern matches the arrow of a relation, with an
+	// optional multiplicity on both sides.
+	plantumlArrowPattern = regexp.MustCompile(`^\s*(?:"[^"]*"\s*)?(<\|?|[*o#+])?([-.=]+(?:\[[^\]]*\])?(?:(?:left|right|up|down|le|ri|do|l|r|u|d)[-.=]+)?[-.=]*)(\|?>|[*o#+](?:\s|$))?\s*(?:"[^"]*"\s*)?`)
+
+	// plantumlIDPattern matches the characters of a name that are
+	// replaced in the ID of its element.
+	plantumlIDPattern = regexp.MustCompile(`[^a-z0-9_.]+`)
+)
+
+// plantumlImport holds the state of a PlantUML import.
+type plantumlImport struct {
+	diagram *diagram
+
+	// groups is the stack of open containers. Containers that do not
+	// become groups, like together, are nil.
+	groups []*diagramGroup
+
+	// aliases is a map of the names and aliases of the elements to
+	// their IDs.
+	aliases map[string]string
+	line    int
+}
+
+// importPlantUML imports a PlantUML component or deployment diagram.
+func importPlantUML(data, config []byte) (ImportResult, error) {
+	var result ImportResult
+	p := &plantumlImport{
+		diagram: newDiagram(&result),
+		aliases: make(map[string]string),
+	}
+
+	lines := strings.Split(string(data), "\n")
+	started := !strings.Contains(string(data), "@startuml")
+	blockEnd := ""
+	comment := false
+	for i, line := range lines {
+		p.line = i + 1
+		line = strings.TrimSpace(line)
+
+		// Block comments can start and end in the middle of lines
+		if comment {
+			end := strings.Index(line, "'/")
+			if end < 0 {
+				continue
+			}
+			line, comment = strings.TrimSpace(line[end+2:]), false
+		}
+		if start := strings.Index(line, "/'"); start >= 0 {
+			if end := strings.Index(line[start:], "'/"); end >= 0 {
+				line = strings.TrimSpace(line[:start] + line[start+end+2:])
+			} else {
+				line, comment = strings.TrimSpace(line[:start]), true
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@startuml"):
+			started = true
+			continue
+		case strings.HasPrefix(line, "@enduml"):
+			started = false
+			continue
+		case !started || line == "" || strings.HasPrefix(line, "'"):
+			continue
+		case blockEnd != "":
+			if strings.EqualFold(strings.Join(strings.Fields(line), " "), blockEnd) {
+				blockEnd = ""
+			}
+			continue
+		}
+
+		keyword := strings.ToLower(strings.Fields(line)[0])
+		switch {
+		case keyword == "title":
+			result.Architecture.Info.Name = strings.TrimSpace(line[len("title"):])
+			continue
+		case strings.HasPrefix(keyword, "!"):
+			result.warnf("line %d: preprocessor directive %s is not supported", p.line, keyword)
+			continue
+		case plantumlSkipped[keyword]:
+			if strings.HasSuffix(line, "{") {
+				blockEnd = "}"
+			}
+			continue
+		case plantumlBlocks[keyword] != "":
+			if plantumlBlockStarts(keyword, line) {
+				blockEnd = plantumlBlocks[keyword]
+			}
+			continue
+		}
+
+		if err := p.statement(line); err != nil {
+			result.warnf("line %d: %v", p.line, err)
+		}
+	}
+	if len(p.groups) > 0 {
+		result.warnf("a container is missing a closing brace")
+	}
+	if len(p.diagram.nodes) == 0 {
+		return ImportResult{}, fmt.Errorf("diagram has no elements")
+	}
+
+	p.diagram.architecture()
+	return result, nil
+}
+
+// plantumlBlockStarts returns true if the line starts a block that is
+// skipped, rather than being the whole of it. Notes on one line have
+// their text after a colon or in quotes, and headers, footers and
+// captions on one line have their text after the keyword.
+func plantumlBlockStarts(keyword, line string) bool {
+	switch keyword {
+	case "legend":
+		return true
+	case "note", "rnote", "hnote":
+		return !strings.ContainsAny(line, `:"`)
+	}
+	return len(strings.Fields(line)) == 1
+}
+
+// group returns the innermost container that is a group, or nil if
+// there is none.
+func (p *plantumlImport) group() *diagramGroup {
+	for i := len(p.groups) - 1; i >= 0; i-- {
+		if p.groups[i] != nil {
+			return p.groups[i]
+		}
+	}
+	return nil
+}
+
+// statement reads an element, a relation or the end of a container.
+func (p *plantumlImport) statement(line string) error {
+	if line == "}" {
+		if len(p.groups) == 0 {
+			return fmt.Errorf("closing brace without a container")
+		}
+		p.groups = p.groups[:len(p.groups)-1]
+		return nil
+	}
+
+	keyword := strings.ToLower(strings.Fields(line)[0])
+	if _, ok := plantumlElements[keyword]; ok || plantumlContainers[keyword] {
+		return p.element(keyword, strings.TrimSpace(line[len(keyword):]))
+	}
+	if !isPlantUMLRelation(line) {
+		// The shorthands can declare elements on their own
+		switch {
+		case strings.HasPrefix(line, "["):
+			return p.element("component", line)
+		case strings.HasPrefix(line, ":"):
+			return p.element("actor", line)
+		case strings.HasPrefix(line, "()"):
+			return p.element("interface", strings.TrimSpace(line[2:]))
+		}
+	}
+	return p.relation(line)
+}
+
+// isPlantUMLRelation returns true if the line starts with an element
+// followed by an arrow.
+func isPlantUMLRelation(line string) bool {
+	match := plantumlEndpointPattern.FindString(line)
+	if match == "" {
+		return false
+	}
+	arrow := plantumlArrowPattern.FindStringSubmatch(line[len(match):])
+	return arrow != nil && arrow[2] != ""
+}
+
+// element reads the declaration of an element, which is the rest of a
+// line such as `component "Web Server" as web <<Spring>> #lightblue`.
+// An element with an opening brace is a container.
+func (p *plantumlImport) element(keyword, spec string) error {
+	container := strings.HasSuffix(spec, "{")
+	spec = strings.TrimSpace(strings.TrimSuffix(spec, "{"))
+	if keyword == "together" {
+		p.groups = append(p.groups, nil)
+		return nil
+	}
+
+	name, rest, err := plantumlName(spec)
+	if err != nil {
+		return fmt.Errorf("%s %v", keyword, err)
+	}
+	alias := name
+	if after, ok := strings.CutPrefix(rest, "as "); ok {
+		// Either the name or the alias can be quoted
+		var other string
+		other, rest, err = plantumlName(strings.TrimSpace(after))
+		if err != nil {
+			return fmt.Errorf("%s %s %v", keyword, name, err)
+		}
+		if strings.HasPrefix(spec, `"`) || strings.HasPrefix(spec, "[") {
+			alias = other
+		} else {
+			alias, name = name, other
+		}
+	}
+
+	labels := make(map[string]string)
+	var color string
+	for _, field := range strings.Fields(rest) {
+		switch {
+		case strings.HasPrefix(field, "<<"):
+			labels["stereotype"] = strings.Trim(field, "<>")
+		case strings.HasPrefix(field, "#"):
+			value := strings.SplitN(strings.TrimPrefix(field, "#"), ";", 2)[0]
+			if !strings.Contains(value, ":") {
+				// Hex colours are written without the leading #
+				c, err := diagramColor(value)
+				if err != nil {
+					c, err = diagramColor("#" + value)
+				}
+				if err != nil {
+					p.diagram.result.warnf("line %d: %v", p.line, err)
+				}
+				color = c
+			}
+		}
+	}
+
+	if container {
+		g := p.diagram.group(p.id(alias), name, p.group())
+		g.color = color
+		g.labels["kind"] = keyword
+		for k, v := range labels {
+			g.labels[k] = v
+		}
+		p.groups = append(p.groups, g)
+		return nil
+	}
+	if plantumlContainers[keyword] {
+		return fmt.Errorf("%s %s has no elements", keyword, name)
+	}
+
+	element := plantumlElements[keyword]
+	n := p.diagram.node(p.id(alias), p.group())
+	n.name = name
+	n.componentType = element.componentType
+	n.geometry = element.geometry
+	n.labels["kind"] = strings.TrimSuffix(keyword, "/")
+	if color != "" {
+		n.color = color
+	}
+	for k, v := range labels {
+		n.labels[k] = v
+	}
+	if name != alias {
+		p.aliases[name] = n.id
+	}
+	return nil
+}
+
+// plantumlName reads a name that is quoted, in brackets, in colons or
+// a single word, and returns it with the rest of the line.
+func plantumlName(s string) (string, string, error) {
+	if s == "" {
+		return "", "", fmt.Errorf("is missing a name")
+	}
+	for _, delimiters := range []string{`""`, "[]", "::"} {
+		if s[0] != delimiters[0] {
+			continue
+		}
+		end := strings.IndexByte(s[1:], delimiters[1])
+		if end < 0 {
+			return "", "", fmt.Errorf("is missing a closing %c", delimiters[1])
+		}
+		return s[1 : end+1], strings.TrimSpace(s[end+2:]), nil
+	}
+	end := strings.IndexAny(s, " \t")
+	if end < 0 {
+		return s, "", nil
+	}
+	return s[:end], strings.TrimSpace(s[end:]), nil
+}
+
+// id returns the ID of the element with the name or alias, which is
+// the alias itself if it is a plain word.
+func (p *plantumlImport) id(alias string) string {
+	if id, ok := p.aliases[alias]; ok {
+		return id
+	}
+	id := strings.Trim(plantumlIDPattern.ReplaceAllString(strings.ToLower(alias), "-"), "-")
+	if id == "" {
+		id = "element"
+	}
+	p.aliases[alias] = id
+	return id
+}
+
+// relation reads a relation such as `[Web] ..> DB : reads`.
+func (p *plantumlImport) relation(line string) error {
+	source, rest, err := plantumlEndpoint(line)
+	if err != nil {
+		return err
+	}
+	match := plantumlArrowPattern.FindStringSubmatch(rest)
+	if match == nil || match[2] == "" {
+		return fmt.Errorf("expected a relation at %q", line)
+	}
+	target, rest, err := plantumlEndpoint(rest[len(match[0]):])
+	if err != nil {
+		return err
+	}
+
+	var label string
+	if rest = strings.TrimSpace(rest); rest != "" {
+		text, ok := strings.CutPrefix(rest, ":")
+		if !ok {
+			return fmt.Errorf("unexpected %q after relation", rest)
+		}
+		label = strings.TrimSpace(text)
+	}
+
+	left := strings.HasPrefix(match[1], "<")
+	right := strings.HasSuffix(match[3], ">")
+	flow := "bi"
+	switch {
+	case left && !right:
+		flow = "in"
+	case right && !left:
+		flow = "out"
+	}
+	e := p.diagram.edge(p.endpoint(source), p.endpoint(target), label, flow)
+	if strings.Contains(match[2], ".") {
+		e.labels["style"] = "dotted"
+	}
+	return nil
+}
+
+// plantumlShorthand is the end of a relation, with the keyword of its
+// shorthand if it has one.
+type plantumlShorthand struct {
+	keyword string
+	name    string
+}
+
+// plantumlEndpoint reads the end of a relation and returns it with the
+// rest of the line.
+func plantumlEndpoint(s string) (plantumlShorthand, string, error) {
+	match := plantumlEndpointPattern.FindStringSubmatch(s)
+	if match == nil {
+		return plantumlShorthand{}, "", fmt.Errorf("expected an element at %q", strings.TrimSpace(s))
+	}
+	rest := s[len(match[0]):]
+	token := match[1]
+	switch {
+	case strings.HasPrefix(token, "["):
+		return plantumlShorthand{"component", token[1 : len(token)-1]}, rest, nil
+	case strings.HasPrefix(token, ":"):
+		return plantumlShorthand{"actor", token[1 : len(token)-1]}, rest, nil
+	case strings.HasPrefix(token, "()"):
+		return plantumlShorthand{"interface", strings.Trim(strings.TrimSpace(token[2:]), `"`)}, rest, nil
+	}
+	return plantumlShorthand{name: strings.Trim(token, `"`)}, rest, nil
+}
+
+// endpoint returns the ID of the end of a relation. Shorthands declare
+// their elements if they are new.
+func (p *plantumlImport) endpoint(e plantumlShorthand) string {
+	// Groups can be the ends of relations as well
+	id := p.id(e.name)
+	if _, ok := p.diagram.byID[id]; ok {
+		return id
+	}
+	if _, ok := p.diagram.index[id]; !ok {
+		n := p.diagram.node(id, p.group())
+		n.name = e.name
+		if e.keyword != "" {
+			element := plantumlElements[e.keyword]
+			n.componentType = element.componentType
+			n.geometry = element.geometry
+			n.labels["kind"] = e.keyword
+		}
+	}
+	return id
+}
@@ -0,0 +1,62 @@
+package ennoea
+
+import "testing"
+
+func TestImportPlantUML(t *testing.T) {
+	testImports(t, "plantuml", []importTest{
+		{
+			name: "component diagram with a package",
+			data: "@startuml\n[Web] --> [API]\npackage Backend {\n  [API] -- DB\n  database DB\n}\n@enduml",
+			want: []string{
+				`component web "Web" box`,
+				`component api "API" box`,
+				`component db "DB" cylinder`,
+				`group backend db`,
+				`connection web->api out`,
+				`connection api->db bi`,
+			},
+		},
+		{
+			name: "shorthands and arrows",
+			data: "@startuml\ntitle Shop\n:User: -up-> () Gateway\nqueue \"Order Queue\" as q\n() Gateway -[#red]-> q : publish\nq <-- [Worker]\n@enduml",
+			want: []string{
+				`component user "User" capsule`,
+				`component gateway "Gateway" sphere`,
+				`component q "Order Queue" torus`,
+				`component worker "Worker" box`,
+				`connection user->gateway out`,
+				`connection gateway->q out`,
+				`connection q->worker in`,
+			},
+		},
+		{
+			name: "skipped parts",
+			data: "@startuml\n' a comment\nskinparam component {\n  BackgroundColor red\n}\nnote left of A\n  text\nend note\n/' block\ncomment '/ component A\nlegend\n  [X] --> [Y]\nendlegend\n!include other.puml\nA --> B\n@enduml",
+			want: []string{
+				`component a "A" box`,
+				`component b "B" box`,
+				`connection a->b out`,
+				"warning line 14: preprocessor directive !include is not supported",
+			},
+		},
+		{
+			name: "unbalanced braces",
+			data: "}\nnode Server {\n  [App]\n",
+			want: []string{
+				`component app "App" box`,
+				`group server app`,
+				"warning line 1: closing brace without a container",
+				"warning a container is missing a closing brace",
+			},
+		},
+		{name: "no elements", data: "@startuml\nskinparam monochrome true\n@enduml", err: "diagram has no elements"},
+	})
+}
+
+func FuzzImportPlantUML(f *testing.F) {
+	f.Add("@startuml\n[Web] --> [API]\npackage Backend {\n  [API] -- DB\n  database DB\n}\n@enduml")
+	f.Add(":User: -up-> () Gateway\nqueue \"Order Queue\" as q\nnote left of q\n  text\nend note\nq <-- [Worker] : pull")
+	f.Fuzz(func(t *testing.T, data string) {
+		importPlantUML([]byte(data), nil)
+	})
+}
@@ -0,0 +1,520 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Policies are declarative rules that are written by users rather than
+in Go. Each policy is stored as a JSON file in the policy directory and
+is evaluated against every architecture.
+
+A policy rule is written in the expression language and has one of two
+forms:
+
+	deny <selector>
+	require <selector> must <expr>
+
+A "deny" rule is violated by every entity that the selector matches. A
+"require" rule is violated by every entity that the selector matches
+but that does not satisfy the expression after "must".
+
+Policy directory structure:
+policies/
+	${policyID}.json
+
+public-to-pii.json
+{
+	"id": "public-to-pii",
+	"description": "Public components must not connect to PII stores",
+	"severity": "error",
+	"enforce": true,
+	"rule": "deny connection where source.labels.zone == \"public\" and target.labels.data == \"pii\""
+}
+
+prod-egress-tls.json
+{
+	"id": "prod-egress-tls",
+	"description": "Connections leaving prod must use TLS",
+	"rule": "require connection where source in group \"prod\" and not target in group \"prod\" must labels.tls == \"true\""
+}
+*/
+
+// Policy represents a user defined rule that the architectures must
+// follow.
+type Policy struct {
+	// ID is the unique ID of the policy. It is also the name of the
+	// policy file.
+	ID string `json:"id"`
+
+	// Description describes the policy. It is used as the message of
+	// the violations.
+	Description string `json:"description,omitempty"`
+
+	// Severity is the severity of the violations. The severity is
+	// either "info", "warning" or "error". It defaults to "error".
+	Severity string `json:"severity,omitempty"`
+
+	// Enforce determines whether or not an architecture that
+	// violates the policy can be saved.
+	Enforce bool `json:"enforce,omitempty"`
+
+	// Rule is the rule of the policy written in the expression
+	// language.
+	Rule string `json:"rule"`
+}
+
+// PolicyViolation represents an entity that violates a policy.
+type PolicyViolation struct {
+	// Policy is the ID of the policy that is violated.
+	Policy string `json:"policy"`
+
+	// Severity is the severity of the policy.
+	Severity string `json:"severity"`
+
+	// Entity is the ID of the component, group or connection that
+	// violates the policy.
+	Entity string `json:"entity"`
+
+	// Message describes the violation.
+	Message string `json:"message"`
+
+	// Enforced is set when the policy is enforced.
+	Enforced bool `json:"enforced"`
+}
+
+// String returns the violation formatted on a single line.
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s", v.Severity, v.Policy, v.Entity, v.Message)
+}
+
+// isValid returns an error if the policy is invalid.
+func (p Policy) isValid() error {
+	if p.ID == "" {
+		return fmt.Errorf("invalid policy: missing id")
+	}
+	if !isValidPolicyID(p.ID) {
+		return fmt.Errorf("invalid policy: invalid id: %s", p.ID)
+	}
+	if _, ok := lintSeverityRank[p.Severity]; p.Severity != "" && !ok {
+		return fmt.Errorf("invalid policy: invalid severity: %s", p.Severity)
+	}
+	if _, err := compilePolicy(p); err != nil {
+		return fmt.Errorf("invalid policy: %v", err)
+	}
+	return nil
+}
+
+// isValidPolicyID returns true if the ID can be used as the name of a
+// file in the policy directory.
+func isValidPolicyID(policyID string) bool {
+	return policyID != "" && filepath.Base(policyID) == policyID && !strings.HasPrefix(policyID, ".")
+}
+
+// compiledPolicy is a policy whose rule has been parsed.
+type compiledPolicy struct {
+	policy Policy
+
+	// selector selects the entities that the rule applies to.
+	selector exprSelector
+
+	// must is the expression that the selected entities must satisfy,
+	// or nil if the selected entities are denied.
+	must exprNode
+}
+
+// compilePolicy parses the rule of the policy.
+func compilePolicy(policy Policy) (compiledPolicy, error) {
+	p, err := newExprParser(policy.Rule)
+	if err != nil {
+		return compiledPolicy{}, err
+	}
+
+	compiled := compiledPolicy{policy: policy}
+	switch {
+	case p.isKeyword("deny"):
+		p.next()
+		compiled.selector, err = p.parseSelector()
+		if err != nil {
+			return compiledPolicy{}, err
+		}
+
+	case p.isKeyword("require"):
+		p.next()
+		compiled.selector, err = p.parseSelector()
+		if err != nil {
+			return compiledPolicy{}, err
+		}
+		if err := p.expectKeyword("must"); err != nil {
+			return compiledPolicy{}, err
+		}
+		compiled.must, err = p.parseExpr()
+		if err != nil {
+			return compiledPolicy{}, err
+		}
+
+	default:
+		return compiledPolicy{}, p.errorf("expected deny or require")
+	}
+
+	if err := p.expectEOF(); err != nil {
+		return compiledPolicy{}, err
+	}
+	return compiled, nil
+}
+
+// evaluate returns the violations of the policy in the architecture.
+func (c compiledPolicy) evaluate(ctx *exprContext) ([]PolicyViolation, error) {
+	severity := c.policy.Severity
+	if severity == "" {
+		severity = LintError
+	}
+
+	var violations []PolicyViolation
+	for _, e := range ctx.entities(c.selector.kind) {
+		matched, err := ctx.matches(c.selector, e)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %s %s: %v", c.policy.ID, e.kind, ctx.id(e), err)
+		}
+		if !matched {
+			continue
+		}
+
+		message := c.policy.Description
+		if c.must != nil {
+			ok, err := evalBool(ctx, c.must, e)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %s %s: %v", c.policy.ID, e.kind, ctx.id(e), err)
+			}
+			if ok {
+				continue
+			}
+			if message == "" {
+				message = fmt.Sprintf("%s does not satisfy the policy", e.kind)
+			}
+		} else if message == "" {
+			message = fmt.Sprintf("%s is denied by the policy", e.kind)
+		}
+
+		violations = append(violations, PolicyViolation{
+			Policy:   c.policy.ID,
+			Severity: severity,
+			Entity:   ctx.id(e),
+			Message:  message,
+			Enforced: c.policy.Enforce,
+		})
+	}
+
+	return violations, nil
+}
+
+// EvaluatePolicies returns the violations of the policies in the
+// architecture, ordered by policy.
+func EvaluatePolicies(arch Architecture, policies []Policy) ([]PolicyViolation, error) {
+	ctx := newExprContext(arch)
+	violations := []PolicyViolation{}
+	for _, policy := range policies {
+		compiled, err := compilePolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %v", policy.ID, err)
+		}
+		v, err := compiled.evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	return violations, nil
+}
+
+// PolicyHandler handles requests to manage the policies. The policies
+// are stored as JSON files in the policy directory.
+type PolicyHandler struct {
+	// dirPath is the path to the directory where policy files are
+	// saved.
+	dirPath string
+
+	// mutex guards the policy files.
+	mutex sync.RWMutex
+}
+
+// NewPolicyHandler creates a new PolicyHandler that stores the
+// policies in the directory.
+func NewPolicyHandler(dirPath string) *PolicyHandler {
+	return &PolicyHandler{dirPath: dirPath}
+}
+
+// Policies returns every policy ordered by ID.
+func (h *PolicyHandler) Policies() ([]Policy, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	entries, err := os.ReadDir(h.dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy directory: %v", err)
+	}
+
+	policies := []Policy{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		policy, err := h.loadPolicy(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].ID < policies[j].ID
+	})
+	return policies, nil
+}
+
+// loadPolicy loads the policy file with the ID.
+func (h *PolicyHandler) loadPolicy(policyID string) (Policy, error) {
+	file, err := os.ReadFile(filepath.Join(h.dirPath, policyID+".json"))
+	if err != nil {
+		return Policy{}, err
+	}
+
+	var policy Policy
+	err = json.Unmarshal(file, &policy)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to unmarshal policy %s: %v", policyID, err)
+	}
+	policy.ID = policyID
+	return policy, nil
+}
+
+// ServeHTTP handles requests to the policy routes.
+//
+// GET /policies/
+//
+//	return a list of the policies.
+//
+// GET /policies/${policyID}
+//
+//	return the policy.
+//
+// PUT /policies/
+//
+//	save a policy. The rule is compiled before the policy is saved
+//	so that mistakes are reported straight away.
+//
+// DELETE /policies/${policyID}
+//
+//	delete the policy.
+func (h *PolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	policyID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/policies"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && policyID == "":
+		h.handleGetAll(w, r)
+	case r.Method == http.MethodGet:
+		h.handleGet(w, r, policyID)
+	case r.Method == http.MethodPut && policyID == "":
+		h.handlePut(w, r)
+	case r.Method == http.MethodDelete && policyID != "":
+		h.handleDelete(w, r, policyID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Method not allowed")
+	}
+}
+
+// handleGetAll handles GET requests for all policies.
+func (h *PolicyHandler) handleGetAll(w http.ResponseWriter, r *http.Request) {
+	policies, err := h.Policies()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load policies: %v", err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, policies)
+}
+
+// handleGet handles GET requests for a policy.
+func (h *PolicyHandler) handleGet(w http.ResponseWriter, r *http.Request, policyID string) {
+	if !isValidPolicyID(policyID) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid policy ID: %s", policyID)
+		return
+	}
+
+	h.mutex.RLock()
+	policy, err := h.loadPolicy(policyID)
+	h.mutex.RUnlock()
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Policy not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load policy: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, policy)
+}
+
+// handlePut handles PUT requests to save a policy.
+func (h *PolicyHandler) handlePut(w http.ResponseWriter, r *http.Request) {
+	var policy Policy
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to decode policy: %v", err)
+		return
+	}
+
+	if err := policy.isValid(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%v", err)
+		return
+	}
+
+	file, err := json.MarshalIndent(policy, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to marshal policy: %v", err)
+		return
+	}
+
+	h.mutex.Lock()
+	err = os.WriteFile(filepath.Join(h.dirPath, policy.ID+".json"), file, 0644)
+	h.mutex.Unlock()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to write policy file: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Policy saved")
+}
+
+// handleDelete handles DELETE requests to delete a policy.
+func (h *PolicyHandler) handleDelete(w http.ResponseWriter, r *http.Request, policyID string) {
+	if !isValidPolicyID(policyID) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid policy ID: %s", policyID)
+		return
+	}
+
+	h.mutex.Lock()
+	err := os.Remove(filepath.Join(h.dirPath, policyID+".json"))
+	h.mutex.Unlock()
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Policy not found")
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to delete policy: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Policy deleted")
+}
+
+// SetPolicies sets the policies that architectures are evaluated
+// against on save and at the policies endpoint.
+func (h *ArchitectureHandler) SetPolicies(policies *PolicyHandler) {
+	h.policies = policies
+}
+
+// loadPolicies returns the policies that architectures are evaluated
+// against, or none if no policy handler has been set.
+func (h *ArchitectureHandler) loadPolicies() ([]Policy, error) {
+	if h.policies == nil {
+		return []Policy{}, nil
+	}
+	return h.policies.Policies()
+}
+
+// evaluatePolicies evaluates the policies against the architecture and
+// returns all of the violations and the violations of enforced
+// policies.
+func (h *ArchitectureHandler) evaluatePolicies(arch Architecture) ([]PolicyViolation, []PolicyViolation, error) {
+	policies, err := h.loadPolicies()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load policies: %v", err)
+	}
+
+	violations, err := EvaluatePolicies(arch, policies)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to evaluate policies: %v", err)
+	}
+
+	var enforced []PolicyViolation
+	for _, v := range violations {
+		if v.Enforced {
+			enforced = append(enforced, v)
+		}
+	}
+	return violations, enforced, nil
+}
+
+// enforcePolicies evaluates the policies against the architecture
+// that is being saved. If any enforced policy is violated, an
+// Unprocessable Entity response listing the violations is written and
+// false is returned. Otherwise the violations are returned so that
+// they can be reported with the response.
+func (h *ArchitectureHandler) enforcePolicies(w http.ResponseWriter, arch Architecture) ([]PolicyViolation, bool) {
+	violations, enforced, err := h.evaluatePolicies(arch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%v", err)
+		return nil, false
+	}
+	if len(enforced) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "Architecture violates %d enforced policies:", len(enforced))
+		for _, v := range enforced {
+			fmt.Fprintf(w, "\n%s", v)
+		}
+		return nil, false
+	}
+
+	return violations, true
+}
+
+// handleGetPolicies handles GET requests for the policy violations of
+// an architecture.
+// GET /architectures/${architectureID}/policies
+//
+//	return the entities of the architecture that violate a policy.
+func (h *ArchitectureHandler) handleGetPolicies(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	policies, err := h.loadPolicies()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to load policies: %v", err)
+		return
+	}
+
+	violations, err := EvaluatePolicies(arch, policies)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to evaluate policies: %v", err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, violations)
+}
@@ -0,0 +1,268 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+)
+
+/*
+OTLP/HTTP exporters send traces as protocol buffers by default. Only
+the fields of the trace export request that are imported are decoded,
+with a small reader of the protobuf wire format, and every other field
+is skipped. The messages are decoded into the same types as OTLP/JSON.
+*/
+
+// maxProtoValueDepth is the deepest nesting of the array values of an
+// attribute that is decoded. Deeper values would overflow the stack.
+const maxProtoValueDepth = 64
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoReader reads the fields of a protobuf message.
+type protoReader struct {
+	data []byte
+}
+
+// protoField is a field of a protobuf message. The value is the
+// number of a varint or fixed field, and the bytes are the value of a
+// length delimited field.
+type protoField struct {
+	number int
+	wire   int
+	value  uint64
+	bytes  []byte
+}
+
+// next reads the next field of the message. It returns false when the
+// message has been read.
+func (r *protoReader) next() (protoField, bool, error) {
+	if len(r.data) == 0 {
+		return protoField{}, false, nil
+	}
+	key, err := r.varint()
+	if err != nil {
+		return protoField{}, false, err
+	}
+	f := protoField{number: int(key >> 3), wire: int(key & 7)}
+	switch f.wire {
+	case wireVarint:
+		f.value, err = r.varint()
+	case wireFixed64:
+		if len(r.data) < 8 {
+			return protoField{}, false, fmt.Errorf("truncated fixed64 field %d", f.number)
+		}
+		f.value = binary.LittleEndian.Uint64(r.data)
+		r.data = r.data[8:]
+	case wireFixed32:
+		if len(r.data) < 4 {
+			return protoField{}, false, fmt.Errorf("truncated fixed32 field %d", f.number)
+		}
+		f.value = uint64(binary.LittleEndian.Uint32(r.data))
+		r.data = r.data[4:]
+	case wireBytes:
+		var length uint64
+		length, err = r.varint()
+		if err == nil && length > uint64(len(r.data)) {
+			err = fmt.Errorf("truncated field %d", f.number)
+		}
+		if err == nil {
+			f.bytes = r.data[:length]
+			r.data = r.data[length:]
+		}
+	default:
+		err = fmt.Errorf("unsupported wire type %d of field %d", f.wire, f.number)
+	}
+	if err != nil {
+		return protoField{}, false, err
+	}
+	return f, true, nil
+}
+
+// varint reads a base 128 varint.
+func (r *protoReader) varint() (uint64, error) {
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint")
+	}
+	r.data = r.data[n:]
+	return value, nil
+}
+
+// readProto calls the function with each field of the message.
+func readProto(data []byte, read func(f protoField) error) error {
+	r := protoReader{data: data}
+	for {
+		f, ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := read(f); err != nil {
+			return err
+		}
+	}
+}
+
+// parseOTLPProto decodes a protobuf ExportTraceServiceRequest.
+func parseOTLPProto(data []byte) (otlpTraces, error) {
+	var traces otlpTraces
+	err := readProto(data, func(f protoField) error {
+		if f.number != 1 || f.wire != wireBytes {
+			return nil
+		}
+		rs, err := parseProtoResourceSpans(f.bytes)
+		traces.ResourceSpans = append(traces.ResourceSpans, rs)
+		return err
+	})
+	if err != nil {
+		return otlpTraces{}, fmt.Errorf("failed to parse otlp protobuf: %v", err)
+	}
+	return traces, nil
+}
+
+// parseProtoResourceSpans decodes a ResourceSpans message.
+func parseProtoResourceSpans(data []byte) (otlpResourceSpans, error) {
+	var rs otlpResourceSpans
+	err := readProto(data, func(f protoField) error {
+		if f.wire != wireBytes {
+			return nil
+		}
+		switch f.number {
+		case 1:
+			// The attributes of the resource
+			return readProto(f.bytes, func(f protoField) error {
+				if f.number != 1 || f.wire != wireBytes {
+					return nil
+				}
+				kv, err := parseProtoKeyValue(f.bytes)
+				rs.Resource.Attributes = append(rs.Resource.Attributes, kv)
+				return err
+			})
+		case 2, 1000:
+			// The scope spans, or the instrumentation library spans
+			// of older exporters
+			scope, err := parseProtoScopeSpans(f.bytes)
+			rs.ScopeSpans = append(rs.ScopeSpans, scope)
+			return err
+		}
+		return nil
+	})
+	return rs, err
+}
+
+// parseProtoScopeSpans decodes a ScopeSpans message.
+func parseProtoScopeSpans(data []byte) (otlpScopeSpans, error) {
+	var scope otlpScopeSpans
+	err := readProto(data, func(f protoField) error {
+		if f.number != 2 || f.wire != wireBytes {
+			return nil
+		}
+		span, err := parseProtoSpan(f.bytes)
+		scope.Spans = append(scope.Spans, span)
+		return err
+	})
+	return scope, err
+}
+
+// parseProtoSpan decodes a Span message. The IDs are hex encoded like
+// they are in OTLP/JSON.
+func parseProtoSpan(data []byte) (otlpSpan, error) {
+	var span otlpSpan
+	err := readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1:
+			span.TraceID = hex.EncodeToString(f.bytes)
+		case 2:
+			span.SpanID = hex.EncodeToString(f.bytes)
+		case 4:
+			span.ParentSpanID = hex.EncodeToString(f.bytes)
+		case 5:
+			span.Name = string(f.bytes)
+		case 6:
+			span.Kind = otlpEnum(f.value)
+		case 7:
+			span.StartTimeUnixNano = otlpInt(f.value)
+		case 8:
+			span.EndTimeUnixNano = otlpInt(f.value)
+		case 9:
+			kv, err := parseProtoKeyValue(f.bytes)
+			span.Attributes = append(span.Attributes, kv)
+			return err
+		case 15:
+			return readProto(f.bytes, func(f protoField) error {
+				if f.number == 3 {
+					span.Status.Code = otlpEnum(f.value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return span, err
+}
+
+// parseProtoKeyValue decodes a KeyValue message.
+func parseProtoKeyValue(data []byte) (otlpKeyValue, error) {
+	var kv otlpKeyValue
+	err := readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1:
+			kv.Key = string(f.bytes)
+		case 2:
+			value, err := parseProtoAnyValue(f.bytes, 0)
+			kv.Value = value
+			return err
+		}
+		return nil
+	})
+	return kv, err
+}
+
+// parseProtoAnyValue decodes an AnyValue message at the depth of
+// nesting. Key value lists and bytes are not imported.
+func parseProtoAnyValue(data []byte, depth int) (otlpAnyValue, error) {
+	var value otlpAnyValue
+	if depth > maxProtoValueDepth {
+		return value, fmt.Errorf("attribute value is nested deeper than %d arrays", maxProtoValueDepth)
+	}
+	err := readProto(data, func(f protoField) error {
+		switch f.number {
+		case 1:
+			s := string(f.bytes)
+			value.StringValue = &s
+		case 2:
+			b := f.value != 0
+			value.BoolValue = &b
+		case 3:
+			i := otlpInt(f.value)
+			value.IntValue = &i
+		case 4:
+			d := math.Float64frombits(f.value)
+			value.DoubleValue = &d
+		case 5:
+			value.ArrayValue = &struct {
+				Values []otlpAnyValue `json:"values"`
+			}{}
+			return readProto(f.bytes, func(f protoField) error {
+				if f.number != 1 || f.wire != wireBytes {
+					return nil
+				}
+				item, err := parseProtoAnyValue(f.bytes, depth+1)
+				value.ArrayValue.Values = append(value.ArrayValue.Values, item)
+				return err
+			})
+		}
+		return nil
+	})
+	return value, err
+}
@@ -0,0 +1,137 @@
+package ennoea
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Helpers that encode the fields of a protobuf message.
+func protoVarint(number int, value uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(number)<<3|wireVarint), value)
+}
+
+func protoFixed64(number int, value uint64) []byte {
+	return binary.LittleEndian.AppendUint64(binary.AppendUvarint(nil, uint64(number)<<3|wireFixed64), value)
+}
+
+func protoBytes(number int, fields ...[]byte) []byte {
+	var value []byte
+	for _, f := range fields {
+		value = append(value, f...)
+	}
+	data := binary.AppendUvarint(nil, uint64(number)<<3|wireBytes)
+	return append(binary.AppendUvarint(data, uint64(len(value))), value...)
+}
+
+func protoString(number int, value string) []byte {
+	return protoBytes(number, []byte(value))
+}
+
+// protoAttribute encodes a KeyValue field of the key and the encoded
+// AnyValue.
+func protoAttribute(number int, key string, value []byte) []byte {
+	return protoBytes(number, protoString(1, key), protoBytes(2, value))
+}
+
+// testOTLPProto is the protobuf encoding of testOTLPJSON.
+var testOTLPProto = append(
+	protoBytes(1,
+		protoBytes(1, protoAttribute(1, "service.name", protoString(1, "web"))),
+		protoBytes(2, protoBytes(2,
+			protoBytes(1, []byte{0x01, 0x02}),
+			protoBytes(2, []byte{0x0a}),
+			protoString(5, "GET /api"),
+			protoVarint(6, spanKindClient),
+			protoFixed64(7, 1700000000000000000),
+			protoFixed64(8, 1700000000020000000),
+			protoAttribute(9, "http.request.body.size", protoVarint(3, 120)),
+			protoAttribute(9, "retried", protoVarint(2, 1)),
+			protoAttribute(9, "sampled", protoFixed64(4, math.Float64bits(0.5))),
+			protoAttribute(9, "tags", protoBytes(5, protoBytes(1, protoString(1, "a")), protoBytes(1, protoString(1, "b")))),
+			protoVarint(99, 7),
+		))),
+	protoBytes(1,
+		protoBytes(1, protoAttribute(1, "service.name", protoString(1, "api"))),
+		protoBytes(1000, protoBytes(2,
+			protoBytes(1, []byte{0x01, 0x02}),
+			protoBytes(2, []byte{0x0b}),
+			protoBytes(4, []byte{0x0a}),
+			protoVarint(6, spanKindServer),
+			protoFixed64(7, 1700000000001000000),
+			protoFixed64(8, 1700000000019000000),
+			protoBytes(15, protoVarint(3, otlpStatusError)),
+		)))...)
+
+// testOTLPJSON is a call from web to api in OTLP/JSON.
+const testOTLPJSON = `{"resourceSpans": [
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "web"}}]},
+	 "scopeSpans": [{"spans": [{"traceId": "0102", "spanId": "0a", "name": "GET /api", "kind": "SPAN_KIND_CLIENT",
+		"startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000020000000",
+		"attributes": [
+			{"key": "http.request.body.size", "value": {"intValue": "120"}},
+			{"key": "retried", "value": {"boolValue": true}},
+			{"key": "sampled", "value": {"doubleValue": 0.5}},
+			{"key": "tags", "value": {"arrayValue": {"values": [{"stringValue": "a"}, {"stringValue": "b"}]}}}
+		]}]}]},
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+	 "instrumentationLibrarySpans": [{"spans": [{"traceId": "0102", "spanId": "0b", "parentSpanId": "0a", "kind": 2,
+		"startTimeUnixNano": "1700000000001000000", "endTimeUnixNano": "1700000000019000000",
+		"status": {"code": "STATUS_CODE_ERROR"}}]}]}
+]}`
+
+func TestParseOTLPProto(t *testing.T) {
+	traces, err := parseOTLPProto(testOTLPProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := make(map[string]traceService)
+	spans := traces.spans(services)
+
+	want, wantServices, err := parseOTLP([]byte(testOTLPJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("spans = %+v, want %+v", spans, want)
+	}
+	if !reflect.DeepEqual(services, wantServices) {
+		t.Errorf("services = %+v, want %+v", services, wantServices)
+	}
+
+	// Arrays nested deeper than the limit are rejected before they
+	// can overflow the stack
+	nested := protoString(1, "x")
+	for i := 0; i <= maxProtoValueDepth; i++ {
+		nested = protoBytes(5, protoBytes(1, nested))
+	}
+	nested = protoBytes(1, protoBytes(2, protoBytes(2, protoAttribute(9, "nested", nested))))
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"nested arrays", nested, "nested deeper than 64 arrays"},
+		{"truncated field", testOTLPProto[:20], "truncated field"},
+		{"invalid varint", []byte{0x80}, "invalid varint"},
+		{"truncated fixed64", protoFixed64(1, 0)[:4], "truncated fixed64 field 1"},
+		{"group", []byte{1<<3 | 3}, "unsupported wire type 3 of field 1"},
+	} {
+		if _, err := parseOTLPProto(test.data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func FuzzParseOTLPProto(f *testing.F) {
+	f.Add(testOTLPProto)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		traces, err := parseOTLPProto(data)
+		if err == nil {
+			traces.spans(make(map[string]traceService))
+		}
+	})
+}
@@ -0,0 +1,447 @@
+package ennoea
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+Queries select the entities of an architecture with the selectors of
+the expression language, for example:
+
+	component where type == "database" and degree > 3
+	component where self in downstream("lb", 2) and labels.tier != "edge"
+	connection where flow == "bi" and outRate > "10MB/s"
+	group where size > 2
+
+The matching entities are returned as a list of IDs, as a filtered
+architecture, or as the whole architecture with the matches
+highlighted. The same queries are used by the views saved with an
+architecture and by the lint rule selectors.
+*/
+
+// Ways in which the result of a query can be returned.
+const (
+	// queryViewIDs returns the IDs of the matching entities.
+	queryViewIDs = "ids"
+
+	// queryViewArchitecture returns an architecture with only the
+	// matching entities and the entities they need.
+	queryViewArchitecture = "architecture"
+
+	// queryViewHighlight returns the whole architecture with the
+	// matching entities coloured and the rest dimmed.
+	queryViewHighlight = "highlight"
+)
+
+const (
+	// defaultHighlightColor is the colour of the highlighted entities.
+	defaultHighlightColor = "#ffcc00"
+
+	// dimColor is the colour that the entities that are not
+	// highlighted are blended towards.
+	dimColor = "#404040"
+
+	// dimAmount is how far the colours are blended towards dimColor.
+	dimAmount = 0.75
+)
+
+// QueryResult represents the entities that matched a query.
+type QueryResult struct {
+	// Query is the query that was run.
+	Query string `json:"query"`
+
+	// Kind is the kind of the entities, either "component", "group"
+	// or "connection".
+	Kind string `json:"kind"`
+
+	// IDs is a list of the IDs of the matching entities, in the order
+	// they appear in the architecture.
+	IDs []string `json:"ids"`
+}
+
+// View represents a saved query that shows part of an architecture.
+type View struct {
+	// ID is the unique identifier of the view within the
+	// architecture.
+	ID string `json:"id"`
+
+	// Name is the name of the view.
+	Name string `json:"name"`
+
+	// Description is the description of the view.
+	Description string `json:"description,omitempty"`
+
+	// Query selects the entities that are shown by the view.
+	Query string `json:"query"`
+
+	// Highlight determines whether the view shows the whole
+	// architecture with the matching entities highlighted, instead of
+	// only the matching entities.
+	Highlight bool `json:"highlight,omitempty"`
+
+	// Color is the colour of the highlighted entities. It defaults to
+	// yellow.
+	Color string `json:"color,omitempty"`
+}
+
+// isValid returns an error if the view is invalid.
+func (v View) isValid() error {
+	// Check that the ID is not empty
+	if v.ID == "" {
+		return fmt.Errorf("invalid view: id is empty")
+	}
+
+	// Check that the name is not empty
+	if v.Name == "" {
+		return fmt.Errorf("invalid view: name is empty")
+	}
+
+	// Check that the query compiles
+	if _, err := compileQuery(v.Query); err != nil {
+		return fmt.Errorf("invalid view: %s: invalid query: %w", v.ID, err)
+	}
+
+	// Check that the highlight colour is valid
+	if v.Color != "" {
+		if err := isValidColor(v.Color); err != nil {
+			return fmt.Errorf("invalid view: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// compileQuery parses a query into a selector.
+func compileQuery(query string) (exprSelector, error) {
+	if strings.TrimSpace(query) == "" {
+		return exprSelector{}, fmt.Errorf("query is empty")
+	}
+	p, err := newExprParser(query)
+	if err != nil {
+		return exprSelector{}, err
+	}
+	selector, err := p.parseSelector()
+	if err != nil {
+		return exprSelector{}, err
+	}
+	if err := p.expectEOF(); err != nil {
+		return exprSelector{}, err
+	}
+	return selector, nil
+}
+
+// selectEntities returns the entities that match the selector, in the
+// order they appear in the architecture.
+func selectEntities(ctx *exprContext, selector exprSelector) ([]exprEntity, error) {
+	var selected []exprEntity
+	for _, e := range ctx.entities(selector.kind) {
+		ok, err := ctx.matches(selector, e)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", e.kind, ctx.id(e), err)
+		}
+		if ok {
+			selected = append(selected, e)
+		}
+	}
+	return selected, nil
+}
+
+// RunQuery returns the IDs of the entities of the architecture that
+// match the query.
+func RunQuery(arch Architecture, query string) (QueryResult, error) {
+	selector, err := compileQuery(query)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("failed to compile query: %v", err)
+	}
+	ctx := newExprContext(arch)
+	selected, err := selectEntities(ctx, selector)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("failed to run query: %v", err)
+	}
+
+	result := QueryResult{Query: query, Kind: selector.kind, IDs: make([]string, 0, len(selected))}
+	for _, e := range selected {
+		result.IDs = append(result.IDs, ctx.id(e))
+	}
+	return result, nil
+}
+
+// subArchitecture returns a copy of the architecture with only the
+// components in the set, the connections between them, and the groups
+// trimmed to the components that remain. Groups without any remaining
+// components are removed.
+func subArchitecture(arch Architecture, ids map[string]bool) Architecture {
+	derived := arch
+
+	derived.Components = make([]Component, 0, len(ids))
+	for _, c := range arch.Components {
+		if ids[c.ID] {
+			derived.Components = append(derived.Components, c)
+		}
+	}
+
+	derived.Groups = make([]Group, 0, len(arch.Groups))
+	for _, group := range arch.Groups {
+		components := make([]string, 0, len(group.Components))
+		for _, id := range group.Components {
+			if ids[id] {
+				components = append(components, id)
+			}
+		}
+		if len(components) > 0 {
+			group.Components = components
+			derived.Groups = append(derived.Groups, group)
+		}
+	}
+
+	derived.Connections = make([]Connection, 0, len(arch.Connections))
+	for _, c := range arch.Connections {
+		if ids[c.Source] && ids[c.Target] {
+			derived.Connections = append(derived.Connections, c)
+		}
+	}
+
+	return derived
+}
+
+// filterArchitecture returns an architecture with only the selected
+// entities. Selected groups keep all of their members, and selected
+// connections keep both of their ends. When groups are selected, the
+// other groups are removed, and when connections are selected, the
+// other connections are removed.
+func filterArchitecture(ctx *exprContext, selected []exprEntity) Architecture {
+	arch := ctx.arch
+	ids := make(map[string]bool)
+	chosen := make(map[int]bool, len(selected))
+	for _, e := range selected {
+		chosen[e.index] = true
+		switch e.kind {
+		case entityComponent:
+			ids[arch.Components[e.index].ID] = true
+		case entityGroup:
+			for _, id := range arch.Groups[e.index].Components {
+				ids[id] = true
+			}
+		case entityConnection:
+			ids[arch.Connections[e.index].Source] = true
+			ids[arch.Connections[e.index].Target] = true
+		}
+	}
+
+	if len(selected) > 0 && selected[0].kind == entityGroup {
+		groups := make([]Group, 0, len(selected))
+		for i, g := range arch.Groups {
+			if chosen[i] {
+				groups = append(groups, g)
+			}
+		}
+		arch.Groups = groups
+	}
+	if len(selected) > 0 && selected[0].kind == entityConnection {
+		connections := make([]Connection, 0, len(selected))
+		for i, c := range arch.Connections {
+			if chosen[i] {
+				connections = append(connections, c)
+			}
+		}
+		arch.Connections = connections
+	}
+
+	return subArchitecture(arch, ids)
+}
+
+// highlightArchitecture returns a copy of the architecture in which the
+// selected entities are drawn in the colour and everything else is
+// dimmed. Connections are highlighted by highlighting both of their
+// ends.
+func highlightArchitecture(ctx *exprContext, selected []exprEntity, color string) Architecture {
+	arch := ctx.arch
+	components := make(map[string]bool)
+	groups := make(map[int]bool)
+	for _, e := range selected {
+		switch e.kind {
+		case entityComponent:
+			components[arch.Components[e.index].ID] = true
+		case entityGroup:
+			groups[e.index] = true
+		case entityConnection:
+			components[arch.Connections[e.index].Source] = true
+			components[arch.Connections[e.index].Target] = true
+		}
+	}
+
+	arch.Components = append([]Component{}, arch.Components...)
+	for i := range arch.Components {
+		c := &arch.Components[i]
+		if components[c.ID] {
+			c.Object.Color = color
+		} else {
+			c.Object.Color = dim(c.Object.Color)
+		}
+	}
+
+	arch.Groups = append([]Group{}, arch.Groups...)
+	for i := range arch.Groups {
+		g := &arch.Groups[i]
+		if groups[i] {
+			g.BoundingBox.Color = color
+		} else {
+			g.BoundingBox.Color = dim(g.BoundingBox.Color)
+		}
+	}
+
+	return arch
+}
+
+// dim blends the colour towards dimColor. Invalid colours are replaced
+// by dimColor.
+func dim(color string) string {
+	rgb, err := parseHexColor(color)
+	if err != nil {
+		return dimColor
+	}
+	target, _ := parseHexColor(dimColor)
+	for i := range rgb {
+		rgb[i] += int(float64(target[i]-rgb[i]) * dimAmount)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// writeQueryResponse runs the query against the architecture and
+// writes the result in the requested view. The info of the
+// architecture is returned as it is, so the caller describes the
+// derived architecture.
+func writeQueryResponse(w http.ResponseWriter, arch Architecture, query, view, color string) {
+	selector, err := compileQuery(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid query: %v", err)
+		return
+	}
+	if color == "" {
+		color = defaultHighlightColor
+	}
+	if err := isValidColor(color); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid color: %v", err)
+		return
+	}
+
+	ctx := newExprContext(arch)
+	selected, err := selectEntities(ctx, selector)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to run query: %v", err)
+		return
+	}
+
+	switch view {
+	case "", queryViewIDs:
+		result := QueryResult{Query: query, Kind: selector.kind, IDs: make([]string, 0, len(selected))}
+		for _, e := range selected {
+			result.IDs = append(result.IDs, ctx.id(e))
+		}
+		writeJSONResponse(w, http.StatusOK, result)
+	case queryViewArchitecture:
+		writeJSONResponse(w, http.StatusOK, filterArchitecture(ctx, selected))
+	case queryViewHighlight:
+		writeJSONResponse(w, http.StatusOK, highlightArchitecture(ctx, selected, color))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid view: %s", view)
+	}
+}
+
+// handleGetQuery handles GET requests to query the entities of an
+// architecture.
+// GET /architectures/${architectureID}/query?q=${query}
+//
+//	return the entities that match the query. The optional parameters
+//	are:
+//	  view   "ids" for the IDs of the matches (ids), "architecture"
+//	         for an architecture with only the matches, or "highlight"
+//	         for the architecture with the matches highlighted
+//	  color  the colour of the highlighted entities (#ffcc00)
+func (h *ArchitectureHandler) handleGetQuery(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	if strings.TrimSpace(query.Get("q")) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Missing query")
+		return
+	}
+
+	arch.Info.Source = arch.Info.ID
+	arch.Info.ID = arch.Info.ID + "-query"
+	arch.Info.Name = arch.Info.Name + " (query)"
+	arch.Info.Description = query.Get("q")
+
+	writeQueryResponse(w, arch, query.Get("q"), query.Get("view"), query.Get("color"))
+}
+
+// handleGetViews handles GET requests for the views of an
+// architecture.
+// GET /architectures/${architectureID}/views
+//
+//	return the list of views saved with the architecture.
+func (h *ArchitectureHandler) handleGetViews(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	views := arch.Views
+	if views == nil {
+		views = []View{}
+	}
+	writeJSONResponse(w, http.StatusOK, views)
+}
+
+// handleGetView handles GET requests for a view of an architecture.
+// GET /architectures/${architectureID}/views/${viewID}[?view=ids]
+//
+//	return the architecture as shown by the view. The view parameter
+//	can be used to return the IDs of the matches instead.
+func (h *ArchitectureHandler) handleGetView(w http.ResponseWriter, r *http.Request, architectureID, viewID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	var view View
+	found := false
+	for _, v := range arch.Views {
+		if v.ID == viewID {
+			view = v
+			found = true
+			break
+		}
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "View %s not found", viewID)
+		return
+	}
+
+	mode := r.URL.Query().Get("view")
+	if mode == "" {
+		mode = queryViewArchitecture
+		if view.Highlight {
+			mode = queryViewHighlight
+		}
+	}
+	arch.Info.Source = arch.Info.ID
+	arch.Info.ID = arch.Info.ID + "-" + view.ID
+	arch.Info.Name = arch.Info.Name + " (" + view.Name + ")"
+	if view.Description != "" {
+		arch.Info.Description = view.Description
+	}
+
+	writeQueryResponse(w, arch, view.Query, mode, view.Color)
+}
@@ -0,0 +1,135 @@
+package ennoea
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		kind  string
+		err   string
+	}{
+		{query: "component", kind: entityComponent},
+		{query: "connections where flow == \"bi\"", kind: entityConnection},
+		{query: "group where size > 2", kind: entityGroup},
+		{query: "", err: "query is empty"},
+		{query: "   ", err: "query is empty"},
+		{query: "\t\n", err: "query is empty"},
+		{query: "server", err: "expected component, group or connection at 0"},
+		{query: "component where", err: "unexpected end of expression"},
+		{query: "component where and", err: "unexpected \"and\" at 16"},
+		{query: "component where labels.", err: "expected a field name at 23"},
+		{query: "component where labels[", err: "expected a string key at 23"},
+		{query: "component where name == \"x\" extra", err: "unexpected \"extra\""},
+	}
+
+	for _, test := range tests {
+		selector, err := compileQuery(test.query)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("compileQuery(%q) error = %v, want %q", test.query, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("compileQuery(%q) error = %v", test.query, err)
+			continue
+		}
+		if selector.kind != test.kind {
+			t.Errorf("compileQuery(%q) kind = %q, want %q", test.query, selector.kind, test.kind)
+		}
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	h, _ := newTestHandler(t)
+	arch, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{`component where name == "Web" or geometry == "cylinder"`, []string{"web", "db"}},
+		{`component where self in downstream("web", 1)`, []string{"api"}},
+		{`connection where inRate > "3KB/s"`, []string{"web-api"}},
+		{`group where size > 1`, []string{"backend"}},
+		{`component where name == "Cache"`, []string{}},
+	}
+	for _, test := range tests {
+		result, err := RunQuery(arch, test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(result.IDs, test.want) {
+			t.Errorf("%s: IDs = %v, want %v", test.query, result.IDs, test.want)
+		}
+	}
+}
+
+func TestGetQuery(t *testing.T) {
+	h, _ := newTestHandler(t)
+	const target = "/architectures/shop/query?q="
+
+	var result QueryResult
+	serveTestJSON(t, h, http.MethodGet, target+"group", "", http.StatusOK, &result)
+	if result.Kind != entityGroup || !reflect.DeepEqual(result.IDs, []string{"backend"}) {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Selected connections keep both of their ends
+	var filtered Architecture
+	serveTestJSON(t, h, http.MethodGet, target+"connection+where+source+%3D%3D+%22api%22&view=architecture", "", http.StatusOK, &filtered)
+	if got := summariseNeighbourhood(filtered); !reflect.DeepEqual(got, []string{"component api", "component db", "group backend api,db", "connection api-db"}) {
+		t.Errorf("filtered architecture = %v", got)
+	}
+	if filtered.Info.ID != "shop-query" || filtered.Info.Source != "shop" {
+		t.Errorf("filtered info = %+v", filtered.Info)
+	}
+
+	var highlighted Architecture
+	serveTestJSON(t, h, http.MethodGet, target+"component+where+name+%3D%3D+%22Web%22&view=highlight&color=%2300ff00", "", http.StatusOK, &highlighted)
+	if got := highlighted.Components[0].Object.Color; got != "#00ff00" {
+		t.Errorf("highlighted web is %s", got)
+	}
+	if got := highlighted.Components[1].Object.Color; got == "#00ff00" || got != dim(filtered.Components[0].Object.Color) {
+		t.Errorf("api is %s, want it dimmed", got)
+	}
+
+	for _, query := range []string{"", "server", "component&view=table", "component&color=red"} {
+		serveTestJSON(t, h, http.MethodGet, target+query, "", http.StatusBadRequest, nil)
+	}
+}
+
+func TestViewIsValidEmptyQuery(t *testing.T) {
+	for _, query := range []string{"", " "} {
+		view := View{ID: "v", Name: "View", Query: query}
+		if err := view.isValid(); err == nil {
+			t.Errorf("View{Query: %q}.isValid() = nil, want an error", query)
+		}
+	}
+}
+
+func TestLintConfigIsValidBlankSelector(t *testing.T) {
+	config := LintConfig{Rules: map[string]LintRuleConfig{
+		"orphaned-component": {Selector: " "},
+	}}
+	if err := config.isValid(); err == nil {
+		t.Errorf("isValid() = nil, want an error for a blank selector")
+	}
+}
+
+func TestCompilePolicyTruncated(t *testing.T) {
+	for _, rule := range []string{"deny", "deny ", "require component must", "deny component where labels."} {
+		if _, err := compilePolicy(Policy{Rule: rule}); err == nil {
+			t.Errorf("compilePolicy(%q) = nil error, want an error", rule)
+		}
+	}
+}
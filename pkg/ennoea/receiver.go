@@ -0,0 +1,350 @@
+package ennoea
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+The trace receiver accepts OTLP/HTTP trace exports, as protobuf or
+JSON, and keeps the spans of a rolling window. The window is measured
+in the time of the spans, up to the end of the latest span received,
+so recorded exports can be replayed to the receiver without a
+collector.
+
+The calls between the services in the window are periodically written
+to the rates of the connections of one architecture, which is created
+from the traces if it does not exist yet. Services and calls that are
+not in the architecture are added to it, labelled with "confirmed":
+"false" until someone reviews them and removes the label. Connections
+that had rates from the receiver but have no calls in the window are
+idle and their rates are set to zero.
+
+	POST /v1/traces  receive an export request
+	GET  /v1/traces  the status of the receiver
+*/
+
+const (
+	// defaultTraceWindow is the length of the rolling window of
+	// spans.
+	defaultTraceWindow = 5 * time.Minute
+
+	// defaultTraceInterval is how often the window is written to the
+	// architecture.
+	defaultTraceInterval = 30 * time.Second
+)
+
+// TraceReceiverConfig represents the configuration of a trace
+// receiver.
+type TraceReceiverConfig struct {
+	// ArchitectureID is the ID of the architecture that the rates are
+	// written to.
+	ArchitectureID string
+
+	// Window is the length of the rolling window of spans. It
+	// defaults to 5 minutes.
+	Window time.Duration
+
+	// Interval is how often the window is written to the
+	// architecture. It defaults to 30 seconds.
+	Interval time.Duration
+}
+
+// TraceReceiverStatus represents the status of a trace receiver.
+type TraceReceiverStatus struct {
+	// ArchitectureID is the ID of the architecture that the rates are
+	// written to.
+	ArchitectureID string `json:"architectureId"`
+
+	// Received is the number of spans received since the receiver
+	// started.
+	Received int `json:"received"`
+
+	// Spans is the number of spans in the window.
+	Spans int `json:"spans"`
+
+	// Services is the number of services that have sent spans.
+	Services int `json:"services"`
+
+	// LastWrite is when the window was last written to the
+	// architecture.
+	LastWrite *time.Time `json:"lastWrite,omitempty"`
+
+	// Unconfirmed is the number of unconfirmed connections in the
+	// architecture after the last write.
+	Unconfirmed int `json:"unconfirmed"`
+
+	// Error is the error of the last write, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// TraceReceiver receives OTLP/HTTP trace exports and writes the rates
+// of the calls between services to an architecture.
+type TraceReceiver struct {
+	// architectures is the handler that the architecture is loaded
+	// from and saved to.
+	architectures *ArchitectureHandler
+
+	// config is the configuration of the receiver.
+	config TraceReceiverConfig
+
+	// mutex guards the window and the status.
+	mutex sync.Mutex
+
+	// spans is the spans in the window.
+	spans []traceSpan
+
+	// services is a map of the names of the services that have sent
+	// spans to their resources.
+	services map[string]traceService
+
+	// latest is the end of the latest span received, in nanoseconds.
+	latest int64
+
+	// changed is true if spans have been received since the last
+	// write.
+	changed bool
+
+	// status is the status of the receiver.
+	status TraceReceiverStatus
+}
+
+// NewTraceReceiver creates a new TraceReceiver that writes to the
+// architecture of the handler.
+func NewTraceReceiver(architectures *ArchitectureHandler, config TraceReceiverConfig) *TraceReceiver {
+	if config.Window <= 0 {
+		config.Window = defaultTraceWindow
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultTraceInterval
+	}
+	return &TraceReceiver{
+		architectures: architectures,
+		config:        config,
+		services:      make(map[string]traceService),
+		status:        TraceReceiverStatus{ArchitectureID: config.ArchitectureID},
+	}
+}
+
+// ServeHTTP handles the requests to the receiver.
+// POST /v1/traces
+//
+//	receive an OTLP/HTTP export request. The body is protobuf if the
+//	content type is application/x-protobuf, or JSON if it is
+//	application/json, and may be gzip encoded.
+//
+// GET /v1/traces
+//
+//	return the status of the receiver.
+func (r *TraceReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		r.mutex.Lock()
+		status := r.status
+		r.mutex.Unlock()
+		writeJSONResponse(w, http.StatusOK, status)
+	case http.MethodPost:
+		r.handlePostTraces(w, req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Method not allowed")
+	}
+}
+
+// handlePostTraces handles an OTLP/HTTP export request. The response
+// is an empty export response in the encoding of the request.
+func (r *TraceReceiver) handlePostTraces(w http.ResponseWriter, req *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if contentType != "application/x-protobuf" && contentType != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "Unsupported content type: %s", contentType)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxImportSize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Failed to read body: %v", err)
+			return
+		}
+		defer reader.Close()
+		body = io.LimitReader(reader, maxImportSize)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Failed to read body: %v", err)
+		return
+	}
+
+	services := make(map[string]traceService)
+	var spans []traceSpan
+	if contentType == "application/x-protobuf" {
+		var traces otlpTraces
+		traces, err = parseOTLPProto(data)
+		spans = traces.spans(services)
+	} else {
+		spans, services, err = parseOTLP(data)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%v", err)
+		return
+	}
+	r.receive(spans, services)
+
+	if contentType == "application/x-protobuf" {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, struct{}{})
+}
+
+// receive adds the spans to the window and drops the spans that have
+// fallen out of it.
+func (r *TraceReceiver) receive(spans []traceSpan, services map[string]traceService) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for name, service := range services {
+		r.services[name] = service
+	}
+	for _, s := range spans {
+		if s.end > r.latest {
+			r.latest = s.end
+		}
+	}
+	r.spans = append(r.spans, spans...)
+
+	start := r.latest - r.config.Window.Nanoseconds()
+	kept := r.spans[:0]
+	for _, s := range r.spans {
+		if s.end >= start {
+			kept = append(kept, s)
+		}
+	}
+	r.spans = kept
+
+	r.changed = r.changed || len(spans) > 0
+	r.status.Received += len(spans)
+	r.status.Spans = len(r.spans)
+	r.status.Services = len(r.services)
+}
+
+// Run writes the window to the architecture at every interval until
+// the stop channel is closed.
+func (r *TraceReceiver) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Write()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Write writes the window to the architecture if spans have been
+// received since the last write. The error is also kept in the status.
+func (r *TraceReceiver) Write() error {
+	r.mutex.Lock()
+	if !r.changed {
+		r.mutex.Unlock()
+		return nil
+	}
+	spans := append([]traceSpan(nil), r.spans...)
+	services := make(map[string]traceService, len(r.services))
+	for name, service := range r.services {
+		services[name] = service
+	}
+	r.changed = false
+	r.mutex.Unlock()
+
+	arch, err := r.write(spans, services)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		// The spans are written again at the next interval
+		r.changed = true
+		r.status.Error = err.Error()
+		return err
+	}
+	now := time.Now()
+	r.status.LastWrite = &now
+	r.status.Error = ""
+	r.status.Unconfirmed = 0
+	for _, c := range arch.Connections {
+		if c.Labels["confirmed"] == "false" {
+			r.status.Unconfirmed++
+		}
+	}
+	return nil
+}
+
+// write writes the rates of the spans to the architecture and returns
+// the architecture that was saved.
+func (r *TraceReceiver) write(spans []traceSpan, services map[string]traceService) (Architecture, error) {
+	h := r.architectures
+	id := r.config.ArchitectureID
+	if len(spans) == 0 {
+		return Architecture{}, fmt.Errorf("no spans in the window")
+	}
+
+	// Requests must not save the architecture between the load and
+	// the save of the update
+	defer h.lockArchitecture(id)()
+
+	var result ImportResult
+	var err error
+	save, ok := h.architectureSave(id)
+	switch {
+	case ok && save.Base != "":
+		return Architecture{}, fmt.Errorf("architecture %s is an overlay of %s", id, save.Base)
+	case !ok:
+		result, err = completeImport("otlp", traceArchitecture(spans, services), ImportOptions{ID: id})
+	default:
+		var previous Architecture
+		previous, err = h.loadArchitectureByID(id)
+		if err != nil {
+			return Architecture{}, err
+		}
+		result = updateTraceRates(previous, spans, services)
+		if len(result.Architecture.Components) > len(previous.Components) {
+			// The new services are laid out around the others
+			result, err = completeImport("otlp", result, ImportOptions{ID: id, Previous: &previous})
+		} else if err = result.Architecture.isValid(); err == nil {
+			err = result.Architecture.isConsistent()
+		}
+	}
+	if err != nil {
+		return Architecture{}, fmt.Errorf("failed to update architecture: %v", err)
+	}
+	arch := result.Architecture
+
+	_, enforced, err := h.evaluatePolicies(arch)
+	if err != nil {
+		return Architecture{}, err
+	}
+	if len(enforced) > 0 {
+		return Architecture{}, fmt.Errorf("architecture violates an enforced policy: %s", enforced[0])
+	}
+
+	if err := h.saveArchitecture(arch); err != nil {
+		return Architecture{}, fmt.Errorf("failed to save architecture: %v", err)
+	}
+	if err := h.checkOverlays(id); err != nil {
+		return Architecture{}, fmt.Errorf("failed to check overlays: %v", err)
+	}
+	return arch, nil
+}
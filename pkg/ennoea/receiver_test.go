@@ -0,0 +1,184 @@
+package ennoea
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiverTestTraces is an OTLP/JSON export with one call from web to
+// api.
+const receiverTestTraces = `{"resourceSpans": [
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "web"}}]},
+	 "scopeSpans": [{"spans": [{"traceId": "01", "spanId": "a", "kind": 3,
+		"startTimeUnixNano": "1700000000000000000", "endTimeUnixNano": "1700000000020000000"}]}]},
+	{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+	 "scopeSpans": [{"spans": [{"traceId": "01", "spanId": "b", "parentSpanId": "a", "kind": 2,
+		"startTimeUnixNano": "1700000000001000000", "endTimeUnixNano": "1700000000019000000"}]}]}
+]}`
+
+// receiverTestCall returns receiverTestTraces with the services renamed
+// and the spans moved by the number of seconds.
+func receiverTestCall(client, server string, seconds int64) []byte {
+	replacements := []string{`"web"`, strconv.Quote(client), `"api"`, strconv.Quote(server)}
+	for _, nanos := range []int64{1700000000000000000, 1700000000001000000, 1700000000019000000, 1700000000020000000} {
+		replacements = append(replacements, strconv.FormatInt(nanos, 10), strconv.FormatInt(nanos+seconds*1e9, 10))
+	}
+	return []byte(strings.NewReplacer(replacements...).Replace(receiverTestTraces))
+}
+
+// postTraces posts the export to the receiver with the content type,
+// gzipping it if asked.
+func postTraces(r *TraceReceiver, contentType string, body []byte, gzipped bool) *httptest.ResponseRecorder {
+	if gzipped {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(body)
+		zw.Close()
+		body = b.Bytes()
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTraceReceiver(t *testing.T) {
+	h, _ := newTestHandler(t)
+	receiver := NewTraceReceiver(h, TraceReceiverConfig{ArchitectureID: "live", Window: time.Minute})
+
+	if w := postTraces(receiver, "application/json", []byte(receiverTestTraces), false); w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Fatalf("POST JSON returned %d: %s", w.Code, w.Body)
+	}
+	if w := postTraces(receiver, "application/x-protobuf", testOTLPProto, true); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("POST protobuf returned %d: %s", w.Code, w.Body)
+	}
+	if err := receiver.Write(); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := h.loadArchitectureByID("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"component api", "component web", "connection web:api"}
+	if got := summariseNeighbourhood(arch); !reflect.DeepEqual(got, want) {
+		t.Errorf("architecture = %v, want %v", got, want)
+	}
+	if got := arch.Connections[0].Labels["requests"]; got != "2" {
+		t.Errorf("requests = %s, want 2", got)
+	}
+
+	// A new call is added as unconfirmed, and the spans that fall out
+	// of the window are dropped
+	if w := postTraces(receiver, "application/json", receiverTestCall("api", "db", 90), false); w.Code != http.StatusOK {
+		t.Fatalf("POST JSON returned %d: %s", w.Code, w.Body)
+	}
+	if err := receiver.Write(); err != nil {
+		t.Fatal(err)
+	}
+	arch, err = h.loadArchitectureByID("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"component api", "component web", "component db", "connection web:api", "connection api:db"}
+	if got := summariseNeighbourhood(arch); !reflect.DeepEqual(got, want) {
+		t.Errorf("architecture = %v, want %v", got, want)
+	}
+	if got := arch.Connections[1].Labels["confirmed"]; got != "false" {
+		t.Errorf("confirmed = %q, want false", got)
+	}
+
+	var status TraceReceiverStatus
+	serveTestJSON(t, receiver, http.MethodGet, "/v1/traces", "", http.StatusOK, &status)
+	if status.Received != 6 || status.Spans != 2 || status.Services != 3 || status.Unconfirmed != 1 || status.LastWrite == nil {
+		t.Errorf("status = %+v", status)
+	}
+
+	// Nothing is written without new spans
+	if err := receiver.Write(); err != nil {
+		t.Errorf("write without spans: %v", err)
+	}
+
+	if w := postTraces(receiver, "text/plain", []byte(receiverTestTraces), false); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("POST text returned %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+	if w := postTraces(receiver, "application/json", []byte(`{`), false); w.Code != http.StatusBadRequest {
+		t.Errorf("POST invalid JSON returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/traces", strings.NewReader(receiverTestTraces))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST with an invalid gzip body returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	serveTestJSON(t, receiver, http.MethodDelete, "/v1/traces", "", http.StatusMethodNotAllowed, nil)
+}
+
+// TestTraceReceiverConcurrentWrites runs the writes of the receiver
+// alongside requests that read and save architectures. It is meant to
+// be run with -race.
+func TestTraceReceiverConcurrentWrites(t *testing.T) {
+	h, err := NewArchitectureHandler(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, services, err := parseOTLP([]byte(receiverTestTraces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := NewTraceReceiver(h, TraceReceiverConfig{ArchitectureID: "live"})
+	receiver.receive(spans, services)
+	if err := receiver.Write(); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := h.loadArchitectureByID("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(arch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			receiver.receive(spans, services)
+			if err := receiver.Write(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/architectures/", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Errorf("PUT returned %d: %s", w.Code, w.Body)
+			}
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/architectures/", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("GET returned %d: %s", w.Code, w.Body)
+			}
+		}
+	}()
+	wg.Wait()
+}
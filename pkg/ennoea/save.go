@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -102,6 +106,17 @@ type ArchitectureSave struct {
 
 	// LastSaved is the time the architecture was last saved.
 	LastSaved time.Time `json:"lastSaved"`
+
+	// Base is the ID of the architecture that this architecture is
+	// an overlay of. The base is empty for concrete architectures.
+	Base string `json:"base,omitempty"`
+
+	// OverlayError is set when the overlay can no longer be resolved
+	// against its base architecture. This happens when the base
+	// changes in a way that breaks the overlay, for example when a
+	// patched component is removed from the base, or when the resolved
+	// overlay violates an enforced policy.
+	OverlayError string `json:"overlayError,omitempty"`
 }
 
 // ArchitectureHandler handles requests to save and load
@@ -115,9 +130,37 @@ type ArchitectureHandler struct {
 	// requested.
 	architectures map[string]ArchitectureSave
 
+	// architecturesMutex guards the architectures and writeMutexes
+	// maps, which are shared by the requests and the trace receiver.
+	architecturesMutex sync.RWMutex
+
+	// writeMutexes is a map of architecture IDs to the locks that are
+	// held while an architecture is loaded, changed and saved again,
+	// so that concurrent writes do not overwrite each other.
+	writeMutexes map[string]*sync.Mutex
+
 	// filePath is the path to the directory where architecture
 	// files are saved.
 	filePath string
+
+	// lintConfig is the configuration of the lint rules that run
+	// when an architecture is saved.
+	lintConfig LintConfig
+
+	// policies is the store of the policies that architectures are
+	// evaluated against, or nil if there are none.
+	policies *PolicyHandler
+
+	// commentsMutex guards the comment files against concurrent
+	// read-modify-write requests.
+	commentsMutex sync.Mutex
+
+	// spatialIndexes is a map of architecture IDs to the cached
+	// spatial index of their components.
+	spatialIndexes map[string]spatialIndex
+
+	// spatialMutex guards the spatial index cache.
+	spatialMutex sync.Mutex
 }
 
 // NewArchitectureHandler creates a new ArchitectureHandler.
@@ -126,13 +169,60 @@ type ArchitectureHandler struct {
 // request a list of saved architectures.
 func NewArchitectureHandler(filePath string) (*ArchitectureHandler, error) {
 	a := &ArchitectureHandler{
-		architectures: make(map[string]ArchitectureSave),
-		filePath:      filePath,
+		architectures:  make(map[string]ArchitectureSave),
+		writeMutexes:   make(map[string]*sync.Mutex),
+		filePath:       filePath,
+		spatialIndexes: make(map[string]spatialIndex),
 	}
 	err := a.loadArchitectureSaves()
 	return a, err
 }
 
+// architectureSave returns the save information of the architecture
+// with the ID.
+func (h *ArchitectureHandler) architectureSave(architectureID string) (ArchitectureSave, bool) {
+	h.architecturesMutex.RLock()
+	defer h.architecturesMutex.RUnlock()
+	save, ok := h.architectures[architectureID]
+	return save, ok
+}
+
+// architectureSaves returns the save information of every
+// architecture.
+func (h *ArchitectureHandler) architectureSaves() []ArchitectureSave {
+	h.architecturesMutex.RLock()
+	defer h.architecturesMutex.RUnlock()
+	saves := make([]ArchitectureSave, 0, len(h.architectures))
+	for _, save := range h.architectures {
+		saves = append(saves, save)
+	}
+	return saves
+}
+
+// setArchitectureSave adds or replaces the save information of an
+// architecture.
+func (h *ArchitectureHandler) setArchitectureSave(save ArchitectureSave) {
+	h.architecturesMutex.Lock()
+	defer h.architecturesMutex.Unlock()
+	h.architectures[save.ID] = save
+}
+
+// lockArchitecture locks the architecture with the ID against other
+// writes and returns the function that unlocks it. The lock is held
+// from before the architecture is loaded until after it is saved.
+func (h *ArchitectureHandler) lockArchitecture(architectureID string) func() {
+	h.architecturesMutex.Lock()
+	mutex, ok := h.writeMutexes[architectureID]
+	if !ok {
+		mutex = &sync.Mutex{}
+		h.writeMutexes[architectureID] = mutex
+	}
+	h.architecturesMutex.Unlock()
+
+	mutex.Lock()
+	return mutex.Unlock
+}
+
 // loadArchitectureSaves loads the architecture save files from
 // the save directory. Each save file "saveInfo.json" is stored
 // under a directory named after the architecture ID.
@@ -192,42 +282,205 @@ func (h *ArchitectureHandler) loadArchitectureSave(architectureID string) (Archi
 //
 //	save an architecture.
 //
-// GET /architectures/${architectureID}
+// GET /architectures/${architectureID}[?units=human]
 //
-//	load an architecture.
+//	load an architecture. Rates and sizes are rendered with human
+//	readable units when units=human is given.
+//
+// GET /architectures/${architectureID}/overlay
+//
+//	load the unresolved overlay document of an overlay architecture.
+//
+// GET /architectures/${architectureID}/analysis
+//
+//	return the graph analysis of an architecture.
+//
+// GET /architectures/${architectureID}/impact
+//
+//	return the blast radius of a failed component or group.
+//
+// GET /architectures/${architectureID}/paths
+//
+//	return the paths between two components.
+//
+// GET /architectures/${architectureID}/throughput
+//
+//	return the throughput report of an architecture.
+//
+// GET /architectures/${architectureID}/lint
+//
+//	return the lint findings of an architecture.
+//
+// GET /architectures/${architectureID}/policies
+//
+//	return the policy violations of an architecture.
+//
+// GET /architectures/${architectureID}/overlaps
+//
+//	return the overlapping scene objects of an architecture.
+//
+// GET /architectures/${architectureID}/neighbourhood
+//
+//	return the components within a number of hops of seed components.
+//
+// GET /architectures/${architectureID}/collapsed
+//
+//	return a summarised view of an architecture with groups collapsed.
+//
+// GET /architectures/${architectureID}/region/${shape}
+//
+//	return the components inside a box, sphere or camera frustum.
+//
+// GET /architectures/${architectureID}/query
+//
+//	return the entities that match a query.
+//
+// GET /architectures/${architectureID}/views
+//
+//	return the views saved with an architecture.
+//
+// GET /architectures/${architectureID}/views/${viewID}
+//
+//	return the architecture as shown by a view.
+//
+// PUT /architectures/${architectureID}/overlays
+//
+//	save an overlay of the architecture.
+//
+// POST /architectures/${architectureID}/layout
+//
+//	compute the positions of the components.
+//
+// POST /architectures/${architectureID}/nudge
+//
+//	move overlapping components apart.
+//
+// POST /architectures/${architectureID}/neighbourhood
+//
+//	save the neighbourhood of seed components as a new architecture.
+//
+// /architectures/${architectureID}/comments/...
+//
+//	manage the comment threads of the architecture.
 func (h *ArchitectureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitArchitecturePath(r.URL.Path)
+
+	// Comments support more methods than the architecture routes so
+	// they are routed separately.
+	if len(segments) >= 2 && segments[1] == "comments" {
+		h.handleCommentsRoute(w, r, segments[0], segments[2:])
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		// There are two possible GET requests:
+		// The possible GET requests are:
 		// 1. GET /architectures/
 		// 2. GET /architectures/${architectureID}
-		h.handleGetRoute(w, r)
+		// 3. GET /architectures/${architectureID}/${subresource}
+		h.handleGetRoute(w, r, segments)
 	case http.MethodPut:
-		// PUT /architectures/
-		h.handlePut(w, r)
+		// There are two possible PUT requests:
+		// 1. PUT /architectures/
+		// 2. PUT /architectures/${architectureID}/overlays
+		h.handlePutRoute(w, r, segments)
+	case http.MethodPost:
+		// The possible POST requests are:
+		// 1. POST /architectures/${architectureID}/layout
+		// 2. POST /architectures/${architectureID}/nudge
+		// 3. POST /architectures/${architectureID}/neighbourhood
+		h.handlePostRoute(w, r, segments)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		fmt.Fprintf(w, "Method not allowed")
 	}
 }
 
-// handleGetRoute handles GET requests to the architecture. There
-// are two possible GET requests:
-// 1. GET /architectures/
-// 2. GET /architectures/${architectureID}
-// This function determines which request was made and calls the
-// appropriate handler.
-func (h *ArchitectureHandler) handleGetRoute(w http.ResponseWriter, r *http.Request) {
-	// Get the architecture ID from the request URL
-	architectureID := filepath.Base(r.URL.Path)
+// splitArchitecturePath splits the request path into the segments
+// that follow the "/architectures/" prefix. An empty slice is
+// returned for the architecture list route.
+func splitArchitecturePath(path string) []string {
+	path = strings.TrimPrefix(path, "/architectures")
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
 
-	// Check if the architecture ID is empty
-	if architectureID == "" || architectureID == "architectures" {
+// handleGetRoute handles GET requests to the architecture. This
+// function determines which request was made from the path
+// segments and calls the appropriate handler.
+func (h *ArchitectureHandler) handleGetRoute(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 0:
 		h.handleGetAll(w, r)
-		return
+	case len(segments) == 1:
+		h.handleGetArchitecture(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "overlay":
+		h.handleGetOverlay(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "analysis":
+		h.handleGetAnalysis(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "impact":
+		h.handleGetImpact(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "paths":
+		h.handleGetPaths(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "throughput":
+		h.handleGetThroughput(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "lint":
+		h.handleGetLint(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "policies":
+		h.handleGetPolicies(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "overlaps":
+		h.handleGetOverlaps(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "neighbourhood":
+		h.handleGetNeighbourhood(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "collapsed":
+		h.handleGetCollapsed(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "query":
+		h.handleGetQuery(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "views":
+		h.handleGetViews(w, r, segments[0])
+	case len(segments) == 3 && segments[1] == "views":
+		h.handleGetView(w, r, segments[0], segments[2])
+	case len(segments) == 3 && segments[1] == "region":
+		h.handleGetRegion(w, r, segments[0], segments[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+	}
+}
+
+// handlePutRoute handles PUT requests to the architecture. This
+// function determines which request was made from the path
+// segments and calls the appropriate handler.
+func (h *ArchitectureHandler) handlePutRoute(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 0:
+		h.handlePut(w, r)
+	case len(segments) == 2 && segments[1] == "overlays":
+		h.handlePutOverlay(w, r, segments[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
 	}
+}
 
-	h.handleGetArchitecture(w, r, architectureID)
+// handlePostRoute handles POST requests to the architecture. This
+// function determines which request was made from the path
+// segments and calls the appropriate handler.
+func (h *ArchitectureHandler) handlePostRoute(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 2 && segments[1] == "layout":
+		h.handlePostLayout(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "nudge":
+		h.handlePostNudge(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "neighbourhood":
+		h.handlePostNeighbourhood(w, r, segments[0])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+	}
 }
 
 // handleGetAll handles GET requests for all saved architectures.
@@ -246,10 +499,7 @@ func (h *ArchitectureHandler) handleGetRoute(w http.ResponseWriter, r *http.Requ
 func (h *ArchitectureHandler) handleGetAll(w http.ResponseWriter, r *http.Request) {
 	// Create a list of architectures for each architecture save
 	// in the map
-	aList := make([]ArchitectureSave, 0, len(h.architectures))
-	for _, arch := range h.architectures {
-		aList = append(aList, arch)
-	}
+	aList := h.architectureSaves()
 
 	// Marshal the architectures into JSON
 	file, err := json.Marshal(aList)
@@ -300,13 +550,20 @@ func (h *ArchitectureHandler) handleGetAll(w http.ResponseWriter, r *http.Reques
 //	}
 func (h *ArchitectureHandler) handleGetArchitecture(w http.ResponseWriter, r *http.Request, architectureID string) {
 	// Check if the architecture ID is valid
-	arch, ok := h.architectures[architectureID]
+	arch, ok := h.architectureSave(architectureID)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Architecture not found")
 		return
 	}
 
+	// Overlays are resolved against their base architecture so
+	// that the client always receives a concrete architecture.
+	if arch.Base != "" {
+		h.handleGetResolvedOverlay(w, r, arch)
+		return
+	}
+
 	// Load the architecture file. The architecture file is stored
 	// under a directory named after the architecture ID with the
 	// name "architecture.json".
@@ -318,6 +575,23 @@ func (h *ArchitectureHandler) handleGetArchitecture(w http.ResponseWriter, r *ht
 		return
 	}
 
+	h.writeArchitectureResponse(w, r, file)
+}
+
+// writeArchitectureResponse writes the architecture JSON to the
+// response. If the request asks for human readable units, the rates
+// and sizes are converted before the response is written.
+func (h *ArchitectureHandler) writeArchitectureResponse(w http.ResponseWriter, r *http.Request, file []byte) {
+	if r.URL.Query().Get("units") == "human" {
+		var err error
+		file, err = humanizeUnits(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Failed to format units: %v", err)
+			return
+		}
+	}
+
 	// Write the response.
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -327,7 +601,10 @@ func (h *ArchitectureHandler) handleGetArchitecture(w http.ResponseWriter, r *ht
 // handlePut handles PUT requests to save an architecture.
 // PUT /architectures/
 //
-//	save an architecture.
+//	save an architecture. Any lint findings and policy violations are
+//	listed in the response, one per line, after the architecture is
+//	saved. An architecture that violates an enforced policy is not
+//	saved.
 func (h *ArchitectureHandler) handlePut(w http.ResponseWriter, r *http.Request) {
 	// Load the architecture from the request body
 	arch, err := h.loadArchitecture(r.Body)
@@ -344,6 +621,44 @@ func (h *ArchitectureHandler) handlePut(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Generate the ID here rather than in saveArchitecture, so that
+	// the overlays of the new architecture are checked by its ID.
+	if arch.Info.ID == "" {
+		arch.Info.ID = generateID()
+	}
+	if err := isValidArchitectureID(arch.Info.ID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid architecture: %v", err)
+		return
+	}
+	defer h.lockArchitecture(arch.Info.ID)()
+
+	// Overlays are saved through the overlay route, so do not allow
+	// a concrete architecture to replace one.
+	if save, ok := h.architectureSave(arch.Info.ID); ok && save.Base != "" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Architecture %s is an overlay of %s", save.ID, save.Base)
+		return
+	}
+
+	// Evaluate the policies. Violations of enforced policies stop the
+	// architecture from being saved.
+	violations, ok := h.enforcePolicies(w, arch)
+	if !ok {
+		return
+	}
+
+	// Lint the architecture before it is saved, so that a failure to
+	// lint does not report an error for an architecture that was
+	// saved. The findings are returned with the response but do not
+	// stop the architecture from being saved.
+	findings, err := LintArchitecture(arch, h.lintConfig)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to lint architecture: %v", err)
+		return
+	}
+
 	// Save the architecture
 	err = h.saveArchitecture(arch)
 	if err != nil {
@@ -352,9 +667,30 @@ func (h *ArchitectureHandler) handlePut(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Flag any overlays that can no longer be resolved against the
+	// new version of the architecture.
+	err = h.checkOverlays(arch.Info.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to check overlays: %v", err)
+		return
+	}
+
 	// Write the response
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Architecture saved")
+	if len(findings) > 0 {
+		fmt.Fprintf(w, " with %d lint findings:", len(findings))
+		for _, f := range findings {
+			fmt.Fprintf(w, "\n%s", f)
+		}
+	}
+	if len(violations) > 0 {
+		fmt.Fprintf(w, "\nPolicy violations:")
+		for _, v := range violations {
+			fmt.Fprintf(w, "\n%s", v)
+		}
+	}
 }
 
 // loadArchitecture loads the architecture from the request body.
@@ -402,11 +738,11 @@ func (h *ArchitectureHandler) saveArchitecture(arch Architecture) error {
 	}
 
 	// Add the architecture to the map
-	h.architectures[arch.Info.ID] = ArchitectureSave{
+	h.setArchitectureSave(ArchitectureSave{
 		ID:        arch.Info.ID,
 		LastSaved: time.Now(),
 		Name:      arch.Info.Name,
-	}
+	})
 
 	return nil
 }
@@ -437,18 +773,24 @@ func (h *ArchitectureHandler) saveArchitectureFile(arch Architecture) error {
 // directory named after the architecture ID with the name
 // "saveInfo.json".
 func (h *ArchitectureHandler) saveArchitectureSave(arch Architecture) error {
-	// Marshal the architecture save into JSON
-	file, err := json.Marshal(ArchitectureSave{
+	return h.writeArchitectureSave(ArchitectureSave{
 		ID:        arch.Info.ID,
 		Name:      arch.Info.Name,
 		LastSaved: time.Now(),
 	})
+}
+
+// writeArchitectureSave writes the save information to the
+// "saveInfo.json" file in the architecture directory.
+func (h *ArchitectureHandler) writeArchitectureSave(save ArchitectureSave) error {
+	// Marshal the architecture save into JSON
+	file, err := json.Marshal(save)
 	if err != nil {
 		return fmt.Errorf("failed to marshal architecture save: %v", err)
 	}
 
 	// Save the architecture save file
-	filePath := filepath.Join(h.filePath, arch.Info.ID, "saveInfo.json")
+	filePath := filepath.Join(h.filePath, save.ID, "saveInfo.json")
 	err = os.WriteFile(filePath, file, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to save architecture save file: %v", err)
@@ -457,8 +799,185 @@ func (h *ArchitectureHandler) saveArchitectureSave(arch Architecture) error {
 	return nil
 }
 
+// loadArchitectureByID loads the architecture with the given ID
+// from the save directory. Overlays are resolved against their
+// base so the returned architecture is always concrete.
+func (h *ArchitectureHandler) loadArchitectureByID(architectureID string) (Architecture, error) {
+	return h.loadArchitectureDepth(architectureID, 0)
+}
+
+// loadArchitectureDepth loads the architecture with the given ID,
+// keeping track of how many overlays deep the lookup is so that
+// cyclic overlays do not recurse forever.
+func (h *ArchitectureHandler) loadArchitectureDepth(architectureID string, depth int) (Architecture, error) {
+	save, ok := h.architectureSave(architectureID)
+	if !ok {
+		return Architecture{}, fmt.Errorf("architecture not found: %s", architectureID)
+	}
+
+	if save.Base != "" {
+		if depth >= maxOverlayDepth {
+			return Architecture{}, fmt.Errorf("overlay %s is nested too deeply", architectureID)
+		}
+		overlay, err := h.loadOverlay(architectureID)
+		if err != nil {
+			return Architecture{}, err
+		}
+		base, err := h.loadArchitectureDepth(save.Base, depth+1)
+		if err != nil {
+			return Architecture{}, fmt.Errorf("failed to load base architecture: %w", err)
+		}
+		return overlay.resolve(base)
+	}
+
+	filePath := filepath.Join(h.filePath, save.ID, "architecture.json")
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return Architecture{}, fmt.Errorf("failed to read architecture file: %v", err)
+	}
+
+	var arch Architecture
+	err = json.Unmarshal(file, &arch)
+	if err != nil {
+		return Architecture{}, fmt.Errorf("failed to unmarshal architecture file: %v", err)
+	}
+
+	return arch, nil
+}
+
+// loadArchitectureForRequest loads the architecture with the ID. If
+// the architecture cannot be loaded, an error is written to the
+// response and false is returned.
+func (h *ArchitectureHandler) loadArchitectureForRequest(w http.ResponseWriter, architectureID string) (Architecture, bool) {
+	if _, ok := h.architectureSave(architectureID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Architecture not found")
+		return Architecture{}, false
+	}
+
+	arch, err := h.loadArchitectureByID(architectureID)
+	if err != nil {
+		// Overlays that no longer resolve are a conflict with their
+		// base rather than a server error.
+		status := http.StatusInternalServerError
+		if save, _ := h.architectureSave(architectureID); save.Base != "" {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "Failed to load architecture: %v", err)
+		return Architecture{}, false
+	}
+
+	return arch, true
+}
+
+// saveArchitectureForRequest saves an architecture that a request has
+// changed and checks the overlays of it. Overlays are resolved from
+// their base every time they are loaded, so changes cannot be saved
+// into them. The caller holds the lock of the architecture from before
+// it was loaded. If the architecture cannot be saved, an error is
+// written to the response and false is returned.
+func (h *ArchitectureHandler) saveArchitectureForRequest(w http.ResponseWriter, arch Architecture) bool {
+	if save, _ := h.architectureSave(arch.Info.ID); save.Base != "" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Architecture %s is an overlay of %s", save.ID, save.Base)
+		return false
+	}
+
+	err := h.saveArchitecture(arch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save architecture: %v", err)
+		return false
+	}
+
+	err = h.checkOverlays(arch.Info.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to check overlays: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// writeJSONResponse marshals the value and writes it to the response
+// with the given status code.
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	file, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to marshal response: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(file)
+}
+
+// queryInt parses an integer query parameter. The default is returned
+// if the parameter is empty, and an error is returned if the value is
+// outside of the range.
+func queryInt(value string, defaultValue, minValue, maxValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if i < minValue || i > maxValue {
+		return 0, fmt.Errorf("%d is not between %d and %d", i, minValue, maxValue)
+	}
+
+	return i, nil
+}
+
+// queryFloat parses a floating point query parameter. The default is
+// returned if the parameter is empty.
+func queryFloat(value string, defaultValue float64) (float64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%s is not a positive number", value)
+	}
+
+	return f, nil
+}
+
+// queryBool parses a boolean query parameter. An empty value is
+// false.
+func queryBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
 // generateID generates a unique ID. The ID is generated by hashing
 // the current time.
 func generateID() string {
 	return fmt.Sprintf("%x", time.Now().UnixNano())
 }
+
+// isValidArchitectureID returns an error if the ID cannot be used as
+// the name of the save directory of an architecture. IDs from request
+// bodies and queries are joined to the save path, so they must not
+// leave it.
+func isValidArchitectureID(architectureID string) error {
+	if architectureID == "" {
+		return fmt.Errorf("invalid ID: ID is empty")
+	}
+	if architectureID == "." || strings.ContainsAny(architectureID, "/\\\x00") || strings.Contains(architectureID, "..") {
+		return fmt.Errorf("invalid ID: %q contains a path separator or ..", architectureID)
+	}
+	return nil
+}
@@ -0,0 +1,478 @@
+package ennoea
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// octreeNodeCapacity is the number of items a node holds before
+	// it is split into eight children.
+	octreeNodeCapacity = 8
+
+	// octreeMaxDepth is the depth below which nodes are not split.
+	// It stops components at the same position from splitting nodes
+	// forever.
+	octreeMaxDepth = 12
+
+	// Defaults of the camera used for frustum queries. They match
+	// the camera that the viewer creates in scene.js.
+	defaultCameraFov  = 45.0
+	defaultCameraNear = 0.1
+	defaultCameraFar  = 1000.0
+)
+
+// octree is a spatial index of the bounding boxes of the components.
+// Each box is stored in the smallest node that contains it entirely,
+// so boxes that straddle the boundaries of the children stay in the
+// parent.
+type octree struct {
+	root *octreeNode
+
+	// boxes is the bounding box of each component.
+	boxes []aabb
+}
+
+// octreeNode is a node of the octree.
+type octreeNode struct {
+	// bounds is the cube that the node covers.
+	bounds aabb
+
+	// items is the list of the indexes of the components that are
+	// stored in the node.
+	items []int
+
+	// children is the eight octants of the node, or nil if the node
+	// is a leaf.
+	children *[8]*octreeNode
+}
+
+// newOctree builds the octree of the bounding boxes.
+func newOctree(boxes []aabb) *octree {
+	t := &octree{boxes: boxes}
+	if len(boxes) == 0 {
+		t.root = &octreeNode{}
+		return t
+	}
+
+	// The root is a cube around every box so that the octants are
+	// cubes as well
+	bounds := boxes[0]
+	for _, b := range boxes[1:] {
+		bounds = bounds.union(b)
+	}
+	centre := bounds.centre()
+	half := 0.0
+	for axis := 0; axis < 3; axis++ {
+		half = math.Max(half, (bounds.max[axis]-bounds.min[axis])/2)
+	}
+	t.root = &octreeNode{bounds: aabb{min: centre, max: centre}.expand(half + 1)}
+
+	for i := range boxes {
+		t.root.insert(t, i, 0)
+	}
+	return t
+}
+
+// insert adds the item to the node or to one of its children.
+func (n *octreeNode) insert(t *octree, item, depth int) {
+	if n.children != nil {
+		if child := n.childFor(t.boxes[item]); child != nil {
+			child.insert(t, item, depth+1)
+			return
+		}
+		n.items = append(n.items, item)
+		return
+	}
+
+	n.items = append(n.items, item)
+	if len(n.items) <= octreeNodeCapacity || depth >= octreeMaxDepth {
+		return
+	}
+
+	// Split the node and move the items that fit into a child
+	n.children = &[8]*octreeNode{}
+	centre := n.bounds.centre()
+	for octant := range n.children {
+		var bounds aabb
+		for axis := 0; axis < 3; axis++ {
+			if octant&(1<<axis) == 0 {
+				bounds.min[axis], bounds.max[axis] = n.bounds.min[axis], centre[axis]
+			} else {
+				bounds.min[axis], bounds.max[axis] = centre[axis], n.bounds.max[axis]
+			}
+		}
+		n.children[octant] = &octreeNode{bounds: bounds}
+	}
+	items := n.items
+	n.items = nil
+	for _, i := range items {
+		if child := n.childFor(t.boxes[i]); child != nil {
+			child.insert(t, i, depth+1)
+		} else {
+			n.items = append(n.items, i)
+		}
+	}
+}
+
+// childFor returns the child that contains the box entirely, or nil
+// if the box straddles the centre of the node.
+func (n *octreeNode) childFor(box aabb) *octreeNode {
+	centre := n.bounds.centre()
+	octant := 0
+	for axis := 0; axis < 3; axis++ {
+		switch {
+		case box.max[axis] <= centre[axis]:
+		case box.min[axis] >= centre[axis]:
+			octant |= 1 << axis
+		default:
+			return nil
+		}
+	}
+	return n.children[octant]
+}
+
+// query returns the items whose boxes match the test, in index
+// order. The test is also used to skip the nodes whose bounds do not
+// match, so it must be true for any box that contains a matching box.
+func (t *octree) query(test func(aabb) bool) []int {
+	var result []int
+	var visit func(n *octreeNode)
+	visit = func(n *octreeNode) {
+		if !test(n.bounds) {
+			return
+		}
+		for _, i := range n.items {
+			if test(t.boxes[i]) {
+				result = append(result, i)
+			}
+		}
+		if n.children != nil {
+			for _, child := range n.children {
+				visit(child)
+			}
+		}
+	}
+	if len(t.boxes) > 0 {
+		visit(t.root)
+	}
+
+	sort.Ints(result)
+	return result
+}
+
+// intersects returns true if the boxes overlap or touch.
+func (b aabb) intersects(o aabb) bool {
+	for axis := 0; axis < 3; axis++ {
+		if b.min[axis] > o.max[axis] || o.min[axis] > b.max[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// intersectsSphere returns true if the box overlaps the sphere.
+func (b aabb) intersectsSphere(centre [3]float64, radius float64) bool {
+	distance := 0.0
+	for axis := 0; axis < 3; axis++ {
+		d := math.Max(b.min[axis]-centre[axis], math.Max(0, centre[axis]-b.max[axis]))
+		distance += d * d
+	}
+	return distance <= radius*radius
+}
+
+// frustumPlane is a plane whose normal points into the frustum. A
+// point p is on the inside if normal·p + offset >= 0.
+type frustumPlane struct {
+	normal [3]float64
+	offset float64
+}
+
+// frustum is the volume that a perspective camera can see.
+type frustum [6]frustumPlane
+
+// Camera parameters used to build a frustum.
+type frustumCamera struct {
+	position [3]float64
+	target   [3]float64
+	up       [3]float64
+
+	// fov is the vertical field of view in degrees.
+	fov    float64
+	aspect float64
+	near   float64
+	far    float64
+}
+
+// newFrustum returns the frustum of the camera looking from its
+// position towards the target.
+func newFrustum(c frustumCamera) (frustum, error) {
+	forward := vecNormalize(vecSub(c.target, c.position))
+	right := vecNormalize(vecCross(forward, c.up))
+	if vecLength(forward) == 0 || vecLength(right) == 0 {
+		return frustum{}, fmt.Errorf("the camera must look at a target that is not in line with up")
+	}
+	up := vecCross(right, forward)
+
+	tanY := math.Tan(c.fov * math.Pi / 360)
+	tanX := tanY * c.aspect
+
+	// The side planes pass through the camera and contain an edge of
+	// the view
+	sides := [][3]float64{
+		vecCross(vecSub(forward, vecScale(right, tanX)), up),
+		vecCross(up, vecAdd(forward, vecScale(right, tanX))),
+		vecCross(right, vecSub(forward, vecScale(up, tanY))),
+		vecCross(vecAdd(forward, vecScale(up, tanY)), right),
+	}
+
+	var f frustum
+	for i, normal := range sides {
+		normal = vecNormalize(normal)
+		f[i] = frustumPlane{normal: normal, offset: -vecDot(normal, c.position)}
+	}
+	nearPoint := vecAdd(c.position, vecScale(forward, c.near))
+	farPoint := vecAdd(c.position, vecScale(forward, c.far))
+	f[4] = frustumPlane{normal: forward, offset: -vecDot(forward, nearPoint)}
+	f[5] = frustumPlane{normal: vecScale(forward, -1), offset: vecDot(forward, farPoint)}
+
+	return f, nil
+}
+
+// intersectsFrustum returns true if the box may be inside the
+// frustum. The box is outside if its corner furthest along the normal
+// of any plane is behind that plane. Boxes near the corners of the
+// frustum can be included even though they are just outside.
+func (b aabb) intersectsFrustum(f frustum) bool {
+	for _, plane := range f {
+		var corner [3]float64
+		for axis := 0; axis < 3; axis++ {
+			corner[axis] = b.min[axis]
+			if plane.normal[axis] >= 0 {
+				corner[axis] = b.max[axis]
+			}
+		}
+		if vecDot(plane.normal, corner)+plane.offset < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RegionResult represents the components inside a region of the
+// scene and the connections between them.
+type RegionResult struct {
+	// Components is the list of the components whose bounding boxes
+	// are inside or overlap the region.
+	Components []Component `json:"components"`
+
+	// Connections is the list of the connections whose source and
+	// target are both in the region.
+	Connections []Connection `json:"connections"`
+}
+
+// newRegionResult returns the components with the indexes and the
+// connections between them.
+func newRegionResult(arch Architecture, indexes []int) RegionResult {
+	result := RegionResult{
+		Components:  make([]Component, 0, len(indexes)),
+		Connections: []Connection{},
+	}
+	inside := make(map[string]bool, len(indexes))
+	for _, i := range indexes {
+		result.Components = append(result.Components, arch.Components[i])
+		inside[arch.Components[i].ID] = true
+	}
+	for _, c := range arch.Connections {
+		if inside[c.Source] && inside[c.Target] {
+			result.Connections = append(result.Connections, c)
+		}
+	}
+	return result
+}
+
+// spatialIndex is a cached octree of a saved architecture.
+type spatialIndex struct {
+	// lastSaved is the time the architecture was saved when the
+	// octree was built.
+	lastSaved time.Time
+
+	tree *octree
+}
+
+// spatialIndexFor returns the octree of the architecture. The octree
+// of a concrete architecture is cached until the architecture is
+// saved again. Overlays change whenever their base does, so their
+// octree is always rebuilt. The save must be taken before the
+// architecture is loaded, so that an architecture saved in between is
+// cached under the older time and rebuilt on the next request.
+func (h *ArchitectureHandler) spatialIndexFor(architectureID string, save ArchitectureSave, arch Architecture) *octree {
+	h.spatialMutex.Lock()
+	defer h.spatialMutex.Unlock()
+
+	if index, ok := h.spatialIndexes[architectureID]; ok && save.Base == "" && index.lastSaved.Equal(save.LastSaved) {
+		return index.tree
+	}
+
+	boxes := make([]aabb, len(arch.Components))
+	for i, c := range arch.Components {
+		boxes[i] = objectAABB(c.Object)
+	}
+	tree := newOctree(boxes)
+
+	if save.Base == "" {
+		h.spatialIndexes[architectureID] = spatialIndex{lastSaved: save.LastSaved, tree: tree}
+	}
+	return tree
+}
+
+// handleGetRegion handles GET requests for the components in a region
+// of the scene.
+// GET /architectures/${architectureID}/region/box?min=${x,y,z}&max=${x,y,z}
+//
+//	return the components that overlap the axis-aligned box.
+//
+// GET /architectures/${architectureID}/region/sphere?centre=${x,y,z}&radius=${r}
+//
+//	return the components that overlap the sphere.
+//
+// GET /architectures/${architectureID}/region/frustum?position=${x,y,z}
+//
+//	return the components that the camera can see. The optional
+//	parameters are:
+//	  target  the point the camera looks at (0,0,0)
+//	  up      the up direction of the camera (0,1,0)
+//	  fov     the vertical field of view in degrees (45)
+//	  aspect  the width divided by the height of the view (1)
+//	  near    the distance to the near plane (0.1)
+//	  far     the distance to the far plane (1000)
+//
+// The connections between the returned components are included.
+func (h *ArchitectureHandler) handleGetRegion(w http.ResponseWriter, r *http.Request, architectureID, shape string) {
+	save, _ := h.architectureSave(architectureID)
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	test, err := regionTest(shape, r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid region: %v", err)
+		return
+	}
+
+	tree := h.spatialIndexFor(architectureID, save, arch)
+	writeJSONResponse(w, http.StatusOK, newRegionResult(arch, tree.query(test)))
+}
+
+// regionTest returns the test for the region described by the shape
+// and the query parameters.
+func regionTest(shape string, query map[string][]string) (func(aabb) bool, error) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	switch shape {
+	case "box":
+		min, err := queryVector(get("min"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("min: %v", err)
+		}
+		max, err := queryVector(get("max"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("max: %v", err)
+		}
+		for i := range min {
+			if min[i] > max[i] {
+				return nil, fmt.Errorf("min must not be greater than max")
+			}
+		}
+		region := aabb{min: min, max: max}
+		return region.intersects, nil
+
+	case "sphere":
+		centre, err := queryVector(get("centre"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("centre: %v", err)
+		}
+		radius, err := queryFloat(get("radius"), -1)
+		if err != nil || radius < 0 {
+			return nil, fmt.Errorf("radius must be a positive number")
+		}
+		return func(b aabb) bool { return b.intersectsSphere(centre, radius) }, nil
+
+	case "frustum":
+		var c frustumCamera
+		var err error
+		if c.position, err = queryVector(get("position"), nil); err != nil {
+			return nil, fmt.Errorf("position: %v", err)
+		}
+		if c.target, err = queryVector(get("target"), &[3]float64{}); err != nil {
+			return nil, fmt.Errorf("target: %v", err)
+		}
+		if c.up, err = queryVector(get("up"), &[3]float64{0, 1, 0}); err != nil {
+			return nil, fmt.Errorf("up: %v", err)
+		}
+		if c.fov, err = queryFloat(get("fov"), defaultCameraFov); err != nil || c.fov <= 0 || c.fov >= 180 {
+			return nil, fmt.Errorf("fov must be between 0 and 180")
+		}
+		if c.aspect, err = queryFloat(get("aspect"), 1); err != nil || c.aspect <= 0 {
+			return nil, fmt.Errorf("aspect must be a positive number")
+		}
+		if c.near, err = queryFloat(get("near"), defaultCameraNear); err != nil {
+			return nil, fmt.Errorf("near: %v", err)
+		}
+		if c.far, err = queryFloat(get("far"), defaultCameraFar); err != nil || c.far <= c.near {
+			return nil, fmt.Errorf("far must be further than near")
+		}
+		f, err := newFrustum(c)
+		if err != nil {
+			return nil, err
+		}
+		return f.contains, nil
+
+	default:
+		return nil, fmt.Errorf("unknown shape: %s", shape)
+	}
+}
+
+// contains returns true if the box may be inside the frustum.
+func (f frustum) contains(b aabb) bool {
+	return b.intersectsFrustum(f)
+}
+
+// queryVector parses a vector query parameter of the form "x,y,z".
+// The default is returned if the parameter is empty, and an error is
+// returned if there is no default.
+func queryVector(value string, defaultValue *[3]float64) ([3]float64, error) {
+	if value == "" {
+		if defaultValue == nil {
+			return [3]float64{}, fmt.Errorf("missing vector")
+		}
+		return *defaultValue, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return [3]float64{}, fmt.Errorf("%s is not a vector of x,y,z", value)
+	}
+
+	var v [3]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return [3]float64{}, fmt.Errorf("%s is not a vector of x,y,z", value)
+		}
+		v[i] = f
+	}
+	return v, nil
+}
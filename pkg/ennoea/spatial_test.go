@@ -0,0 +1,216 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// randomBoxes returns count boxes of random sizes spread over a cube.
+func randomBoxes(rng *rand.Rand, count int, side float64) []aabb {
+	boxes := make([]aabb, count)
+	for i := range boxes {
+		var centre [3]float64
+		for axis := range centre {
+			centre[axis] = (rng.Float64() - 0.5) * side
+		}
+		boxes[i] = aabb{min: centre, max: centre}.expand(rng.Float64() * 2)
+	}
+	return boxes
+}
+
+// bruteForceQuery returns the indexes of the boxes that match the
+// test without using an index.
+func bruteForceQuery(boxes []aabb, test func(aabb) bool) []int {
+	var result []int
+	for i, b := range boxes {
+		if test(b) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func TestOctreeQuery(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	boxes := randomBoxes(rng, 500, 100)
+	tree := newOctree(boxes)
+	if tree.root.children == nil {
+		t.Fatalf("root of 500 boxes was not split")
+	}
+
+	camera := frustumCamera{position: [3]float64{0, 0, 80}, up: [3]float64{0, 1, 0}, fov: 45, aspect: 1.5, near: 0.1, far: 100}
+	f, err := newFrustum(camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 50; n++ {
+		region := randomBoxes(rng, 1, 100)[0].expand(rng.Float64() * 20)
+		centre := randomBoxes(rng, 1, 100)[0].centre()
+		radius := rng.Float64() * 30
+		tests := map[string]func(aabb) bool{
+			"box":     region.intersects,
+			"sphere":  func(b aabb) bool { return b.intersectsSphere(centre, radius) },
+			"frustum": f.contains,
+		}
+		for name, test := range tests {
+			if got, want := tree.query(test), bruteForceQuery(boxes, test); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s query %d returned %v, want %v", name, n, got, want)
+			}
+		}
+	}
+}
+
+func TestOctreeSamePosition(t *testing.T) {
+	// Boxes at the same position cannot be separated, so the depth
+	// of the tree is limited
+	boxes := make([]aabb, 100)
+	for i := range boxes {
+		boxes[i] = aabb{min: [3]float64{1, 1, 1}, max: [3]float64{1, 1, 1}}
+	}
+	tree := newOctree(boxes)
+	if got := tree.query(func(b aabb) bool { return b.containsPoint([3]float64{1, 1, 1}) }); len(got) != 100 {
+		t.Errorf("query returned %d boxes, want 100", len(got))
+	}
+	if got := newOctree(nil).query(func(aabb) bool { return true }); len(got) != 0 {
+		t.Errorf("query of an empty tree returned %v", got)
+	}
+}
+
+func TestFrustumContains(t *testing.T) {
+	camera := frustumCamera{position: [3]float64{0, 0, 10}, up: [3]float64{0, 1, 0}, fov: 90, aspect: 1, near: 1, far: 20}
+	f, err := newFrustum(camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := aabb{min: [3]float64{-0.5, -0.5, -0.5}, max: [3]float64{0.5, 0.5, 0.5}}
+	tests := []struct {
+		name   string
+		offset [3]float64
+		want   bool
+	}{
+		{"in front", [3]float64{0, 0, 0}, true},
+		{"at the edge of the view", [3]float64{10, 0, 0}, true},
+		{"left of the view", [3]float64{-12, 0, 0}, false},
+		{"above the view", [3]float64{0, 12, 0}, false},
+		{"behind the camera", [3]float64{0, 0, 12}, false},
+		{"closer than near", [3]float64{0, 0, 9.6}, false},
+		{"further than far", [3]float64{0, 0, -11}, false},
+	}
+	for _, test := range tests {
+		if got := f.contains(unit.translate(test.offset)); got != test.want {
+			t.Errorf("%s: contains = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	camera.up = [3]float64{0, 0, 1}
+	if _, err := newFrustum(camera); err == nil {
+		t.Errorf("camera looking along up: expected an error")
+	}
+}
+
+// regionIDs returns the IDs of the components and connections of the
+// region.
+func regionIDs(result RegionResult) []string {
+	ids := []string{}
+	for _, c := range result.Components {
+		ids = append(ids, c.ID)
+	}
+	for _, c := range result.Connections {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestGetRegion(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"box?min=-6,-1,-1&max=-4,1,1", []string{"web"}},
+		{"box?min=-5,0,0&max=0,0,0", []string{"web", "api", "web-api"}},
+		{"sphere?centre=5,3,0&radius=2", []string{"db"}},
+		{"sphere?centre=0,10,0&radius=1", []string{}},
+		{"frustum?position=0,0,10", []string{"api", "db", "api-db"}},
+		{"frustum?position=0,0,10&fov=90", []string{"web", "api", "db", "web-api", "api-db"}},
+		{"frustum?position=0,0,10&target=5,0,0&fov=10", []string{"db"}},
+		{"frustum?position=0,0,10&far=5", []string{}},
+	}
+	for _, test := range tests {
+		var result RegionResult
+		serveTestJSON(t, h, http.MethodGet, "/architectures/shop/region/"+test.query, "", http.StatusOK, &result)
+		if got := regionIDs(result); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: region = %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	invalid := []string{
+		"cone?centre=0,0,0",
+		"box?min=0,0,0",
+		"box?min=0,0&max=1,1,1",
+		"box?min=1,0,0&max=0,1,1",
+		"sphere?centre=0,0,0",
+		"sphere?centre=0,0,0&radius=-1",
+		"frustum",
+		"frustum?position=0,0,10&fov=180",
+		"frustum?position=0,0,10&near=5&far=5",
+		"frustum?position=0,10,0&up=0,1,0",
+	}
+	for _, query := range invalid {
+		serveTestJSON(t, h, http.MethodGet, "/architectures/shop/region/"+query, "", http.StatusBadRequest, nil)
+	}
+	serveTestJSON(t, h, http.MethodGet, "/architectures/none/region/box?min=0,0,0&max=1,1,1", "", http.StatusNotFound, nil)
+}
+
+func TestGetRegionAfterSave(t *testing.T) {
+	h, _ := newTestHandler(t)
+	query := "/architectures/shop/region/box?min=-6,-1,-1&max=-4,1,1"
+	var result RegionResult
+	serveTestJSON(t, h, http.MethodGet, query, "", http.StatusOK, &result)
+
+	// Move web away and remove db, so that a cached octree of the old
+	// version would return the wrong components
+	arch, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch.Components[0].Object.Position = [3]float64{50, 0, 0}
+	arch.Components = arch.Components[:2]
+	arch.Groups[0].Components = []string{"api"}
+	arch.Connections = arch.Connections[:1]
+	body, _ := json.Marshal(arch)
+	serveTestJSON(t, h, http.MethodPut, "/architectures/", string(body), http.StatusOK, nil)
+
+	serveTestJSON(t, h, http.MethodGet, query, "", http.StatusOK, &result)
+	if got := regionIDs(result); len(got) != 0 {
+		t.Errorf("region after moving web = %v, want none", got)
+	}
+}
+
+// TestSpatialIndexForSaveInBetween builds the octree of a version that
+// was replaced while the request loaded it, which must not be cached
+// as the octree of the new version.
+func TestSpatialIndexForSaveInBetween(t *testing.T) {
+	h, _ := newTestHandler(t)
+	save, _ := h.architectureSave("shop")
+	old, err := h.loadArchitectureByID("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch := old
+	arch.Components = append([]Component(nil), old.Components...)
+	arch.Components[0].Object.Position = [3]float64{50, 0, 0}
+	body, _ := json.Marshal(arch)
+	serveTestJSON(t, h, http.MethodPut, "/architectures/", string(body), http.StatusOK, nil)
+	h.spatialIndexFor("shop", save, old)
+
+	var result RegionResult
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/region/box?min=-6,-1,-1&max=-4,1,1", "", http.StatusOK, &result)
+	if got := regionIDs(result); len(got) != 0 {
+		t.Errorf("region after moving web = %v, want none", got)
+	}
+}
@@ -0,0 +1,1034 @@
+package ennoea
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+The structurizr importer reads workspaces written in the Structurizr
+DSL. The people, software systems, containers and components of the
+model become components, and their relationships become connections
+with the description and technology of the relationship:
+
+	person          an app drawn as a capsule
+	softwareSystem  an app drawn as an icosahedron
+	container       an app drawn as a box, or a server drawn as a
+	                cylinder if it is tagged "Database"
+	component       an app drawn as a tetrahedron
+
+Software systems with containers and containers with components are
+boundaries, which become groups of the element and everything inside
+it. The groups and enterprises of the model become groups as well, so
+the groups are nested like the boundaries are. The background and
+shape of the element styles are applied to the components by their
+tags.
+
+The system landscape, system context, container and component views
+become views that show the elements included in them. A view includes
+the elements it names, and "*" includes the elements of its scope and
+the people and systems they have relationships with.
+
+Everything else, such as deployment environments, dynamic views and
+include expressions, is reported as a warning with its line, instead
+of being dropped silently. Identifiers are flat unless the workspace
+uses "!identifiers hierarchical".
+
+	labels.c4           person, softwareSystem, container or component
+	labels.description  the description of the element or relationship
+	labels.technology   the technology of the element or relationship
+	labels.tags         the tags of the element or relationship
+	labels.url          the URL of the element or relationship
+*/
+
+// C4 element kinds.
+const (
+	c4Person         = "person"
+	c4SoftwareSystem = "softwareSystem"
+	c4Container      = "container"
+	c4Component      = "component"
+)
+
+// c4DefaultTags is a map of the element kinds to the tags that
+// Structurizr gives to every element of the kind.
+var c4DefaultTags = map[string]string{
+	c4Person:         "Person",
+	c4SoftwareSystem: "Software System",
+	c4Container:      "Container",
+	c4Component:      "Component",
+}
+
+// c4Colors is a map of the element kinds to the colours of the C4
+// model.
+var c4Colors = map[string]string{
+	c4Person:         "#08427b",
+	c4SoftwareSystem: "#1168bd",
+	c4Container:      "#438dd5",
+	c4Component:      "#85bbf0",
+}
+
+// c4Shapes maps the shapes of the Structurizr styles to geometries.
+var c4Shapes = map[string]string{
+	"box":                   "box",
+	"roundedbox":            "box",
+	"component":             "box",
+	"folder":                "box",
+	"webbrowser":            "box",
+	"window":                "box",
+	"mobiledeviceportrait":  "box",
+	"mobiledevicelandscape": "box",
+	"cylinder":              "cylinder",
+	"person":                "capsule",
+	"robot":                 "capsule",
+	"pipe":                  "torus",
+	"hexagon":               "dodecahedron",
+	"circle":                "sphere",
+	"ellipse":               "sphere",
+	"diamond":               "octahedron",
+}
+
+// dslToken is a token of the Structurizr DSL.
+type dslToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// is returns true if the token is the unquoted keyword. Keywords are
+// not case sensitive.
+func (t dslToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// dslStatement is a line of the DSL with the statements of its block,
+// if it has one.
+type dslStatement struct {
+	tokens   []dslToken
+	children []dslStatement
+	line     int
+}
+
+// keyword returns the lower case first token of the statement.
+func (s dslStatement) keyword() string {
+	if len(s.tokens) == 0 {
+		return ""
+	}
+	return strings.ToLower(s.tokens[0].text)
+}
+
+// arg returns the text of the token at the index, or an empty string
+// if there is none.
+func (s dslStatement) arg(i int) string {
+	if i < len(s.tokens) {
+		return s.tokens[i].text
+	}
+	return ""
+}
+
+// tokenizeDSL splits the DSL into tokens. Newlines are returned as
+// tokens with the text "\n", and comments and line continuations are
+// removed.
+func tokenizeDSL(text string) ([]dslToken, error) {
+	var tokens []dslToken
+	line := 1
+	lineStart := true
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\n':
+			tokens = append(tokens, dslToken{text: "\n", line: line})
+			line++
+			lineStart = true
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '\\' && dslLineContinues(text[i+1:]):
+			// A line continuation joins the next line
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i - 1
+			} else {
+				line++
+			}
+			i += end + 1
+		case strings.HasPrefix(text[i:], "//") || (c == '#' && lineStart):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			i += end
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(text[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(text) && text[j] != '"'; j++ {
+				if text[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				if text[j] == '\\' && j+1 < len(text) && text[j+1] == '"' {
+					j++
+				}
+				b.WriteByte(text[j])
+			}
+			if j == len(text) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, dslToken{text: b.String(), quoted: true, line: line})
+			lineStart = false
+			i = j + 1
+		case c == '{' || c == '}':
+			tokens = append(tokens, dslToken{text: string(c), line: line})
+			lineStart = false
+			i++
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\r\n\"{}", rune(text[j])) {
+				j++
+			}
+			tokens = append(tokens, dslToken{text: text[i:j], line: line})
+			lineStart = false
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// dslLineContinues returns true if the rest of the line after a
+// backslash is blank, which makes the backslash a line continuation.
+func dslLineContinues(rest string) bool {
+	if end := strings.IndexByte(rest, '\n'); end >= 0 {
+		rest = rest[:end]
+	}
+	return strings.TrimSpace(rest) == ""
+}
+
+// dslParser parses the tokens of the DSL into statements.
+type dslParser struct {
+	tokens []dslToken
+	pos    int
+	depth  int
+}
+
+// statements parses statements until the end of the block, or the end
+// of the file if the statements are not in a block.
+func (p *dslParser) statements(inBlock bool) ([]dslStatement, error) {
+	var statements []dslStatement
+	for {
+		if p.pos == len(p.tokens) {
+			if inBlock {
+				return nil, fmt.Errorf("missing }")
+			}
+			return statements, nil
+		}
+		token := p.tokens[p.pos]
+		switch {
+		case token.text == "\n":
+			p.pos++
+			continue
+		case token.is("}"):
+			if !inBlock {
+				return nil, fmt.Errorf("line %d: unexpected }", token.line)
+			}
+			p.pos++
+			return statements, nil
+		}
+
+		s := dslStatement{line: token.line}
+		for p.pos < len(p.tokens) {
+			token := p.tokens[p.pos]
+			if token.text == "\n" || token.is("}") {
+				break
+			}
+			p.pos++
+			if token.is("{") {
+				if p.depth == maxImportDepth {
+					return nil, fmt.Errorf("line %d: blocks are nested deeper than %d", token.line, maxImportDepth)
+				}
+				p.depth++
+				children, err := p.statements(true)
+				p.depth--
+				if err != nil {
+					return nil, err
+				}
+				s.children = children
+				break
+			}
+			s.tokens = append(s.tokens, token)
+		}
+		statements = append(statements, s)
+	}
+}
+
+// c4Element is an element of the model.
+type c4Element struct {
+	id          string
+	key         string
+	kind        string
+	name        string
+	description string
+	technology  string
+	url         string
+	tags        []string
+	properties  map[string]string
+	parent      *c4Element
+	children    []*c4Element
+}
+
+// within returns true if the element is the other element or inside
+// it.
+func (e *c4Element) within(other *c4Element) bool {
+	for ; e != nil; e = e.parent {
+		if e == other {
+			return true
+		}
+	}
+	return false
+}
+
+// top returns the person or software system that the element is in.
+func (e *c4Element) top() *c4Element {
+	for e.parent != nil {
+		e = e.parent
+	}
+	return e
+}
+
+// c4Relationship is a relationship of the model. The source and
+// target are resolved after the model has been read.
+type c4Relationship struct {
+	id          string
+	source      string
+	target      string
+	scope       *c4Element
+	description string
+	technology  string
+	url         string
+	tags        []string
+	properties  map[string]string
+	line        int
+}
+
+// c4Group is a group or enterprise of the model.
+type c4Group struct {
+	name     string
+	elements []*c4Element
+	parent   *c4Group
+}
+
+// c4View is a view of the workspace.
+type c4View struct {
+	kind        string
+	scope       *c4Element
+	key         string
+	title       string
+	description string
+	includes    []*c4Element
+	wildcard    bool
+	excludes    []*c4Element
+}
+
+// c4Style is an element style.
+type c4Style struct {
+	tag      string
+	color    string
+	geometry string
+}
+
+// structurizrImport holds the state of a Structurizr import.
+type structurizrImport struct {
+	result        *ImportResult
+	hierarchical  bool
+	elements      []*c4Element
+	identifiers   map[string]*c4Element
+	relationships []*c4Relationship
+	groups        []*c4Group
+	views         []c4View
+	pendingViews  []dslStatement
+	styles        []c4Style
+}
+
+// importStructurizr imports a Structurizr DSL workspace.
+func importStructurizr(data, config []byte) (ImportResult, error) {
+	tokens, err := tokenizeDSL(string(data))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	parser := dslParser{tokens: tokens}
+	statements, err := parser.statements(false)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	s := &structurizrImport{
+		result:      &result,
+		identifiers: make(map[string]*c4Element),
+	}
+	workspace := false
+	for _, statement := range statements {
+		if statement.keyword() != "workspace" {
+			s.unsupported(statement)
+			continue
+		}
+		if statement.arg(1) == "extends" {
+			return ImportResult{}, fmt.Errorf("line %d: workspaces that extend other workspaces are not supported", statement.line)
+		}
+		workspace = true
+		result.Architecture.Info.Name = statement.arg(1)
+		result.Architecture.Info.Description = statement.arg(2)
+		s.readWorkspace(statement.children)
+	}
+	if !workspace {
+		return ImportResult{}, fmt.Errorf("no workspace")
+	}
+	if len(s.elements) == 0 {
+		return ImportResult{}, fmt.Errorf("workspace has no elements")
+	}
+
+	// The views are read after the model so that they can refer to
+	// any element
+	for _, statement := range s.pendingViews {
+		s.readView(statement)
+	}
+
+	s.addComponents()
+	s.addGroups()
+	s.addConnections()
+	s.addViews()
+	return result, nil
+}
+
+// unsupported reports a statement that is not imported.
+func (s *structurizrImport) unsupported(statement dslStatement) {
+	if len(statement.tokens) == 0 {
+		return
+	}
+	s.result.warnf("line %d: %s is not supported", statement.line, statement.tokens[0].text)
+}
+
+// readWorkspace reads the statements of the workspace.
+func (s *structurizrImport) readWorkspace(statements []dslStatement) {
+	for _, statement := range statements {
+		switch statement.keyword() {
+		case "name":
+			s.result.Architecture.Info.Name = statement.arg(1)
+		case "description":
+			s.result.Architecture.Info.Description = statement.arg(1)
+		case "!identifiers":
+			s.hierarchical = strings.EqualFold(statement.arg(1), "hierarchical")
+		case "!impliedrelationships":
+			// Implied relationships are not imported either way
+		case "model":
+			s.readModel(statement.children, nil, nil)
+		case "views":
+			s.readViews(statement.children)
+		default:
+			s.unsupported(statement)
+		}
+	}
+}
+
+// readModel reads the statements of the model or of an element or
+// group. The parent is the element that the statements are in, and the
+// group is the innermost group.
+func (s *structurizrImport) readModel(statements []dslStatement, parent *c4Element, group *c4Group) {
+	for _, statement := range statements {
+		identifier := ""
+		tokens := statement.tokens
+		if len(tokens) >= 3 && tokens[1].is("=") {
+			identifier = tokens[0].text
+			statement.tokens = tokens[2:]
+		}
+
+		if s.readRelationship(statement, identifier, parent) {
+			continue
+		}
+
+		switch keyword := statement.keyword(); keyword {
+		case "person", "softwaresystem", "container", "component":
+			kind := map[string]string{
+				"person":         c4Person,
+				"softwaresystem": c4SoftwareSystem,
+				"container":      c4Container,
+				"component":      c4Component,
+			}[keyword]
+			if !c4CanContain(parent, kind) {
+				s.result.warnf("line %d: a %s cannot be defined here", statement.line, kind)
+				continue
+			}
+			e := s.addElement(statement, identifier, kind, parent)
+			if group != nil {
+				group.elements = append(group.elements, e)
+			}
+			s.readModel(statement.children, e, group)
+		case "group", "enterprise":
+			g := &c4Group{name: statement.arg(1), parent: group}
+			if g.name == "" {
+				g.name = keyword
+			}
+			s.groups = append(s.groups, g)
+			s.readModel(statement.children, parent, g)
+		case "!identifiers":
+			s.hierarchical = strings.EqualFold(statement.arg(1), "hierarchical")
+		case "!impliedrelationships":
+		default:
+			if parent != nil && s.readProperty(statement, &parent.description, &parent.technology, &parent.url, &parent.tags, &parent.properties) {
+				continue
+			}
+			s.unsupported(statement)
+		}
+	}
+}
+
+// c4CanContain returns true if an element of the kind can be defined
+// in the parent.
+func c4CanContain(parent *c4Element, kind string) bool {
+	switch kind {
+	case c4Person, c4SoftwareSystem:
+		return parent == nil
+	case c4Container:
+		return parent != nil && parent.kind == c4SoftwareSystem
+	default:
+		return parent != nil && parent.kind == c4Container
+	}
+}
+
+// addElement adds the element that the statement defines. The
+// arguments after the name are the description, the technology of
+// containers and components, and the tags.
+func (s *structurizrImport) addElement(statement dslStatement, identifier, kind string, parent *c4Element) *c4Element {
+	e := &c4Element{
+		kind:       kind,
+		name:       statement.arg(1),
+		parent:     parent,
+		properties: make(map[string]string),
+	}
+	args := []*string{&e.description}
+	if kind == c4Container || kind == c4Component {
+		args = append(args, &e.technology)
+	}
+	var tags string
+	args = append(args, &tags)
+	for i, arg := range args {
+		*arg = statement.arg(i + 2)
+	}
+	e.tags = c4Tags(tags)
+	if parent != nil {
+		parent.children = append(parent.children, e)
+	}
+
+	if identifier != "" {
+		e.key = identifier
+		if s.hierarchical && parent != nil {
+			e.key = parent.key + "." + identifier
+		}
+		s.identifiers[e.key] = e
+	}
+	s.elements = append(s.elements, e)
+	return e
+}
+
+// readRelationship reads the statement if it is a relationship, which
+// is "source -> target" or "-> target" in the block of the source. The
+// arguments after the target are the description, technology and
+// tags.
+func (s *structurizrImport) readRelationship(statement dslStatement, identifier string, parent *c4Element) bool {
+	tokens := statement.tokens
+	var source string
+	var rest []dslToken
+	switch {
+	case len(tokens) >= 2 && tokens[0].is("->"):
+		source, rest = "this", tokens[1:]
+	case len(tokens) >= 3 && tokens[1].is("->"):
+		source, rest = tokens[0].text, tokens[2:]
+	default:
+		return false
+	}
+
+	r := &c4Relationship{
+		id:         identifier,
+		source:     source,
+		target:     rest[0].text,
+		scope:      parent,
+		properties: make(map[string]string),
+		line:       statement.line,
+	}
+	var tags string
+	for i, arg := range []*string{&r.description, &r.technology, &tags} {
+		if i+1 < len(rest) {
+			*arg = rest[i+1].text
+		}
+	}
+	r.tags = c4Tags(tags)
+	for _, child := range statement.children {
+		if !s.readProperty(child, &r.description, &r.technology, &r.url, &r.tags, &r.properties) {
+			s.unsupported(child)
+		}
+	}
+	s.relationships = append(s.relationships, r)
+	return true
+}
+
+// readProperty reads a statement that sets a property of an element or
+// relationship, and returns false if it is something else.
+func (s *structurizrImport) readProperty(statement dslStatement, description, technology, url *string, tags *[]string, properties *map[string]string) bool {
+	switch statement.keyword() {
+	case "description":
+		*description = statement.arg(1)
+	case "technology":
+		*technology = statement.arg(1)
+	case "url":
+		*url = statement.arg(1)
+	case "tags", "tag":
+		for _, token := range statement.tokens[1:] {
+			*tags = append(*tags, c4Tags(token.text)...)
+		}
+	case "properties":
+		for _, property := range statement.children {
+			if len(property.tokens) < 2 {
+				s.result.warnf("line %d: property is missing a value", property.line)
+				continue
+			}
+			(*properties)[property.tokens[0].text] = property.tokens[1].text
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// c4Tags splits a comma separated list of tags.
+func c4Tags(tags string) []string {
+	var list []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			list = append(list, tag)
+		}
+	}
+	return list
+}
+
+// resolve returns the element that the identifier refers to from the
+// scope. Hierarchical identifiers can be relative to the enclosing
+// elements.
+func (s *structurizrImport) resolve(identifier string, scope *c4Element) *c4Element {
+	if identifier == "this" {
+		return scope
+	}
+	if s.hierarchical {
+		for e := scope; e != nil; e = e.parent {
+			if found, ok := s.identifiers[e.key+"."+identifier]; ok {
+				return found
+			}
+		}
+	}
+	return s.identifiers[identifier]
+}
+
+// readViews reads the statements of the views. The views themselves
+// are read after the model.
+func (s *structurizrImport) readViews(statements []dslStatement) {
+	for _, statement := range statements {
+		switch statement.keyword() {
+		case "systemlandscape", "systemcontext", "container", "component":
+			s.pendingViews = append(s.pendingViews, statement)
+		case "styles":
+			s.readStyles(statement.children)
+		default:
+			s.unsupported(statement)
+		}
+	}
+}
+
+// readView reads a view. The arguments are the element in scope,
+// except for system landscape views, the key and the description.
+func (s *structurizrImport) readView(statement dslStatement) {
+	v := c4View{kind: statement.keyword()}
+	args := 1
+	if v.kind != "systemlandscape" {
+		v.scope = s.resolve(statement.arg(1), nil)
+		want := map[string]string{
+			"systemcontext": c4SoftwareSystem,
+			"container":     c4SoftwareSystem,
+			"component":     c4Container,
+		}[v.kind]
+		if v.scope == nil || v.scope.kind != want {
+			s.result.warnf("line %d: %s view: %s is not a %s", statement.line, statement.tokens[0].text, statement.arg(1), want)
+			return
+		}
+		args++
+	}
+	v.key = statement.arg(args)
+	v.description = statement.arg(args + 1)
+
+	for _, child := range statement.children {
+		switch child.keyword() {
+		case "include", "exclude":
+			for _, token := range child.tokens[1:] {
+				if token.text == "*" && child.keyword() == "include" {
+					v.wildcard = true
+					continue
+				}
+				e := s.resolve(token.text, nil)
+				if e == nil {
+					s.result.warnf("line %d: %s %s is not supported", child.line, child.keyword(), token.text)
+					continue
+				}
+				if child.keyword() == "include" {
+					v.includes = append(v.includes, e)
+				} else {
+					v.excludes = append(v.excludes, e)
+				}
+			}
+		case "title":
+			v.title = child.arg(1)
+		case "description":
+			v.description = child.arg(1)
+		case "autolayout", "default", "properties":
+			// The layout and presentation of views are not imported
+		default:
+			s.unsupported(child)
+		}
+	}
+	s.views = append(s.views, v)
+}
+
+// readStyles reads the element styles.
+func (s *structurizrImport) readStyles(statements []dslStatement) {
+	for _, statement := range statements {
+		if statement.keyword() != "element" {
+			s.result.warnf("line %d: %s styles are not supported", statement.line, statement.arg(0))
+			continue
+		}
+		style := c4Style{tag: statement.arg(1)}
+		for _, child := range statement.children {
+			switch child.keyword() {
+			case "background":
+				if err := isValidColor(child.arg(1)); err != nil {
+					s.result.warnf("line %d: %v", child.line, err)
+					continue
+				}
+				style.color = child.arg(1)
+			case "shape":
+				geometry, ok := c4Shapes[strings.ToLower(child.arg(1))]
+				if !ok {
+					s.result.warnf("line %d: shape %s is not supported", child.line, child.arg(1))
+					continue
+				}
+				style.geometry = geometry
+			}
+		}
+		s.styles = append(s.styles, style)
+	}
+}
+
+// c4IDPattern matches the characters that are replaced in generated
+// IDs.
+var c4IDPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// addComponents adds a component for each element. Elements without
+// an identifier get an ID from their name.
+func (s *structurizrImport) addComponents() {
+	arch := &s.result.Architecture
+	ids := make(map[string]bool)
+	for _, e := range s.elements {
+		if e.key != "" {
+			ids[e.key] = true
+		}
+	}
+	for _, e := range s.elements {
+		e.id = e.key
+		if e.id == "" {
+			base := strings.Trim(c4IDPattern.ReplaceAllString(strings.ToLower(e.name), "-"), "-")
+			if base == "" {
+				base = e.kind
+			}
+			e.id = base
+			for n := 2; ids[e.id]; n++ {
+				e.id = base + "-" + strconv.Itoa(n)
+			}
+			ids[e.id] = true
+		}
+
+		c := newImportedComponent(e.id, e.name, "app")
+		if c.Name == "" {
+			c.Name = e.id
+		}
+		c.Object.Color = c4Colors[e.kind]
+		switch e.kind {
+		case c4Person:
+			c.Object.Geometry = "capsule"
+		case c4SoftwareSystem:
+			c.Object.Geometry = "icosahedron"
+		case c4Component:
+			c.Object.Geometry = "tetrahedron"
+		}
+		tags := append([]string{"Element", c4DefaultTags[e.kind]}, e.tags...)
+		if containsString(tags, "Database") {
+			c.Type = "server"
+			c.Object.Geometry = "cylinder"
+		}
+		for _, style := range s.styles {
+			if !containsString(tags, style.tag) {
+				continue
+			}
+			if style.color != "" {
+				c.Object.Color = style.color
+			}
+			if style.geometry != "" {
+				c.Object.Geometry = style.geometry
+				if style.geometry == "cylinder" {
+					c.Type = "server"
+				}
+			}
+		}
+
+		for k, v := range e.properties {
+			c.Labels[k] = v
+		}
+		c.Labels["c4"] = e.kind
+		setLabel(c.Labels, "description", e.description)
+		setLabel(c.Labels, "technology", e.technology)
+		setLabel(c.Labels, "url", e.url)
+		setLabel(c.Labels, "tags", strings.Join(e.tags, ","))
+		arch.Components = append(arch.Components, c)
+	}
+}
+
+// setLabel sets the label if the value is not empty.
+func setLabel(labels map[string]string, key, value string) {
+	if value != "" {
+		labels[key] = value
+	}
+}
+
+// addGroups adds a group for each boundary and for each group of the
+// model, with the components inside them.
+func (s *structurizrImport) addGroups() {
+	arch := &s.result.Architecture
+	ids := make(map[string]bool)
+
+	// members returns the IDs of the elements and everything inside
+	// them, once each
+	members := func(elements []*c4Element) []string {
+		var ids []string
+		seen := make(map[*c4Element]bool)
+		var add func(elements []*c4Element)
+		add = func(elements []*c4Element) {
+			for _, e := range elements {
+				if !seen[e] {
+					seen[e] = true
+					ids = append(ids, e.id)
+				}
+				add(e.children)
+			}
+		}
+		add(elements)
+		return ids
+	}
+
+	for _, e := range s.elements {
+		if len(e.children) == 0 {
+			continue
+		}
+		g := newImportedGroup(e.id, e.name, len(arch.Groups))
+		g.Components = members([]*c4Element{e})
+		g.Labels["c4"] = e.kind
+		arch.Groups = append(arch.Groups, g)
+		ids[g.ID] = true
+	}
+
+	// Groups include the elements of their nested groups
+	elements := make(map[*c4Group][]*c4Element)
+	for _, g := range s.groups {
+		for parent := g; parent != nil; parent = parent.parent {
+			elements[parent] = append(elements[parent], g.elements...)
+		}
+	}
+	for _, g := range s.groups {
+		if len(elements[g]) == 0 {
+			continue
+		}
+		base := "group-" + strings.Trim(c4IDPattern.ReplaceAllString(strings.ToLower(g.name), "-"), "-")
+		id := base
+		for n := 2; ids[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		ids[id] = true
+		group := newImportedGroup(id, g.name, len(arch.Groups))
+		group.Components = members(elements[g])
+		arch.Groups = append(arch.Groups, group)
+	}
+}
+
+// addConnections adds a connection for each relationship.
+func (s *structurizrImport) addConnections() {
+	arch := &s.result.Architecture
+	ids := make(map[string]bool)
+	for _, r := range s.relationships {
+		source := s.resolve(r.source, r.scope)
+		target := s.resolve(r.target, r.scope)
+		if source == nil || target == nil {
+			missing := r.source
+			if source != nil {
+				missing = r.target
+			}
+			s.result.warnf("line %d: relationship refers to unknown element %s", r.line, missing)
+			continue
+		}
+
+		id := r.id
+		if id == "" || ids[id] {
+			base := source.id + "->" + target.id
+			id = base
+			for n := 2; ids[id]; n++ {
+				id = base + "-" + strconv.Itoa(n)
+			}
+		}
+		ids[id] = true
+		name := r.description
+		if name == "" {
+			name = source.name + " uses " + target.name
+		}
+		c := newImportedConnection(id, name, source.id, target.id)
+		for k, v := range r.properties {
+			c.Labels[k] = v
+		}
+		setLabel(c.Labels, "description", r.description)
+		setLabel(c.Labels, "technology", r.technology)
+		setLabel(c.Labels, "url", r.url)
+		setLabel(c.Labels, "tags", strings.Join(r.tags, ","))
+		arch.Connections = append(arch.Connections, c)
+	}
+}
+
+// addViews adds a view for each view of the workspace, with a query of
+// the elements that it includes.
+func (s *structurizrImport) addViews() {
+	arch := &s.result.Architecture
+	keys := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, v := range s.views {
+		included := make(map[*c4Element]bool)
+		if v.wildcard {
+			for _, e := range s.viewScope(v) {
+				included[e] = true
+			}
+		}
+		for _, e := range v.includes {
+			included[e] = true
+		}
+		for _, e := range v.excludes {
+			delete(included, e)
+		}
+
+		key := v.key
+		if key == "" || keys[key] {
+			counts[v.kind]++
+			key = fmt.Sprintf("%s-%03d", v.kind, counts[v.kind])
+		}
+		keys[key] = true
+		if len(included) == 0 {
+			s.result.warnf("view %s includes no elements", key)
+			continue
+		}
+
+		var ids []string
+		for _, e := range s.elements {
+			if included[e] {
+				ids = append(ids, strconv.Quote(e.id))
+			}
+		}
+		name := v.title
+		if name == "" {
+			name = key
+		}
+		arch.Views = append(arch.Views, View{
+			ID:          key,
+			Name:        name,
+			Description: v.description,
+			Query:       fmt.Sprintf("component where id in (%s)", strings.Join(ids, ", ")),
+		})
+	}
+}
+
+// viewScope returns the elements that "*" includes in the view: the
+// elements in the scope of the view, and the elements that they have
+// relationships with. The other ends of relationships are shown as the
+// containers of the same software system, or as the people and
+// software systems they are in.
+func (s *structurizrImport) viewScope(v c4View) []*c4Element {
+	var scope []*c4Element
+	switch v.kind {
+	case "systemlandscape":
+		for _, e := range s.elements {
+			if e.parent == nil {
+				scope = append(scope, e)
+			}
+		}
+		return scope
+	case "systemcontext":
+		scope = []*c4Element{v.scope}
+	default:
+		scope = v.scope.children
+	}
+
+	// shown returns the element that stands for the end of a
+	// relationship in the view
+	shown := func(e *c4Element) *c4Element {
+		for _, in := range scope {
+			if e.within(in) {
+				return in
+			}
+		}
+		if v.kind == "component" && e.top() == v.scope.top() {
+			for e.parent != nil && e.parent.kind != c4SoftwareSystem {
+				e = e.parent
+			}
+			return e
+		}
+		return e.top()
+	}
+
+	elements := append([]*c4Element(nil), scope...)
+	seen := make(map[*c4Element]bool)
+	for _, e := range scope {
+		seen[e] = true
+	}
+	for _, r := range s.relationships {
+		source := s.resolve(r.source, r.scope)
+		target := s.resolve(r.target, r.scope)
+		if source == nil || target == nil {
+			continue
+		}
+		a, b := shown(source), shown(target)
+		if a == b {
+			continue
+		}
+		for _, pair := range [][2]*c4Element{{a, b}, {b, a}} {
+			if seen[pair[0]] && !seen[pair[1]] && containsElement(scope, pair[0]) {
+				seen[pair[1]] = true
+				elements = append(elements, pair[1])
+			}
+		}
+	}
+	return elements
+}
+
+// containsElement returns true if the list contains the element.
+func containsElement(list []*c4Element, e *c4Element) bool {
+	for _, item := range list {
+		if item == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package ennoea
+
+import (
+	"strings"
+	"testing"
+)
+
+// testStructurizrDSL is a workspace with a person, a software system of
+// two containers, a deployment environment and a container view.
+const testStructurizrDSL = `workspace "Shop" "An online shop." {
+    !identifiers flat
+
+    model {
+        user = person "User"
+        shop = softwareSystem "Shop" {
+            web = container "Web" "The storefront." "Go"
+            db = container "Database" "" "PostgreSQL" "Database" {
+                url https://example.com/db
+            }
+        }
+        user -> web "Uses" "HTTPS"
+        web -> db "Reads from" \
+            "SQL"
+
+        production = deploymentEnvironment "Production" {
+        }
+    }
+
+    views {
+        container shop "containers" {
+            include *
+        }
+        styles {
+            element "Person" {
+                shape Person
+                background #08427b
+            }
+        }
+    }
+}
+`
+
+func TestImportStructurizr(t *testing.T) {
+	testImports(t, "structurizr", []importTest{
+		{
+			name: "workspace",
+			data: testStructurizrDSL,
+			want: []string{
+				`component user "User" capsule`,
+				`component shop "Shop" icosahedron`,
+				`component web "Web" box`,
+				`component db "Database" cylinder`,
+				`group shop shop,web,db`,
+				`connection user->web out`,
+				`connection web->db out`,
+				`view containers component where id in ("user", "web", "db")`,
+				"warning line 16: deploymentEnvironment is not supported",
+			},
+		},
+		{
+			name: "comments",
+			data: "# a comment\nworkspace {\n  // another\n  model {\n    /* a\n    block */\n    a = softwareSystem \"A\"\n  }\n}\n",
+			want: []string{`component a "A" icosahedron`},
+		},
+		{name: "no workspace", data: `model { a = person "A" }`, err: "no workspace"},
+		{name: "no elements", data: "workspace {\n  model {\n  }\n}\n", err: "workspace has no elements"},
+		{name: "missing brace", data: "workspace {\n  model {\n}\n", err: "missing }"},
+		{name: "unterminated string", data: "workspace {\n  model {\n    a = person \"A\n  }\n}\n", err: "line 3: unterminated string"},
+		{name: "extends", data: "workspace extends base.dsl {\n}\n", err: "line 1: workspaces that extend other workspaces are not supported"},
+		{name: "nested blocks", data: "workspace " + strings.Repeat("{\n", maxImportDepth+1), err: "line 101: blocks are nested deeper than 100"},
+	})
+}
+
+func FuzzTokenizeDSL(f *testing.F) {
+	f.Add(testStructurizrDSL)
+	f.Add("workspace {\n  model {\n    a = softwareSystem \"A\" {\n      b = container \"B\"\n    }\n    a -> b\n  }\n}\n")
+	f.Fuzz(func(t *testing.T, data string) {
+		if _, err := tokenizeDSL(data); err != nil {
+			return
+		}
+		importStructurizr([]byte(data), nil)
+	})
+}
@@ -0,0 +1,913 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+The terraform importer reads the output of "terraform show -json", for
+either a state or a plan. Managed resources become components, and
+modules become groups of the resources in them and in their child
+modules. Data sources are not imported.
+
+The type, geometry and colour of a component are looked up by its
+resource type in the type mappings of its provider. The built-in
+mappings cover common resources of the aws, google and azurerm
+providers, and can be extended or overridden with a configuration file.
+The keys are resource types or patterns like "aws_iam_*", and resources
+whose mapping has "skip" set are not imported as components:
+
+terraform.json
+{
+	"providers": {
+		"aws": {
+			"aws_instance": {"type": "server", "geometry": "box", "color": "#ff9900"},
+			"aws_iam_*": {"skip": true}
+		}
+	}
+}
+
+Connections are made from each resource to the resources it depends
+on, and for the network relationships of aws resources: from the
+members of a security group to the members of the security groups that
+its rules allow them to reach, and from load balancers to the targets
+of their target groups. Resources that only describe these
+relationships, like security group rules and target group attachments,
+are skipped by default.
+
+When a plan is imported, the resources that the plan changes are
+labelled with the action and coloured: green for created, yellow for
+updated, orange for replaced and red for destroyed resources.
+
+	labels.address        the address of the resource
+	labels.type           the resource type
+	labels.provider       the provider of the resource
+	labels.action         the planned action: create, update, replace or delete
+	labels.relationships  the relationships of a connection: dependency,
+	                      security-group or load-balancer
+	labels.ports          the ports of the security group rules
+*/
+
+// Relationships between terraform resources that become connections.
+const (
+	tfDependency    = "dependency"
+	tfSecurityGroup = "security-group"
+	tfLoadBalancer  = "load-balancer"
+)
+
+// tfActionColors maps the planned actions to the colours of the
+// resources.
+var tfActionColors = map[string]string{
+	"create":  "#59a14f",
+	"update":  "#edc948",
+	"replace": "#f28e2b",
+	"delete":  "#e15759",
+}
+
+// TerraformTypeMapping represents how resources of a type are imported.
+type TerraformTypeMapping struct {
+	// Type is the type of the component, either "app" or "server".
+	Type string `json:"type,omitempty"`
+
+	// Geometry is the geometry of the component.
+	Geometry string `json:"geometry,omitempty"`
+
+	// Color is the colour of the component.
+	Color string `json:"color,omitempty"`
+
+	// Skip determines whether or not the resources are left out of
+	// the architecture.
+	Skip bool `json:"skip,omitempty"`
+}
+
+// isValid returns an error if the mapping is invalid.
+func (m TerraformTypeMapping) isValid() error {
+	if m.Type != "" && m.Type != "app" && m.Type != "server" {
+		return fmt.Errorf("invalid type: %s", m.Type)
+	}
+	if m.Geometry != "" {
+		if err := isValidGeometry(m.Geometry); err != nil {
+			return err
+		}
+	}
+	if m.Color != "" {
+		if err := isValidColor(m.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TerraformConfig represents the configuration of a terraform import.
+type TerraformConfig struct {
+	// Providers is a map of provider names, like "aws", to maps of
+	// resource types or patterns to their mappings.
+	Providers map[string]map[string]TerraformTypeMapping `json:"providers"`
+}
+
+// tfDefaultMappings is the built-in type mappings.
+var tfDefaultMappings = map[string]map[string]TerraformTypeMapping{
+	"aws": {
+		"aws_instance":                        {Type: "server", Geometry: "box"},
+		"aws_autoscaling_group":               {Type: "server", Geometry: "box"},
+		"aws_db_instance":                     {Type: "server", Geometry: "cylinder"},
+		"aws_rds_cluster":                     {Type: "server", Geometry: "cylinder"},
+		"aws_dynamodb_table":                  {Type: "server", Geometry: "cylinder"},
+		"aws_elasticache_cluster":             {Type: "server", Geometry: "cylinder"},
+		"aws_s3_bucket":                       {Type: "server", Geometry: "cylinder"},
+		"aws_lb":                              {Type: "server", Geometry: "octahedron"},
+		"aws_alb":                             {Type: "server", Geometry: "octahedron"},
+		"aws_elb":                             {Type: "server", Geometry: "octahedron"},
+		"aws_lambda_function":                 {Type: "app", Geometry: "tetrahedron"},
+		"aws_ecs_service":                     {Type: "app", Geometry: "box"},
+		"aws_sqs_queue":                       {Type: "app", Geometry: "torus"},
+		"aws_sns_topic":                       {Type: "app", Geometry: "torus"},
+		"aws_security_group_rule":             {Skip: true},
+		"aws_vpc_security_group_ingress_rule": {Skip: true},
+		"aws_vpc_security_group_egress_rule":  {Skip: true},
+		"aws_lb_target_group_attachment":      {Skip: true},
+		"aws_autoscaling_attachment":          {Skip: true},
+		"aws_lb_listener_rule":                {Skip: true},
+		"aws_route_table_association":         {Skip: true},
+		"aws_iam_role_policy_attachment":      {Skip: true},
+	},
+	"google": {
+		"google_compute_instance":          {Type: "server", Geometry: "box"},
+		"google_sql_database_instance":     {Type: "server", Geometry: "cylinder"},
+		"google_storage_bucket":            {Type: "server", Geometry: "cylinder"},
+		"google_cloudfunctions_function":   {Type: "app", Geometry: "tetrahedron"},
+		"google_cloud_run_service":         {Type: "app", Geometry: "box"},
+		"google_compute_forwarding_rule":   {Type: "server", Geometry: "octahedron"},
+		"google_project_iam_member":        {Skip: true},
+		"google_compute_firewall":          {Type: "server", Geometry: "plane"},
+		"google_pubsub_topic":              {Type: "app", Geometry: "torus"},
+		"google_container_cluster":         {Type: "server", Geometry: "dodecahedron"},
+		"google_container_node_pool":       {Type: "server", Geometry: "box"},
+		"google_compute_instance_template": {Skip: true},
+	},
+	"azurerm": {
+		"azurerm_linux_virtual_machine":   {Type: "server", Geometry: "box"},
+		"azurerm_windows_virtual_machine": {Type: "server", Geometry: "box"},
+		"azurerm_mssql_database":          {Type: "server", Geometry: "cylinder"},
+		"azurerm_storage_account":         {Type: "server", Geometry: "cylinder"},
+		"azurerm_linux_function_app":      {Type: "app", Geometry: "tetrahedron"},
+		"azurerm_linux_web_app":           {Type: "app", Geometry: "box"},
+		"azurerm_lb":                      {Type: "server", Geometry: "octahedron"},
+		"azurerm_kubernetes_cluster":      {Type: "server", Geometry: "dodecahedron"},
+		"azurerm_role_assignment":         {Skip: true},
+	},
+}
+
+// mapping returns the mapping of the resource type of the provider.
+// The configured mappings are checked before the built-in ones, and
+// exact types before patterns. Resources without a mapping are apps.
+func (c TerraformConfig) mapping(provider, resourceType string) TerraformTypeMapping {
+	for _, mappings := range []map[string]TerraformTypeMapping{c.Providers[provider], tfDefaultMappings[provider]} {
+		if m, ok := mappings[resourceType]; ok {
+			return m
+		}
+
+		// The longest matching pattern is the most specific
+		patterns := sortedKeys(mappings)
+		sort.SliceStable(patterns, func(i, j int) bool {
+			return len(patterns[i]) > len(patterns[j])
+		})
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, resourceType); ok {
+				return mappings[pattern]
+			}
+		}
+	}
+	return TerraformTypeMapping{Type: "app", Geometry: "box"}
+}
+
+// tfShow represents the output of "terraform show -json". A state has
+// values, and a plan has planned values, the prior state, the resource
+// changes and the configuration.
+type tfShow struct {
+	FormatVersion string    `json:"format_version"`
+	Values        *tfValues `json:"values"`
+	PlannedValues *tfValues `json:"planned_values"`
+	PriorState    *struct {
+		Values *tfValues `json:"values"`
+	} `json:"prior_state"`
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Change  struct {
+			Actions []string               `json:"actions"`
+			Before  map[string]interface{} `json:"before"`
+		} `json:"change"`
+		ProviderName string `json:"provider_name"`
+	} `json:"resource_changes"`
+	Configuration *struct {
+		RootModule tfConfigModule `json:"root_module"`
+	} `json:"configuration"`
+}
+
+// tfValues represents the values of a state or a plan.
+type tfValues struct {
+	RootModule tfModule `json:"root_module"`
+}
+
+// tfModule represents a module and its resources.
+type tfModule struct {
+	Resources    []tfResource `json:"resources"`
+	ChildModules []tfModule   `json:"child_modules"`
+}
+
+// tfResource represents a resource instance.
+type tfResource struct {
+	Address      string                 `json:"address"`
+	Mode         string                 `json:"mode"`
+	Type         string                 `json:"type"`
+	Name         string                 `json:"name"`
+	ProviderName string                 `json:"provider_name"`
+	Values       map[string]interface{} `json:"values"`
+	DependsOn    []string               `json:"depends_on"`
+}
+
+// tfConfigModule represents a module of the configuration of a plan.
+type tfConfigModule struct {
+	Resources []struct {
+		Address     string                 `json:"address"`
+		Mode        string                 `json:"mode"`
+		Expressions map[string]interface{} `json:"expressions"`
+		DependsOn   []string               `json:"depends_on"`
+	} `json:"resources"`
+	ModuleCalls map[string]struct {
+		Module tfConfigModule `json:"module"`
+	} `json:"module_calls"`
+}
+
+// tfInstance is a resource that is imported.
+type tfInstance struct {
+	tfResource
+
+	// action is the planned action of the resource, if any.
+	action string
+
+	// references is a map of attribute names to the configuration
+	// addresses that the expressions of the attribute refer to. It is
+	// only known for plans.
+	references map[string][]string
+
+	// dependencies is a list of the configuration addresses that the
+	// resource depends on.
+	dependencies []string
+}
+
+// tfEdge accumulates the relationships between two resources.
+type tfEdge struct {
+	source, target string
+	relationships  []string
+	ports          []string
+}
+
+// tfImport holds the state of a terraform import.
+type tfImport struct {
+	result    *ImportResult
+	config    TerraformConfig
+	instances []*tfInstance
+	index     map[string]*tfInstance
+
+	// byConfig maps configuration addresses to the addresses of their
+	// instances.
+	byConfig map[string][]string
+
+	// byValue maps the IDs, ARNs and some names of resources to their
+	// addresses.
+	byValue map[string]string
+
+	edges map[[2]string]*tfEdge
+	order [][2]string
+}
+
+// importTerraform imports the JSON output of "terraform show".
+func importTerraform(data, config []byte) (ImportResult, error) {
+	var show tfShow
+	if err := json.Unmarshal(data, &show); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to parse terraform json: %v", err)
+	}
+	if show.FormatVersion == "" {
+		return ImportResult{}, fmt.Errorf("missing format_version, expected the output of terraform show -json")
+	}
+
+	var result ImportResult
+	t := &tfImport{
+		result:   &result,
+		index:    make(map[string]*tfInstance),
+		byConfig: make(map[string][]string),
+		byValue:  make(map[string]string),
+		edges:    make(map[[2]string]*tfEdge),
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &t.config); err != nil {
+			return ImportResult{}, fmt.Errorf("failed to parse config: %v", err)
+		}
+		for provider, mappings := range t.config.Providers {
+			for resourceType, m := range mappings {
+				if err := m.isValid(); err != nil {
+					return ImportResult{}, fmt.Errorf("invalid config: %s: %s: %v", provider, resourceType, err)
+				}
+			}
+		}
+	}
+
+	arch := &result.Architecture
+	arch.Info.Name = "terraform"
+	if show.PlannedValues != nil {
+		t.addPlan(show)
+	} else if show.Values != nil {
+		t.addModule(show.Values.RootModule)
+		arch.Info.Description = "Imported from terraform state"
+	}
+	if len(t.instances) == 0 {
+		return ImportResult{}, fmt.Errorf("no managed resources")
+	}
+
+	t.addDependencies()
+	t.addSecurityGroups()
+	t.addLoadBalancers()
+	t.addComponents()
+	t.addModuleGroups()
+	t.addConnections()
+
+	return result, nil
+}
+
+// add adds the resource if it is managed and has not been added.
+func (t *tfImport) add(r tfResource) *tfInstance {
+	if r.Mode != "managed" {
+		return nil
+	}
+	if i, ok := t.index[r.Address]; ok {
+		return i
+	}
+
+	i := &tfInstance{tfResource: r, references: make(map[string][]string)}
+	i.dependencies = append(i.dependencies, r.DependsOn...)
+	t.instances = append(t.instances, i)
+	t.index[r.Address] = i
+	configAddress := tfConfigAddress(r.Address)
+	t.byConfig[configAddress] = append(t.byConfig[configAddress], r.Address)
+
+	for _, key := range []string{"id", "arn"} {
+		if v, ok := r.Values[key].(string); ok && v != "" {
+			t.byValue[v] = r.Address
+		}
+	}
+	switch r.Type {
+	case "aws_security_group", "aws_autoscaling_group":
+		if v, ok := r.Values["name"].(string); ok && v != "" {
+			t.byValue[v] = r.Address
+		}
+	}
+	return i
+}
+
+// addModule adds the resources of the module and its child modules.
+func (t *tfImport) addModule(m tfModule) {
+	for _, r := range m.Resources {
+		t.add(r)
+	}
+	for _, child := range m.ChildModules {
+		t.addModule(child)
+	}
+}
+
+// addPlan adds the resources of a plan. The resources that the plan
+// destroys are not in the planned values, so they are added from the
+// prior state, or from the resource changes if there is none.
+func (t *tfImport) addPlan(show tfShow) {
+	t.addModule(show.PlannedValues.RootModule)
+
+	prior := make(map[string]tfResource)
+	if show.PriorState != nil && show.PriorState.Values != nil {
+		var collect func(m tfModule)
+		collect = func(m tfModule) {
+			for _, r := range m.Resources {
+				prior[r.Address] = r
+			}
+			for _, child := range m.ChildModules {
+				collect(child)
+			}
+		}
+		collect(show.PriorState.Values.RootModule)
+	}
+
+	counts := make(map[string]int)
+	for _, change := range show.ResourceChanges {
+		action := tfAction(change.Change.Actions)
+		i, ok := t.index[change.Address]
+		if !ok && action == "delete" {
+			r, ok := prior[change.Address]
+			if !ok {
+				r = tfResource{
+					Address:      change.Address,
+					Mode:         change.Mode,
+					Type:         change.Type,
+					Name:         change.Name,
+					ProviderName: change.ProviderName,
+					Values:       change.Change.Before,
+				}
+			}
+			i = t.add(r)
+		}
+		if i == nil || action == "" {
+			continue
+		}
+		i.action = action
+		counts[action]++
+	}
+
+	// The dependencies of the resources that are kept are in the prior
+	// state, and the ones of new resources are in the configuration
+	for address, r := range prior {
+		if i, ok := t.index[address]; ok && i.action != "delete" {
+			i.dependencies = append(i.dependencies, r.DependsOn...)
+		}
+	}
+	if show.Configuration != nil {
+		t.addConfigModule(show.Configuration.RootModule, "")
+	}
+
+	t.result.Architecture.Info.Description = fmt.Sprintf(
+		"Imported from a terraform plan that creates %d, updates %d, replaces %d and destroys %d resources",
+		counts["create"], counts["update"], counts["replace"], counts["delete"])
+}
+
+// tfAction returns the action of the list of planned actions, or an
+// empty string if there is no change.
+func tfAction(actions []string) string {
+	switch {
+	case len(actions) == 2:
+		return "replace"
+	case len(actions) == 1 && actions[0] != "no-op" && actions[0] != "read":
+		return actions[0]
+	default:
+		return ""
+	}
+}
+
+// addConfigModule adds the dependencies and references from the
+// configuration of the module and its module calls. The addresses in
+// a module are relative to the module.
+func (t *tfImport) addConfigModule(m tfConfigModule, prefix string) {
+	for _, r := range m.Resources {
+		if r.Mode != "" && r.Mode != "managed" {
+			continue
+		}
+		var dependencies []string
+		for _, d := range r.DependsOn {
+			dependencies = append(dependencies, prefix+d)
+		}
+		references := make(map[string][]string)
+		for attribute, expression := range r.Expressions {
+			for _, ref := range tfReferences(expression) {
+				if address := tfReferenceAddress(ref); address != "" {
+					references[attribute] = append(references[attribute], prefix+address)
+					dependencies = append(dependencies, prefix+address)
+				}
+			}
+		}
+
+		for _, address := range t.byConfig[prefix+r.Address] {
+			i := t.index[address]
+			i.dependencies = append(i.dependencies, dependencies...)
+			for attribute, refs := range references {
+				i.references[attribute] = append(i.references[attribute], refs...)
+			}
+		}
+	}
+	for _, name := range sortedKeys(m.ModuleCalls) {
+		t.addConfigModule(m.ModuleCalls[name].Module, prefix+"module."+name+".")
+	}
+}
+
+// tfReferences returns the references in an expression and in the
+// nested blocks of the expression.
+func tfReferences(expression interface{}) []string {
+	var refs []string
+	switch e := expression.(type) {
+	case map[string]interface{}:
+		if list, ok := e["references"].([]interface{}); ok {
+			for _, ref := range list {
+				if s, ok := ref.(string); ok {
+					refs = append(refs, s)
+				}
+			}
+		}
+		for key, value := range e {
+			if key != "references" {
+				refs = append(refs, tfReferences(value)...)
+			}
+		}
+	case []interface{}:
+		for _, value := range e {
+			refs = append(refs, tfReferences(value)...)
+		}
+	}
+	return refs
+}
+
+// tfReferenceAddress returns the configuration address of the managed
+// resource that the reference refers to, such as "aws_lb.main" for
+// "aws_lb.main.arn", or an empty string if the reference is to
+// something else, like a variable or a data source.
+func tfReferenceAddress(ref string) string {
+	parts := tfSplitAddress(tfConfigAddress(ref))
+	if len(parts) < 2 {
+		return ""
+	}
+	switch parts[0] {
+	case "var", "local", "each", "count", "path", "self", "data", "module", "terraform":
+		return ""
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// tfSplitAddress splits an address on the dots that are not inside
+// brackets.
+func tfSplitAddress(address string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range address {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, address[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, address[start:])
+}
+
+// tfConfigAddress removes the instance keys from the address.
+func tfConfigAddress(address string) string {
+	var b strings.Builder
+	depth := 0
+	for _, c := range address {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// tfModules returns the addresses of the modules that the resource is
+// in, from the outermost to the innermost, such as "module.app[0]" and
+// "module.app[0].module.db" for a resource in a nested module.
+func tfModules(address string) []string {
+	parts := tfSplitAddress(address)
+	var modules []string
+	for i := 0; i+2 < len(parts) && parts[i] == "module"; i += 2 {
+		module := "module." + parts[i+1]
+		if len(modules) > 0 {
+			module = modules[len(modules)-1] + "." + module
+		}
+		modules = append(modules, module)
+	}
+	return modules
+}
+
+// tfProvider returns the short name of the provider, such as "aws" for
+// "registry.terraform.io/hashicorp/aws".
+func tfProvider(providerName string) string {
+	return providerName[strings.LastIndex(providerName, "/")+1:]
+}
+
+// resolve returns the addresses of the resources that a configuration
+// address refers to. A module address refers to every resource in
+// the module.
+func (t *tfImport) resolve(configAddress string) []string {
+	if addresses, ok := t.byConfig[configAddress]; ok {
+		return addresses
+	}
+	var addresses []string
+	if strings.HasPrefix(configAddress, "module.") {
+		for _, i := range t.instances {
+			if strings.HasPrefix(tfConfigAddress(i.Address), configAddress+".") {
+				addresses = append(addresses, i.Address)
+			}
+		}
+	}
+	return addresses
+}
+
+// attribute returns the addresses of the resources that the attribute
+// of the resource refers to, from the values of the attribute and from
+// the references of its expression. Attributes in nested blocks are
+// found by name.
+func (t *tfImport) attribute(i *tfInstance, name string) []string {
+	var addresses []string
+	for _, v := range tfFindValues(i.Values, name) {
+		if address, ok := t.byValue[v]; ok && !containsString(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+	for _, ref := range i.references[name] {
+		for _, address := range t.resolve(ref) {
+			if !containsString(addresses, address) {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// tfFindValues returns the strings of the attribute anywhere in the
+// values.
+func tfFindValues(values interface{}, name string) []string {
+	var found []string
+	switch v := values.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if key == name {
+				switch value := value.(type) {
+				case string:
+					found = append(found, value)
+				case []interface{}:
+					for _, item := range value {
+						if s, ok := item.(string); ok {
+							found = append(found, s)
+						}
+					}
+				}
+				continue
+			}
+			found = append(found, tfFindValues(value, name)...)
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = append(found, tfFindValues(value, name)...)
+		}
+	}
+	return found
+}
+
+// edge adds the relationship between the resources. Relationships with
+// the same resource at both ends are ignored.
+func (t *tfImport) edge(source, target, relationship, ports string) {
+	if source == target {
+		return
+	}
+	key := [2]string{source, target}
+	e, ok := t.edges[key]
+	if !ok {
+		e = &tfEdge{source: source, target: target}
+		t.edges[key] = e
+		t.order = append(t.order, key)
+	}
+	if !containsString(e.relationships, relationship) {
+		e.relationships = append(e.relationships, relationship)
+	}
+	if ports != "" && !containsString(e.ports, ports) {
+		e.ports = append(e.ports, ports)
+	}
+}
+
+// addDependencies adds an edge from each resource to the resources it
+// depends on.
+func (t *tfImport) addDependencies() {
+	for _, i := range t.instances {
+		for _, d := range i.dependencies {
+			for _, address := range t.resolve(d) {
+				t.edge(i.Address, address, tfDependency, "")
+			}
+		}
+	}
+}
+
+// addSecurityGroups adds the edges between the members of security
+// groups that the rules of the groups allow to connect.
+func (t *tfImport) addSecurityGroups() {
+	members := make(map[string][]string)
+	for _, i := range t.instances {
+		if strings.HasPrefix(i.Type, "aws_security_group") || strings.HasPrefix(i.Type, "aws_vpc_security_group") {
+			continue
+		}
+		for _, attribute := range []string{"vpc_security_group_ids", "security_group_ids", "security_groups"} {
+			for _, group := range t.attribute(i, attribute) {
+				if t.index[group].Type == "aws_security_group" && !containsString(members[group], i.Address) {
+					members[group] = append(members[group], i.Address)
+				}
+			}
+		}
+	}
+
+	// allow adds the edges from the members of the source group to the
+	// members of the target group
+	allow := func(source, target []string, ports string) {
+		for _, s := range source {
+			for _, t2 := range target {
+				for _, from := range members[s] {
+					for _, to := range members[t2] {
+						t.edge(from, to, tfSecurityGroup, ports)
+					}
+				}
+			}
+		}
+	}
+
+	for _, i := range t.instances {
+		switch i.Type {
+		case "aws_security_group_rule":
+			group := t.attribute(i, "security_group_id")
+			peer := t.attribute(i, "source_security_group_id")
+			if self, _ := i.Values["self"].(bool); self {
+				peer = group
+			}
+			ports := tfPorts(i.Values, "protocol")
+			if ruleType, _ := i.Values["type"].(string); ruleType == "egress" {
+				allow(group, peer, ports)
+			} else {
+				allow(peer, group, ports)
+			}
+		case "aws_vpc_security_group_ingress_rule":
+			allow(t.attribute(i, "referenced_security_group_id"), t.attribute(i, "security_group_id"), tfPorts(i.Values, "ip_protocol"))
+		case "aws_vpc_security_group_egress_rule":
+			allow(t.attribute(i, "security_group_id"), t.attribute(i, "referenced_security_group_id"), tfPorts(i.Values, "ip_protocol"))
+		case "aws_security_group":
+			// Inline rules refer to the other groups by ID
+			for _, direction := range []string{"ingress", "egress"} {
+				rules, _ := i.Values[direction].([]interface{})
+				for _, rule := range rules {
+					values, _ := rule.(map[string]interface{})
+					var peers []string
+					for _, id := range tfFindValues(values, "security_groups") {
+						if address, ok := t.byValue[id]; ok {
+							peers = append(peers, address)
+						}
+					}
+					if self, _ := values["self"].(bool); self {
+						peers = append(peers, i.Address)
+					}
+					if direction == "ingress" {
+						allow(peers, []string{i.Address}, tfPorts(values, "protocol"))
+					} else {
+						allow([]string{i.Address}, peers, tfPorts(values, "protocol"))
+					}
+				}
+			}
+		}
+	}
+}
+
+// tfPorts returns the port range and protocol of a security group
+// rule, such as "443/tcp" or "8000-8080/tcp".
+func tfPorts(values map[string]interface{}, protocolKey string) string {
+	from, fromOK := values["from_port"].(float64)
+	to, toOK := values["to_port"].(float64)
+	protocol, _ := values[protocolKey].(string)
+	if protocol == "-1" {
+		protocol = "all"
+	}
+	switch {
+	case !fromOK && !toOK:
+		return protocol
+	case from == to || !toOK:
+		return fmt.Sprintf("%.0f/%s", from, protocol)
+	default:
+		return fmt.Sprintf("%.0f-%.0f/%s", from, to, protocol)
+	}
+}
+
+// addLoadBalancers adds the edges from the load balancers to the
+// targets of their target groups.
+func (t *tfImport) addLoadBalancers() {
+	targets := make(map[string][]string)
+	balancers := make(map[string][]string)
+	listeners := make(map[string][]string)
+	for _, i := range t.instances {
+		switch i.Type {
+		case "aws_lb_target_group_attachment":
+			for _, group := range t.attribute(i, "target_group_arn") {
+				targets[group] = append(targets[group], t.attribute(i, "target_id")...)
+			}
+		case "aws_autoscaling_attachment":
+			for _, group := range t.attribute(i, "lb_target_group_arn") {
+				targets[group] = append(targets[group], t.attribute(i, "autoscaling_group_name")...)
+			}
+		case "aws_autoscaling_group", "aws_ecs_service":
+			for _, group := range append(t.attribute(i, "target_group_arns"), t.attribute(i, "target_group_arn")...) {
+				targets[group] = append(targets[group], i.Address)
+			}
+		case "aws_lb_listener", "aws_alb_listener":
+			lbs := t.attribute(i, "load_balancer_arn")
+			listeners[i.Address] = lbs
+			for _, group := range append(t.attribute(i, "target_group_arn"), t.attribute(i, "arn")...) {
+				balancers[group] = append(balancers[group], lbs...)
+			}
+		}
+	}
+	for _, i := range t.instances {
+		if i.Type != "aws_lb_listener_rule" && i.Type != "aws_alb_listener_rule" {
+			continue
+		}
+		for _, listener := range t.attribute(i, "listener_arn") {
+			for _, group := range append(t.attribute(i, "target_group_arn"), t.attribute(i, "arn")...) {
+				balancers[group] = append(balancers[group], listeners[listener]...)
+			}
+		}
+	}
+
+	for _, group := range sortedKeys(balancers) {
+		for _, lb := range balancers[group] {
+			for _, target := range targets[group] {
+				t.edge(lb, target, tfLoadBalancer, "")
+			}
+		}
+	}
+}
+
+// addComponents adds a component for each resource that is not
+// skipped.
+func (t *tfImport) addComponents() {
+	arch := &t.result.Architecture
+	for _, i := range t.instances {
+		provider := tfProvider(i.ProviderName)
+		m := t.config.mapping(provider, i.Type)
+		if m.Skip {
+			continue
+		}
+
+		name := i.Address
+		if modules := tfModules(i.Address); len(modules) > 0 {
+			name = strings.TrimPrefix(i.Address, modules[len(modules)-1]+".")
+		}
+		c := newImportedComponent(i.Address, name, m.Type)
+		if c.Type == "" {
+			c.Type = "app"
+		}
+		if m.Geometry != "" {
+			c.Object.Geometry = m.Geometry
+		}
+		if m.Color != "" {
+			c.Object.Color = m.Color
+		}
+		c.Labels["address"] = i.Address
+		c.Labels["type"] = i.Type
+		c.Labels["provider"] = provider
+		if i.action != "" {
+			c.Labels["action"] = i.action
+			c.Object.Color = tfActionColors[i.action]
+		}
+		arch.Components = append(arch.Components, c)
+	}
+}
+
+// addModuleGroups adds a group for each module with the resources in
+// it and in its child modules.
+func (t *tfImport) addModuleGroups() {
+	arch := &t.result.Architecture
+	members := make(map[string][]string)
+	for _, c := range arch.Components {
+		for _, module := range tfModules(c.ID) {
+			members[module] = append(members[module], c.ID)
+		}
+	}
+	for _, module := range sortedKeys(members) {
+		g := newImportedGroup(module, module, len(arch.Groups))
+		g.Components = members[module]
+		arch.Groups = append(arch.Groups, g)
+	}
+}
+
+// addConnections adds a connection for each edge between two
+// components.
+func (t *tfImport) addConnections() {
+	arch := &t.result.Architecture
+	components := make(map[string]bool, len(arch.Components))
+	for _, c := range arch.Components {
+		components[c.ID] = true
+	}
+	for _, key := range t.order {
+		e := t.edges[key]
+		if !components[e.source] || !components[e.target] {
+			continue
+		}
+		c := newImportedConnection(e.source+" -> "+e.target, strings.Join(e.relationships, ", "), e.source, e.target)
+		c.Labels["relationships"] = strings.Join(e.relationships, ",")
+		if len(e.ports) > 0 {
+			c.Labels["ports"] = strings.Join(e.ports, ",")
+		}
+		arch.Connections = append(arch.Connections, c)
+	}
+}
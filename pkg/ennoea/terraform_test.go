@@ -0,0 +1,264 @@
+package ennoea
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tfTestState is the state of a load balancer in front of an instance,
+// and a database in a module that the security group of the instance
+// is allowed to reach.
+const tfTestState = `{
+	"format_version": "1.0",
+	"values": {
+		"root_module": {
+			"resources": [
+				{"address": "aws_lb.main", "mode": "managed", "type": "aws_lb", "name": "main", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"arn": "arn:lb"}},
+				{"address": "aws_lb_target_group.web", "mode": "managed", "type": "aws_lb_target_group", "name": "web", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"arn": "arn:tg"}},
+				{"address": "aws_lb_listener.http", "mode": "managed", "type": "aws_lb_listener", "name": "http", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"load_balancer_arn": "arn:lb", "default_action": [{"target_group_arn": "arn:tg"}]}},
+				{"address": "aws_lb_target_group_attachment.web", "mode": "managed", "type": "aws_lb_target_group_attachment", "name": "web", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"target_group_arn": "arn:tg", "target_id": "i-1"}},
+				{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "name": "web", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"id": "i-1", "vpc_security_group_ids": ["sg-web"]}},
+				{"address": "aws_security_group.web", "mode": "managed", "type": "aws_security_group", "name": "web", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"id": "sg-web"}},
+				{"address": "aws_security_group.db", "mode": "managed", "type": "aws_security_group", "name": "db", "provider_name": "registry.terraform.io/hashicorp/aws",
+					"values": {"id": "sg-db", "ingress": [{"from_port": 5432, "to_port": 5432, "protocol": "tcp", "security_groups": ["sg-web"]}]}},
+				{"address": "data.aws_ami.base", "mode": "data", "type": "aws_ami", "name": "base", "provider_name": "registry.terraform.io/hashicorp/aws"}
+			],
+			"child_modules": [
+				{
+					"resources": [
+						{"address": "module.db.aws_db_instance.main", "mode": "managed", "type": "aws_db_instance", "name": "main", "provider_name": "registry.terraform.io/hashicorp/aws",
+							"values": {"id": "db-1", "vpc_security_group_ids": ["sg-db"]}, "depends_on": ["aws_security_group.db"]}
+					]
+				}
+			]
+		}
+	}
+}`
+
+// tfTestPlan is a plan that creates an instance, replaces the bucket it
+// refers to and destroys a queue.
+const tfTestPlan = `{
+	"format_version": "1.2",
+	"planned_values": {
+		"root_module": {
+			"resources": [
+				{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "name": "web", "provider_name": "registry.terraform.io/hashicorp/aws"},
+				{"address": "aws_s3_bucket.logs", "mode": "managed", "type": "aws_s3_bucket", "name": "logs", "provider_name": "registry.terraform.io/hashicorp/aws"},
+				{"address": "aws_sns_topic.alerts", "mode": "managed", "type": "aws_sns_topic", "name": "alerts", "provider_name": "registry.terraform.io/hashicorp/aws"}
+			]
+		}
+	},
+	"resource_changes": [
+		{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "name": "web", "change": {"actions": ["create"]}},
+		{"address": "aws_s3_bucket.logs", "mode": "managed", "type": "aws_s3_bucket", "name": "logs", "change": {"actions": ["delete", "create"]}},
+		{"address": "aws_sns_topic.alerts", "mode": "managed", "type": "aws_sns_topic", "name": "alerts", "change": {"actions": ["no-op"]}},
+		{"address": "aws_sqs_queue.old", "mode": "managed", "type": "aws_sqs_queue", "name": "old", "provider_name": "registry.terraform.io/hashicorp/aws",
+			"change": {"actions": ["delete"], "before": {"id": "q-1"}}}
+	],
+	"configuration": {
+		"root_module": {
+			"resources": [
+				{"address": "aws_instance.web", "mode": "managed", "expressions": {"user_data": {"references": ["aws_s3_bucket.logs.id", "aws_s3_bucket.logs", "var.env"]}}}
+			]
+		}
+	}
+}`
+
+func TestImportTerraform(t *testing.T) {
+	testImports(t, "terraform", []importTest{
+		{
+			name: "state",
+			data: tfTestState,
+			want: []string{
+				`component aws_lb.main "aws_lb.main" octahedron`,
+				`component aws_lb_target_group.web "aws_lb_target_group.web" box`,
+				`component aws_lb_listener.http "aws_lb_listener.http" box`,
+				`component aws_instance.web "aws_instance.web" box`,
+				`component aws_security_group.web "aws_security_group.web" box`,
+				`component aws_security_group.db "aws_security_group.db" box`,
+				`component module.db.aws_db_instance.main "aws_db_instance.main" cylinder`,
+				`group module.db module.db.aws_db_instance.main`,
+				`connection module.db.aws_db_instance.main -> aws_security_group.db out`,
+				`connection aws_instance.web -> module.db.aws_db_instance.main out`,
+				`connection aws_lb.main -> aws_instance.web out`,
+			},
+		},
+		{
+			name:   "state with a config",
+			data:   tfTestState,
+			config: `{"providers": {"aws": {"aws_security_group": {"skip": true}, "aws_lb_*": {"skip": true}, "aws_lb": {"type": "server", "geometry": "torus"}}}}`,
+			want: []string{
+				`component aws_lb.main "aws_lb.main" torus`,
+				`component aws_instance.web "aws_instance.web" box`,
+				`component module.db.aws_db_instance.main "aws_db_instance.main" cylinder`,
+				`group module.db module.db.aws_db_instance.main`,
+				`connection aws_instance.web -> module.db.aws_db_instance.main out`,
+				`connection aws_lb.main -> aws_instance.web out`,
+			},
+		},
+		{
+			name: "plan",
+			data: tfTestPlan,
+			want: []string{
+				`component aws_instance.web "aws_instance.web" box`,
+				`component aws_s3_bucket.logs "aws_s3_bucket.logs" cylinder`,
+				`component aws_sns_topic.alerts "aws_sns_topic.alerts" torus`,
+				`component aws_sqs_queue.old "aws_sqs_queue.old" torus`,
+				`connection aws_instance.web -> aws_s3_bucket.logs out`,
+			},
+		},
+		{name: "invalid JSON", data: `{`, err: "failed to parse terraform json"},
+		{name: "not terraform", data: `{"values": {}}`, err: "missing format_version"},
+		{name: "no resources", data: `{"format_version": "1.0", "values": {"root_module": {}}}`, err: "no managed resources"},
+		{name: "invalid config", data: tfTestState, config: `{"providers": {"aws": {"aws_lb": {"type": "db"}}}}`, err: "invalid config: aws: aws_lb: invalid type: db"},
+		{name: "invalid colour", data: tfTestState, config: `{"providers": {"aws": {"aws_lb": {"color": "red"}}}}`, err: "invalid config: aws: aws_lb"},
+	})
+}
+
+func TestImportTerraformLabels(t *testing.T) {
+	result, err := Import("terraform", []byte(tfTestState), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := result.Architecture
+	want := map[string]string{"address": "module.db.aws_db_instance.main", "type": "aws_db_instance", "provider": "aws"}
+	if got := arch.Components[6].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("database labels = %v, want %v", got, want)
+	}
+	want = map[string]string{"relationships": "security-group", "ports": "5432/tcp"}
+	if got := arch.Connections[1].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("security group labels = %v, want %v", got, want)
+	}
+	if want := "Imported from terraform state"; arch.Info.Description != want {
+		t.Errorf("description = %q, want %q", arch.Info.Description, want)
+	}
+
+	result, err = Import("terraform", []byte(tfTestPlan), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch = result.Architecture
+	if want := "Imported from a terraform plan that creates 1, updates 0, replaces 1 and destroys 1 resources"; arch.Info.Description != want {
+		t.Errorf("description = %q, want %q", arch.Info.Description, want)
+	}
+	for i, action := range []string{"create", "replace", "", "delete"} {
+		c := arch.Components[i]
+		color := tfActionColors[action]
+		if action == "" {
+			color = "#ffffff"
+		}
+		if c.Labels["action"] != action || c.Object.Color != color {
+			t.Errorf("%s has action %q and colour %s, want %q and %s", c.ID, c.Labels["action"], c.Object.Color, action, color)
+		}
+	}
+}
+
+func TestTerraformConfigMapping(t *testing.T) {
+	config := TerraformConfig{Providers: map[string]map[string]TerraformTypeMapping{
+		"aws": {
+			"aws_*":        {Type: "server", Geometry: "sphere"},
+			"aws_iam_*":    {Skip: true},
+			"aws_lambda_*": {Type: "app"},
+		},
+	}}
+	tests := []struct {
+		provider, resourceType string
+		want                   TerraformTypeMapping
+	}{
+		{"aws", "aws_iam_role", TerraformTypeMapping{Skip: true}},
+		{"aws", "aws_vpc", TerraformTypeMapping{Type: "server", Geometry: "sphere"}},
+		{"aws", "aws_lambda_function", TerraformTypeMapping{Type: "app"}},
+		{"google", "google_sql_database_instance", TerraformTypeMapping{Type: "server", Geometry: "cylinder"}},
+		{"google", "google_project_iam_member", TerraformTypeMapping{Skip: true}},
+		{"random", "random_id", TerraformTypeMapping{Type: "app", Geometry: "box"}},
+	}
+	for _, test := range tests {
+		if got := config.mapping(test.provider, test.resourceType); got != test.want {
+			t.Errorf("mapping(%s, %s) = %+v, want %+v", test.provider, test.resourceType, got, test.want)
+		}
+	}
+}
+
+func TestTerraformAddresses(t *testing.T) {
+	if got, want := tfModules(`module.app["a.b"].module.db.aws_db_instance.main[0]`), []string{`module.app["a.b"]`, `module.app["a.b"].module.db`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tfModules = %q, want %q", got, want)
+	}
+	if got, want := tfConfigAddress(`module.app["a.b"].aws_instance.web[0]`), "module.app.aws_instance.web"; got != want {
+		t.Errorf("tfConfigAddress = %q, want %q", got, want)
+	}
+	refs := map[string]string{
+		"aws_lb.main.arn":      "aws_lb.main",
+		"aws_lb.main[0].arn":   "aws_lb.main",
+		"var.region":           "",
+		"data.aws_ami.base.id": "",
+		"module.db.endpoint":   "",
+		"aws_lb":               "",
+	}
+	for ref, want := range refs {
+		if got := tfReferenceAddress(ref); got != want {
+			t.Errorf("tfReferenceAddress(%q) = %q, want %q", ref, got, want)
+		}
+	}
+	ports := []struct {
+		values map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"from_port": 443.0, "to_port": 443.0, "protocol": "tcp"}, "443/tcp"},
+		{map[string]interface{}{"from_port": 8000.0, "to_port": 8080.0, "protocol": "tcp"}, "8000-8080/tcp"},
+		{map[string]interface{}{"protocol": "-1"}, "all"},
+	}
+	for _, test := range ports {
+		if got := tfPorts(test.values, "protocol"); got != test.want {
+			t.Errorf("tfPorts(%v) = %q, want %q", test.values, got, test.want)
+		}
+	}
+}
+
+func TestPostImportMultipart(t *testing.T) {
+	h, _ := newTestHandler(t)
+	imports := NewImportHandler(h)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(tfTestState))
+	form.WriteField("config", `{"providers": {"aws": {"aws_security_group": {"skip": true}}}}`)
+	form.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/import/terraform?preview=true", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	imports.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST returned %d: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), `"aws_security_group.web"`) {
+		t.Errorf("the config was not used: %s", w.Body)
+	}
+
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	form.WriteField("config", "{}")
+	form.Close()
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/import/terraform", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	imports.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST without a file returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
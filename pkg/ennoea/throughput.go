@@ -0,0 +1,364 @@
+package ennoea
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+const (
+	// defaultBottleneckThreshold is the default utilisation at or
+	// above which a component or connection is a bottleneck.
+	defaultBottleneckThreshold = 0.8
+
+	// defaultAsymmetryThreshold is the default ratio between the
+	// larger and the smaller rate of a "bi" connection at or above
+	// which the flow is asymmetric.
+	defaultAsymmetryThreshold = 10.0
+)
+
+// ThroughputReport represents the aggregated data rates of the
+// components, groups and connections of an architecture.
+type ThroughputReport struct {
+	// Components is the throughput of each component.
+	Components []ComponentThroughput `json:"components"`
+
+	// Groups is the throughput of each group.
+	Groups []GroupThroughput `json:"groups"`
+
+	// Connections is the throughput of each connection.
+	Connections []ConnectionThroughput `json:"connections"`
+
+	// Bottlenecks is a list of the IDs of the components and
+	// connections whose utilisation is at or above the threshold.
+	Bottlenecks []string `json:"bottlenecks"`
+
+	// Asymmetric is a list of the IDs of the "bi" connections whose
+	// rates differ by at least the asymmetry threshold.
+	Asymmetric []string `json:"asymmetric"`
+}
+
+// ComponentThroughput represents the throughput of a component.
+type ComponentThroughput struct {
+	// ID is the ID of the component.
+	ID string `json:"id"`
+
+	// Ingress is the total rate of data received by the component.
+	Ingress Rate `json:"ingress"`
+
+	// Egress is the total rate of data sent by the component.
+	Egress Rate `json:"egress"`
+
+	// IngressPackets is the number of packets received per second.
+	// Connections without a packet size are not counted.
+	IngressPackets float64 `json:"ingressPackets"`
+
+	// EgressPackets is the number of packets sent per second.
+	// Connections without a packet size are not counted.
+	EgressPackets float64 `json:"egressPackets"`
+
+	// Capacity is the declared capacity of the component.
+	Capacity Rate `json:"capacity,omitempty"`
+
+	// Utilisation is the larger of the ingress and egress divided by
+	// the capacity. It is zero if the capacity is unknown.
+	Utilisation float64 `json:"utilisation"`
+
+	// Bottleneck is set when the utilisation is at or above the
+	// bottleneck threshold.
+	Bottleneck bool `json:"bottleneck"`
+
+	// Heat is a value between 0 and 1 that the viewer can colour
+	// by. It is the utilisation if the capacity is known, otherwise
+	// it is the throughput relative to the busiest component.
+	Heat float64 `json:"heat"`
+
+	// Color is the heat as a colour from green to red.
+	Color string `json:"color"`
+}
+
+// GroupThroughput represents the throughput of a group. Only the
+// connections that cross the boundary of the group count towards
+// the ingress and egress.
+type GroupThroughput struct {
+	// ID is the ID of the group.
+	ID string `json:"id"`
+
+	// Ingress is the total rate of data received from components
+	// outside of the group.
+	Ingress Rate `json:"ingress"`
+
+	// Egress is the total rate of data sent to components outside
+	// of the group.
+	Egress Rate `json:"egress"`
+
+	// Internal is the total rate of data sent between the
+	// components inside the group.
+	Internal Rate `json:"internal"`
+}
+
+// ConnectionThroughput represents the throughput of a connection.
+type ConnectionThroughput struct {
+	// ID is the ID of the connection.
+	ID string `json:"id"`
+
+	// OutRate is the rate from the source to the target. It is zero
+	// if the flow is "in".
+	OutRate Rate `json:"outRate"`
+
+	// InRate is the rate from the target to the source. It is zero
+	// if the flow is "out".
+	InRate Rate `json:"inRate"`
+
+	// OutPackets is the number of packets per second from the
+	// source to the target.
+	OutPackets float64 `json:"outPackets"`
+
+	// InPackets is the number of packets per second from the
+	// target to the source.
+	InPackets float64 `json:"inPackets"`
+
+	// Capacity is the declared capacity of the connection.
+	Capacity Rate `json:"capacity,omitempty"`
+
+	// Utilisation is the larger of the two rates divided by the
+	// capacity. It is zero if the capacity is unknown.
+	Utilisation float64 `json:"utilisation"`
+
+	// Bottleneck is set when the utilisation is at or above the
+	// bottleneck threshold.
+	Bottleneck bool `json:"bottleneck"`
+
+	// Asymmetric is set when the flow is "bi" and the larger rate
+	// is at least the asymmetry threshold times the smaller rate.
+	Asymmetric bool `json:"asymmetric"`
+
+	// Heat is a value between 0 and 1 that the viewer can colour
+	// by. It is the utilisation if the capacity is known, otherwise
+	// it is the rate relative to the busiest connection.
+	Heat float64 `json:"heat"`
+
+	// Color is the heat as a colour from green to red.
+	Color string `json:"color"`
+}
+
+// computeThroughput computes the throughput report of the
+// architecture. Components and connections with a utilisation at or
+// above the bottleneck threshold are bottlenecks, and "bi"
+// connections whose rates differ by the asymmetry ratio are
+// asymmetric.
+func computeThroughput(arch Architecture, bottleneckThreshold, asymmetryThreshold float64) ThroughputReport {
+	g := newArchitectureGraph(arch)
+	report := ThroughputReport{
+		Components:  make([]ComponentThroughput, len(arch.Components)),
+		Groups:      make([]GroupThroughput, 0, len(arch.Groups)),
+		Connections: make([]ConnectionThroughput, len(arch.Connections)),
+		Bottlenecks: []string{},
+		Asymmetric:  []string{},
+	}
+
+	for i, c := range arch.Components {
+		report.Components[i] = ComponentThroughput{ID: c.ID, Capacity: c.Capacity}
+	}
+	for i, c := range arch.Connections {
+		report.Connections[i] = ConnectionThroughput{ID: c.ID, Capacity: c.Capacity}
+	}
+
+	// Add up the rate and packets of each edge. Every edge follows
+	// the flow of data, so the rate of a forward edge is the out
+	// rate of the connection and the rate of a reverse edge is the
+	// in rate.
+	for v := 0; v < g.size(); v++ {
+		for _, e := range g.out[v] {
+			rate, packets := edgeRate(arch.Connections[e.connection], e.forward)
+			report.Components[e.from].Egress += rate
+			report.Components[e.from].EgressPackets += packets
+			report.Components[e.to].Ingress += rate
+			report.Components[e.to].IngressPackets += packets
+
+			connection := &report.Connections[e.connection]
+			if e.forward {
+				connection.OutRate = rate
+				connection.OutPackets = packets
+			} else {
+				connection.InRate = rate
+				connection.InPackets = packets
+			}
+		}
+	}
+
+	// Group totals only count the connections that cross the
+	// boundary of the group.
+	for _, group := range arch.Groups {
+		members := make(map[int]bool, len(group.Components))
+		for _, id := range group.Components {
+			if i, ok := g.index[id]; ok {
+				members[i] = true
+			}
+		}
+
+		t := GroupThroughput{ID: group.ID}
+		for v := range members {
+			for _, e := range g.out[v] {
+				rate, _ := edgeRate(arch.Connections[e.connection], e.forward)
+				if members[e.to] {
+					t.Internal += rate
+				} else {
+					t.Egress += rate
+				}
+			}
+			for _, e := range g.in[v] {
+				if !members[e.from] {
+					rate, _ := edgeRate(arch.Connections[e.connection], e.forward)
+					t.Ingress += rate
+				}
+			}
+		}
+		report.Groups = append(report.Groups, t)
+	}
+
+	// Utilisation, bottlenecks and heat of the components
+	busiestComponent := Rate(0)
+	for _, c := range report.Components {
+		busiestComponent = rateMax(busiestComponent, rateMax(c.Ingress, c.Egress))
+	}
+	for i := range report.Components {
+		c := &report.Components[i]
+		peak := rateMax(c.Ingress, c.Egress)
+		c.Utilisation = utilisation(peak, c.Capacity)
+		c.Bottleneck = c.Capacity > 0 && c.Utilisation >= bottleneckThreshold
+		c.Heat = heat(peak, c.Capacity, busiestComponent)
+		c.Color = heatColor(c.Heat)
+		if c.Bottleneck {
+			report.Bottlenecks = append(report.Bottlenecks, c.ID)
+		}
+	}
+
+	// Utilisation, bottlenecks, asymmetry and heat of the connections
+	busiestConnection := Rate(0)
+	for _, c := range report.Connections {
+		busiestConnection = rateMax(busiestConnection, rateMax(c.OutRate, c.InRate))
+	}
+	for i := range report.Connections {
+		c := &report.Connections[i]
+		peak := rateMax(c.OutRate, c.InRate)
+		c.Utilisation = utilisation(peak, c.Capacity)
+		c.Bottleneck = c.Capacity > 0 && c.Utilisation >= bottleneckThreshold
+		c.Heat = heat(peak, c.Capacity, busiestConnection)
+		c.Color = heatColor(c.Heat)
+		if c.Bottleneck {
+			report.Bottlenecks = append(report.Bottlenecks, c.ID)
+		}
+
+		if arch.Connections[i].Flow == "bi" && peak > 0 {
+			low := math.Min(float64(c.OutRate), float64(c.InRate))
+			c.Asymmetric = low == 0 || float64(peak)/low >= asymmetryThreshold
+			if c.Asymmetric {
+				report.Asymmetric = append(report.Asymmetric, c.ID)
+			}
+		}
+	}
+
+	return report
+}
+
+// edgeRate returns the rate and the packets per second of the
+// connection in the direction of the edge.
+func edgeRate(c Connection, forward bool) (Rate, float64) {
+	rate, size := c.OutRate, c.OutPacketSize
+	if !forward {
+		rate, size = c.InRate, c.InPacketSize
+	}
+
+	packets := 0.0
+	if size > 0 {
+		packets = float64(rate) / float64(size)
+	}
+
+	return rate, packets
+}
+
+// utilisation returns the rate divided by the capacity, or zero if
+// the capacity is unknown.
+func utilisation(rate, capacity Rate) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(rate) / float64(capacity)
+}
+
+// heat returns a value between 0 and 1 for the rate. The utilisation
+// is used when the capacity is known, otherwise the rate is compared
+// to the busiest rate.
+func heat(rate, capacity, busiest Rate) float64 {
+	if capacity > 0 {
+		return math.Min(utilisation(rate, capacity), 1)
+	}
+	if busiest <= 0 {
+		return 0
+	}
+	return float64(rate) / float64(busiest)
+}
+
+// heatColor returns the heat as a colour that goes from green at 0,
+// through yellow, to red at 1.
+func heatColor(h float64) string {
+	h = math.Max(0, math.Min(h, 1))
+	red, green := 255.0, 255.0
+	if h < 0.5 {
+		red = 255 * h * 2
+	} else {
+		green = 255 * (1 - h) * 2
+	}
+	return fmt.Sprintf("#%02x%02x00", int(math.Round(red)), int(math.Round(green)))
+}
+
+// rateMax returns the larger of the two rates.
+func rateMax(a, b Rate) Rate {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// handleGetThroughput handles GET requests for the throughput report
+// of an architecture.
+// GET /architectures/${architectureID}/throughput
+//
+//	return the ingress, egress, packets per second and utilisation of
+//	each component, group and connection. The optional parameters are:
+//	  bottleneck  the utilisation threshold for bottlenecks (0.8)
+//	  asymmetry   the rate ratio threshold for asymmetric flows (10)
+//	  view        "architecture" to return the architecture with the
+//	              components coloured by heat
+func (h *ArchitectureHandler) handleGetThroughput(w http.ResponseWriter, r *http.Request, architectureID string) {
+	arch, ok := h.loadArchitectureForRequest(w, architectureID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	bottleneck, err := queryFloat(query.Get("bottleneck"), defaultBottleneckThreshold)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid bottleneck: %v", err)
+		return
+	}
+	asymmetry, err := queryFloat(query.Get("asymmetry"), defaultAsymmetryThreshold)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid asymmetry: %v", err)
+		return
+	}
+
+	report := computeThroughput(arch, bottleneck, asymmetry)
+
+	if query.Get("view") == "architecture" {
+		for i := range arch.Components {
+			arch.Components[i].Object.Color = report.Components[i].Color
+		}
+		writeJSONResponse(w, http.StatusOK, arch)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,103 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// throughputTestArchitecture returns an architecture in which a and
+// b are grouped, a sends to b and c, and b and c exchange data in
+// both directions.
+func throughputTestArchitecture() Architecture {
+	return Architecture{
+		Components: []Component{
+			{ID: "a", Capacity: 2000},
+			{ID: "b"},
+			{ID: "c"},
+		},
+		Groups: []Group{
+			{ID: "ab", Components: []string{"a", "b"}},
+		},
+		Connections: []Connection{
+			{ID: "a-b", Source: "a", Target: "b", Flow: "out", OutRate: 1000, OutPacketSize: 100, Capacity: 1000},
+			{ID: "b-c", Source: "b", Target: "c", Flow: "bi", OutRate: 100, InRate: 2000, InPacketSize: 500},
+			{ID: "c-a", Source: "c", Target: "a", Flow: "in", OutRate: 999, InRate: 500},
+		},
+	}
+}
+
+func TestComputeThroughput(t *testing.T) {
+	report := computeThroughput(throughputTestArchitecture(), defaultBottleneckThreshold, defaultAsymmetryThreshold)
+
+	components := []ComponentThroughput{
+		{ID: "a", Egress: 1500, EgressPackets: 10, Capacity: 2000, Utilisation: 0.75, Heat: 0.75, Color: "#ff8000"},
+		{ID: "b", Ingress: 3000, Egress: 100, IngressPackets: 14, Heat: 1, Color: "#ff0000"},
+		{ID: "c", Ingress: 600, Egress: 2000, EgressPackets: 4, Heat: 2.0 / 3, Color: "#ffaa00"},
+	}
+	if !reflect.DeepEqual(report.Components, components) {
+		t.Errorf("components = %+v, want %+v", report.Components, components)
+	}
+
+	groups := []GroupThroughput{{ID: "ab", Ingress: 2000, Egress: 600, Internal: 1000}}
+	if !reflect.DeepEqual(report.Groups, groups) {
+		t.Errorf("groups = %+v, want %+v", report.Groups, groups)
+	}
+
+	connections := []ConnectionThroughput{
+		{ID: "a-b", OutRate: 1000, OutPackets: 10, Capacity: 1000, Utilisation: 1, Bottleneck: true, Heat: 1, Color: "#ff0000"},
+		{ID: "b-c", OutRate: 100, InRate: 2000, InPackets: 4, Asymmetric: true, Heat: 1, Color: "#ff0000"},
+		{ID: "c-a", InRate: 500, Heat: 0.25, Color: "#80ff00"},
+	}
+	if !reflect.DeepEqual(report.Connections, connections) {
+		t.Errorf("connections = %+v, want %+v", report.Connections, connections)
+	}
+
+	if want := []string{"a-b"}; !reflect.DeepEqual(report.Bottlenecks, want) {
+		t.Errorf("bottlenecks = %v, want %v", report.Bottlenecks, want)
+	}
+	if want := []string{"b-c"}; !reflect.DeepEqual(report.Asymmetric, want) {
+		t.Errorf("asymmetric = %v, want %v", report.Asymmetric, want)
+	}
+}
+
+func TestComputeThroughputThresholds(t *testing.T) {
+	report := computeThroughput(throughputTestArchitecture(), 0.7, 30)
+	if want := []string{"a", "a-b"}; !reflect.DeepEqual(report.Bottlenecks, want) {
+		t.Errorf("bottlenecks = %v, want %v", report.Bottlenecks, want)
+	}
+	if len(report.Asymmetric) != 0 {
+		t.Errorf("asymmetric = %v, want none", report.Asymmetric)
+	}
+
+	// A "bi" connection that only carries data one way is asymmetric
+	arch := throughputTestArchitecture()
+	arch.Connections[1].OutRate = 0
+	report = computeThroughput(arch, defaultBottleneckThreshold, 30)
+	if want := []string{"b-c"}; !reflect.DeepEqual(report.Asymmetric, want) {
+		t.Errorf("asymmetric = %v, want %v", report.Asymmetric, want)
+	}
+}
+
+func TestGetThroughput(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var report ThroughputReport
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/throughput?asymmetry=4", "", http.StatusOK, &report)
+	if want := []string{"web-api", "api-db"}; !reflect.DeepEqual(report.Asymmetric, want) {
+		t.Errorf("asymmetric = %v, want %v", report.Asymmetric, want)
+	}
+
+	var arch Architecture
+	serveTestJSON(t, h, http.MethodGet, "/architectures/shop/throughput?view=architecture", "", http.StatusOK, &arch)
+	if len(arch.Components) != 3 || arch.Components[1].Object.Color != "#ff0000" {
+		body, _ := json.Marshal(arch.Components)
+		t.Errorf("coloured components = %s, want api to be the hottest", body)
+	}
+
+	for _, query := range []string{"bottleneck=x", "bottleneck=-1", "asymmetry=NaN"} {
+		serveTestJSON(t, h, http.MethodGet, "/architectures/shop/throughput?"+query, "", http.StatusBadRequest, nil)
+	}
+	serveTestJSON(t, h, http.MethodGet, "/architectures/none/throughput", "", http.StatusNotFound, nil)
+}
@@ -0,0 +1,403 @@
+package ennoea
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+Rates and sizes are stored as plain numbers of bytes per second and
+bytes. When loading an architecture they can also be given as strings
+with a unit, which are normalised to the canonical number of bytes.
+
+Sizes:
+	"512"     512 bytes
+	"1e3"     1000 bytes
+	"512B"    512 bytes
+	"1.5KB"   1500 bytes (decimal prefixes: K, M, G, T)
+	"512KiB"  524288 bytes (binary prefixes: Ki, Mi, Gi, Ti)
+	"8Kb"     1000 bytes (a lower case "b" is bits)
+
+Rates are sizes per second, written with either a "/s" or a "ps"
+suffix:
+	"10MB/s"   10000000 bytes per second
+	"10MiB/s"  10485760 bytes per second
+	"1.5Gbps"  187500000 bytes per second
+
+A rate without a per second suffix is a size, and a size with a per
+second suffix is a rate. Both are rejected as a unit mismatch. Numbers
+without a unit must be a whole number of bytes, as a JSON number or as
+a string, while numbers with a unit are rounded to the nearest byte.
+Values that do not fit in a 64 bit integer are rejected.
+
+The human readable units of a rate or a size always parse back to the
+same number of bytes. Values that no unit represents with two decimal
+places use three decimal places of a decimal unit, or plain bytes, and
+values above 2^53 bytes are left as numbers.
+*/
+
+// Rate is a data rate in bytes per second. A rate can be unmarshalled
+// from a JSON number or from a string with a unit such as "10MB/s".
+type Rate int
+
+// UnmarshalJSON unmarshals the rate from a number or a unit string.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalQuantity(data, true)
+	if err != nil {
+		return err
+	}
+	*r = Rate(value)
+	return nil
+}
+
+// String returns the rate formatted with a human readable unit.
+func (r Rate) String() string {
+	return formatBytes(int64(r)) + "/s"
+}
+
+// Size is a data size in bytes. A size can be unmarshalled from a
+// JSON number or from a string with a unit such as "512KiB".
+type Size int
+
+// UnmarshalJSON unmarshals the size from a number or a unit string.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalQuantity(data, false)
+	if err != nil {
+		return err
+	}
+	*s = Size(value)
+	return nil
+}
+
+// String returns the size formatted with a human readable unit.
+func (s Size) String() string {
+	return formatBytes(int64(s))
+}
+
+// unmarshalQuantity unmarshals a JSON number or unit string into a
+// number of bytes, or bytes per second when isRate is true.
+func unmarshalQuantity(data []byte, isRate bool) (int, error) {
+	kind := "size"
+	if isRate {
+		kind = "rate"
+	}
+
+	// Plain numbers are already in the canonical unit. Integers are
+	// parsed directly so that large values keep their precision.
+	if value, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return int(value), nil
+	}
+	var number float64
+	if err := json.Unmarshal(data, &number); err == nil {
+		value, err := wholeBytes(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", kind, err)
+		}
+		return value, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return 0, fmt.Errorf("invalid %s: expected a number or a string: %s", kind, data)
+	}
+
+	if isRate {
+		return parseRate(text)
+	}
+	return parseSize(text)
+}
+
+// parseRate parses a rate with a unit, such as "10MB/s" or "1.5Gbps",
+// and returns the number of bytes per second.
+func parseRate(text string) (int, error) {
+	value, unit, err := splitQuantity(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %w", err)
+	}
+
+	// A plain number is already in bytes per second.
+	if unit == "" {
+		value, err := wholeBytes(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate: %w", err)
+		}
+		return value, nil
+	}
+
+	perSecond := false
+	for _, suffix := range []string{"/s", "ps"} {
+		if strings.HasSuffix(unit, suffix) {
+			unit = strings.TrimSuffix(unit, suffix)
+			perSecond = true
+			break
+		}
+	}
+	if !perSecond {
+		return 0, fmt.Errorf("invalid rate: %q is a size, expected a unit per second", text)
+	}
+
+	multiplier, err := unitMultiplier(unit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %w: %q", err, text)
+	}
+
+	rounded, err := roundBytes(value * multiplier)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %w", err)
+	}
+	return rounded, nil
+}
+
+// parseSize parses a size with a unit, such as "512KiB", and returns
+// the number of bytes.
+func parseSize(text string) (int, error) {
+	value, unit, err := splitQuantity(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %w", err)
+	}
+
+	// A plain number is already in bytes.
+	if unit == "" {
+		value, err := wholeBytes(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size: %w", err)
+		}
+		return value, nil
+	}
+
+	if strings.HasSuffix(unit, "/s") || strings.HasSuffix(unit, "ps") {
+		return 0, fmt.Errorf("invalid size: %q is a rate, expected a size", text)
+	}
+
+	multiplier, err := unitMultiplier(unit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %w: %q", err, text)
+	}
+
+	rounded, err := roundBytes(value * multiplier)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %w", err)
+	}
+	return rounded, nil
+}
+
+// splitQuantity splits the text into the leading number, which may
+// have an exponent, and the unit that follows it.
+func splitQuantity(text string) (float64, string, error) {
+	text = strings.TrimSpace(text)
+	isDigit := func(i int) bool {
+		return i < len(text) && text[i] >= '0' && text[i] <= '9'
+	}
+	end := 0
+	for end < len(text) && (text[end] == '.' || text[end] == '-' || text[end] == '+' || isDigit(end)) {
+		end++
+	}
+
+	// An "e" is an exponent if digits follow it, and a unit otherwise
+	if end < len(text) && (text[end] == 'e' || text[end] == 'E') {
+		exponent := end + 1
+		if exponent < len(text) && (text[exponent] == '-' || text[exponent] == '+') {
+			exponent++
+		}
+		if isDigit(exponent) {
+			for end = exponent; isDigit(end); end++ {
+			}
+		}
+	}
+
+	value, err := strconv.ParseFloat(text[:end], 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("missing number: %q", text)
+	}
+	if value < 0 {
+		return 0, "", fmt.Errorf("negative value: %q", text)
+	}
+
+	return value, strings.TrimSpace(text[end:]), nil
+}
+
+// unitMultiplier returns the number of bytes in the unit. The
+// prefix is case insensitive, but the final "B" or "b" decides
+// whether the unit is bytes or bits.
+func unitMultiplier(unit string) (float64, error) {
+	if unit == "" {
+		return 0, fmt.Errorf("missing unit")
+	}
+
+	var bits bool
+	switch unit[len(unit)-1] {
+	case 'B':
+		bits = false
+	case 'b':
+		bits = true
+	default:
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+
+	var multiplier float64
+	switch strings.ToLower(unit[:len(unit)-1]) {
+	case "":
+		multiplier = 1
+	case "k":
+		multiplier = 1e3
+	case "m":
+		multiplier = 1e6
+	case "g":
+		multiplier = 1e9
+	case "t":
+		multiplier = 1e12
+	case "ki":
+		multiplier = 1 << 10
+	case "mi":
+		multiplier = 1 << 20
+	case "gi":
+		multiplier = 1 << 30
+	case "ti":
+		multiplier = 1 << 40
+	default:
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+
+	if bits {
+		multiplier /= 8
+	}
+
+	return multiplier, nil
+}
+
+// roundBytes rounds the value to the nearest whole byte. Values that
+// do not fit in a 64 bit integer are rejected.
+func roundBytes(value float64) (int, error) {
+	value = math.Round(value)
+	if math.IsNaN(value) || value >= 1<<63 || value < -(1<<63) {
+		return 0, fmt.Errorf("%v is out of range", value)
+	}
+	return int(value), nil
+}
+
+// wholeBytes returns the value as a whole number of bytes, or an error
+// if it has a fraction or is out of range.
+func wholeBytes(value float64) (int, error) {
+	if value != math.Trunc(value) {
+		return 0, fmt.Errorf("%v is not a whole number of bytes", value)
+	}
+	return roundBytes(value)
+}
+
+// byteUnits are the units that bytes are formatted with, largest
+// first.
+var byteUnits = []struct {
+	name       string
+	multiplier int64
+	decimal    bool
+}{
+	{"TiB", 1 << 40, false}, {"TB", 1e12, true},
+	{"GiB", 1 << 30, false}, {"GB", 1e9, true},
+	{"MiB", 1 << 20, false}, {"MB", 1e6, true},
+	{"KiB", 1 << 10, false}, {"KB", 1e3, true},
+}
+
+// formatBytes formats the number of bytes with a human readable unit
+// that parses back to the same number. The largest unit that
+// represents the value exactly with at most two decimal places is
+// preferred, then the largest decimal unit that represents it with
+// three, and then plain bytes.
+func formatBytes(value int64) string {
+	sign := ""
+	magnitude := uint64(value)
+	if value < 0 {
+		sign = "-"
+		magnitude = -magnitude
+	}
+
+	for _, places := range []uint64{100, 1000} {
+		for _, unit := range byteUnits {
+			multiplier := uint64(unit.multiplier)
+			if magnitude < multiplier || (places == 1000 && !unit.decimal) {
+				continue
+			}
+			remainder := magnitude % multiplier
+			if remainder*places%multiplier == 0 {
+				return sign + formatFixed(magnitude/multiplier, remainder*places/multiplier, places) + unit.name
+			}
+		}
+	}
+
+	return sign + strconv.FormatUint(magnitude, 10) + "B"
+}
+
+// formatFixed formats a whole number and a fraction of the places,
+// without trailing zeros.
+func formatFixed(whole, fraction, places uint64) string {
+	if fraction == 0 {
+		return strconv.FormatUint(whole, 10)
+	}
+	digits := len(strconv.FormatUint(places, 10)) - 1
+	formatted := fmt.Sprintf("%d.%0*d", whole, digits, fraction)
+	return strings.TrimRight(formatted, "0")
+}
+
+// maxHumanBytes is the largest number of bytes that is formatted with
+// a unit. Larger values do not survive the floating point parse of a
+// unit string, so they are left as numbers.
+const maxHumanBytes = 1 << 53
+
+// humanizeUnits rewrites the rates and sizes of the components and
+// connections in the architecture JSON as strings with human readable
+// units. The result can be loaded again without changing any values,
+// because the units are accepted on input and always parse back to the
+// same number of bytes.
+func humanizeUnits(file []byte) ([]byte, error) {
+	var arch map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(file))
+	decoder.UseNumber()
+	if err := decoder.Decode(&arch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal architecture: %v", err)
+	}
+
+	rate := func(n int64) string { return Rate(n).String() }
+	size := func(n int64) string { return Size(n).String() }
+
+	components, _ := arch["components"].([]interface{})
+	for _, c := range components {
+		component, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		humanizeQuantity(component, "capacity", rate)
+	}
+
+	connections, _ := arch["connections"].([]interface{})
+	for _, c := range connections {
+		connection, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"outRate", "inRate", "capacity"} {
+			humanizeQuantity(connection, key, rate)
+		}
+		for _, key := range []string{"outPacketSize", "inPacketSize"} {
+			humanizeQuantity(connection, key, size)
+		}
+	}
+
+	return json.Marshal(arch)
+}
+
+// humanizeQuantity formats the number under the key of the object with
+// a unit, unless it is not a whole number or is too large to format.
+func humanizeQuantity(object map[string]interface{}, key string, format func(int64) string) {
+	number, ok := object[key].(json.Number)
+	if !ok {
+		return
+	}
+	value, err := number.Int64()
+	if err != nil || value > maxHumanBytes || value < -maxHumanBytes {
+		return
+	}
+	object[key] = format(value)
+}
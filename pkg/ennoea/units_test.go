@@ -0,0 +1,110 @@
+package ennoea
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalRate(t *testing.T) {
+	tests := []struct {
+		json string
+		rate Rate
+		err  string
+	}{
+		{json: `1000`, rate: 1000},
+		{json: `1e3`, rate: 1000},
+		{json: `9223372036854775807`, rate: math.MaxInt64},
+		{json: `"1000"`, rate: 1000},
+		{json: `"1e3"`, rate: 1000},
+		{json: `"1.5e3"`, rate: 1500},
+		{json: `"1e3B/s"`, rate: 1000},
+		{json: `"10MB/s"`, rate: 10000000},
+		{json: `"10MiB/s"`, rate: 10485760},
+		{json: `"1.5Gbps"`, rate: 187500000},
+		{json: `"1234.567KB/s"`, rate: 1234567},
+		{json: `1.5`, err: "not a whole number"},
+		{json: `"1.5"`, err: "not a whole number"},
+		{json: `1e30`, err: "out of range"},
+		{json: `"1e30"`, err: "out of range"},
+		{json: `"1e30TB/s"`, err: "out of range"},
+		{json: `"10MB"`, err: "is a size"},
+		{json: `"1eB/s"`, err: "unknown unit"},
+		{json: `"-1KB/s"`, err: "negative value"},
+		{json: `true`, err: "expected a number or a string"},
+	}
+
+	for _, test := range tests {
+		var rate Rate
+		err := json.Unmarshal([]byte(test.json), &rate)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error = %v, want %q", test.json, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error = %v", test.json, err)
+			continue
+		}
+		if rate != test.rate {
+			t.Errorf("%s: rate = %d, want %d", test.json, rate, test.rate)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		value int64
+		text  string
+	}{
+		{0, "0B"},
+		{512, "512B"},
+		{1000, "1KB"},
+		{1024, "1KiB"},
+		{1536, "1.5KiB"},
+		{10485760, "10MiB"},
+		{1234567, "1234.567KB"},
+		{1000001, "1000.001KB"},
+		{1025, "1.025KB"},
+		{-2048, "-2KiB"},
+	}
+	for _, test := range tests {
+		if text := formatBytes(test.value); text != test.text {
+			t.Errorf("formatBytes(%d) = %q, want %q", test.value, text, test.text)
+		}
+	}
+}
+
+func TestHumanizeUnitsRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := []int64{0, 1, 999, 1000, 1023, 1024, 1234567, maxHumanBytes, maxHumanBytes + 1, math.MaxInt64}
+	for i := 0; i < 1000; i++ {
+		values = append(values, r.Int63n(1<<uint(1+r.Intn(53))))
+	}
+
+	for _, value := range values {
+		file, _ := json.Marshal(map[string]interface{}{
+			"connections": []map[string]interface{}{{"outRate": value, "outPacketSize": value}},
+		})
+		human, err := humanizeUnits(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var arch struct {
+			Connections []struct {
+				OutRate       Rate `json:"outRate"`
+				OutPacketSize Size `json:"outPacketSize"`
+			} `json:"connections"`
+		}
+		if err := json.Unmarshal(human, &arch); err != nil {
+			t.Fatalf("%d: %s: %v", value, human, err)
+		}
+		c := arch.Connections[0]
+		if int64(c.OutRate) != value || int64(c.OutPacketSize) != value {
+			t.Errorf("%d: %s parsed back as %d and %d", value, human, c.OutRate, c.OutPacketSize)
+		}
+	}
+}